@@ -7,8 +7,10 @@ package cext
 import "C"
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"log/slog"
@@ -21,40 +23,76 @@ type Destructor interface {
 	Deinit()
 }
 
+type scrapeTimer interface {
+	ScrapeDuration() time.Duration
+}
+
+// exposes the last native fetch duration so it can be compared against the cli path
+type ScrapeTimingCollector struct {
+	fetchers       map[string]scrapeTimer
+	scrapeDuration *prometheus.Desc
+}
+
+func NewScrapeTimingCollector(node *CNodeFetcher, job *CJobFetcher) *ScrapeTimingCollector {
+	return &ScrapeTimingCollector{
+		fetchers: map[string]scrapeTimer{
+			"node": node,
+			"job":  job,
+		},
+		scrapeDuration: prometheus.NewDesc("slurm_cext_scrape_duration_seconds", "duration of the last native libslurm fetch", []string{"collector"}, nil),
+	}
+}
+
+func (stc *ScrapeTimingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stc.scrapeDuration
+}
+
+func (stc *ScrapeTimingCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, fetcher := range stc.fetchers {
+		ch <- prometheus.MustNewConstMetric(stc.scrapeDuration, prometheus.GaugeValue, fetcher.ScrapeDuration().Seconds(), name)
+	}
+}
+
+// base node states of the C api
+var nodeStates = map[uint64]string{
+	0: "UNKNOWN",
+	1: "DOWN",
+	2: "IDLE",
+	3: "ALLOCATED",
+	4: "ERROR",
+	5: "MIXED",
+	6: "FUTURE",
+	// used by the C api to detect end of enum. Shouldn't ever be emitted
+	7: "END",
+}
+
 type CNodeFetcher struct {
 	cache        *exporter.AtomicThrottledCache[exporter.NodeMetric]
 	scraper      NodeMetricScraper
 	duration     time.Duration
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
+	deinitOnce   sync.Once
 }
 
-// should be defer'd immediately after new cmd to prevent mem leaks
+// should be defer'd immediately after new cmd to prevent mem leaks.
+// safe to call more than once, the scraper is only freed on the first call
 func (cni *CNodeFetcher) Deinit() {
-	DeleteNodeMetricScraper(cni.scraper)
+	cni.deinitOnce.Do(func() {
+		DeleteNodeMetricScraper(cni.scraper)
+	})
 }
 
+// the duration covers the libslurm call, like the cli scrape duration covers the exec
 func (cni *CNodeFetcher) CToGoMetricConvert() ([]exporter.NodeMetric, error) {
+	defer func(t time.Time) { cni.duration = time.Since(t) }(time.Now())
 	if errno := cni.scraper.CollectNodeInfo(); errno != 0 {
-		cni.errorCounter.Inc()
+		cni.errorCounter.WithLabelValues(exporter.ScrapeErrorApi).Inc()
 		return nil, fmt.Errorf("Node Info CPP errno: %d", errno)
 	}
 	cni.scraper.IterReset()
 	nodeMetrics := make([]exporter.NodeMetric, 0)
 	metric := NewPromNodeMetric()
 	defer DeletePromNodeMetric(metric)
-	nodeStates := map[uint64]string{
-		0: "UNKNOWN",
-		1: "DOWN",
-		2: "IDLE",
-		3: "ALLOCATED",
-		4: "ERROR",
-		5: "MIXED",
-		6: "FUTURE",
-		// used by the C api to detect end of enum. Shouldn't ever be emitted
-		7: "END",
-	}
-
-	now := time.Now()
 	for cni.scraper.IterNext(metric) == 0 {
 		nodeMetrics = append(nodeMetrics, exporter.NodeMetric{
 			Hostname:    metric.GetHostname(),
@@ -70,19 +108,21 @@ func (cni *CNodeFetcher) CToGoMetricConvert() ([]exporter.NodeMetric, error) {
 			CpuLoad:     float64(metric.GetCpuLoad()),
 		})
 	}
-	cni.duration = time.Since(now)
 	return nodeMetrics, nil
 }
 
-func (cni *CNodeFetcher) FetchMetrics() ([]exporter.NodeMetric, error) {
-	return cni.cache.FetchOrThrottle(cni.CToGoMetricConvert)
+func (cni *CNodeFetcher) FetchMetrics(ctx context.Context) ([]exporter.NodeMetric, error) {
+	// libslurm calls can't be cancelled, the ctx only reaches the cache
+	return cni.cache.FetchOrThrottle(ctx, func(context.Context) ([]exporter.NodeMetric, error) {
+		return cni.CToGoMetricConvert()
+	})
 }
 
 func (cni *CNodeFetcher) ScrapeDuration() time.Duration {
 	return cni.duration
 }
 
-func (cni *CNodeFetcher) ScrapeError() prometheus.Counter {
+func (cni *CNodeFetcher) ScrapeError() *prometheus.CounterVec {
 	return cni.errorCounter
 }
 
@@ -90,7 +130,7 @@ func NewNodeFetcher(pollLimit float64) *CNodeFetcher {
 	return &CNodeFetcher{
 		cache:   exporter.NewAtomicThrottledCache[exporter.NodeMetric](pollLimit),
 		scraper: NewNodeMetricScraper(""),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: exporter.NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "slurm_cplugin_node_fetch_error",
 			Help: "slurm cplugin fetch error",
 		}),
@@ -101,12 +141,14 @@ type CJobFetcher struct {
 	cache        *exporter.AtomicThrottledCache[exporter.JobMetric]
 	scraper      JobMetricScraper
 	duration     time.Duration
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
+	deinitOnce   sync.Once
 }
 
 func (cjf *CJobFetcher) CToGoMetricConvert() ([]exporter.JobMetric, error) {
+	defer func(t time.Time) { cjf.duration = time.Since(t) }(time.Now())
 	if errno := cjf.scraper.CollectJobInfo(); errno != 0 {
-		cjf.errorCounter.Inc()
+		cjf.errorCounter.WithLabelValues(exporter.ScrapeErrorApi).Inc()
 		return nil, fmt.Errorf("Job Info CPP errno: %d", errno)
 	}
 	jobStates := map[int]string{
@@ -145,31 +187,110 @@ func (cjf *CJobFetcher) CToGoMetricConvert() ([]exporter.JobMetric, error) {
 		metrics = append(metrics, metric)
 		slog.Error(fmt.Sprintf("metrics %v, alloc mem %f", metric, metric.JobResources.AllocNodes["0"].Mem))
 	}
-
 	return metrics, nil
 }
 
-func (cjf *CJobFetcher) FetchMetrics() ([]exporter.JobMetric, error) {
-	return cjf.cache.FetchOrThrottle(cjf.CToGoMetricConvert)
+func (cjf *CJobFetcher) FetchMetrics(ctx context.Context) ([]exporter.JobMetric, error) {
+	return cjf.cache.FetchOrThrottle(ctx, func(context.Context) ([]exporter.JobMetric, error) {
+		return cjf.CToGoMetricConvert()
+	})
 }
 
 func (cjf *CJobFetcher) ScrapeDuration() time.Duration {
 	return cjf.duration
 }
 
-func (cjf *CJobFetcher) ScrapeError() prometheus.Counter {
+func (cjf *CJobFetcher) ScrapeError() *prometheus.CounterVec {
 	return cjf.errorCounter
 }
 
 func (cjf *CJobFetcher) Deinit() {
-	DeleteJobMetricScraper(cjf.scraper)
+	cjf.deinitOnce.Do(func() {
+		DeleteJobMetricScraper(cjf.scraper)
+	})
+}
+
+// reads GPU gres per node from libslurm node info. Allocations come from gres_used, so there are no
+// per job allocations or pending GPUs
+type CGpuFetcher struct {
+	sync.Mutex
+	config       *exporter.Config
+	scraper      NodeMetricScraper
+	limit        float64
+	t            time.Time
+	cache        *exporter.GpuMetrics
+	duration     time.Duration
+	errorCounter *prometheus.CounterVec
+	deinitOnce   sync.Once
+}
+
+func (cgf *CGpuFetcher) CToGoMetricConvert() (*exporter.GpuMetrics, error) {
+	defer func(t time.Time) { cgf.duration = time.Since(t) }(time.Now())
+	if errno := cgf.scraper.CollectNodeInfo(); errno != 0 {
+		cgf.errorCounter.WithLabelValues(exporter.ScrapeErrorApi).Inc()
+		return nil, fmt.Errorf("GPU Node Info CPP errno: %d", errno)
+	}
+	cgf.scraper.IterReset()
+	nodes := make([]exporter.GpuGresNode, 0)
+	metric := NewPromNodeMetric()
+	defer DeletePromNodeMetric(metric)
+	for cgf.scraper.IterNext(metric) == 0 {
+		nodes = append(nodes, exporter.GpuGresNode{
+			Name:     metric.GetName(),
+			Gres:     metric.GetGres(),
+			GresUsed: metric.GetGresUsed(),
+			State:    nodeStates[metric.GetNodeState()],
+		})
+	}
+	return exporter.NewGresGpuMetrics(cgf.config, nodes), nil
+}
+
+func (cgf *CGpuFetcher) FetchMetrics(_ context.Context) (*exporter.GpuMetrics, error) {
+	cgf.Lock()
+	defer cgf.Unlock()
+	if cgf.cache != nil && time.Since(cgf.t).Seconds() < cgf.limit {
+		return cgf.cache, nil
+	}
+	metrics, err := cgf.CToGoMetricConvert()
+	if err != nil {
+		return nil, err
+	}
+	cgf.cache = metrics
+	cgf.t = time.Now()
+	return metrics, nil
+}
+
+func (cgf *CGpuFetcher) ScrapeDuration() time.Duration {
+	return cgf.duration
+}
+
+func (cgf *CGpuFetcher) ScrapeError() *prometheus.CounterVec {
+	return cgf.errorCounter
+}
+
+func (cgf *CGpuFetcher) Deinit() {
+	cgf.deinitOnce.Do(func() {
+		DeleteNodeMetricScraper(cgf.scraper)
+	})
+}
+
+func NewGpuFetcher(config *exporter.Config) *CGpuFetcher {
+	return &CGpuFetcher{
+		config:  config,
+		limit:   config.PollLimit,
+		scraper: NewNodeMetricScraper(""),
+		errorCounter: exporter.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_cplugin_gpu_fetch_error",
+			Help: "slurm cplugin gpu fetch error",
+		}),
+	}
 }
 
 func NewJobFetcher(pollLimit float64) *CJobFetcher {
 	return &CJobFetcher{
 		cache:   exporter.NewAtomicThrottledCache[exporter.JobMetric](pollLimit),
 		scraper: NewJobMetricScraper(""),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: exporter.NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "slurm_cplugin_job_fetch_error",
 			Help: "slurm cplugin job fetch error",
 		}),
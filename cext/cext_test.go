@@ -63,6 +63,17 @@ func TestCtoGoNodeMetricsTwice(t *testing.T) {
 	assert.Positive(len(metrics))
 }
 
+func TestCtoGoGpuMetrics(t *testing.T) {
+	assert := assert.New(t)
+	config, err := exporter.NewConfig(&exporter.CliFlags{SlurmGpusEnabled: true})
+	assert.NoError(err)
+	fetcher := NewGpuFetcher(config)
+	defer fetcher.Deinit()
+	metrics, err := fetcher.CToGoMetricConvert()
+	assert.NoError(err)
+	assert.GreaterOrEqual(metrics.Total, metrics.Alloc)
+}
+
 func TestNodeCollectorCFetcher(t *testing.T) {
 	if os.Getenv("TEST_CLUSTER") != "true" {
 		return
@@ -86,3 +97,52 @@ func TestNodeCollectorCFetcher(t *testing.T) {
 	}
 	assert.NotEmpty(metrics)
 }
+
+func TestScrapeTimingCollector(t *testing.T) {
+	assert := assert.New(t)
+	nodeFetcher := NewNodeFetcher(0)
+	defer nodeFetcher.Deinit()
+	jobFetcher := NewJobFetcher(0)
+	defer jobFetcher.Deinit()
+	_, err := nodeFetcher.CToGoMetricConvert()
+	assert.NoError(err)
+	_, err = jobFetcher.CToGoMetricConvert()
+	assert.NoError(err)
+	assert.Positive(nodeFetcher.ScrapeDuration())
+	assert.Positive(jobFetcher.ScrapeDuration())
+	stc := NewScrapeTimingCollector(nodeFetcher, jobFetcher)
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		stc.Collect(metricChan)
+		close(metricChan)
+	}()
+	metrics := make([]prometheus.Metric, 0)
+	for m, ok := <-metricChan; ok; m, ok = <-metricChan {
+		metrics = append(metrics, m)
+	}
+	assert.Len(metrics, 2)
+}
+
+type countingDestructor struct {
+	calls *[]string
+	name  string
+}
+
+func (cd countingDestructor) Deinit() {
+	*cd.calls = append(*cd.calls, cd.name)
+}
+
+func TestDeinit(t *testing.T) {
+	assert := assert.New(t)
+	calls := make([]string, 0)
+	Deinit([]Destructor{countingDestructor{&calls, "node"}, countingDestructor{&calls, "job"}})
+	assert.Equal([]string{"job", "node"}, calls)
+}
+
+func TestFetcherDeinitTwice(t *testing.T) {
+	nodeFetcher := NewNodeFetcher(0)
+	jobFetcher := NewJobFetcher(0)
+	// the server error path in main frees before the deferred free runs, must not double free
+	Deinit([]Destructor{nodeFetcher, jobFetcher})
+	Deinit([]Destructor{nodeFetcher, jobFetcher})
+}
@@ -41,6 +41,21 @@ string PromNodeMetric::GetPartitions()
     return node_info.partitions;
 }
 
+string PromNodeMetric::GetName()
+{
+    return node_info.name ? node_info.name : "";
+}
+
+string PromNodeMetric::GetGres()
+{
+    return node_info.gres ? node_info.gres : "";
+}
+
+string PromNodeMetric::GetGresUsed()
+{
+    return node_info.gres_used ? node_info.gres_used : "";
+}
+
 double PromNodeMetric::GetCpuLoad()
 {
     return (double)node_info.cpu_load / 100;
@@ -34,6 +34,12 @@ public:
     double GetCpuLoad();
     string GetHostname();
     string GetPartitions();
+    // NodeName, which job nodelists refer to. Can differ from the hostname
+    string GetName();
+    // i.e gpu:a100:4(S:0-1), empty without gres
+    string GetGres();
+    // i.e gpu:a100:3(IDX:0-2)
+    string GetGresUsed();
 };
 
 struct NodeMetricScraper
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+#include <slurm/slurm.h>
+#include "cversion.hpp"
+
+string LibslurmVersion()
+{
+    return SLURM_VERSION_STRING;
+}
+
+string ControllerVersion()
+{
+    slurm_conf_t *conf = nullptr;
+    if (slurm_load_ctl_conf((time_t) nullptr, &conf) != SLURM_SUCCESS || !conf)
+        return "";
+    string version = conf->version ? conf->version : "";
+    slurm_free_ctl_conf(conf);
+    return version;
+}
@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+#include <slurm/slurm.h>
+#include <string>
+
+using namespace std;
+
+// version of the slurm headers the exporter was compiled against i.e 23.11.4
+string LibslurmVersion();
+// version reported by the running slurmctld, empty if it can't be loaded.
+// expects slurm_init to have been called
+string ControllerVersion();
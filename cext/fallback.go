@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+package cext
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rivosinc/prometheus-slurm-exporter/exporter"
+)
+
+var cextFallbackCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "slurm_cext_fallback_total",
+	Help: "number of scrapes served by the cli fetcher after the native fetcher failed",
+}, []string{"collector"})
+
+// serves metrics from the native fetcher, falling back to the cli fetcher for
+// any scrape where libslurm errors (e.g version drift between the .so and slurmctld)
+type FallbackFetcher[M exporter.SlurmPrimitiveMetric] struct {
+	native       exporter.SlurmMetricFetcher[M]
+	cli          exporter.SlurmMetricFetcher[M]
+	fallbacks    prometheus.Counter
+	usedFallback bool
+}
+
+func NewFallbackFetcher[M exporter.SlurmPrimitiveMetric](collector string, native, cli exporter.SlurmMetricFetcher[M]) *FallbackFetcher[M] {
+	return &FallbackFetcher[M]{
+		native:    native,
+		cli:       cli,
+		fallbacks: cextFallbackCounter.WithLabelValues(collector),
+	}
+}
+
+func (ff *FallbackFetcher[M]) FetchMetrics(ctx context.Context) ([]M, error) {
+	metrics, err := ff.native.FetchMetrics(ctx)
+	ff.usedFallback = err != nil
+	if err == nil {
+		return metrics, nil
+	}
+	slog.Error(fmt.Sprintf("native fetch failed, falling back to cli: %q", err))
+	ff.fallbacks.Inc()
+	return ff.cli.FetchMetrics(ctx)
+}
+
+func (ff *FallbackFetcher[M]) ScrapeDuration() time.Duration {
+	if ff.usedFallback {
+		return ff.cli.ScrapeDuration()
+	}
+	return ff.native.ScrapeDuration()
+}
+
+// the collector collects the native fetcher's errors, see CliScrapeError for the cli fetcher's
+func (ff *FallbackFetcher[M]) ScrapeError() *prometheus.CounterVec {
+	return ff.native.ScrapeError()
+}
+
+// errors of the cli fetcher during fallback scrapes. The collector only collects ScrapeError,
+// so these are registered alongside it
+func (ff *FallbackFetcher[M]) CliScrapeError() *prometheus.CounterVec {
+	return ff.cli.ScrapeError()
+}
+
+// FallbackFetcher for the GPU collector, whose fetchers return a single *GpuMetrics
+type GpuFallbackFetcher struct {
+	native       exporter.GpuFetcher
+	cli          exporter.GpuFetcher
+	fallbacks    prometheus.Counter
+	usedFallback bool
+}
+
+func NewGpuFallbackFetcher(native, cli exporter.GpuFetcher) *GpuFallbackFetcher {
+	return &GpuFallbackFetcher{
+		native:    native,
+		cli:       cli,
+		fallbacks: cextFallbackCounter.WithLabelValues("gpu"),
+	}
+}
+
+func (gff *GpuFallbackFetcher) FetchMetrics(ctx context.Context) (*exporter.GpuMetrics, error) {
+	metrics, err := gff.native.FetchMetrics(ctx)
+	gff.usedFallback = err != nil
+	if err == nil {
+		return metrics, nil
+	}
+	slog.Error(fmt.Sprintf("native GPU fetch failed, falling back to cli: %q", err))
+	gff.fallbacks.Inc()
+	return gff.cli.FetchMetrics(ctx)
+}
+
+func (gff *GpuFallbackFetcher) ScrapeDuration() time.Duration {
+	if gff.usedFallback {
+		return gff.cli.ScrapeDuration()
+	}
+	return gff.native.ScrapeDuration()
+}
+
+func (gff *GpuFallbackFetcher) ScrapeError() *prometheus.CounterVec {
+	return gff.native.ScrapeError()
+}
+
+// see FallbackFetcher.CliScrapeError
+func (gff *GpuFallbackFetcher) CliScrapeError() *prometheus.CounterVec {
+	return gff.cli.ScrapeError()
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+package cext
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rivosinc/prometheus-slurm-exporter/exporter"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubNodeFetcher struct {
+	metrics      []exporter.NodeMetric
+	err          error
+	duration     time.Duration
+	calls        int
+	errorCounter *prometheus.CounterVec
+}
+
+func (sf *stubNodeFetcher) FetchMetrics(_ context.Context) ([]exporter.NodeMetric, error) {
+	sf.calls++
+	return sf.metrics, sf.err
+}
+
+func (sf *stubNodeFetcher) ScrapeDuration() time.Duration {
+	return sf.duration
+}
+
+func (sf *stubNodeFetcher) ScrapeError() *prometheus.CounterVec {
+	if sf.errorCounter == nil {
+		sf.errorCounter = exporter.NewScrapeErrorCounter(prometheus.CounterOpts{Name: "stub_error"})
+	}
+	return sf.errorCounter
+}
+
+type stubGpuFetcher struct {
+	metrics *exporter.GpuMetrics
+	err     error
+	calls   int
+}
+
+func (sf *stubGpuFetcher) FetchMetrics(_ context.Context) (*exporter.GpuMetrics, error) {
+	sf.calls++
+	return sf.metrics, sf.err
+}
+
+func (sf *stubGpuFetcher) ScrapeDuration() time.Duration {
+	return time.Second
+}
+
+func (sf *stubGpuFetcher) ScrapeError() *prometheus.CounterVec {
+	return exporter.NewScrapeErrorCounter(prometheus.CounterOpts{Name: "stub_gpu_error"})
+}
+
+func fallbackCount(t *testing.T, collector string) float64 {
+	metric := &dto.Metric{}
+	assert.NoError(t, cextFallbackCounter.WithLabelValues(collector).Write(metric))
+	return metric.Counter.GetValue()
+}
+
+func TestFallbackFetcher_NativeOk(t *testing.T) {
+	assert := assert.New(t)
+	native := &stubNodeFetcher{metrics: []exporter.NodeMetric{{Hostname: "native"}}, duration: time.Second}
+	cli := &stubNodeFetcher{metrics: []exporter.NodeMetric{{Hostname: "cli"}}, duration: time.Minute}
+	before := fallbackCount(t, "node_ok")
+	fetcher := NewFallbackFetcher[exporter.NodeMetric]("node_ok", native, cli)
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal("native", metrics[0].Hostname)
+	assert.Zero(cli.calls)
+	assert.Equal(time.Second, fetcher.ScrapeDuration())
+	assert.Equal(before, fallbackCount(t, "node_ok"))
+}
+
+func TestFallbackFetcher_NativeErr(t *testing.T) {
+	assert := assert.New(t)
+	native := &stubNodeFetcher{err: errors.New("Node Info CPP errno: 1"), duration: time.Second}
+	cli := &stubNodeFetcher{metrics: []exporter.NodeMetric{{Hostname: "cli"}}, duration: time.Minute}
+	before := fallbackCount(t, "node_err")
+	fetcher := NewFallbackFetcher[exporter.NodeMetric]("node_err", native, cli)
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal("cli", metrics[0].Hostname)
+	assert.Equal(1, cli.calls)
+	assert.Equal(time.Minute, fetcher.ScrapeDuration())
+	assert.Equal(before+1, fallbackCount(t, "node_err"))
+	// native recovers on the next scrape
+	native.err = nil
+	_, err = fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(1, cli.calls)
+	assert.Equal(time.Second, fetcher.ScrapeDuration())
+}
+
+func TestFallbackFetcher_ScrapeErrors(t *testing.T) {
+	assert := assert.New(t)
+	native := &stubNodeFetcher{err: errors.New("Node Info CPP errno: 1")}
+	cli := &stubNodeFetcher{err: errors.New("sinfo failed")}
+	fetcher := NewFallbackFetcher[exporter.NodeMetric]("node_scrape_errors", native, cli)
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+	// both fetchers' errors are exported, the cli's through CliScrapeError
+	assert.Same(native.ScrapeError(), fetcher.ScrapeError())
+	assert.Same(cli.ScrapeError(), fetcher.CliScrapeError())
+	cli.ScrapeError().WithLabelValues(exporter.ScrapeErrorExec).Inc()
+	metric := &dto.Metric{}
+	assert.NoError(fetcher.CliScrapeError().WithLabelValues(exporter.ScrapeErrorExec).Write(metric))
+	assert.Equal(1., metric.Counter.GetValue())
+}
+
+func TestGpuFallbackFetcher(t *testing.T) {
+	assert := assert.New(t)
+	native := &stubGpuFetcher{err: errors.New("GPU Node Info CPP errno: 1")}
+	cli := &stubGpuFetcher{metrics: &exporter.GpuMetrics{Total: 8}}
+	before := fallbackCount(t, "gpu")
+	fetcher := NewGpuFallbackFetcher(native, cli)
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(8., metrics.Total)
+	assert.Equal(before+1, fallbackCount(t, "gpu"))
+	native.err = nil
+	native.metrics = &exporter.GpuMetrics{Total: 4}
+	metrics, err = fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(4., metrics.Total)
+	assert.Equal(1, cli.calls)
+}
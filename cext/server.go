@@ -14,6 +14,13 @@ import (
 	"github.com/rivosinc/prometheus-slurm-exporter/exporter"
 )
 
+// frees every destructor in reverse order of construction
+func Deinit(destructors []Destructor) {
+	for i := len(destructors) - 1; i >= 0; i-- {
+		destructors[i].Deinit()
+	}
+}
+
 func InitPromServer(config *exporter.Config) (http.Handler, []Destructor) {
 	textHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: config.LogLevel,
@@ -21,11 +28,26 @@ func InitPromServer(config *exporter.Config) (http.Handler, []Destructor) {
 	slog.SetDefault(slog.New(textHandler))
 	nodeCollector := exporter.NewNodeCollecter(config)
 	cNodeFetcher := NewNodeFetcher(config.PollLimit)
-	nodeCollector.SetFetcher(cNodeFetcher)
-	prometheus.MustRegister(nodeCollector)
+	nodeFallback := NewFallbackFetcher("node", cNodeFetcher, nodeCollector.Fetcher())
+	nodeCollector.SetFetcher(nodeFallback)
+	prometheus.MustRegister(nodeCollector, nodeFallback.CliScrapeError())
 	CJobFetcher := NewJobFetcher(config.PollLimit)
 	jobCollector := exporter.NewJobsController(config)
-	jobCollector.SetFetcher(CJobFetcher)
-	prometheus.MustRegister(jobCollector)
-	return promhttp.Handler(), []Destructor{cNodeFetcher, CJobFetcher}
+	jobFallback := NewFallbackFetcher("job", CJobFetcher, jobCollector.Fetcher())
+	jobCollector.SetFetcher(jobFallback)
+	prometheus.MustRegister(jobCollector, jobFallback.CliScrapeError())
+	destructors := []Destructor{cNodeFetcher, CJobFetcher}
+	if config.GpusEnabled() {
+		cGpuFetcher := NewGpuFetcher(config)
+		gpuCollector := exporter.NewGpuCollector(config)
+		gpuFallback := NewGpuFallbackFetcher(cGpuFetcher, gpuCollector.Fetcher())
+		gpuCollector.SetFetcher(gpuFallback)
+		prometheus.MustRegister(gpuCollector, gpuFallback.CliScrapeError())
+		destructors = append(destructors, cGpuFetcher)
+	}
+	checkLibslurmVersion()
+	prometheus.MustRegister(libslurmVersionInfo)
+	prometheus.MustRegister(cextFallbackCounter)
+	prometheus.MustRegister(NewScrapeTimingCollector(cNodeFetcher, CJobFetcher))
+	return promhttp.Handler(), destructors
 }
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+package cext
+
+import (
+	"fmt"
+	"strings"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var libslurmVersionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "slurm_cext_libslurm_version_info",
+	Help: "always 1, labeled with the libslurm version the native fetchers were compiled against",
+}, []string{"version"})
+
+// major.minor of a slurm version i.e 23.11 for 23.11.4. Releases sharing it speak the same rpc protocol
+func slurmRelease(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// the native fetchers break in subtle ways when libslurm and slurmctld are from different releases
+func compareSlurmVersions(libVersion string, ctldVersion string) bool {
+	if ctldVersion == "" {
+		slog.Warn(fmt.Sprintf("couldn't load the slurmctld version to compare against libslurm %s", libVersion))
+		return false
+	}
+	if slurmRelease(libVersion) != slurmRelease(ctldVersion) {
+		slog.Warn(fmt.Sprintf("libslurm %s doesn't match slurmctld %s, native fetches may fail or misparse", libVersion, ctldVersion))
+		return false
+	}
+	return true
+}
+
+// exports the compiled libslurm version and checks it against the running slurmctld.
+// The fetchers must be constructed first, they initialize libslurm
+func checkLibslurmVersion() {
+	libVersion := LibslurmVersion()
+	libslurmVersionInfo.WithLabelValues(libVersion).Set(1)
+	if compareSlurmVersions(libVersion, ControllerVersion()) {
+		slog.Info("native fetchers compiled against libslurm " + libVersion)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build cenabled
+
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+package cext
+
+import (
+	"os"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlurmRelease(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("23.11", slurmRelease("23.11.4"))
+	assert.Equal("24.05", slurmRelease("24.05.0-0rc1"))
+	assert.Equal("23", slurmRelease("23"))
+}
+
+func TestCompareSlurmVersions(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(compareSlurmVersions("23.11.4", "23.11.7"))
+	assert.False(compareSlurmVersions("23.11.4", "24.05.1"))
+	assert.False(compareSlurmVersions("23.11.4", ""))
+}
+
+func TestCheckLibslurmVersion(t *testing.T) {
+	if os.Getenv("TEST_CLUSTER") != "true" {
+		return
+	}
+	assert := assert.New(t)
+	fetcher := NewNodeFetcher(0)
+	defer fetcher.Deinit()
+	checkLibslurmVersion()
+	metric := &dto.Metric{}
+	assert.NoError(libslurmVersionInfo.WithLabelValues(LibslurmVersion()).Write(metric))
+	assert.Equal(1., metric.GetGauge().GetValue())
+	assert.NotEmpty(ControllerVersion())
+}
@@ -6,9 +6,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"log/slog"
 
@@ -21,27 +27,46 @@ var (
 		`Address to listen on for telemetry "(default: :9092)"`)
 	metricsPath = flag.String("web.telemetry-path", "",
 		"Path under which to expose metrics (default: /metrics)")
-	logLevel = flag.String("web.log-level", "", "Log level: info, debug, error, warning")
+	logLevel    = flag.String("web.log-level", "", "Log level: info, debug, error, warning")
+	gpusEnabled = flag.Bool("slurm.collect-gpus", false, "Collect GPU metrics, natively from libslurm node info")
 )
 
 func main() {
 	flag.Parse()
 	cliArgs := exporter.CliFlags{
-		ListenAddress: *listenAddress,
-		MetricsPath:   *metricsPath,
-		LogLevel:      *logLevel,
+		ListenAddress:    *listenAddress,
+		MetricsPath:      *metricsPath,
+		LogLevel:         *logLevel,
+		SlurmGpusEnabled: *gpusEnabled,
 	}
 	config, err := exporter.NewConfig(&cliArgs)
 	if err != nil {
 		log.Fatalf("failed to init config with %q", err)
 	}
 	handler, fetchersToFree := cext.InitPromServer(config)
-	defer func() {
-		for _, fetcher := range fetchersToFree {
-			fetcher.Deinit()
-		}
-	}()
+	// log.Fatal skips defers, so the native handles are freed explicitly on every exit path
+	defer cext.Deinit(fetchersToFree)
 	http.Handle(config.MetricsPath, handler)
+	server := &http.Server{Addr: config.ListenAddress}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
 	slog.Info("serving metrics at " + config.ListenAddress + config.MetricsPath)
-	log.Fatalf("server exited with %q", http.ListenAndServe(config.ListenAddress, nil))
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case sig := <-sigs:
+		slog.Info("received " + sig.String() + ", shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("server shutdown failed: " + err.Error())
+		}
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			cext.Deinit(fetchersToFree)
+			log.Fatalf("server exited with %q", err)
+		}
+	}
 }
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"log/slog"
+)
+
+const unknownCluster = "unknown"
+
+// parses ClusterName out of either slurm.conf ("ClusterName=rivos")
+// or scontrol show config ("ClusterName             = rivos")
+func parseClusterName(conf []byte) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(conf))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "ClusterName") {
+			continue
+		}
+		if val = strings.TrimSpace(val); val != "" {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// the cluster name doesn't change so this is only run once at startup.
+// $SLURM_CONF is preferred since it doesn't need slurmctld to be reachable
+func detectClusterName(ctx context.Context, scontrol SlurmByteScraper) string {
+	if confPath, ok := os.LookupEnv("SLURM_CONF"); ok {
+		if conf, err := os.ReadFile(confPath); err == nil {
+			if name, ok := parseClusterName(conf); ok {
+				return name
+			}
+		} else {
+			slog.Warn(fmt.Sprintf("failed to read SLURM_CONF %s: %q", confPath, err))
+		}
+	}
+	conf, err := scontrol.FetchRawBytes(ctx)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to detect cluster name, defaulting to %s: %q", unknownCluster, err))
+		return unknownCluster
+	}
+	if name, ok := parseClusterName(conf); ok {
+		return name
+	}
+	return unknownCluster
+}
+
+// detects the cluster name unless -slurm.cluster set it. Run from InitPromServer
+// rather than NewConfig since detection may shell out to scontrol
+func (c *Config) resolveClusterName() {
+	if c.ClusterName == "" {
+		c.ClusterName = detectClusterName(context.Background(), c.cliOpts.scraper("scontrol_config", c.cliOpts.scontrolConfig))
+	}
+	if !c.ClusterLabelEnabled {
+		return
+	}
+	// an explicit cluster external label wins
+	if _, ok := c.ExternalLabels["cluster"]; !ok {
+		c.ExternalLabels["cluster"] = c.ClusterName
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClusterName_Scontrol(t *testing.T) {
+	assert := assert.New(t)
+	name, ok := parseClusterName([]byte("AuthType                = auth/munge\nClusterName             = rivos\n"))
+	assert.True(ok)
+	assert.Equal("rivos", name)
+}
+
+func TestParseClusterName_Missing(t *testing.T) {
+	assert := assert.New(t)
+	_, ok := parseClusterName([]byte("AuthType=auth/munge\n# ClusterName=commented\nClusterName=\n"))
+	assert.False(ok)
+}
+
+func TestDetectClusterName_Scontrol(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("SLURM_CONF", "")
+	assert.Equal("rivos", detectClusterName(context.Background(), &MockScraper{fixture: "fixtures/scontrol_config.txt"}))
+}
+
+func TestDetectClusterName_SlurmConf(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("SLURM_CONF", "fixtures/slurm.conf")
+	scraper := &MockScraper{fixture: "fixtures/scontrol_config.txt"}
+	assert.Equal("rivos-conf", detectClusterName(context.Background(), scraper))
+	// scontrol isn't consulted when slurm.conf names the cluster
+	assert.Zero(scraper.CallCount)
+}
+
+func TestDetectClusterName_Unreachable(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("SLURM_CONF", "fixtures/does_not_exist.conf")
+	assert.Equal(unknownCluster, detectClusterName(context.Background(), new(MockFetchErrored)))
+}
+
+func TestNewConfig_ClusterOverride(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ClusterName: "rivos", ExternalLabels: "region=us-east"})
+	assert.Nil(err)
+	config.resolveClusterName()
+	assert.Equal("rivos", config.ClusterName)
+	// the cluster label is opt-in
+	assert.Equal(prometheus.Labels{"region": "us-east"}, config.ExternalLabels)
+	config, err = NewConfig(&CliFlags{ClusterName: "rivos", ClusterLabelEnabled: true, ExternalLabels: "region=us-east"})
+	assert.Nil(err)
+	config.resolveClusterName()
+	assert.Equal(prometheus.Labels{"region": "us-east", "cluster": "rivos"}, config.ExternalLabels)
+	// an explicit cluster external label wins over the cluster name
+	config, err = NewConfig(&CliFlags{ClusterName: "rivos", ClusterLabelEnabled: true, ExternalLabels: "cluster=other"})
+	assert.Nil(err)
+	config.resolveClusterName()
+	assert.Equal("other", config.ExternalLabels["cluster"])
+}
+
+func TestNewConfig_ClusterNotDetected(t *testing.T) {
+	assert := assert.New(t)
+	// building the config never shells out to scontrol
+	config, err := NewConfig(new(CliFlags))
+	assert.Nil(err)
+	assert.Empty(config.ClusterName)
+	assert.Empty(config.ExternalLabels)
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// terminal states counted by slurm_jobs_completed_total
+const completedJobStates = "COMPLETED,FAILED,TIMEOUT,CANCELLED"
+
+// default lookback of the completed jobs sacct query
+const defaultCompletedJobsMinutes = 15
+
+// sacct job state, a plain string in older data_parser versions and a list i.e ["CANCELLED"] in newer ones.
+// Only the base state, the first entry, is kept
+type sacctJobState string
+
+func (sjs *sacctJobState) UnmarshalJSON(data []byte) error {
+	var state string
+	if err := json.Unmarshal(data, &state); err == nil {
+		*sjs = sacctJobState(state)
+		return nil
+	}
+	var states []string
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	if len(states) > 0 {
+		*sjs = sacctJobState(states[0])
+	}
+	return nil
+}
+
+// a job that ended within the sacct window
+type CompletedJobMetric struct {
+	JobId     CoercedInt `json:"job_id"`
+	Partition string     `json:"partition"`
+	State     struct {
+		Current sacctJobState `json:"current"`
+	} `json:"state"`
+	Time struct {
+		End IntFromOptionalStruct `json:"end"`
+	} `json:"time"`
+}
+
+type sacctCompletedResponse struct {
+	Errors []string             `json:"errors"`
+	Jobs   []CompletedJobMetric `json:"jobs"`
+}
+
+type CompletedJobsFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[CompletedJobMetric]
+}
+
+func (cjf *CompletedJobsFetcher) fetch(ctx context.Context) ([]CompletedJobMetric, error) {
+	cliJson, err := cjf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		cjf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
+	}
+	resp := new(sacctCompletedResponse)
+	if err := unmarshalCliJson(cliJson, resp); err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling completed job metrics %q", err))
+		cjf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		recordApiErrors("sacct", resp.Errors)
+		for _, e := range resp.Errors {
+			slog.Error(fmt.Sprintf("sacct API error response: %q", e))
+		}
+		cjf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(resp.Errors)))
+		return nil, errors.New(resp.Errors[0])
+	}
+	return resp.Jobs, nil
+}
+
+func (cjf *CompletedJobsFetcher) FetchMetrics(ctx context.Context) ([]CompletedJobMetric, error) {
+	return cjf.cache.FetchOrThrottle(ctx, cjf.fetch)
+}
+
+func (cjf *CompletedJobsFetcher) ScrapeError() *prometheus.CounterVec {
+	return cjf.errorCounter
+}
+
+func (cjf *CompletedJobsFetcher) ScrapeDuration() time.Duration {
+	return cjf.scraper.Duration()
+}
+
+type completedJobKey struct {
+	state     string
+	partition string
+}
+
+// a requeued job ends once per run under the same id, so runs are told apart by their end time
+type completedJobRun struct {
+	jobId int
+	end   int
+}
+
+// counts jobs ending in a terminal state. Every scrape sees the jobs that ended within the sacct window,
+// so consecutive windows overlap. A run is only counted when it wasn't in the previous window.
+// The window moves forward, so a run that left it can't come back
+type CompletedJobsCollector struct {
+	fetcher            SlurmMetricFetcher[CompletedJobMetric]
+	partitionAllowlist LabelAllowlist
+	completed          *prometheus.Desc
+	scrapeDuration     *prometheus.Desc
+	scrapeError        *prometheus.CounterVec
+	// guards the running totals, collects may run concurrently
+	mu     sync.Mutex
+	totals map[completedJobKey]float64
+	// runs of the previous window
+	seen map[completedJobRun]bool
+}
+
+func NewCompletedJobsCollector(config *Config) *CompletedJobsCollector {
+	cliOpts := config.cliOpts
+	fetcher := &CompletedJobsFetcher{
+		scraper: cliOpts.scraper("sacct_completed", cliOpts.completedJobs),
+		cache:   newCollectorCache[CompletedJobMetric](config.PollLimit, "completed_jobs"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_completed_jobs_scrape_error",
+			Help: "completed jobs sacct scrape errors",
+		}),
+	}
+	return &CompletedJobsCollector{
+		fetcher:            fetcher,
+		partitionAllowlist: cliOpts.partitionAllowlist,
+		completed:          config.NewDesc("slurm_jobs_completed_total", "jobs that ended in a terminal state since the exporter started, per state per partition", []string{"state", "partition"}, nil),
+		scrapeDuration:     config.NewDesc("slurm_completed_jobs_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.completedJobs), nil, nil),
+		scrapeError:        fetcher.ScrapeError(),
+		totals:             make(map[completedJobKey]float64),
+		seen:               make(map[completedJobRun]bool),
+	}
+}
+
+// adds the runs missing from the previous window to the totals
+func (cjc *CompletedJobsCollector) observe(jobs []CompletedJobMetric) {
+	cjc.mu.Lock()
+	defer cjc.mu.Unlock()
+	window := make(map[completedJobRun]bool, len(jobs))
+	for _, job := range jobs {
+		run := completedJobRun{jobId: int(job.JobId), end: int(job.Time.End)}
+		window[run] = true
+		if cjc.seen[run] {
+			continue
+		}
+		key := completedJobKey{state: string(job.State.Current), partition: cjc.partitionAllowlist.Bucket(job.Partition)}
+		cjc.totals[key]++
+	}
+	cjc.seen = window
+}
+
+func (cjc *CompletedJobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cjc.completed
+	ch <- cjc.scrapeDuration
+	cjc.scrapeError.Describe(ch)
+}
+
+func (cjc *CompletedJobsCollector) Collect(ch chan<- prometheus.Metric) {
+	cjc.CollectContext(context.Background(), ch)
+}
+
+func (cjc *CompletedJobsCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer cjc.scrapeError.Collect(ch)
+	jobs, err := cjc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(cjc.scrapeDuration, prometheus.GaugeValue, float64(cjc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("completed jobs fetch error %q", err))
+	} else {
+		cjc.observe(jobs)
+	}
+	// totals survive failed scrapes, dropping them would read as a counter reset
+	cjc.mu.Lock()
+	defer cjc.mu.Unlock()
+	for key, total := range cjc.totals {
+		ch <- prometheus.MustNewConstMetric(cjc.completed, prometheus.CounterValue, total, key.state, key.partition)
+	}
+}
+
+// sacct cmd listing the jobs that ended within the last minutes
+func completedJobsCmd(minutes int) []string {
+	return []string{"sacct", "-a", "-X", "--starttime=now-" + strconv.Itoa(minutes) + "minutes", "--state=" + completedJobStates, "--json"}
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+var MockCompletedJobsScraper = &MockScraper{fixture: "fixtures/sacct_completed.json"}
+
+func newTestCompletedJobsCollector(scraper SlurmByteScraper) *CompletedJobsCollector {
+	config := &Config{PollLimit: 10, cliOpts: &CliOpts{completedEnabled: true}}
+	cjc := NewCompletedJobsCollector(config)
+	cjc.fetcher = &CompletedJobsFetcher{
+		scraper:      scraper,
+		errorCounter: cjc.scrapeError,
+		cache:        NewAtomicThrottledCache[CompletedJobMetric](10),
+	}
+	return cjc
+}
+
+func TestCompletedJobsFetcher(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &CompletedJobsFetcher{
+		scraper:      MockCompletedJobsScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[CompletedJobMetric](10),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(jobs, 7)
+	assert.Equal(sacctJobState("COMPLETED"), jobs[0].State.Current)
+	// older data_parser versions report the state as a plain string
+	assert.Equal(sacctJobState("FAILED"), jobs[2].State.Current)
+	assert.Equal(IntFromOptionalStruct(1700000000), jobs[0].Time.End)
+}
+
+func TestCompletedJobsCollector_Observe(t *testing.T) {
+	assert := assert.New(t)
+	cjc := newTestCompletedJobsCollector(MockCompletedJobsScraper)
+	jobs, err := cjc.fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	cjc.observe(jobs[:6])
+	assert.Equal(2., cjc.totals[completedJobKey{"COMPLETED", "gpu"}])
+	// the next window overlaps the previous one, only the requeued run of 106 that completed since is new
+	cjc.observe(jobs)
+	expected := map[completedJobKey]float64{
+		{"COMPLETED", "gpu"}: 3,
+		{"FAILED", "cpu"}:    1,
+		{"FAILED", "gpu"}:    1,
+		{"TIMEOUT", "gpu"}:   1,
+		{"CANCELLED", "cpu"}: 1,
+	}
+	assert.Equal(expected, cjc.totals)
+	// the oldest runs left the window, the rest were already counted
+	cjc.observe(jobs[3:])
+	assert.Equal(expected, cjc.totals)
+	// runs that left the window are forgotten, the totals remain
+	cjc.observe(nil)
+	assert.Empty(cjc.seen)
+	assert.Equal(expected, cjc.totals)
+}
+
+func TestCompletedJobsCollector_Collect(t *testing.T) {
+	assert := assert.New(t)
+	cjc := newTestCompletedJobsCollector(MockCompletedJobsScraper)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cjc)
+	for i := 0; i < 2; i++ {
+		families, err := registry.Gather()
+		assert.NoError(err)
+		var completed *dto.MetricFamily
+		for _, family := range families {
+			if family.GetName() == "slurm_jobs_completed_total" {
+				completed = family
+			}
+		}
+		assert.NotNil(completed)
+		assert.Equal(dto.MetricType_COUNTER, completed.GetType())
+		total := 0.
+		for _, metric := range completed.GetMetric() {
+			total += metric.GetCounter().GetValue()
+		}
+		assert.Equal(7., total)
+	}
+}
+
+func TestCompletedJobsCollector_FetchError(t *testing.T) {
+	assert := assert.New(t)
+	cjc := newTestCompletedJobsCollector(MockCompletedJobsScraper)
+	jobs, err := cjc.fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	cjc.observe(jobs)
+	cjc.fetcher = &CompletedJobsFetcher{
+		scraper:      new(MockFetchErrored),
+		errorCounter: cjc.scrapeError,
+		cache:        NewAtomicThrottledCache[CompletedJobMetric](10),
+	}
+	ch := make(chan prometheus.Metric, 20)
+	cjc.Collect(ch)
+	close(ch)
+	series := 0
+	for metric := range ch {
+		if metric.Desc() == cjc.completed {
+			series++
+		}
+	}
+	// a failed scrape keeps reporting the totals so far instead of resetting the counters
+	assert.Equal(5, series)
+}
+
+func TestNewConfig_CompletedJobs(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{CompletedJobsEnabled: true})
+	assert.NoError(err)
+	assert.Equal(completedJobsCmd(defaultCompletedJobsMinutes), config.cliOpts.completedJobs)
+	assert.Contains(config.cliOpts.debugCommands(), "sacct_completed")
+	config, err = NewConfig(&CliFlags{CompletedJobsMinutes: 60})
+	assert.NoError(err)
+	assert.Contains(config.cliOpts.completedJobs, "--starttime=now-60minutes")
+	_, err = NewConfig(&CliFlags{CompletedJobsMinutes: -1})
+	assert.Error(err)
+}
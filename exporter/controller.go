@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// a slurmctld as reported by scontrol ping
+type ControllerPing struct {
+	Hostname string `json:"hostname"`
+	// UP or DOWN
+	Pinged string `json:"pinged"`
+	// primary, backup, backup2...
+	Mode string `json:"mode"`
+	// only set by newer data_parser versions, preferred over Pinged when present
+	Responding *bool `json:"responding"`
+}
+
+func (cp *ControllerPing) Up() bool {
+	if cp.Responding != nil {
+		return *cp.Responding
+	}
+	return cp.Pinged == "UP"
+}
+
+type scontrolPingResponse struct {
+	Errors []string         `json:"errors"`
+	Pings  []ControllerPing `json:"pings"`
+}
+
+// wraps the first error of a scontrol ping response, so it's counted apart from unparseable output
+var errPingApi = errors.New("scontrol ping api error")
+
+// parses `scontrol ping --json`. Controllers are listed primary first
+func parseControllerPingJson(data []byte) ([]ControllerPing, error) {
+	resp := new(scontrolPingResponse)
+	if err := unmarshalCliJson(data, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		recordApiErrors("scontrol", resp.Errors)
+		return nil, fmt.Errorf("%w: %s", errPingApi, resp.Errors[0])
+	}
+	return resp.Pings, nil
+}
+
+// parses plain `scontrol ping` lines i.e `Slurmctld(primary) at ctl1 is UP`. Other lines, like the
+// `** RESTORE SLURMCTLD DAEMON TO SERVICE **` banner printed while the primary is down, are skipped
+func parseControllerPingCli(data []byte) ([]ControllerPing, error) {
+	var pings []ControllerPing
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var mode, host, state string
+		if _, err := fmt.Sscanf(line, "Slurmctld(%s at %s is %s", &mode, &host, &state); err != nil {
+			continue
+		}
+		pings = append(pings, ControllerPing{Hostname: host, Pinged: state, Mode: strings.TrimSuffix(mode, ")")})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pings) == 0 {
+		return nil, fmt.Errorf("no slurmctld in scontrol ping output %q", strings.TrimSpace(string(data)))
+	}
+	return pings, nil
+}
+
+// reachability of each slurmctld, independent of whether the other collectors parse
+type ControllerCollector struct {
+	scraper        SlurmByteScraper
+	fallback       bool
+	controllerUp   *prometheus.Desc
+	primaryUp      *prometheus.Desc
+	backupUp       *prometheus.Desc
+	scrapeDuration *prometheus.Desc
+	scrapeError    *prometheus.CounterVec
+	// controllers of the last successful ping, reported down while the ping fails
+	mu    sync.Mutex
+	known []string
+}
+
+func NewControllerCollector(config *Config) *ControllerCollector {
+	cliOpts := config.cliOpts
+	return &ControllerCollector{
+		scraper:        cliOpts.scraper("scontrol_ping", cliOpts.ctldPing),
+		fallback:       cliOpts.fallback,
+		controllerUp:   config.NewDesc("slurm_controller_up", "1 when the slurmctld responds to scontrol ping. index 0 is the primary", []string{"host", "index"}, nil),
+		primaryUp:      config.NewDesc("slurm_controller_primary_up", "1 when the primary slurmctld responds to scontrol ping", nil, nil),
+		backupUp:       config.NewDesc("slurm_controller_backup_up", "1 when any backup slurmctld responds to scontrol ping. Absent without a backup", nil, nil),
+		scrapeDuration: config.NewDesc("slurm_controller_ping_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.ctldPing), nil, nil),
+		scrapeError: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_controller_ping_scrape_error",
+			Help: "scontrol ping scrape errors",
+		}),
+	}
+}
+
+func (cc *ControllerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.controllerUp
+	ch <- cc.primaryUp
+	ch <- cc.backupUp
+	ch <- cc.scrapeDuration
+	cc.scrapeError.Describe(ch)
+}
+
+func (cc *ControllerCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.CollectContext(context.Background(), ch)
+}
+
+func (cc *ControllerCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer cc.scrapeError.Collect(ch)
+	data, err := cc.scraper.FetchRawBytes(ctx)
+	ch <- prometheus.MustNewConstMetric(cc.scrapeDuration, prometheus.GaugeValue, float64(cc.scraper.Duration().Milliseconds()))
+	if err != nil {
+		cc.scrapeError.WithLabelValues(fetchErrorReason(err)).Inc()
+		slog.Error(fmt.Sprintf("controller ping fetch error %q", err))
+		cc.collectDown(ch)
+		return
+	}
+	parse := parseControllerPingJson
+	if cc.fallback {
+		parse = parseControllerPingCli
+	}
+	pings, err := parse(data)
+	if errors.Is(err, errPingApi) {
+		cc.scrapeError.WithLabelValues(ScrapeErrorApi).Inc()
+	} else if err != nil {
+		cc.scrapeError.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("controller ping parse error %q", err))
+		cc.collectDown(ch)
+		return
+	}
+	known := make([]string, 0, len(pings))
+	for _, ping := range pings {
+		known = append(known, ping.Hostname)
+	}
+	cc.mu.Lock()
+	cc.known = known
+	cc.mu.Unlock()
+	cc.collectPings(ch, pings)
+}
+
+// a failed ping means no controller could be reached, so report every known controller down
+func (cc *ControllerCollector) collectDown(ch chan<- prometheus.Metric) {
+	cc.mu.Lock()
+	pings := make([]ControllerPing, 0, len(cc.known))
+	for _, host := range cc.known {
+		pings = append(pings, ControllerPing{Hostname: host, Pinged: "DOWN"})
+	}
+	cc.mu.Unlock()
+	cc.collectPings(ch, pings)
+}
+
+func (cc *ControllerCollector) collectPings(ch chan<- prometheus.Metric, pings []ControllerPing) {
+	// scontrol lists the primary first, every following controller is a backup
+	primaryUp, backupUp := 0., 0.
+	for i, ping := range pings {
+		up := 0.
+		if ping.Up() {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(cc.controllerUp, prometheus.GaugeValue, up, ping.Hostname, strconv.Itoa(i))
+		if i == 0 {
+			primaryUp = up
+		} else {
+			backupUp = max(backupUp, up)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(cc.primaryUp, prometheus.GaugeValue, primaryUp)
+	// single controller clusters have no backup to report
+	if len(pings) > 1 {
+		ch <- prometheus.MustNewConstMetric(cc.backupUp, prometheus.GaugeValue, backupUp)
+	}
+}
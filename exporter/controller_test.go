@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// slurm_controller_up keyed by host/index, along with the primary and backup gauges
+func collectControllerUp(t *testing.T, cc *ControllerCollector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		cc.Collect(ch)
+		close(ch)
+	}()
+	up := make(map[string]float64)
+	for m := range ch {
+		metric := new(dto.Metric)
+		assert.NoError(t, m.Write(metric))
+		switch m.Desc() {
+		case cc.primaryUp:
+			up["primary"] = metric.GetGauge().GetValue()
+		case cc.backupUp:
+			up["backup"] = metric.GetGauge().GetValue()
+		case cc.controllerUp:
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			up[labels["host"]+"/"+labels["index"]] = metric.GetGauge().GetValue()
+		}
+	}
+	return up
+}
+
+func TestParseControllerPingJson(t *testing.T) {
+	assert := assert.New(t)
+	data, err := os.ReadFile("fixtures/scontrol_ping.json")
+	assert.NoError(err)
+	pings, err := parseControllerPingJson(data)
+	assert.NoError(err)
+	assert.Len(pings, 2)
+	assert.Equal("slurmctl1", pings[0].Hostname)
+	assert.Equal("primary", pings[0].Mode)
+	assert.True(pings[0].Up())
+	// responding wins over pinged when present
+	pings, err = parseControllerPingJson([]byte(`{"pings": [{"hostname": "slurmctl1", "pinged": "UP", "responding": false, "mode": "primary"}], "errors": []}`))
+	assert.NoError(err)
+	assert.False(pings[0].Up())
+	_, err = parseControllerPingJson([]byte(`{"pings": [], "errors": ["Unable to contact slurm controller"]}`))
+	assert.Error(err)
+}
+
+func TestParseControllerPingCli(t *testing.T) {
+	assert := assert.New(t)
+	data, err := os.ReadFile("fixtures/scontrol_ping.txt")
+	assert.NoError(err)
+	pings, err := parseControllerPingCli(data)
+	assert.NoError(err)
+	assert.Equal([]ControllerPing{
+		{Hostname: "slurmctl1", Pinged: "UP", Mode: "primary"},
+		{Hostname: "slurmctl2", Pinged: "DOWN", Mode: "backup"},
+	}, pings)
+	// the banner printed while the primary is down isn't a controller
+	data, err = os.ReadFile("fixtures/scontrol_ping_primary_down.txt")
+	assert.NoError(err)
+	pings, err = parseControllerPingCli(data)
+	assert.NoError(err)
+	assert.Equal([]ControllerPing{
+		{Hostname: "slurmctl1", Pinged: "DOWN", Mode: "primary"},
+		{Hostname: "slurmctl2", Pinged: "UP", Mode: "backup"},
+	}, pings)
+	_, err = parseControllerPingCli([]byte("slurm_load_ctl_conf error: Unable to contact slurm controller\n"))
+	assert.Error(err)
+}
+
+func TestControllerCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ControllerPingEnabled: true})
+	assert.NoError(err)
+	assert.Equal([]string{"scontrol", "ping", "--json"}, config.cliOpts.debugCommands()["scontrol_ping"])
+	cc := NewControllerCollector(config)
+	cc.scraper = &MockScraper{fixture: "fixtures/scontrol_ping.json"}
+	assert.Equal(map[string]float64{"slurmctl1/0": 1, "slurmctl2/1": 1, "primary": 1, "backup": 1}, collectControllerUp(t, cc))
+	assert.Zero(CollectCounterValue(cc.scrapeError))
+	// a failed ping reports the controllers of the last successful ping down
+	down := map[string]float64{"slurmctl1/0": 0, "slurmctl2/1": 0, "primary": 0, "backup": 0}
+	cc.scraper = &MockScraper{fixture: "fixtures/scontrol_ping.txt"}
+	assert.Equal(down, collectControllerUp(t, cc))
+	assert.Equal(1., CollectCounterValue(cc.scrapeError.WithLabelValues(ScrapeErrorUnmarshal)))
+	// an error response is counted apart from unparseable output
+	cc.scraper = &StringByteScraper{msg: `{"pings": [], "errors": ["Unable to contact slurm controller"]}`}
+	assert.Equal(down, collectControllerUp(t, cc))
+	assert.Equal(1., CollectCounterValue(cc.scrapeError.WithLabelValues(ScrapeErrorApi)))
+	cc.scraper = &MockFetchErrored{}
+	assert.Equal(down, collectControllerUp(t, cc))
+	assert.Equal(3., CollectCounterValue(cc.scrapeError))
+}
+
+func TestControllerCollector_FailedFirstPing(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ControllerPingEnabled: true})
+	assert.NoError(err)
+	cc := NewControllerCollector(config)
+	cc.scraper = &MockFetchErrored{}
+	// no controller is known yet, only the primary is reported down
+	assert.Equal(map[string]float64{"primary": 0}, collectControllerUp(t, cc))
+}
+
+func TestControllerCollector_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ControllerPingEnabled: true, SlurmCliFallback: true})
+	assert.NoError(err)
+	assert.Equal([]string{"scontrol", "ping"}, config.cliOpts.ctldPing)
+	cc := NewControllerCollector(config)
+	cc.scraper = &MockScraper{fixture: "fixtures/scontrol_ping.txt"}
+	assert.Equal(map[string]float64{"slurmctl1/0": 1, "slurmctl2/1": 0, "primary": 1, "backup": 0}, collectControllerUp(t, cc))
+	cc.scraper = &MockScraper{fixture: "fixtures/scontrol_ping_primary_down.txt"}
+	assert.Equal(map[string]float64{"slurmctl1/0": 0, "slurmctl2/1": 1, "primary": 0, "backup": 1}, collectControllerUp(t, cc))
+	assert.Zero(CollectCounterValue(cc.scrapeError))
+}
+
+func TestControllerCollector_BackupDown(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ControllerPingEnabled: true})
+	assert.NoError(err)
+	cc := NewControllerCollector(config)
+	cc.scraper = &MockScraper{fixture: "fixtures/scontrol_ping_backup_down.json"}
+	assert.Equal(map[string]float64{"slurmctl1/0": 1, "slurmctl2/1": 0, "primary": 1, "backup": 0}, collectControllerUp(t, cc))
+}
+
+func TestControllerCollector_SingleController(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ControllerPingEnabled: true, SlurmCliFallback: true})
+	assert.NoError(err)
+	fixture := filepath.Join(t.TempDir(), "scontrol_ping")
+	assert.NoError(os.WriteFile(fixture, []byte("Slurmctld(primary) at slurmctl1 is DOWN\n"), 0o644))
+	cc := NewControllerCollector(config)
+	cc.scraper = &MockScraper{fixture: fixture}
+	// no backup gauge without a backup
+	assert.Equal(map[string]float64{"slurmctl1/0": 0, "primary": 0}, collectControllerUp(t, cc))
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// dcgm-exporter fields joined with slurm context
+const (
+	dcgmPowerField = "DCGM_FI_DEV_POWER_USAGE"
+	dcgmTempField  = "DCGM_FI_DEV_GPU_TEMP"
+)
+
+// a single dcgm-exporter sample of a GPU
+type DcgmGpuMetric struct {
+	Field string
+	Node  string
+	Gpu   string
+	// job from dcgm-exporter's hpc job mapping. Empty when unmapped
+	Job   string
+	Value float64
+}
+
+// splits dcgm-exporter output into exposition documents. The output of several nodes may be
+// concatenated, so a HELP or TYPE line repeating one of the current document starts the next one
+func splitDcgmDocuments(data []byte) [][]byte {
+	var docs [][]byte
+	var doc bytes.Buffer
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if fields := strings.Fields(string(line)); len(fields) >= 3 && fields[0] == "#" && (fields[1] == "HELP" || fields[1] == "TYPE") {
+			key := fields[1] + " " + fields[2]
+			if seen[key] {
+				docs = append(docs, bytes.Clone(doc.Bytes()))
+				doc.Reset()
+				clear(seen)
+			}
+			seen[key] = true
+		}
+		doc.Write(line)
+		doc.WriteByte('\n')
+	}
+	if doc.Len() > 0 {
+		docs = append(docs, doc.Bytes())
+	}
+	return docs
+}
+
+// keeps the power and temp samples of dcgm-exporter's text output. A malformed document is
+// kept up to the line the parser failed on. Returns the count of malformed documents and samples
+func parseDcgmMetrics(data []byte) ([]DcgmGpuMetric, int) {
+	var metrics []DcgmGpuMetric
+	skipped := 0
+	for _, doc := range splitDcgmDocuments(data) {
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(bytes.NewReader(doc))
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to parse dcgm metrics: %q", err))
+			skipped++
+		}
+		for _, field := range []string{dcgmPowerField, dcgmTempField} {
+			family, ok := families[field]
+			if !ok {
+				continue
+			}
+			for _, sample := range family.GetMetric() {
+				// the sample the parser failed on has no value
+				if sample.Gauge == nil && sample.Untyped == nil {
+					continue
+				}
+				labels := make(map[string]string)
+				for _, label := range sample.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["Hostname"] == "" {
+					slog.Error(fmt.Sprintf("dcgm sample %s without Hostname: %s", field, sample))
+					skipped++
+					continue
+				}
+				value := sample.GetGauge().GetValue()
+				if sample.Untyped != nil {
+					value = sample.GetUntyped().GetValue()
+				}
+				metrics = append(metrics, DcgmGpuMetric{
+					Field: field,
+					Node:  labels["Hostname"],
+					Gpu:   labels["gpu"],
+					Job:   labels["hpc_job"],
+					Value: value,
+				})
+			}
+		}
+	}
+	return metrics, skipped
+}
+
+type DcgmFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[DcgmGpuMetric]
+}
+
+func (df *DcgmFetcher) fetch(ctx context.Context) ([]DcgmGpuMetric, error) {
+	data, err := df.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		df.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
+	}
+	metrics, skipped := parseDcgmMetrics(data)
+	df.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Add(float64(skipped))
+	return metrics, nil
+}
+
+func (df *DcgmFetcher) FetchMetrics(ctx context.Context) ([]DcgmGpuMetric, error) {
+	return df.cache.FetchOrThrottle(ctx, df.fetch)
+}
+
+func (df *DcgmFetcher) ScrapeError() *prometheus.CounterVec {
+	return df.errorCounter
+}
+
+func (df *DcgmFetcher) ScrapeDuration() time.Duration {
+	return df.scraper.Duration()
+}
+
+// hardware GPU readings from dcgm-exporter, labeled with the slurm node and job of each GPU.
+// Slurm only knows about allocations, not how hard the GPUs actually run
+type DcgmCollector struct {
+	fetcher SlurmMetricFetcher[DcgmGpuMetric]
+	// running jobs, shared with the job collector
+	jobFetcher         SlurmMetricFetcher[JobMetric]
+	power              *prometheus.Desc
+	temp               *prometheus.Desc
+	nodeSuffixes       NodeSuffixes
+	dcgmScrapeDuration *prometheus.Desc
+	dcgmScrapeError    *prometheus.CounterVec
+}
+
+func NewDcgmCollector(config *Config) *DcgmCollector {
+	cliOpts := config.cliOpts
+	fetcher := &DcgmFetcher{
+		scraper: cliOpts.scraper("dcgm", cliOpts.dcgm),
+		cache:   newCollectorCache[DcgmGpuMetric](config.PollLimit, "dcgm"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_dcgm_scrape_error",
+			Help: "dcgm scrape errors and malformed samples",
+		}),
+	}
+	return &DcgmCollector{
+		fetcher:            fetcher,
+		jobFetcher:         config.TraceConf.sharedFetcher,
+		power:              config.NewDesc("slurm_node_gpu_power_watts", "GPU power draw reported by dcgm-exporter", []string{"node", "gpu", "job"}, nil),
+		temp:               config.NewDesc("slurm_node_gpu_temp_celsius", "GPU temperature reported by dcgm-exporter", []string{"node", "gpu", "job"}, nil),
+		nodeSuffixes:       cliOpts.nodeSuffixes,
+		dcgmScrapeDuration: config.NewDesc("slurm_dcgm_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.dcgm), nil, nil),
+		dcgmScrapeError:    fetcher.ScrapeError(),
+	}
+}
+
+func (dc *DcgmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dc.power
+	ch <- dc.temp
+	ch <- dc.dcgmScrapeDuration
+	dc.dcgmScrapeError.Describe(ch)
+}
+
+func (dc *DcgmCollector) Collect(ch chan<- prometheus.Metric) {
+	dc.CollectContext(context.Background(), ch)
+}
+
+func (dc *DcgmCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer dc.dcgmScrapeError.Collect(ch)
+	metrics, err := dc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(dc.dcgmScrapeDuration, prometheus.GaugeValue, float64(dc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("dcgm fetch error %q", err))
+		return
+	}
+	nodeJobs := dc.runningJobs(ctx)
+	// a GPU scraped twice, i.e a node listed twice in the cmd, would be a duplicate series. The last sample wins
+	type gpuKey struct {
+		field, node, gpu, job string
+	}
+	samples := make(map[gpuKey]float64)
+	hostnames := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		hostnames = append(hostnames, m.Node)
+	}
+	nodeLabels := dc.nodeSuffixes.Labels(hostnames)
+	for _, m := range metrics {
+		node := nodeLabels[m.Node]
+		samples[gpuKey{m.Field, node, m.Gpu, dcgmJob(nodeJobs[node], m.Job)}] = m.Value
+	}
+	for key, value := range samples {
+		desc := dc.power
+		if key.field == dcgmTempField {
+			desc = dc.temp
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, key.node, key.gpu, key.job)
+	}
+}
+
+// ids of the jobs running on each node, keyed by the node label. Nil when squeue fails
+func (dc *DcgmCollector) runningJobs(ctx context.Context) map[string][]string {
+	jobs, err := dc.jobFetcher.FetchMetrics(ctx)
+	if err != nil {
+		slog.Error(fmt.Sprintf("dcgm job join failed, GPUs are reported without jobs: %q", err))
+		return nil
+	}
+	jobNodes := make(map[string][]string)
+	var hostnames []string
+	for _, job := range jobs {
+		if job.JobState != "RUNNING" || job.Nodes == "" {
+			continue
+		}
+		nodes, err := ExpandHostlist(job.Nodes)
+		if err != nil {
+			slog.Error(fmt.Sprintf("dcgm job join failed to expand nodes of job %v: %q", job.JobId, err))
+			continue
+		}
+		jobId := strconv.FormatFloat(job.JobId, 'f', -1, 64)
+		jobNodes[jobId] = nodes
+		hostnames = append(hostnames, nodes...)
+	}
+	nodeLabels := dc.nodeSuffixes.Labels(hostnames)
+	nodeJobs := make(map[string][]string)
+	for jobId, nodes := range jobNodes {
+		for _, node := range nodes {
+			nodeJobs[nodeLabels[node]] = append(nodeJobs[nodeLabels[node]], jobId)
+		}
+	}
+	return nodeJobs
+}
+
+// the slurm job of a GPU. dcgm-exporter's hpc job mapping picks between the jobs of a shared node, but
+// only counts when slurm runs that job on the node. A node running a single job attributes every GPU to it
+func dcgmJob(nodeJobs []string, mapped string) string {
+	if mapped != "" && slices.Contains(nodeJobs, mapped) {
+		return mapped
+	}
+	if len(nodeJobs) == 1 {
+		return nodeJobs[0]
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDcgmDocuments(t *testing.T) {
+	assert := assert.New(t)
+	data, err := os.ReadFile(filepath.Join("fixtures", "dcgm_out.txt"))
+	assert.NoError(err)
+	docs := splitDcgmDocuments(data)
+	assert.Len(docs, 2)
+	assert.Contains(string(docs[0]), `Hostname="gpu01"`)
+	assert.NotContains(string(docs[0]), `Hostname="gpu02"`)
+	assert.Contains(string(docs[1]), `Hostname="gpu02"`)
+	// a single node's output is a single document
+	assert.Len(splitDcgmDocuments(docs[0]), 1)
+}
+
+// the fixture concatenates the output of two nodes
+func TestParseDcgmMetrics(t *testing.T) {
+	assert := assert.New(t)
+	data, err := os.ReadFile(filepath.Join("fixtures", "dcgm_out.txt"))
+	assert.NoError(err)
+	metrics, skipped := parseDcgmMetrics(data)
+	// the sample without Hostname and gpu02's output failing on N/A
+	assert.Equal(2, skipped)
+	assert.Len(metrics, 6)
+	assert.Contains(metrics, DcgmGpuMetric{Field: dcgmPowerField, Node: "gpu02", Gpu: "0", Job: "50580016", Value: 650.5})
+	assert.Contains(metrics, DcgmGpuMetric{Field: dcgmTempField, Node: "gpu01", Gpu: "1", Value: 34})
+}
+
+func TestDcgmCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{DcgmEnabled: true})
+	assert.NoError(err)
+	assert.Contains(config.cliOpts.debugCommands(), "dcgm")
+	dc := NewDcgmCollector(config)
+	dc.fetcher = &DcgmFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/dcgm_out.txt"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[DcgmGpuMetric](1),
+	}
+	dc.jobFetcher = &JobJsonFetcher{
+		scraper: &StringByteScraper{msg: `{"jobs": [
+			{"job_id": 26515966, "job_state": "RUNNING", "nodes": "gpu01"},
+			{"job_id": 50580016, "job_state": "RUNNING", "nodes": "gpu[02-03]"},
+			{"job_id": 50580017, "job_state": "RUNNING", "nodes": "gpu02"},
+			{"job_id": 50580018, "job_state": "PENDING", "nodes": ""}
+		], "errors": []}`},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		dc.Collect(ch)
+		close(ch)
+	}()
+	power := make(map[string]float64)
+	temps := 0
+	for metric := range ch {
+		m := new(dto.Metric)
+		assert.NoError(metric.Write(m))
+		switch metric.Desc() {
+		case dc.power:
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			power[labels["node"]+"/"+labels["gpu"]+"/"+labels["job"]] = m.GetGauge().GetValue()
+		case dc.temp:
+			temps++
+		}
+	}
+	assert.Equal(map[string]float64{
+		"gpu01/0/26515966": 312.457,
+		// unmapped, but gpu01 runs a single job
+		"gpu01/1/26515966": 61.2,
+		"gpu02/0/50580016": 650.5,
+	}, power)
+	assert.Equal(3, temps)
+	assert.Equal(2., CollectCounterValue(dc.fetcher.ScrapeError()))
+}
+
+func TestDcgmJob(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("1", dcgmJob([]string{"1"}, ""))
+	assert.Equal("1", dcgmJob([]string{"1"}, "2"))
+	assert.Equal("2", dcgmJob([]string{"1", "2"}, "2"))
+	// a shared node without a mapping, or a mapping slurm doesn't confirm
+	assert.Equal("", dcgmJob([]string{"1", "2"}, ""))
+	assert.Equal("", dcgmJob([]string{"1", "2"}, "3"))
+	// squeue failed
+	assert.Equal("", dcgmJob(nil, "2"))
+}
@@ -5,6 +5,7 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -100,14 +101,14 @@ func (sr *SdiagResponse) IsDataParserPlugin() bool {
 
 func parseDiagMetrics(sdiagResp []byte) (*SdiagResponse, error) {
 	sdiag := new(SdiagResponse)
-	err := json.Unmarshal(sdiagResp, sdiag)
+	err := unmarshalCliJson(sdiagResp, sdiag)
 	return sdiag, err
 }
 
 type DiagnosticsCollector struct {
 	// collector state
 	fetcher            SlurmByteScraper
-	diagScrapeError    prometheus.Counter
+	diagScrapeError    *prometheus.CounterVec
 	diagScrapeDuration *prometheus.Desc
 	// user rpc metrics
 	slurmUserRpcCount     *prometheus.Desc
@@ -129,24 +130,24 @@ type DiagnosticsCollector struct {
 func NewDiagsCollector(config *Config) *DiagnosticsCollector {
 	cliOpts := config.cliOpts
 	return &DiagnosticsCollector{
-		fetcher:                        NewCliScraper(config.cliOpts.sdiag...),
-		slurmUserRpcCount:              prometheus.NewDesc("slurm_rpc_user_count", "slurm rpc count per user", []string{"user"}, nil),
-		slurmUserRpcTotalTime:          prometheus.NewDesc("slurm_rpc_user_total_time", "slurm rpc avg time per user", []string{"user"}, nil),
-		slurmTypeRpcCount:              prometheus.NewDesc("slurm_rpc_msg_type_count", "slurm rpc count per message type", []string{"type"}, nil),
-		slurmTypeRpcAvgTime:            prometheus.NewDesc("slurm_rpc_msg_type_avg_time", "slurm rpc total time consumed per message type", []string{"type"}, nil),
-		slurmTypeRpcTotalTime:          prometheus.NewDesc("slurm_rpc_msg_type_total_time", "slurm rpc avg time per message type", []string{"type"}, nil),
-		slurmCtlThreadCount:            prometheus.NewDesc("slurm_daemon_thread_count", "slurm daemon thread count", nil, nil),
-		slurmDbdAgentQueueSize:         prometheus.NewDesc("slurm_dbd_agent_queue_size", "slurmDbd queue size. Number of threads interacting with SlrumDBD. Will grow rapidly if DB is down or under stress", nil, nil),
-		slurmBackfillJobCount:          prometheus.NewDesc("slurm_backfill_job_count", "slurm number of jobs started thanks to backfilling since last slurm start", nil, nil),
-		slurmBackfillCycleCount:        prometheus.NewDesc("slurm_backfill_cycle_count", "slurm number of Number of backfill scheduling cycles since last reset", nil, nil),
-		slurmBackfillLastDepth:         prometheus.NewDesc("slurm_backfill_last_depth", "slurm number of processed jobs during last backfilling scheduling cycle. It counts every job even if that job can not be started due to dependencies or limits", nil, nil),
-		slurmBackfillLastDepthTrySched: prometheus.NewDesc("slurm_backfill_last_depth_try_sched", "slurm number of processed jobs during last backfilling scheduling cycle. It counts only jobs with a chance to start using available resources", nil, nil),
-		slurmBackfillCycleCounter:      prometheus.NewDesc("slurm_backfill_cycle_counter", "slurm number of backfill scheduling cycles since last reset", nil, nil),
-		diagScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
+		fetcher:                        cliOpts.scraper("sdiag", cliOpts.sdiag),
+		slurmUserRpcCount:              config.NewDesc("slurm_rpc_user_count", "slurm rpc count per user", []string{"user"}, nil),
+		slurmUserRpcTotalTime:          config.NewDesc("slurm_rpc_user_total_time", "slurm rpc avg time per user", []string{"user"}, nil),
+		slurmTypeRpcCount:              config.NewDesc("slurm_rpc_msg_type_count", "slurm rpc count per message type", []string{"type"}, nil),
+		slurmTypeRpcAvgTime:            config.NewDesc("slurm_rpc_msg_type_avg_time", "slurm rpc total time consumed per message type", []string{"type"}, nil),
+		slurmTypeRpcTotalTime:          config.NewDesc("slurm_rpc_msg_type_total_time", "slurm rpc avg time per message type", []string{"type"}, nil),
+		slurmCtlThreadCount:            config.NewDesc("slurm_daemon_thread_count", "slurm daemon thread count", nil, nil),
+		slurmDbdAgentQueueSize:         config.NewDesc("slurm_dbd_agent_queue_size", "slurmDbd queue size. Number of threads interacting with SlrumDBD. Will grow rapidly if DB is down or under stress", nil, nil),
+		slurmBackfillJobCount:          config.NewDesc("slurm_backfill_job_count", "slurm number of jobs started thanks to backfilling since last slurm start", nil, nil),
+		slurmBackfillCycleCount:        config.NewDesc("slurm_backfill_cycle_count", "slurm number of Number of backfill scheduling cycles since last reset", nil, nil),
+		slurmBackfillLastDepth:         config.NewDesc("slurm_backfill_last_depth", "slurm number of processed jobs during last backfilling scheduling cycle. It counts every job even if that job can not be started due to dependencies or limits", nil, nil),
+		slurmBackfillLastDepthTrySched: config.NewDesc("slurm_backfill_last_depth_try_sched", "slurm number of processed jobs during last backfilling scheduling cycle. It counts only jobs with a chance to start using available resources", nil, nil),
+		slurmBackfillCycleCounter:      config.NewDesc("slurm_backfill_cycle_counter", "slurm number of backfill scheduling cycles since last reset", nil, nil),
+		diagScrapeError: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "slurm_diag_scrape_error",
 			Help: "slurm diag scrape erro",
 		}),
-		diagScrapeDuration: prometheus.NewDesc("slurm_diag_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sdiag), nil, nil),
+		diagScrapeDuration: config.NewDesc("slurm_diag_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sdiag), nil, nil),
 	}
 }
 
@@ -164,28 +165,33 @@ func (sc *DiagnosticsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- sc.slurmBackfillLastDepth
 	ch <- sc.slurmBackfillLastDepthTrySched
 	ch <- sc.slurmBackfillCycleCounter
-	ch <- sc.diagScrapeError.Desc()
+	sc.diagScrapeError.Describe(ch)
 }
 
 func (sc *DiagnosticsCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- sc.diagScrapeError
-	}()
-	sdiag, err := sc.fetcher.FetchRawBytes()
+	sc.CollectContext(context.Background(), ch)
+}
+
+func (sc *DiagnosticsCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer sc.diagScrapeError.Collect(ch)
+	sdiag, err := sc.fetcher.FetchRawBytes(ctx)
 	if err != nil {
-		sc.diagScrapeError.Inc()
+		sc.diagScrapeError.WithLabelValues(fetchErrorReason(err)).Inc()
 		slog.Error(fmt.Sprintf("sdiag fetch error %q", err))
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(sc.diagScrapeDuration, prometheus.GaugeValue, float64(sc.fetcher.Duration().Abs().Milliseconds()))
 	sdiagResponse, err := parseDiagMetrics(sdiag)
 	if err != nil {
-		sc.diagScrapeError.Inc()
+		sc.diagScrapeError.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		slog.Error(fmt.Sprintf("diag parse error: %q", err))
 		return
 	}
+	if len(sdiagResponse.Errors) > 0 {
+		recordApiErrors("sdiag", sdiagResponse.Errors)
+	}
 	if !sdiagResponse.IsDataParserPlugin() {
-		sc.diagScrapeError.Inc()
+		sc.diagScrapeError.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		slog.Error("only the data_parser plugin is supported")
 		return
 	}
@@ -5,6 +5,7 @@
 package exporter
 
 import (
+	"context"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,7 +15,7 @@ import (
 func TestParseDiagJson(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
@@ -80,7 +81,7 @@ func TestDiagDescribe(t *testing.T) {
 func TestDataParserVersionDiscovery_Slurm23(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
@@ -90,7 +91,7 @@ func TestDataParserVersionDiscovery_Slurm23(t *testing.T) {
 func TestDataParserVersionDiscovery_Slurm24(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := MockScraper{fixture: "fixtures/sdiag_2405.json"}
-	sdiag, err := fetcher.FetchRawBytes()
+	sdiag, err := fetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	resp, err := parseDiagMetrics(sdiag)
 	assert.NoError(err)
@@ -2,10 +2,13 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,51 +24,406 @@ type GpuMetrics struct {
 	Idle        float64
 	Total       float64
 	Utilization float64
+	// idle GPUs on down, drained or failing nodes, which can't be allocated
+	Unavailable float64
+	// GPUs requested by pending jobs, array jobs count once per pending task
+	RequestedPending float64
+	// sacct alloc - squeue alloc. Only populated when the squeue cross check is enabled
+	AllocDiscrepancy float64
+	// allocated GPUs per node, a job's GPUs are split evenly across its NodeList
+	NodeAlloc map[string]float64
+	// configured GPUs per node. Empty when the sinfo output doesn't identify nodes
+	NodeTotal map[string]float64
+	// allocated GPUs per running job. Only populated when per job collection is enabled
+	JobAlloc []JobGpuAlloc
+	// set when sinfo failed but the alloc cmd didn't, totals and everything derived from them are unknown
+	TotalStale bool
+	// set when the alloc cmd failed but sinfo didn't, allocations and everything derived from them are unknown
+	AllocStale bool
+	// set when the pending cmd failed, RequestedPending is unknown
+	PendingStale bool
+	// set when the squeue cross check failed, AllocDiscrepancy is unknown
+	DiscrepancyStale bool
+	// per gres type i.e a100, see addGpuTypes for untyped GPUs
+	TypeTotal map[string]float64
+	TypeAlloc map[string]float64
+	TypeIdle  map[string]float64
+}
+
+type JobGpuAlloc struct {
+	JobId string
+	User  string
+	Gpus  float64
+}
+
+// sinfo and sacct aren't queried atomically, so alloc can momentarily exceed total.
+// clamp idle to 0 so it never goes negative
+func newGpuMetrics(totalGpus float64, allocGpus float64) *GpuMetrics {
+	utilization := 0.0
+	if totalGpus > 0 {
+		utilization = allocGpus / totalGpus
+	}
+	return &GpuMetrics{
+		Alloc:       allocGpus,
+		Idle:        math.Max(0, totalGpus-allocGpus),
+		Total:       totalGpus,
+		Utilization: utilization,
+	}
+}
+
+// with the available basis, unavailable GPUs are dropped from the utilization denominator
+// so draining a node doesn't read as a drop in utilization
+func (gm *GpuMetrics) setUnavailable(unavailable float64, basis string) {
+	gm.Unavailable = unavailable
+	if basis != gpuUtilizationBasisAvailable {
+		return
+	}
+	gm.Utilization = 0
+	if available := gm.Total - unavailable; available > 0 {
+		gm.Utilization = gm.Alloc / available
+	}
+}
+
+// builds the metrics from whichever of sinfo and the alloc cmd succeeded, marking the other stale.
+// Fails only when both did
+func newPartialGpuMetrics(counts *sinfoGpuCounts, totalErr error, typeAlloc map[string]float64, nodeAlloc map[string]float64, allocErr error, basis string) (*GpuMetrics, error) {
+	if totalErr != nil && allocErr != nil {
+		return nil, errors.Join(totalErr, allocErr)
+	}
+	if totalErr != nil {
+		slog.Error(fmt.Sprintf("GPU totals are stale, sinfo failed: %q", totalErr))
+		counts = newSinfoGpuCounts()
+	}
+	if allocErr != nil {
+		slog.Error(fmt.Sprintf("GPU allocations are stale, the alloc cmd failed: %q", allocErr))
+		typeAlloc, nodeAlloc = make(map[string]float64), make(map[string]float64)
+	}
+	metrics := newGpuMetrics(sumGpuTypes(counts.typeTotal), sumGpuTypes(typeAlloc))
+	metrics.setTypes(counts.typeTotal, typeAlloc)
+	metrics.setUnavailable(counts.unavailableGpus(nodeAlloc), basis)
+	metrics.NodeAlloc = nodeAlloc
+	metrics.NodeTotal = counts.nodeTotal
+	metrics.TotalStale = totalErr != nil
+	metrics.AllocStale = allocErr != nil
+	return metrics, nil
+}
+
+// idle per type is clamped to 0 like the overall idle. Types only seen in allocations
+// i.e untyped allocations on typed nodes, are reported with a total of 0
+func (gm *GpuMetrics) setTypes(typeTotal map[string]float64, typeAlloc map[string]float64) {
+	gm.TypeTotal = typeTotal
+	gm.TypeAlloc = typeAlloc
+	gm.TypeIdle = make(map[string]float64)
+	for gpuType, total := range typeTotal {
+		gm.TypeIdle[gpuType] = math.Max(0, total-typeAlloc[gpuType])
+	}
+	for gpuType := range typeAlloc {
+		if _, ok := typeTotal[gpuType]; !ok {
+			gm.TypeTotal[gpuType] = 0
+			gm.TypeIdle[gpuType] = 0
+		}
+	}
 }
 
 // GPU response structures for JSON API
 type sinfoGpuNode struct {
-	Gres string `json:"gres"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Gres     string `json:"gres"`
+	State    string `json:"state"`
+	// gres currently allocated i.e "gpu:a100:3(IDX:0-2)"
+	GresUsed string `json:"gres_used"`
+}
+
+// job nodelists refer to the NodeName, which only older responses may omit
+func (node *sinfoGpuNode) nodeName() string {
+	if node.Name != "" {
+		return node.Name
+	}
+	return node.Hostname
+}
+
+// per type & per node GPU counts parsed from sinfo
+type sinfoGpuCounts struct {
+	typeTotal map[string]float64
+	nodeTotal map[string]float64
+	// in use according to gres_used
+	typeUsed map[string]float64
+	nodeUsed map[string]float64
+	// down, drained or failing nodes with GPUs
+	unavailable map[string]bool
+}
+
+func newSinfoGpuCounts() *sinfoGpuCounts {
+	return &sinfoGpuCounts{
+		typeTotal:   make(map[string]float64),
+		nodeTotal:   make(map[string]float64),
+		typeUsed:    make(map[string]float64),
+		nodeUsed:    make(map[string]float64),
+		unavailable: make(map[string]bool),
+	}
+}
+
+// idle GPUs of the unavailable nodes. Draining nodes still run jobs, their allocated GPUs aren't unavailable
+func (counts *sinfoGpuCounts) unavailableGpus(nodeAlloc map[string]float64) float64 {
+	unavailable := 0.0
+	for node := range counts.unavailable {
+		unavailable += math.Max(0, counts.nodeTotal[node]-nodeAlloc[node])
+	}
+	return unavailable
 }
 
 type sinfoGpuResponse struct {
 	Meta struct {
-		SlurmVersion struct {
-			Version struct {
-				Major int `json:"major"`
-				Micro int `json:"micro"`
-				Minor int `json:"minor"`
-			} `json:"version"`
-			Release string `json:"release"`
-		} `json:"Slurm"`
+		SlurmVersion SlurmVersion `json:"Slurm"`
 	} `json:"meta"`
 	Errors []string       `json:"errors"`
 	Nodes  []sinfoGpuNode `json:"nodes"`
 }
 
+type sacctTres struct {
+	Type  string     `json:"type"`
+	Name  string     `json:"name"`
+	Count CoercedInt `json:"count"`
+}
+
 type sacctGpuJob struct {
-	AllocGRES string `json:"allocated_gres"`
+	AllocGRES string     `json:"allocated_gres"`
+	Nodes     string     `json:"nodes"`
+	JobId     CoercedInt `json:"job_id"`
+	User      string     `json:"user"`
+	// 23.11+ reports the allocation here and may leave allocated_gres empty
+	Tres struct {
+		Allocated []sacctTres `json:"allocated"`
+		Requested []sacctTres `json:"requested"`
+	} `json:"tres"`
 }
 
-type sacctGpuResponse struct {
-	Meta struct {
-		SlurmVersion struct {
-			Version struct {
-				Major int `json:"major"`
-				Micro int `json:"micro"`
-				Minor int `json:"minor"`
-			} `json:"version"`
-			Release string `json:"release"`
-		} `json:"Slurm"`
-	} `json:"meta"`
-	Errors []string      `json:"errors"`
-	Jobs   []sacctGpuJob `json:"jobs"`
+// gres resource counted as GPUs unless overridden i.e sites exposing nvidia_gpu
+const defaultGpuGresName = "gpu"
+
+// type label of GPUs without a gres type when no default type is configured
+const untypedGpuType = "untyped"
+
+// fallback records dropped because they don't match the expected format, i.e a misconfigured -O/-o override
+var gpuParseSkippedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "slurm_gpu_parse_skipped_total",
+	Help: "malformed fallback GPU records skipped while parsing",
+}, []string{"command"})
+
+// gres|nodelist|jobid|user|nodecount
+const maxSacctGpuFields = 5
+
+// denominators of slurm_gpus_utilization
+const (
+	gpuUtilizationBasisTotal     = "total"
+	gpuUtilizationBasisAvailable = "available"
+)
+
+// label values of slurm_gpus_stale
+const (
+	gpuStaleTotal   = "total"
+	gpuStaleAlloc   = "alloc"
+	gpuStalePending = "pending"
+	// the squeue cross check, see AllocDiscrepancy
+	gpuStaleDiscrepancy = "alloc_discrepancy"
+)
+
+// sources of the allocated GPU count in json mode
+const (
+	gpuAllocSourceSacct    = "sacct"
+	gpuAllocSourceGresUsed = "gres_used"
+)
+
+// job states holding allocated GPUs unless overridden
+const defaultGpuAllocStates = "RUNNING"
+
+// job states that released their GPUs. Counting them over-counts allocations
+var terminalJobStates = []string{"BOOT_FAIL", "CANCELLED", "COMPLETED", "DEADLINE", "FAILED", "NODE_FAIL", "OUT_OF_MEMORY", "PREEMPTED", "TIMEOUT"}
+
+// normalizes comma separated job states i.e "running, completing" into a sacct --state or squeue -t filter
+func parseGpuAllocStates(states string) (string, error) {
+	parsed, err := parseJobStates(states)
+	if err != nil {
+		return "", fmt.Errorf("GPU alloc states: %w", err)
+	}
+	for _, state := range parsed {
+		if slices.Contains(terminalJobStates, state) {
+			slog.Warn(fmt.Sprintf("GPU alloc state %s is terminal, its jobs no longer hold GPUs and are over-counted", state))
+		}
+	}
+	if len(parsed) == 0 {
+		return defaultGpuAllocStates, nil
+	}
+	return strings.Join(parsed, ","), nil
+}
+
+// slurm lists typed gpus i.e gres/gpu:a100 next to the untyped gres/gpu total.
+// Prefer the total and only sum the typed entries when it's missing
+func parseTresGpuCount(tres []sacctTres, name string) float64 {
+	return sumGpuTypes(parseTresGpuTypes(tres, name))
+}
+
+// per type breakdown of parseTresGpuCount. GPUs the typed entries don't account for are keyed by ""
+func parseTresGpuTypes(tres []sacctTres, name string) map[string]float64 {
+	if name == "" {
+		name = defaultGpuGresName
+	}
+	counter := newTresGpuCounter()
+	for _, t := range tres {
+		if t.Type != "gres" {
+			continue
+		}
+		resource, gpuType, _ := strings.Cut(t.Name, ":")
+		if strings.EqualFold(resource, name) {
+			counter.add(gpuType, float64(t.Count))
+		}
+	}
+	return counter.types()
+}
+
+// tres list the untyped gpu total alongside the typed breakdown rather than in addition to it
+type tresGpuCounter struct {
+	total    float64
+	hasTotal bool
+	typed    map[string]float64
+}
+
+func newTresGpuCounter() *tresGpuCounter {
+	return &tresGpuCounter{typed: make(map[string]float64)}
+}
+
+func (tc *tresGpuCounter) add(gpuType string, count float64) {
+	if gpuType == "" {
+		tc.total = count
+		tc.hasTotal = true
+		return
+	}
+	tc.typed[gpuType] += count
+}
+
+func (tc *tresGpuCounter) types() map[string]float64 {
+	if untyped := tc.total - sumGpuTypes(tc.typed); tc.hasTotal && untyped > 0 {
+		tc.typed[""] = untyped
+	}
+	return tc.typed
+}
+
+func sumGpuTypes(types map[string]float64) float64 {
+	total := 0.0
+	for _, count := range types {
+		total += count
+	}
+	return total
+}
+
+// untyped GPUs are reported under defaultType, or "untyped" when it isn't configured
+func addGpuTypes(dst map[string]float64, src map[string]float64, defaultType string) {
+	for gpuType, count := range src {
+		if gpuType == "" {
+			gpuType = defaultType
+		}
+		if gpuType == "" {
+			gpuType = untypedGpuType
+		}
+		dst[gpuType] += count
+	}
+}
+
+func (job *sacctGpuJob) allocatedGpuTypes(name string) map[string]float64 {
+	if job.AllocGRES != "" {
+		return parseGresGpuTypes(job.AllocGRES, name)
+	}
+	return parseTresGpuTypes(job.Tres.Allocated, name)
+}
+
+// nodes of a job's nodelist, none for jobs without assigned nodes
+func expandJobNodes(nodelist string) ([]string, error) {
+	if nodelist == "" || nodelist == "None assigned" || nodelist == "(null)" {
+		return nil, nil
+	}
+	return ExpandHostlist(nodelist)
+}
+
+// splits gpus evenly across the expanded nodelist. Jobs without assigned nodes are skipped
+func addNodeGpuAlloc(nodeAlloc map[string]float64, nodelist string, gpus float64) error {
+	if gpus == 0 {
+		return nil
+	}
+	nodes, err := expandJobNodes(nodelist)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		nodeAlloc[node] += gpus / float64(len(nodes))
+	}
+	return nil
+}
+
+type squeueGpuJob struct {
+	TresAlloc string `json:"tres_alloc_str"`
+	TresReq   string `json:"tres_req_str"`
+	// pending array tasks that haven't been split off yet i.e "1-10%2"
+	ArrayTaskString string `json:"array_task_string"`
+}
+
+// number of tasks a pending job record represents. Array ranges may be stepped i.e "1-9:2"
+// and throttled i.e "1-10%2", the throttle doesn't change the pending demand
+func arrayTaskCount(taskString string) float64 {
+	taskString, _, _ = strings.Cut(taskString, "%")
+	if taskString == "" {
+		return 1
+	}
+	count := 0.0
+	for _, r := range strings.Split(taskString, ",") {
+		r, stepStr, hasStep := strings.Cut(r, ":")
+		lo, hi, isRange := strings.Cut(r, "-")
+		if !isRange {
+			count++
+			continue
+		}
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return 1
+		}
+		end, err := strconv.Atoi(hi)
+		if err != nil || end < start {
+			return 1
+		}
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 1
+			}
+		}
+		count += float64((end-start)/step + 1)
+	}
+	return count
+}
+
+type squeueGpuResponse struct {
+	Errors []string       `json:"errors"`
+	Jobs   []squeueGpuJob `json:"jobs"`
 }
 
 type GpuJsonFetcher struct {
 	sinfoScraper SlurmByteScraper
 	sacctScraper SlurmByteScraper
-	errorCounter prometheus.Counter
+	// optional, cross checks the sacct allocation against squeue's allocated TRES
+	squeueScraper SlurmByteScraper
+	// optional, pending jobs' requested GPUs
+	pendingScraper SlurmByteScraper
+	// retain per job allocations. High cardinality
+	perJob bool
+	// read allocations from sinfo's gres_used instead of sacct. No per job allocations
+	gresUsedAlloc bool
+	// total or available, the utilization denominator
+	utilBasis string
+	// gres resource name counted as GPUs, defaults to gpu
+	gresName string
+	// type label of untyped GPUs, defaults to untyped
+	defaultType  string
+	errorCounter *prometheus.CounterVec
 	cache        *gpuCache
 }
 
@@ -75,113 +433,278 @@ type gpuCache struct {
 	limit    float64
 	cache    *GpuMetrics
 	duration time.Duration
+	// see newJitterFactor
+	jitter float64
+	// ewma smoothing factor for utilization. 0 disables smoothing
+	alpha float64
+	// nil until the first utilization has been observed
+	smoothedUtilization *float64
+	// see AtomicThrottledCache
+	served prometheus.Gauge
 }
 
-func (gmf *GpuJsonFetcher) fetch() (*GpuMetrics, error) {
-	totalGpus, err := gmf.fetchTotalGpus()
-	if err != nil {
-		return nil, err
+func (gc *gpuCache) Get() (*GpuMetrics, bool) {
+	return gc.cache, gc.cache != nil && gc.Age().Seconds() < jitteredLimit(gc.limit, gc.jitter)
+}
+
+func (gc *gpuCache) Set(metrics *GpuMetrics) {
+	gc.cache = metrics
+	gc.t = time.Now()
+}
+
+func (gc *gpuCache) Age() time.Duration {
+	return time.Since(gc.t)
+}
+
+// atomic fetch of either the cache or fetchFunc, see AtomicThrottledCache
+func (gc *gpuCache) FetchOrThrottle(ctx context.Context, fetchFunc func(context.Context) (*GpuMetrics, error)) (*GpuMetrics, error) {
+	gc.Lock()
+	defer gc.Unlock()
+	return fetchOrThrottle[*GpuMetrics](ctx, gc, &gc.duration, gc.served, fetchFunc)
+}
+
+// blends utilization into the running ewma. Callers must hold the lock
+func (gc *gpuCache) smooth(utilization float64) float64 {
+	if gc.alpha <= 0 {
+		return utilization
+	}
+	if gc.smoothedUtilization != nil {
+		utilization = gc.alpha*utilization + (1-gc.alpha)*(*gc.smoothedUtilization)
 	}
+	gc.smoothedUtilization = &utilization
+	return utilization
+}
 
-	allocGpus, err := gmf.fetchAllocatedGpus()
-	if err != nil {
-		return nil, err
+func (gmf *GpuJsonFetcher) fetch(ctx context.Context) (*GpuMetrics, error) {
+	counts, totalErr := gmf.fetchSinfoGpus(ctx)
+	if totalErr != nil && gmf.gresUsedAlloc {
+		// allocations come from the same sinfo output
+		return nil, totalErr
 	}
 
-	idleGpus := totalGpus - allocGpus
-	utilization := 0.0
-	if totalGpus > 0 {
-		utilization = allocGpus / totalGpus
+	var typeAlloc, nodeAlloc map[string]float64
+	var jobAlloc []JobGpuAlloc
+	var allocErr error
+	if gmf.gresUsedAlloc {
+		typeAlloc, nodeAlloc = counts.typeUsed, counts.nodeUsed
+	} else {
+		typeAlloc, nodeAlloc, jobAlloc, allocErr = gmf.fetchAllocatedGpus(ctx)
 	}
 
-	metrics := &GpuMetrics{
-		Alloc:       allocGpus,
-		Idle:        idleGpus,
-		Total:       totalGpus,
-		Utilization: utilization,
+	metrics, err := newPartialGpuMetrics(counts, totalErr, typeAlloc, nodeAlloc, allocErr, gmf.utilBasis)
+	if err != nil {
+		return nil, err
+	}
+	if !metrics.TotalStale && !metrics.AllocStale {
+		metrics.Utilization = gmf.cache.smooth(metrics.Utilization)
+	}
+	metrics.JobAlloc = jobAlloc
+	if gmf.pendingScraper != nil {
+		if metrics.RequestedPending, err = gmf.fetchPendingGpus(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Failed to fetch pending GPUs: %q", err))
+			metrics.PendingStale = true
+		}
+	}
+	// like the pending GPUs, a failed cross check only drops the discrepancy
+	if gmf.squeueScraper != nil && !metrics.AllocStale {
+		if squeueAllocGpus, err := gmf.fetchSqueueAllocatedGpus(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Failed to cross check allocated GPUs: %q", err))
+			metrics.DiscrepancyStale = true
+		} else {
+			metrics.AllocDiscrepancy = metrics.Alloc - squeueAllocGpus
+		}
 	}
 
 	return metrics, nil
 }
 
-func (gmf *GpuJsonFetcher) fetchTotalGpus() (float64, error) {
+func (gmf *GpuJsonFetcher) fetchSinfoGpus(ctx context.Context) (*sinfoGpuCounts, error) {
 	sinfoResp := new(sinfoGpuResponse)
-	cliJson, err := gmf.sinfoScraper.FetchRawBytes()
+	cliJson, err := gmf.sinfoScraper.FetchRawBytes(ctx)
 	if err != nil {
-		return 0, err
+		gmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
 	}
 
-	if err := json.Unmarshal(cliJson, sinfoResp); err != nil {
+	detectSchemaVersion("sinfo", cliJson)
+	if err := unmarshalCliJson(cliJson, sinfoResp); err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling sinfo GPU metrics: %q", err))
-		return 0, err
+		gmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, err
 	}
 
 	if len(sinfoResp.Errors) > 0 {
+		recordApiErrors("sinfo", sinfoResp.Errors)
 		for _, e := range sinfoResp.Errors {
 			slog.Error(fmt.Sprintf("sinfo API error response: %q", e))
 		}
-		gmf.errorCounter.Add(float64(len(sinfoResp.Errors)))
-		return 0, errors.New(sinfoResp.Errors[0])
+		gmf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(sinfoResp.Errors)))
+		return nil, errors.New(sinfoResp.Errors[0])
 	}
 
-	totalGpus := 0.0
+	counts := newSinfoGpuCounts()
 	for _, node := range sinfoResp.Nodes {
-		gpuCount := parseGresGpuCount(node.Gres)
-		totalGpus += gpuCount
+		counts.addNode(&node, gmf.gresName, gmf.defaultType)
 	}
 
-	return totalGpus, nil
+	return counts, nil
 }
 
-func (gmf *GpuJsonFetcher) fetchAllocatedGpus() (float64, error) {
-	sacctResp := new(sacctGpuResponse)
-	cliJson, err := gmf.sacctScraper.FetchRawBytes()
+func (counts *sinfoGpuCounts) addNode(node *sinfoGpuNode, gresName string, defaultType string) {
+	totalTypes := parseGresGpuTypes(node.Gres, gresName)
+	addGpuTypes(counts.typeTotal, totalTypes, defaultType)
+	if total := sumGpuTypes(totalTypes); total > 0 {
+		counts.nodeTotal[node.nodeName()] += total
+		if (&NodeMetric{State: node.State}).Unavailable() {
+			counts.unavailable[node.nodeName()] = true
+		}
+	}
+	usedTypes := parseGresGpuTypes(node.GresUsed, gresName)
+	addGpuTypes(counts.typeUsed, usedTypes, defaultType)
+	if used := sumGpuTypes(usedTypes); used > 0 {
+		counts.nodeUsed[node.nodeName()] += used
+	}
+}
+
+// per node GRES read outside the slurm cli, i.e by the native libslurm fetcher
+type GpuGresNode struct {
+	Name     string
+	Gres     string
+	GresUsed string
+	State    string
+}
+
+// GPU metrics from per node gres and gres_used, like -slurm.gpu-alloc-source gres_used.
+// There are no per job allocations or pending GPUs
+func NewGresGpuMetrics(config *Config, nodes []GpuGresNode) *GpuMetrics {
+	cliOpts := config.cliOpts
+	counts := newSinfoGpuCounts()
+	for _, node := range nodes {
+		counts.addNode(&sinfoGpuNode{Name: node.Name, Gres: node.Gres, GresUsed: node.GresUsed, State: node.State}, cliOpts.gpuGresName, cliOpts.gpuDefaultType)
+	}
+	metrics := newGpuMetrics(sumGpuTypes(counts.typeTotal), sumGpuTypes(counts.typeUsed))
+	metrics.setTypes(counts.typeTotal, counts.typeUsed)
+	metrics.setUnavailable(counts.unavailableGpus(counts.nodeUsed), cliOpts.gpuUtilizationBasis)
+	metrics.NodeAlloc = counts.nodeUsed
+	metrics.NodeTotal = counts.nodeTotal
+	return metrics
+}
+
+func (gmf *GpuJsonFetcher) fetchAllocatedGpus(ctx context.Context) (map[string]float64, map[string]float64, []JobGpuAlloc, error) {
+	cliJson, err := gmf.sacctScraper.FetchRawBytes(ctx)
 	if err != nil {
-		return 0, err
+		gmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, nil, nil, err
 	}
 
-	if err := json.Unmarshal(cliJson, sacctResp); err != nil {
+	detectSchemaVersion("sacct", cliJson)
+	// sacct lists every running job, so jobs are streamed and only their GPU counts are kept
+	typeAlloc := make(map[string]float64)
+	nodeAlloc := make(map[string]float64)
+	var jobAlloc []JobGpuAlloc
+	var nodeErrors float64
+	apiErrors, found, err := streamCliJsonArray(cliJson, "jobs", func(job *sacctGpuJob) {
+		jobTypes := job.allocatedGpuTypes(gmf.gresName)
+		addGpuTypes(typeAlloc, jobTypes, gmf.defaultType)
+		gpuCount := sumGpuTypes(jobTypes)
+		if err := addNodeGpuAlloc(nodeAlloc, job.Nodes, gpuCount); err != nil {
+			slog.Error(fmt.Sprintf("failed to attribute GPUs to nodes %q: %q", job.Nodes, err))
+			nodeErrors++
+		}
+		if gmf.perJob && gpuCount > 0 {
+			jobAlloc = append(jobAlloc, JobGpuAlloc{JobId: strconv.Itoa(int(job.JobId)), User: job.User, Gpus: gpuCount})
+		}
+	})
+	if err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling sacct GPU metrics: %q", err))
-		return 0, err
+		gmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, nil, nil, err
 	}
 
-	if len(sacctResp.Errors) > 0 {
-		for _, e := range sacctResp.Errors {
+	// errors may follow the jobs, so the streamed counts are only kept once the whole response is read
+	if len(apiErrors) > 0 {
+		recordApiErrors("sacct", apiErrors)
+		for _, e := range apiErrors {
 			slog.Error(fmt.Sprintf("sacct API error response: %q", e))
 		}
-		gmf.errorCounter.Add(float64(len(sacctResp.Errors)))
-		return 0, errors.New(sacctResp.Errors[0])
+		gmf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(apiErrors)))
+		return nil, nil, nil, errors.New(apiErrors[0])
+	}
+	if !found {
+		recordMissingField("sacct", "jobs")
+	}
+	gmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Add(nodeErrors)
+
+	return typeAlloc, nodeAlloc, jobAlloc, nil
+}
+
+func (gmf *GpuJsonFetcher) fetchSqueueAllocatedGpus(ctx context.Context) (float64, error) {
+	squeueResp := new(squeueGpuResponse)
+	cliJson, err := gmf.squeueScraper.FetchRawBytes(ctx)
+	if err != nil {
+		gmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return 0, err
+	}
+
+	if err := unmarshalCliJson(cliJson, squeueResp); err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling squeue GPU metrics: %q", err))
+		gmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return 0, err
+	}
+
+	if len(squeueResp.Errors) > 0 {
+		recordApiErrors("squeue", squeueResp.Errors)
+		for _, e := range squeueResp.Errors {
+			slog.Error(fmt.Sprintf("squeue API error response: %q", e))
+		}
+		gmf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(squeueResp.Errors)))
+		return 0, errors.New(squeueResp.Errors[0])
 	}
 
 	allocGpus := 0.0
-	for _, job := range sacctResp.Jobs {
-		gpuCount := parseGresGpuCount(job.AllocGRES)
-		allocGpus += gpuCount
+	for _, job := range squeueResp.Jobs {
+		allocGpus += parseGresGpuCount(job.TresAlloc, gmf.gresName)
 	}
 
 	return allocGpus, nil
 }
 
-func (gmf *GpuJsonFetcher) FetchMetrics() (*GpuMetrics, error) {
-	gmf.cache.Lock()
-	defer gmf.cache.Unlock()
+func (gmf *GpuJsonFetcher) fetchPendingGpus(ctx context.Context) (float64, error) {
+	squeueResp := new(squeueGpuResponse)
+	cliJson, err := gmf.pendingScraper.FetchRawBytes(ctx)
+	if err != nil {
+		gmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return 0, err
+	}
 
-	if gmf.cache.cache != nil && time.Since(gmf.cache.t).Seconds() < gmf.cache.limit {
-		return gmf.cache.cache, nil
+	if err := unmarshalCliJson(cliJson, squeueResp); err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling squeue pending GPU metrics: %q", err))
+		gmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return 0, err
 	}
 
-	t := time.Now()
-	metrics, err := gmf.fetch()
-	if err != nil {
-		return nil, err
+	if len(squeueResp.Errors) > 0 {
+		recordApiErrors("squeue", squeueResp.Errors)
+		for _, e := range squeueResp.Errors {
+			slog.Error(fmt.Sprintf("squeue API error response: %q", e))
+		}
+		gmf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(squeueResp.Errors)))
+		return 0, errors.New(squeueResp.Errors[0])
 	}
-	gmf.cache.duration = time.Since(t)
-	gmf.cache.cache = metrics
-	gmf.cache.t = time.Now()
-	return metrics, nil
+
+	pendingGpus := 0.0
+	for _, job := range squeueResp.Jobs {
+		pendingGpus += parseGresGpuCount(job.TresReq, gmf.gresName) * arrayTaskCount(job.ArrayTaskString)
+	}
+
+	return pendingGpus, nil
+}
+
+func (gmf *GpuJsonFetcher) FetchMetrics(ctx context.Context) (*GpuMetrics, error) {
+	return gmf.cache.FetchOrThrottle(ctx, gmf.fetch)
 }
 
-func (gmf *GpuJsonFetcher) ScrapeError() prometheus.Counter {
+func (gmf *GpuJsonFetcher) ScrapeError() *prometheus.CounterVec {
 	return gmf.errorCounter
 }
 
@@ -192,118 +715,237 @@ func (gmf *GpuJsonFetcher) ScrapeDuration() time.Duration {
 // CLI Fallback Fetcher
 type GpuCliFallbackFetcher struct {
 	sinfoScraper SlurmByteScraper
+	// sinfoScraper emits the node fallback format (see sinfoCsvHeader) rather than a lone Gres column
+	nodeFormat   bool
 	sacctScraper SlurmByteScraper
-	errorCounter prometheus.Counter
+	// optional, cross checks the sacct allocation against squeue's allocated TRES
+	squeueScraper SlurmByteScraper
+	// optional, pending jobs' requested GPUs
+	pendingScraper SlurmByteScraper
+	// retain per job allocations. High cardinality
+	perJob bool
+	// total or available, the utilization denominator
+	utilBasis string
+	// gres resource name counted as GPUs, defaults to gpu
+	gresName string
+	// type label of untyped GPUs, defaults to untyped
+	defaultType  string
+	errorCounter *prometheus.CounterVec
 	cache        *gpuCache
 }
 
-func (gcf *GpuCliFallbackFetcher) fetch() (*GpuMetrics, error) {
-	totalGpus, err := gcf.fetchTotalGpus()
-	if err != nil {
-		return nil, err
-	}
+func (gcf *GpuCliFallbackFetcher) fetch(ctx context.Context) (*GpuMetrics, error) {
+	counts, totalErr := gcf.fetchTotalGpus(ctx)
+	typeAlloc, nodeAlloc, jobAlloc, allocErr := gcf.fetchAllocatedGpus(ctx)
 
-	allocGpus, err := gcf.fetchAllocatedGpus()
+	metrics, err := newPartialGpuMetrics(counts, totalErr, typeAlloc, nodeAlloc, allocErr, gcf.utilBasis)
 	if err != nil {
 		return nil, err
 	}
-
-	idleGpus := totalGpus - allocGpus
-	utilization := 0.0
-	if totalGpus > 0 {
-		utilization = allocGpus / totalGpus
+	if !metrics.TotalStale && !metrics.AllocStale {
+		metrics.Utilization = gcf.cache.smooth(metrics.Utilization)
 	}
-
-	metrics := &GpuMetrics{
-		Alloc:       allocGpus,
-		Idle:        idleGpus,
-		Total:       totalGpus,
-		Utilization: utilization,
+	metrics.JobAlloc = jobAlloc
+	if gcf.pendingScraper != nil {
+		if metrics.RequestedPending, err = gcf.fetchPendingGpus(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Failed to fetch pending GPUs: %q", err))
+			metrics.PendingStale = true
+		}
+	}
+	if gcf.squeueScraper != nil && !metrics.AllocStale {
+		if squeueAllocGpus, err := gcf.fetchSqueueAllocatedGpus(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Failed to cross check allocated GPUs: %q", err))
+			metrics.DiscrepancyStale = true
+		} else {
+			metrics.AllocDiscrepancy = metrics.Alloc - squeueAllocGpus
+		}
 	}
 
 	return metrics, nil
 }
 
-func (gcf *GpuCliFallbackFetcher) fetchTotalGpus() (float64, error) {
-	sinfoOutput, err := gcf.sinfoScraper.FetchRawBytes()
+// configured GPUs per type and per node. Nodes and their state are only known when the output
+// carries the NodeHost and State columns i.e the node format. gres_used isn't parsed
+func (gcf *GpuCliFallbackFetcher) fetchTotalGpus(ctx context.Context) (*sinfoGpuCounts, error) {
+	sinfoOutput, err := gcf.sinfoScraper.FetchRawBytes(ctx)
 	if err != nil {
-		return 0, err
+		gcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
 	}
 
+	counts := newSinfoGpuCounts()
 	sinfoOutput = bytes.TrimSpace(sinfoOutput)
 	if len(sinfoOutput) == 0 {
-		return 0, nil
+		return counts, nil
 	}
 
-	totalGpus := 0.0
 	reader := csv.NewReader(bytes.NewReader(sinfoOutput))
 	reader.Comma = '|'
 	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
 
 	records, err := reader.ReadAll()
 	if err != nil {
 		slog.Error(fmt.Sprintf("Failed to parse sinfo GPU output: %q", err))
-		gcf.errorCounter.Inc()
-		return 0, err
+		gcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, err
+	}
+
+	cols := sinfoColumns{sinfoGres: 0}
+	if gcf.nodeFormat {
+		cols = defaultSinfoColumns()
+	}
+	if headerCols, ok := parseSinfoHeader(records[0]); ok {
+		cols = headerCols
+		records = records[1:]
 	}
 
+	// nodes within multiple partitions are listed once per partition in the node format
+	seenHosts := make(map[string]bool)
 	for _, record := range records {
-		if len(record) == 0 {
+		gresField, ok := cols.field(record, sinfoGres)
+		if !ok {
+			slog.Debug(fmt.Sprintf("skipping sinfo GPU record without a gres column: %v", record))
+			gpuParseSkippedCounter.WithLabelValues("sinfo").Inc()
 			continue
 		}
-		gresField := strings.TrimSpace(record[0])
-		gpuCount := parseGresGpuCount(gresField)
-		totalGpus += gpuCount
+		host, hasHost := cols.field(record, sinfoNodeHost)
+		if hasHost {
+			if seenHosts[host] {
+				continue
+			}
+			seenHosts[host] = true
+		}
+		totalTypes := parseGresGpuTypes(gresField, gcf.gresName)
+		addGpuTypes(counts.typeTotal, totalTypes, gcf.defaultType)
+		if total := sumGpuTypes(totalTypes); hasHost && total > 0 {
+			counts.nodeTotal[host] += total
+			if state, ok := cols.field(record, sinfoState); ok && (&NodeMetric{State: state}).Unavailable() {
+				counts.unavailable[host] = true
+			}
+		}
 	}
 
-	return totalGpus, nil
+	return counts, nil
 }
 
-func (gcf *GpuCliFallbackFetcher) fetchAllocatedGpus() (float64, error) {
-	sacctOutput, err := gcf.sacctScraper.FetchRawBytes()
+// parses lines of the form gres|nodelist|jobid|user|nodecount. All but the gres are optional and may be quoted.
+// squeue's gres is per node, so every node of the job is attributed the gres and the job holds it times its
+// node count. Without a node count the expanded nodelist is counted
+func (gcf *GpuCliFallbackFetcher) fetchAllocatedGpus(ctx context.Context) (map[string]float64, map[string]float64, []JobGpuAlloc, error) {
+	sacctOutput, err := gcf.sacctScraper.FetchRawBytes(ctx)
 	if err != nil {
-		return 0, err
+		gcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, nil, nil, err
 	}
 
+	typeAlloc := make(map[string]float64)
+	nodeAlloc := make(map[string]float64)
+	var jobAlloc []JobGpuAlloc
 	sacctOutput = bytes.TrimSpace(sacctOutput)
 	if len(sacctOutput) == 0 {
-		return 0, nil
+		return typeAlloc, nodeAlloc, jobAlloc, nil
 	}
 
-	allocGpus := 0.0
-	for _, line := range bytes.Split(sacctOutput, []byte("\n")) {
-		line = bytes.TrimSpace(line)
-		if len(line) == 0 {
+	reader := csv.NewReader(bytes.NewReader(sacctOutput))
+	reader.Comma = '|'
+	reader.Comment = '#'
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to parse GPU alloc output: %q", err))
+		gcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, nil, nil, err
+	}
+
+	for _, fields := range records {
+		for i, field := range fields {
+			fields[i] = strings.TrimSpace(field)
+		}
+		if fields[0] == "" || len(fields) > maxSacctGpuFields {
+			slog.Debug(fmt.Sprintf("skipping malformed GPU alloc record: %q", fields))
+			gpuParseSkippedCounter.WithLabelValues("sacct").Inc()
 			continue
 		}
-		gresField := strings.Trim(string(line), "\"")
-		gpuCount := parseGresGpuCount(gresField)
-		allocGpus += gpuCount
+		nodeTypes := parseGresGpuTypes(fields[0], gcf.gresName)
+		var nodes []string
+		if len(fields) > 1 {
+			if nodes, err = expandJobNodes(fields[1]); err != nil {
+				slog.Error(fmt.Sprintf("failed to attribute GPUs to nodes %q: %q", fields[1], err))
+				gcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+			}
+		}
+		nodeCount := max(float64(len(nodes)), 1)
+		if len(fields) > 4 {
+			if n, err := strconv.ParseFloat(fields[4], 64); err == nil && n > 0 {
+				nodeCount = n
+			}
+		}
+		nodeGpus := sumGpuTypes(nodeTypes)
+		for _, node := range nodes {
+			if nodeGpus > 0 {
+				nodeAlloc[node] += nodeGpus
+			}
+		}
+		for gpuType, count := range nodeTypes {
+			nodeTypes[gpuType] = count * nodeCount
+		}
+		addGpuTypes(typeAlloc, nodeTypes, gcf.defaultType)
+		gpuCount := nodeGpus * nodeCount
+		if gcf.perJob && gpuCount > 0 && len(fields) > 3 {
+			jobAlloc = append(jobAlloc, JobGpuAlloc{JobId: fields[2], User: fields[3], Gpus: gpuCount})
+		}
 	}
 
-	return allocGpus, nil
+	return typeAlloc, nodeAlloc, jobAlloc, nil
 }
 
-func (gcf *GpuCliFallbackFetcher) FetchMetrics() (*GpuMetrics, error) {
-	gcf.cache.Lock()
-	defer gcf.cache.Unlock()
+func (gcf *GpuCliFallbackFetcher) fetchSqueueAllocatedGpus(ctx context.Context) (float64, error) {
+	squeueOutput, err := gcf.squeueScraper.FetchRawBytes(ctx)
+	if err != nil {
+		gcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return 0, err
+	}
 
-	if gcf.cache.cache != nil && time.Since(gcf.cache.t).Seconds() < gcf.cache.limit {
-		return gcf.cache.cache, nil
+	allocGpus := 0.0
+	for _, line := range bytes.Split(bytes.TrimSpace(squeueOutput), []byte("\n")) {
+		allocGpus += parseGresGpuCount(string(bytes.TrimSpace(line)), gcf.gresName)
 	}
 
-	t := time.Now()
-	metrics, err := gcf.fetch()
+	return allocGpus, nil
+}
+
+// parses lines of the form gres|nodes where the gres is requested per node
+func (gcf *GpuCliFallbackFetcher) fetchPendingGpus(ctx context.Context) (float64, error) {
+	squeueOutput, err := gcf.pendingScraper.FetchRawBytes(ctx)
 	if err != nil {
-		return nil, err
+		gcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return 0, err
 	}
-	gcf.cache.duration = time.Since(t)
-	gcf.cache.cache = metrics
-	gcf.cache.t = time.Now()
-	return metrics, nil
+
+	pendingGpus := 0.0
+	for _, line := range bytes.Split(bytes.TrimSpace(squeueOutput), []byte("\n")) {
+		gresField, nodesField, hasNodes := strings.Cut(string(bytes.TrimSpace(line)), "|")
+		nodes := 1.0
+		if hasNodes {
+			if n, err := strconv.ParseFloat(strings.TrimSpace(nodesField), 64); err == nil && n > 0 {
+				nodes = n
+			}
+		}
+		pendingGpus += parseGresGpuCount(strings.TrimSpace(gresField), gcf.gresName) * nodes
+	}
+
+	return pendingGpus, nil
 }
 
-func (gcf *GpuCliFallbackFetcher) ScrapeError() prometheus.Counter {
+func (gcf *GpuCliFallbackFetcher) FetchMetrics(ctx context.Context) (*GpuMetrics, error) {
+	return gcf.cache.FetchOrThrottle(ctx, gcf.fetch)
+}
+
+func (gcf *GpuCliFallbackFetcher) ScrapeError() *prometheus.CounterVec {
 	return gcf.errorCounter
 }
 
@@ -311,74 +953,122 @@ func (gcf *GpuCliFallbackFetcher) ScrapeDuration() time.Duration {
 	return gcf.sinfoScraper.Duration()
 }
 
-// parseGresGpuCount parses GPU count from GRES or TRES string
-// GRES Examples: "gpu:2", "gpu:tesla:2", "gpu:1(IDX:0)"
-// TRES Examples: "cpu=4,mem=1024M,gres/gpu=2", "billing=8,cpu=8,gres/gpu=4,mem=32G,node=1"
-func parseGresGpuCount(gres string) float64 {
+// parseGresGpuCount parses the count of the named GPU gres from a GRES or TRES string, name defaults to gpu
+// GRES Examples: "gpu:2", "gpu:tesla:2", "gpu:1(IDX:0)", "gres/gpu:4", "nvidia_gpu:2"
+// TRES Examples: "cpu=4,mem=1024M,gres/gpu=2", "billing=8,cpu=8,gres/gpu=4,mem=32G,node=1", "gres/nvidia_gpu=2"
+func parseGresGpuCount(gres string, name string) float64 {
+	return sumGpuTypes(parseGresGpuTypes(gres, name))
+}
+
+// per type breakdown of parseGresGpuCount, untyped GPUs are keyed by ""
+func parseGresGpuTypes(gres string, name string) map[string]float64 {
+	types := make(map[string]float64)
 	if gres == "" || gres == "N/A" || gres == "(null)" {
-		return 0
+		return types
+	}
+	if name == "" {
+		name = defaultGpuGresName
 	}
 
 	// Handle TRES format: "cpu=4,mem=1024M,gres/gpu=2"
 	if strings.Contains(gres, "=") {
-		// This is TRES format
+		counter := newTresGpuCounter()
 		for _, part := range strings.Split(gres, ",") {
-			part = strings.TrimSpace(part)
-			// Look for gres/gpu=N or gres/gpu:type=N
-			if strings.HasPrefix(part, "gres/gpu") {
-				// Extract the value after =
-				eqIdx := strings.Index(part, "=")
-				if eqIdx != -1 && eqIdx < len(part)-1 {
-					countStr := part[eqIdx+1:]
-					// Remove any type suffix like gres/gpu:tesla=4
-					if count, err := strconv.ParseFloat(countStr, 64); err == nil {
-						return count
-					}
-				}
+			key, countStr, _ := strings.Cut(strings.TrimSpace(part), "=")
+			// Look for gres/<name>=N or gres/<name>:type=N
+			resource, isGres := strings.CutPrefix(key, "gres/")
+			resource, gpuType, _ := strings.Cut(resource, ":")
+			if !isGres || !strings.EqualFold(resource, name) {
+				continue
+			}
+			// a malformed entry i.e gres/gpu= or gres/gpu=abc counts nothing rather than guessing
+			count, err := parseGpuCount(countStr)
+			if err != nil {
+				slog.Debug(fmt.Sprintf("Failed to parse GPU count from TRES '%s': %v", part, err))
+				continue
 			}
+			counter.add(gpuType, count)
 		}
-		return 0 // No GPU found in TRES
+		return counter.types()
 	}
 
 	// Handle multiple GRES resources separated by comma (legacy GRES format)
-	if strings.Contains(gres, ",") {
-		total := 0.0
-		for _, part := range strings.Split(gres, ",") {
-			total += parseGresGpuCount(strings.TrimSpace(part))
+	for _, part := range strings.Split(gres, ",") {
+		if gpuType, count, ok := parseGresEntry(strings.TrimSpace(part), name); ok {
+			types[gpuType] += count
 		}
-		return total
 	}
+	return types
+}
 
+// GPU counts are whole and non-negative. Rejects what ParseFloat would take i.e NaN, Inf, -1 or 1e308,
+// the 32 bit bound keeps sums of many entries finite
+func parseGpuCount(count string) (float64, error) {
+	parsed, err := strconv.ParseUint(count, 10, 32)
+	return float64(parsed), err
+}
+
+// parses a single legacy GRES entry i.e "gpu:a100:8(IDX:0-7)"
+func parseGresEntry(gres string, name string) (string, float64, bool) {
 	// Remove any trailing index information like (IDX:0-1)
 	if idx := strings.Index(gres, "("); idx != -1 {
 		gres = gres[:idx]
 	}
 
-	// Check if it contains "gpu"
-	if !strings.Contains(strings.ToLower(gres), "gpu") {
-		return 0
-	}
+	// squeue %b prefixes the resource i.e gres/gpu:4 or gres:gpu:4
+	gres = strings.TrimPrefix(strings.TrimPrefix(gres, "gres/"), "gres:")
 
 	// Split by colon to get parts: ["gpu", "type", "count"] or ["gpu", "count"]
 	parts := strings.Split(gres, ":")
-	if len(parts) < 2 {
-		return 0
+	if len(parts) < 2 || !strings.EqualFold(parts[0], name) {
+		return "", 0, false
 	}
 
 	// The last part should be the count
 	countStr := parts[len(parts)-1]
-	count, err := strconv.ParseFloat(countStr, 64)
+	count, err := parseGpuCount(countStr)
 	if err != nil {
 		slog.Debug(fmt.Sprintf("Failed to parse GPU count from '%s': %v", gres, err))
-		return 0
+		return "", 0, false
 	}
 
-	return count
+	if len(parts) > 2 {
+		return parts[1], count, true
+	}
+	return "", count, true
+}
+
+// upper bounds of the per node GPU utilization histogram
+var nodeGpuUtilizationBuckets = []float64{0, 0.25, 0.5, 0.75, 1}
+
+// observes alloc/total of every node with GPUs. Returns the count, sum and cumulative bucket counts.
+// Like idle, the ratio is clamped to 1 in case sacct reports more GPUs than sinfo
+func nodeGpuUtilizationHistogram(nodeTotal map[string]float64, nodeAlloc map[string]float64) (uint64, float64, map[float64]uint64) {
+	var count uint64
+	var sum float64
+	buckets := make(map[float64]uint64, len(nodeGpuUtilizationBuckets))
+	for _, bound := range nodeGpuUtilizationBuckets {
+		buckets[bound] = 0
+	}
+	for node, total := range nodeTotal {
+		if total <= 0 {
+			continue
+		}
+		utilization := math.Min(1, nodeAlloc[node]/total)
+		count++
+		sum += utilization
+		for _, bound := range nodeGpuUtilizationBuckets {
+			if utilization <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	return count, sum, buckets
 }
 
 type GpuFetcher interface {
-	FetchMetrics() (*GpuMetrics, error)
-	ScrapeError() prometheus.Counter
+	FetchMetrics(ctx context.Context) (*GpuMetrics, error)
+	ScrapeError() *prometheus.CounterVec
 	ScrapeDuration() time.Duration
 }
 
@@ -388,90 +1078,373 @@ type GpuCollector struct {
 	idle        *prometheus.Desc
 	total       *prometheus.Desc
 	utilization *prometheus.Desc
-	fetcher     GpuFetcher
+	unavailable *prometheus.Desc
+	// 1 when sinfo reports any GPU gres, so dashboards can hide GPU panels on clusters without them
+	configured *prometheus.Desc
+	// emitted on every collect so a failed scrape is distinguishable from a cluster without GPUs
+	scrapeSuccess *prometheus.Desc
+	nodeAlloc     *prometheus.Desc
+	pending       *prometheus.Desc
+	// labeled by gres type
+	typeAlloc *prometheus.Desc
+	typeIdle  *prometheus.Desc
+	typeTotal *prometheus.Desc
+	// nil unless per job collection is enabled
+	jobAlloc *prometheus.Desc
+	// nil unless the per node utilization histogram is enabled
+	nodeUtilization *prometheus.Desc
+	// nil unless the squeue cross check is enabled
+	allocDiscrepancy *prometheus.Desc
+	fetcher          GpuFetcher
+	// stripped from node labels
+	nodeSuffixes NodeSuffixes
+	// labeled by the sub-metric, total or alloc, whose fetch failed
+	stale *prometheus.Desc
+	// alloc * seconds between fresh allocations, see accumulateGpuSeconds
+	gpuSeconds     *prometheus.Desc
+	gpuSecondsLock sync.Mutex
+	gpuSecondsSum  float64
+	// the last fresh metrics and when they were seen. Cache served metrics are the same pointer
+	lastAlloc   *GpuMetrics
+	lastAllocAt time.Time
 }
 
 func NewGpuCollector(config *Config) *GpuCollector {
 	var fetcher GpuFetcher
 	cliOpts := config.cliOpts
+	sinfoScraper := cliOpts.scraper("sinfo_gpu", cliOpts.sinfoGpu)
+	if cliOpts.gpuSharesSinfo && cliOpts.sharedSinfo != nil {
+		sinfoScraper = cliOpts.sharedSinfo
+	}
+	var squeueScraper SlurmByteScraper
+	if cliOpts.gpuAllocCrosscheck {
+		squeueScraper = cliOpts.scraper("squeue_gpu", cliOpts.squeueGpu)
+	}
+	var pendingScraper SlurmByteScraper
+	if cliOpts.gpuPending {
+		pendingScraper = cliOpts.scraper("squeue_pending_gpu", cliOpts.squeuePendingGpu)
+	}
 
 	if cliOpts.fallback {
 		// CLI fallback mode
 		fetcher = &GpuCliFallbackFetcher{
-			sinfoScraper: NewCliScraper(cliOpts.sinfoGpu...),
-			sacctScraper: NewCliScraper(cliOpts.sacctGpu...),
+			sinfoScraper:   sinfoScraper,
+			nodeFormat:     cliOpts.gpuSharesSinfo,
+			sacctScraper:   cliOpts.scraper("sacct_gpu", cliOpts.sacctGpu),
+			squeueScraper:  squeueScraper,
+			pendingScraper: pendingScraper,
+			perJob:         cliOpts.gpuPerJob,
+			gresName:       cliOpts.gpuGresName,
+			defaultType:    cliOpts.gpuDefaultType,
+			utilBasis:      cliOpts.gpuUtilizationBasis,
 			cache: &gpuCache{
-				limit: config.PollLimit,
+				limit:  config.PollLimit,
+				alpha:  cliOpts.gpuUtilizationAlpha,
+				jitter: newJitterFactor(),
+				served: cacheServedGauge.WithLabelValues("gpu"),
 			},
-			errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 				Name: "gpu_scrape_errors",
 				Help: "GPU scrape errors",
 			}),
 		}
 	} else {
 		// JSON API mode
-		fetcher = &GpuJsonFetcher{
-			sinfoScraper: NewCliScraper(cliOpts.sinfoGpu...),
-			sacctScraper: NewCliScraper(cliOpts.sacctGpu...),
+		jsonFetcher := &GpuJsonFetcher{
+			sinfoScraper:   sinfoScraper,
+			squeueScraper:  squeueScraper,
+			pendingScraper: pendingScraper,
+			perJob:         cliOpts.gpuPerJob,
+			gresUsedAlloc:  cliOpts.gpuAllocSource == gpuAllocSourceGresUsed,
+			gresName:       cliOpts.gpuGresName,
+			defaultType:    cliOpts.gpuDefaultType,
+			utilBasis:      cliOpts.gpuUtilizationBasis,
 			cache: &gpuCache{
-				limit: config.PollLimit,
+				limit:  config.PollLimit,
+				alpha:  cliOpts.gpuUtilizationAlpha,
+				jitter: newJitterFactor(),
+				served: cacheServedGauge.WithLabelValues("gpu"),
 			},
-			errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 				Name: "gpu_scrape_errors",
 				Help: "GPU scrape errors",
 			}),
 		}
+		if !jsonFetcher.gresUsedAlloc {
+			jsonFetcher.sacctScraper = cliOpts.scraper("sacct_gpu", cliOpts.sacctGpu)
+		}
+		fetcher = jsonFetcher
+	}
+
+	var allocDiscrepancy *prometheus.Desc
+	if cliOpts.gpuAllocCrosscheck {
+		allocDiscrepancy = config.NewDesc(
+			"slurm_gpus_alloc_discrepancy",
+			"Allocated GPUs reported by sacct minus allocated GPUs reported by squeue",
+			nil,
+			nil,
+		)
+	}
+
+	var pending *prometheus.Desc
+	if cliOpts.gpuPending {
+		pending = config.NewDesc(
+			"slurm_gpus_requested_pending",
+			"GPUs requested by pending jobs",
+			nil,
+			nil,
+		)
+	}
+
+	var jobAlloc *prometheus.Desc
+	if cliOpts.gpuPerJob {
+		jobAlloc = config.NewDesc(
+			"slurm_job_gpus_alloc",
+			"Allocated GPUs per running job",
+			[]string{"job_id", "user"},
+			nil,
+		)
+	}
+
+	var nodeUtilization *prometheus.Desc
+	if cliOpts.gpuNodeHistogram {
+		nodeUtilization = config.NewDesc(
+			"slurm_node_gpu_utilization",
+			"Distribution of allocated / total GPUs across nodes with GPUs",
+			nil,
+			nil,
+		)
 	}
 
 	return &GpuCollector{
-		alloc: prometheus.NewDesc(
+		alloc: config.NewDesc(
 			"slurm_gpus_alloc",
 			"Allocated GPUs",
 			nil,
 			nil,
 		),
-		idle: prometheus.NewDesc(
+		idle: config.NewDesc(
 			"slurm_gpus_idle",
 			"Idle GPUs",
 			nil,
 			nil,
 		),
-		total: prometheus.NewDesc(
+		total: config.NewDesc(
 			"slurm_gpus_total",
 			"Total GPUs",
 			nil,
 			nil,
 		),
-		utilization: prometheus.NewDesc(
+		utilization: config.NewDesc(
 			"slurm_gpus_utilization",
 			"Total GPU utilization",
 			nil,
 			nil,
 		),
-		fetcher: fetcher,
+		unavailable: config.NewDesc(
+			"slurm_gpus_unavailable",
+			"Idle GPUs on down, drained or failing nodes",
+			nil,
+			nil,
+		),
+		configured: config.NewDesc(
+			"slurm_gpus_configured",
+			"1 if any node has GPU gres configured, 0 otherwise",
+			nil,
+			nil,
+		),
+		typeAlloc: config.NewDesc(
+			"slurm_gpus_alloc_per_type",
+			"Allocated GPUs per gres type",
+			[]string{"type"},
+			nil,
+		),
+		typeIdle: config.NewDesc(
+			"slurm_gpus_idle_per_type",
+			"Idle GPUs per gres type, total minus allocated clamped at 0",
+			[]string{"type"},
+			nil,
+		),
+		typeTotal: config.NewDesc(
+			"slurm_gpus_total_per_type",
+			"Total GPUs per gres type",
+			[]string{"type"},
+			nil,
+		),
+		nodeAlloc: config.NewDesc(
+			"slurm_node_gpus_alloc",
+			"Allocated GPUs per node",
+			[]string{"node"},
+			nil,
+		),
+		scrapeSuccess: config.NewDesc(
+			"slurm_gpus_scrape_success",
+			"1 if the last GPU scrape succeeded, 0 otherwise",
+			nil,
+			nil,
+		),
+		stale: config.NewDesc(
+			"slurm_gpus_stale",
+			"1 if the GPU sub-metric couldn't be fetched and its series are omitted, 0 otherwise",
+			[]string{"metric"},
+			nil,
+		),
+		gpuSeconds: config.NewDesc(
+			"slurm_gpu_seconds_total",
+			"Allocated GPUs times the seconds between scrapes, accumulated since the exporter started",
+			nil,
+			nil,
+		),
+		pending:          pending,
+		jobAlloc:         jobAlloc,
+		nodeUtilization:  nodeUtilization,
+		allocDiscrepancy: allocDiscrepancy,
+		fetcher:          fetcher,
+		nodeSuffixes:     cliOpts.nodeSuffixes,
 	}
 }
 
+func (gc *GpuCollector) SetFetcher(fetcher GpuFetcher) {
+	gc.fetcher = fetcher
+}
+
+func (gc *GpuCollector) Fetcher() GpuFetcher {
+	return gc.fetcher
+}
+
 func (gc *GpuCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- gc.alloc
 	ch <- gc.idle
 	ch <- gc.total
 	ch <- gc.utilization
+	ch <- gc.unavailable
+	ch <- gc.configured
+	ch <- gc.typeAlloc
+	ch <- gc.typeIdle
+	ch <- gc.typeTotal
+	ch <- gc.nodeAlloc
+	ch <- gc.scrapeSuccess
+	ch <- gc.stale
+	ch <- gc.gpuSeconds
+	gc.fetcher.ScrapeError().Describe(ch)
+	if gc.jobAlloc != nil {
+		ch <- gc.jobAlloc
+	}
+	if gc.nodeUtilization != nil {
+		ch <- gc.nodeUtilization
+	}
+	if gc.allocDiscrepancy != nil {
+		ch <- gc.allocDiscrepancy
+	}
+	if gc.pending != nil {
+		ch <- gc.pending
+	}
+}
+
+// adds the previous fresh allocation times the time it was held for, up to this fresh allocation. Throttled
+// scrapes are served the cached metrics again, so they're skipped instead of counting the same allocation twice.
+// A failed or stale alloc fetch, nil metrics, stops the clock since nothing is known about the allocation in the
+// meantime. The first allocation after it only starts the clock again
+func (gc *GpuCollector) accumulateGpuSeconds(metrics *GpuMetrics) float64 {
+	gc.gpuSecondsLock.Lock()
+	defer gc.gpuSecondsLock.Unlock()
+	if metrics == nil || metrics.AllocStale {
+		gc.lastAlloc = nil
+		return gc.gpuSecondsSum
+	}
+	if metrics == gc.lastAlloc {
+		return gc.gpuSecondsSum
+	}
+	now := time.Now()
+	if gc.lastAlloc != nil {
+		gc.gpuSecondsSum += gc.lastAlloc.Alloc * now.Sub(gc.lastAllocAt).Seconds()
+	}
+	gc.lastAlloc = metrics
+	gc.lastAllocAt = now
+	return gc.gpuSecondsSum
 }
 
 func (gc *GpuCollector) Collect(ch chan<- prometheus.Metric) {
-	metrics, err := gc.fetcher.FetchMetrics()
-	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to fetch GPU metrics: %q", err))
+	gc.CollectContext(context.Background(), ch)
+}
+
+func (gc *GpuCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer gc.fetcher.ScrapeError().Collect(ch)
+	metrics, err := gc.fetcher.FetchMetrics(ctx)
+	if err != nil || metrics == nil {
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to fetch GPU metrics: %q", err))
+		}
+		gc.accumulateGpuSeconds(nil)
+		ch <- prometheus.MustNewConstMetric(gc.scrapeSuccess, prometheus.GaugeValue, 0)
 		return
 	}
 
-	if metrics == nil {
+	// a partial scrape only emits the series the successful fetch can back
+	complete := !metrics.TotalStale && !metrics.AllocStale
+	success := 0.
+	if complete {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(gc.scrapeSuccess, prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(gc.gpuSeconds, prometheus.CounterValue, gc.accumulateGpuSeconds(metrics))
+	staleMetrics := map[string]bool{gpuStaleTotal: metrics.TotalStale, gpuStaleAlloc: metrics.AllocStale}
+	if gc.pending != nil {
+		staleMetrics[gpuStalePending] = metrics.PendingStale
+	}
+	if gc.allocDiscrepancy != nil {
+		staleMetrics[gpuStaleDiscrepancy] = metrics.DiscrepancyStale
+	}
+	for metric, isStale := range staleMetrics {
+		stale := 0.
+		if isStale {
+			stale = 1
+		}
+		ch <- prometheus.MustNewConstMetric(gc.stale, prometheus.GaugeValue, stale, metric)
+	}
+	if gc.pending != nil && !metrics.PendingStale {
+		ch <- prometheus.MustNewConstMetric(gc.pending, prometheus.GaugeValue, metrics.RequestedPending)
+	}
+	if !metrics.TotalStale {
+		configured := 0.
+		if metrics.Total > 0 {
+			configured = 1
+		}
+		ch <- prometheus.MustNewConstMetric(gc.configured, prometheus.GaugeValue, configured)
+		ch <- prometheus.MustNewConstMetric(gc.total, prometheus.GaugeValue, metrics.Total)
+		for gpuType, total := range metrics.TypeTotal {
+			ch <- prometheus.MustNewConstMetric(gc.typeTotal, prometheus.GaugeValue, total, gpuType)
+		}
+	}
+	if !metrics.AllocStale {
+		ch <- prometheus.MustNewConstMetric(gc.alloc, prometheus.GaugeValue, metrics.Alloc)
+		for gpuType, alloc := range metrics.TypeAlloc {
+			ch <- prometheus.MustNewConstMetric(gc.typeAlloc, prometheus.GaugeValue, alloc, gpuType)
+		}
+		nodeLabels := gc.nodeSuffixes.Labels(slices.Collect(maps.Keys(metrics.NodeAlloc)))
+		for node, alloc := range metrics.NodeAlloc {
+			ch <- prometheus.MustNewConstMetric(gc.nodeAlloc, prometheus.GaugeValue, alloc, nodeLabels[node])
+		}
+		if gc.jobAlloc != nil {
+			for _, job := range metrics.JobAlloc {
+				ch <- prometheus.MustNewConstMetric(gc.jobAlloc, prometheus.GaugeValue, job.Gpus, job.JobId, job.User)
+			}
+		}
+		if gc.allocDiscrepancy != nil && !metrics.DiscrepancyStale {
+			ch <- prometheus.MustNewConstMetric(gc.allocDiscrepancy, prometheus.GaugeValue, metrics.AllocDiscrepancy)
+		}
+	}
+	if !complete {
 		return
 	}
-
-	ch <- prometheus.MustNewConstMetric(gc.alloc, prometheus.GaugeValue, metrics.Alloc)
 	ch <- prometheus.MustNewConstMetric(gc.idle, prometheus.GaugeValue, metrics.Idle)
-	ch <- prometheus.MustNewConstMetric(gc.total, prometheus.GaugeValue, metrics.Total)
 	ch <- prometheus.MustNewConstMetric(gc.utilization, prometheus.GaugeValue, metrics.Utilization)
+	ch <- prometheus.MustNewConstMetric(gc.unavailable, prometheus.GaugeValue, metrics.Unavailable)
+	for gpuType, idle := range metrics.TypeIdle {
+		ch <- prometheus.MustNewConstMetric(gc.typeIdle, prometheus.GaugeValue, idle, gpuType)
+	}
+	if gc.nodeUtilization != nil {
+		count, sum, buckets := nodeGpuUtilizationHistogram(metrics.NodeTotal, metrics.NodeAlloc)
+		ch <- prometheus.MustNewConstHistogram(gc.nodeUtilization, count, sum, buckets)
+	}
 }
@@ -1,9 +1,18 @@
 package exporter
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,6 +20,8 @@ var MockGpuSinfoScraper = &MockScraper{fixture: "fixtures/sinfo_gpu_out.json"}
 var MockGpuSacctScraper = &MockScraper{fixture: "fixtures/sacct_gpu_out.json"}
 var MockGpuSinfoFallbackScraper = &MockScraper{fixture: "fixtures/sinfo_gpu_fallback.txt"}
 var MockGpuSacctFallbackScraper = &MockScraper{fixture: "fixtures/sacct_gpu_fallback.txt"}
+var MockGpuSqueueScraper = &MockScraper{fixture: "fixtures/squeue_gpu_out.json"}
+var MockGpuSqueueFallbackScraper = &MockScraper{fixture: "fixtures/squeue_gpu_fallback.txt"}
 
 func TestParseGresGpuCount(t *testing.T) {
 	tests := []struct {
@@ -29,11 +40,25 @@ func TestParseGresGpuCount(t *testing.T) {
 		{"GPU uppercase", "GPU:3", 3.0},
 		{"Complex GRES", "gpu:a100:8(IDX:0-7)", 8.0},
 		{"Mixed resources", "gpu:2,mem:10G", 2.0},
+		{"Only the gpu resource", "gpu:2,mps_gpu:100", 2.0},
+		{"squeue GRES", "gres/gpu:4", 4.0},
+		{"Legacy squeue GRES", "gres:gpu:tesla:2", 2.0},
+		{"TRES", "cpu=4,mem=1024M,gres/gpu=2", 2.0},
+		{"Typed TRES", "cpu=4,gres/gpu:tesla=4", 4.0},
+		{"TRES prefix only", "cpu=4,gres/gpumem=16G", 0.0},
+		{"Missing count", "gpu:", 0.0},
+		{"Empty index", "gpu:(IDX:)", 0.0},
+		{"Trailing comma", "gpu:2,", 2.0},
+		{"NaN", "gpu:NaN", 0.0},
+		{"Inf", "gpu:Inf", 0.0},
+		{"Negative", "gpu:-2", 0.0},
+		{"Fractional", "gpu:1.5", 0.0},
+		{"Overflowing sum", "gpu:1e308,gpu:1e308", 0.0},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := parseGresGpuCount(tt.gres)
+			result := parseGresGpuCount(tt.gres, "gpu")
 			assert.Equal(t, tt.expected, result, "Failed for input: %s", tt.gres)
 		})
 	}
@@ -63,13 +88,66 @@ func TestNewGpuCollector(t *testing.T) {
 	assert.IsType(&GpuJsonFetcher{}, collector.fetcher)
 }
 
+func TestNewGpuCollector_SharedSinfo(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true})
+	assert.Nil(err)
+	nodeCollector := NewNodeCollecter(config)
+	gpuCollector := NewGpuCollector(config)
+	nodeFetcher := nodeCollector.fetcher.(*NodeJsonFetcher)
+	gpuFetcher := gpuCollector.fetcher.(*GpuJsonFetcher)
+	assert.Same(nodeFetcher.scraper, gpuFetcher.sinfoScraper)
+
+	// an explicit GPU sinfo override gets its own scraper
+	config, err = NewConfig(&CliFlags{SlurmGpusEnabled: true, SlurmSinfoGpuOverride: "sinfo --json -p gpu"})
+	assert.Nil(err)
+	gpuFetcher = NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.NotSame(config.cliOpts.sharedSinfo, gpuFetcher.sinfoScraper)
+
+	// the default fallback node format carries the Gres column
+	config, err = NewConfig(&CliFlags{SlurmGpusEnabled: true, SlurmCliFallback: true})
+	assert.Nil(err)
+	fallbackFetcher := NewGpuCollector(config).fetcher.(*GpuCliFallbackFetcher)
+	assert.Same(config.cliOpts.sharedSinfo, fallbackFetcher.sinfoScraper)
+	assert.True(fallbackFetcher.nodeFormat)
+
+	// overriding the node sinfo cmd drops the Gres column guarantee
+	config, err = NewConfig(&CliFlags{SlurmGpusEnabled: true, SlurmCliFallback: true, SlurmSinfoOverride: "cat fixtures/sinfo_fallback.txt"})
+	assert.Nil(err)
+	fallbackFetcher = NewGpuCollector(config).fetcher.(*GpuCliFallbackFetcher)
+	assert.NotSame(config.cliOpts.sharedSinfo, fallbackFetcher.sinfoScraper)
+	assert.False(fallbackFetcher.nodeFormat)
+}
+
+func TestGpuCliFallbackFetcher_NodeFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_node_fallback.txt"},
+		nodeFormat:   true,
+		sacctScraper: MockGpuSacctFallbackScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	// gpu01 is listed under 2 partitions but only counted once
+	assert.Equal(12., metrics.Total)
+	assert.Equal(9., metrics.Alloc)
+}
+
 func TestGpuJsonFetcher(t *testing.T) {
 	assert := assert.New(t)
 
 	fetcher := &GpuJsonFetcher{
 		sinfoScraper: MockGpuSinfoScraper,
 		sacctScraper: MockGpuSacctScraper,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "test_gpu_errors",
 		}),
 		cache: &gpuCache{
@@ -77,7 +155,7 @@ func TestGpuJsonFetcher(t *testing.T) {
 		},
 	}
 
-	metrics, err := fetcher.FetchMetrics()
+	metrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotNil(metrics)
 	assert.GreaterOrEqual(metrics.Total, metrics.Alloc)
@@ -96,7 +174,7 @@ func TestGpuCliFallbackFetcher(t *testing.T) {
 	fetcher := &GpuCliFallbackFetcher{
 		sinfoScraper: MockGpuSinfoFallbackScraper,
 		sacctScraper: MockGpuSacctFallbackScraper,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "test_gpu_errors",
 		}),
 		cache: &gpuCache{
@@ -104,7 +182,7 @@ func TestGpuCliFallbackFetcher(t *testing.T) {
 		},
 	}
 
-	metrics, err := fetcher.FetchMetrics()
+	metrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotNil(metrics)
 	assert.GreaterOrEqual(metrics.Total, 0.0)
@@ -118,7 +196,7 @@ func TestGpuMetricsCache(t *testing.T) {
 	fetcher := &GpuJsonFetcher{
 		sinfoScraper: MockGpuSinfoScraper,
 		sacctScraper: MockGpuSacctScraper,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "test_gpu_errors",
 		}),
 		cache: &gpuCache{
@@ -127,12 +205,12 @@ func TestGpuMetricsCache(t *testing.T) {
 	}
 
 	// First fetch
-	metrics1, err := fetcher.FetchMetrics()
+	metrics1, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotNil(metrics1)
 
 	// Second fetch should use cache
-	metrics2, err := fetcher.FetchMetrics()
+	metrics2, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotNil(metrics2)
 
@@ -143,6 +221,49 @@ func TestGpuMetricsCache(t *testing.T) {
 	assert.Equal(metrics1.Utilization, metrics2.Utilization)
 }
 
+func TestGpuFetchers_SharedCachePath(t *testing.T) {
+	sinfo := &MockScraper{fixture: "fixtures/sinfo_gpu_out.json"}
+	sacct := &MockScraper{fixture: "fixtures/sacct_gpu_out.json"}
+	sinfoFallback := &MockScraper{fixture: "fixtures/sinfo_gpu_fallback.txt"}
+	sacctFallback := &MockScraper{fixture: "fixtures/sacct_gpu_fallback.txt"}
+	jsonCache := &gpuCache{limit: 10.0}
+	fallbackCache := &gpuCache{limit: 10.0}
+	tests := map[string]struct {
+		fetcher  GpuFetcher
+		cache    *gpuCache
+		scrapers []*MockScraper
+	}{
+		"json": {
+			fetcher:  &GpuJsonFetcher{sinfoScraper: sinfo, sacctScraper: sacct, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}), cache: jsonCache},
+			cache:    jsonCache,
+			scrapers: []*MockScraper{sinfo, sacct},
+		},
+		"fallback": {
+			fetcher:  &GpuCliFallbackFetcher{sinfoScraper: sinfoFallback, sacctScraper: sacctFallback, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}), cache: fallbackCache},
+			cache:    fallbackCache,
+			scrapers: []*MockScraper{sinfoFallback, sacctFallback},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := tc.fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			// the miss hydrates the shared cache
+			cached, ok := tc.cache.Get()
+			assert.True(ok)
+			assert.Same(metrics, cached)
+			assert.Positive(tc.cache.duration)
+			// the hit is served without rescraping
+			_, err = tc.fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			for _, scraper := range tc.scrapers {
+				assert.Equal(1, scraper.CallCount)
+			}
+		})
+	}
+}
+
 func TestGpuCollectorCollect(t *testing.T) {
 	assert := assert.New(t)
 
@@ -161,7 +282,7 @@ func TestGpuCollectorCollect(t *testing.T) {
 	collector.fetcher = &GpuJsonFetcher{
 		sinfoScraper: MockGpuSinfoScraper,
 		sacctScraper: MockGpuSacctScraper,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "test_gpu_errors",
 		}),
 		cache: &gpuCache{
@@ -169,7 +290,7 @@ func TestGpuCollectorCollect(t *testing.T) {
 		},
 	}
 
-	ch := make(chan prometheus.Metric, 10)
+	ch := make(chan prometheus.Metric, 30)
 	collector.Collect(ch)
 	close(ch)
 
@@ -178,8 +299,47 @@ func TestGpuCollectorCollect(t *testing.T) {
 		metricCount++
 	}
 
-	// Should collect 4 metrics: alloc, idle, total, utilization
-	assert.Equal(4, metricCount)
+	// Should collect 26 metrics: alloc, idle, total, utilization, unavailable, configured, scrape success,
+	// gpu seconds, 4 scrape error reasons, 2 stale, 3 node allocs and alloc, idle, total for each of the tesla, a100
+	// and untyped types
+	assert.Equal(26, metricCount)
+}
+
+func TestGpuCollectorCollect_FetchError(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		PollLimit: 10.0,
+		cliOpts: &CliOpts{
+			fallback:    false,
+			gpusEnabled: true,
+		},
+	}
+
+	collector := NewGpuCollector(config)
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper: new(MockFetchErrored),
+		sacctScraper: new(MockFetchErrored),
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+
+	// with both sinfo and sacct failing only the scrape success gauge and the scrape errors per reason are emitted
+	assert.Equal(5, len(ch))
+	metric := <-ch
+	assert.Equal(collector.scrapeSuccess, metric.Desc())
+	dtoMetric := new(dto.Metric)
+	assert.NoError(metric.Write(dtoMetric))
+	assert.Zero(dtoMetric.GetGauge().GetValue())
+	assert.Equal(2., CollectCounterValue(collector.fetcher.ScrapeError().WithLabelValues(ScrapeErrorExec)))
 }
 
 func TestGpuCollectorDescribe(t *testing.T) {
@@ -195,7 +355,7 @@ func TestGpuCollectorDescribe(t *testing.T) {
 
 	collector := NewGpuCollector(config)
 
-	ch := make(chan *prometheus.Desc, 10)
+	ch := make(chan *prometheus.Desc, 20)
 	collector.Describe(ch)
 	close(ch)
 
@@ -204,6 +364,1462 @@ func TestGpuCollectorDescribe(t *testing.T) {
 		descCount++
 	}
 
-	// Should describe 4 metrics
-	assert.Equal(4, descCount)
+	// Should describe 14 metrics
+	assert.Equal(14, descCount)
+}
+
+func TestGpuJsonFetcher_AllocExceedsTotal(t *testing.T) {
+	assert := assert.New(t)
+
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: &StringByteScraper{msg: `{"errors": [], "nodes": [{"gres": "gpu:2"}]}`},
+		sacctScraper: &StringByteScraper{msg: `{"errors": [], "jobs": [{"allocated_gres": "gpu:4"}]}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	assert.Equal(2., metrics.Total)
+	assert.Equal(4., metrics.Alloc)
+	assert.Equal(0., metrics.Idle)
+}
+
+func TestGpuJsonFetcher_StringVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	// slurm 24.05 reports version numbers as strings
+	meta := `"meta": {"plugin": {"data_parser": "data_parser/v0.0.41"}, "slurm": {"version": {"major": "24", "micro": "5", "minor": "05"}, "release": "24.05.5"}}`
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: &StringByteScraper{msg: `{` + meta + `, "errors": [], "nodes": [{"gres": "gpu:4"}]}`},
+		sacctScraper: &StringByteScraper{msg: `{` + meta + `, "errors": [], "jobs": [{"allocated_gres": "gpu:1"}]}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	assert.Equal(4., metrics.Total)
+	assert.Equal(1., metrics.Alloc)
+}
+
+func TestGpuCliFallbackFetcher_AllocExceedsTotal(t *testing.T) {
+	assert := assert.New(t)
+
+	// a running job counted on a node sinfo has just marked down
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: &StringByteScraper{msg: "gpu:a100:4|\n(null)|"},
+		sacctScraper: &StringByteScraper{msg: "gpu:a100:4\ngpu:a100:2"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	assert.Equal(4., metrics.Total)
+	assert.Equal(6., metrics.Alloc)
+	assert.Equal(0., metrics.Idle)
+	assert.Equal(1.5, metrics.Utilization)
+}
+
+func TestGpuJsonFetcher_AllocCrosscheck(t *testing.T) {
+	assert := assert.New(t)
+
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper:  MockGpuSinfoScraper,
+		sacctScraper:  MockGpuSacctScraper,
+		squeueScraper: MockGpuSqueueScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	// sacct reports 7 allocated, squeue reports 3
+	assert.Equal(7., metrics.Alloc)
+	assert.Equal(4., metrics.AllocDiscrepancy)
+}
+
+func TestGpuCliFallbackFetcher_AllocCrosscheck(t *testing.T) {
+	assert := assert.New(t)
+
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper:  MockGpuSinfoFallbackScraper,
+		sacctScraper:  MockGpuSacctFallbackScraper,
+		squeueScraper: MockGpuSqueueFallbackScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "test_gpu_errors",
+		}),
+		cache: &gpuCache{
+			limit: 10.0,
+		},
+	}
+
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	assert.Equal(9., metrics.Alloc)
+	assert.Equal(6., metrics.AllocDiscrepancy)
+}
+
+// a failed cross check keeps every other GPU series
+func TestGpuFetchers_AllocCrosscheckFailure(t *testing.T) {
+	for name, fetcher := range map[string]GpuFetcher{
+		"json": &GpuJsonFetcher{
+			sinfoScraper:  MockGpuSinfoScraper,
+			sacctScraper:  MockGpuSacctScraper,
+			squeueScraper: new(MockFetchErrored),
+			errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:         &gpuCache{limit: 10.0},
+		},
+		"fallback": &GpuCliFallbackFetcher{
+			sinfoScraper:  MockGpuSinfoFallbackScraper,
+			sacctScraper:  MockGpuSacctFallbackScraper,
+			squeueScraper: new(MockFetchErrored),
+			errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:         &gpuCache{limit: 10.0},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			assert.True(metrics.DiscrepancyStale)
+			assert.False(metrics.AllocStale)
+			assert.Positive(metrics.Alloc)
+			assert.Zero(metrics.AllocDiscrepancy)
+			assert.Equal(1., CollectCounterValue(fetcher.ScrapeError().WithLabelValues(ScrapeErrorExec)))
+		})
+	}
+}
+
+func TestGpuCollectorDescribe_Crosscheck(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		PollLimit: 10.0,
+		cliOpts: &CliOpts{
+			fallback:           true,
+			gpusEnabled:        true,
+			gpuAllocCrosscheck: true,
+		},
+	}
+
+	collector := NewGpuCollector(config)
+	assert.NotNil(collector.allocDiscrepancy)
+
+	ch := make(chan *prometheus.Desc, 20)
+	collector.Describe(ch)
+	close(ch)
+
+	// alloc, idle, total, utilization, unavailable, configured, node alloc, scrape success, stale,
+	// gpu seconds, scrape error, alloc discrepancy and the per type alloc, idle, total
+	assert.Equal(15, len(ch))
+}
+
+func TestGpuJsonFetcher_NodeAlloc(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(map[string]float64{"gpu01": 5, "gpu02": 1, "gpu03": 1}, metrics.NodeAlloc)
+}
+
+func TestGpuCliFallbackFetcher_NodeAlloc(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: MockGpuSinfoFallbackScraper,
+		sacctScraper: MockGpuSacctFallbackScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// gpu:2 on gpu[01-02] is per node
+	assert.Equal(9., metrics.Alloc)
+	assert.Equal(map[string]float64{"gpu01": 6, "gpu02": 2, "gpu03": 1}, metrics.NodeAlloc)
+}
+
+func TestGpuCliFallbackFetcher_MultiNode(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: MockGpuSinfoFallbackScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_multinode_fallback.txt"},
+		perJob:       true,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(20., metrics.Alloc)
+	assert.Equal(map[string]float64{"untyped": 16, "a100": 4}, metrics.TypeAlloc)
+	assert.Equal(map[string]float64{"gpu01": 4, "gpu02": 4, "gpu03": 4, "gpu04": 4, "gpu05": 2, "gpu06": 2}, metrics.NodeAlloc)
+	assert.Equal([]JobGpuAlloc{
+		{JobId: "1001", User: "alice", Gpus: 16},
+		{JobId: "1002", User: "bob", Gpus: 4},
+	}, metrics.JobAlloc)
+}
+
+func TestGpuCliFallbackFetcher_NodeAllocMalformed(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: MockGpuSinfoFallbackScraper,
+		sacctScraper: &StringByteScraper{msg: "gpu:2|gpu[01-02\ngpu:1|None assigned\ngpu:4"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// the cluster wide alloc is unaffected by a bad nodelist
+	assert.Equal(7., metrics.Alloc)
+	assert.Empty(metrics.NodeAlloc)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestGpuCollector_ExternalLabels(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		PollLimit: 10.0,
+		cliOpts: &CliOpts{
+			gpusEnabled: true,
+		},
+	}
+	collector := NewGpuCollector(config)
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(prometheus.Labels{"region": "us-east"}, registry).MustRegister(collector)
+	families, err := registry.Gather()
+	assert.NoError(err)
+	assert.NotEmpty(families)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			assert.Equal("us-east", labels["region"], family.GetName())
+		}
+	}
+}
+
+func TestGpuJsonFetcher_PerJob(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_jobs_out.json"},
+		perJob:       true,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal([]JobGpuAlloc{
+		{JobId: "26515966", User: "bkd", Gpus: 8},
+		{JobId: "26515967", User: "bkd", Gpus: 1},
+		{JobId: "26515970", User: "alice", Gpus: 2},
+	}, metrics.JobAlloc)
+}
+
+func TestGpuCliFallbackFetcher_PerJob(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: MockGpuSinfoFallbackScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_jobs_fallback.txt"},
+		perJob:       true,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(11., metrics.Alloc)
+	assert.Equal([]JobGpuAlloc{
+		{JobId: "26515966", User: "bkd", Gpus: 8},
+		{JobId: "26515967", User: "bkd", Gpus: 1},
+		{JobId: "26515970", User: "alice", Gpus: 2},
+	}, metrics.JobAlloc)
+	assert.Equal(map[string]float64{"gpu01": 4, "gpu02": 4, "gpu03": 1, "gpu04": 2}, metrics.NodeAlloc)
+}
+
+func TestGpuJsonFetcher_PerJobDisabled(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_jobs_out.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Empty(metrics.JobAlloc)
+}
+
+func TestGpuCollectorCollect_PerJob(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		PollLimit: 10.0,
+		cliOpts: &CliOpts{
+			gpusEnabled: true,
+			gpuPerJob:   true,
+		},
+	}
+	collector := NewGpuCollector(config)
+	assert.NotNil(collector.jobAlloc)
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_jobs_out.json"},
+		perJob:       true,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	ch := make(chan prometheus.Metric, 40)
+	collector.Collect(ch)
+	close(ch)
+	jobSeries := 0
+	for metric := range ch {
+		if metric.Desc() == collector.jobAlloc {
+			jobSeries++
+		}
+	}
+	assert.Equal(3, jobSeries)
+}
+
+func TestGpuCacheSmooth(t *testing.T) {
+	assert := assert.New(t)
+	alpha := 0.3
+	cache := &gpuCache{alpha: alpha}
+	expected := 0.0
+	for i, utilization := range []float64{0.8, 0.2, 0.5, 1, 0} {
+		if i == 0 {
+			expected = utilization
+		} else {
+			expected = alpha*utilization + (1-alpha)*expected
+		}
+		assert.InDelta(expected, cache.smooth(utilization), 1e-9)
+	}
+}
+
+func TestGpuCacheSmooth_Disabled(t *testing.T) {
+	assert := assert.New(t)
+	cache := new(gpuCache)
+	for _, utilization := range []float64{0.8, 0.2, 0.5} {
+		assert.Equal(utilization, cache.smooth(utilization))
+	}
+	assert.Nil(cache.smoothedUtilization)
+}
+
+func TestGpuCliFallbackFetcher_SmoothedUtilization(t *testing.T) {
+	assert := assert.New(t)
+	sacct := &StringByteScraper{}
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: &StringByteScraper{msg: "gpu:10|"},
+		sacctScraper: sacct,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		// refetch on every call
+		cache: &gpuCache{limit: 0, alpha: 0.5},
+	}
+	expected := []float64{1, 0.5, 0.5}
+	for i, alloc := range []string{"gpu:10", "gpu:0", "gpu:5"} {
+		sacct.msg = alloc
+		metrics, err := fetcher.FetchMetrics(context.Background())
+		assert.NoError(err)
+		assert.InDelta(expected[i], metrics.Utilization, 1e-9)
+	}
+}
+
+func TestNewConfig_GpuUtilizationAlpha(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{GpuUtilizationAlpha: 0.2})
+	assert.NoError(err)
+	assert.Equal(0.2, config.cliOpts.gpuUtilizationAlpha)
+	_, err = NewConfig(&CliFlags{GpuUtilizationAlpha: 1.5})
+	assert.Error(err)
+}
+
+func TestParseTresGpuCount(t *testing.T) {
+	assert := assert.New(t)
+	// the untyped total wins over the typed breakdown
+	assert.Equal(8., parseTresGpuCount([]sacctTres{
+		{Type: "cpu", Count: 16},
+		{Type: "gres", Name: "gpu:a100", Count: 8},
+		{Type: "gres", Name: "gpu", Count: 8},
+	}, "gpu"))
+	assert.Equal(3., parseTresGpuCount([]sacctTres{
+		{Type: "gres", Name: "gpu:a100", Count: 1},
+		{Type: "gres", Name: "gpu:tesla", Count: 2},
+	}, "gpu"))
+	assert.Zero(parseTresGpuCount([]sacctTres{{Type: "cpu", Count: 4}, {Type: "gres", Name: "shard", Count: 4}}, "gpu"))
+	// the name defaults to gpu
+	assert.Equal(2., parseTresGpuCount([]sacctTres{{Type: "gres", Name: "gpu", Count: 2}}, ""))
+	assert.Equal(2., parseTresGpuCount([]sacctTres{
+		{Type: "gres", Name: "gpu", Count: 4},
+		{Type: "gres", Name: "nvidia_gpu", Count: 2},
+	}, "nvidia_gpu"))
+}
+
+func TestParseGresGpuCount_MalformedTres(t *testing.T) {
+	tests := []struct {
+		name     string
+		gres     string
+		expected float64
+	}{
+		{"Empty value", "gres/gpu=", 0.0},
+		{"Missing equals", "gres/gpu", 0.0},
+		{"Non numeric", "gres/gpu=abc", 0.0},
+		{"Empty value among TRES", "cpu=4,gres/gpu=,mem=1G", 0.0},
+		{"Missing equals among TRES", "cpu=4,gres/gpu", 0.0},
+		{"Empty typed value", "gres/gpu:a100=", 0.0},
+		{"Malformed total with valid type", "gres/gpu=abc,gres/gpu:a100=2", 2.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseGresGpuCount(tt.gres, "gpu"), "Failed for input: %s", tt.gres)
+		})
+	}
+}
+
+func TestParseGresGpuCount_CustomName(t *testing.T) {
+	tests := []struct {
+		name     string
+		gres     string
+		expected float64
+	}{
+		{"Legacy GRES", "nvidia_gpu:2", 2.0},
+		{"Legacy typed GRES", "nvidia_gpu:a100:4(IDX:0-3)", 4.0},
+		{"Legacy GRES ignores gpu", "gpu:8,nvidia_gpu:2", 2.0},
+		{"TRES", "cpu=4,mem=1024M,gres/nvidia_gpu=2", 2.0},
+		{"Typed TRES", "cpu=4,gres/nvidia_gpu:a100=4", 4.0},
+		{"TRES ignores gpu", "cpu=4,gres/gpu=8", 0.0},
+		{"Uppercase", "NVIDIA_GPU:3", 3.0},
+		{"squeue GRES", "gres/nvidia_gpu:4", 4.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseGresGpuCount(tt.gres, "nvidia_gpu"), "Failed for input: %s", tt.gres)
+		})
+	}
+}
+
+func TestGpuFetchers_CustomGresName(t *testing.T) {
+	fetchers := map[string]GpuFetcher{
+		"json": &GpuJsonFetcher{
+			sinfoScraper:   &StringByteScraper{msg: `{"errors": [], "nodes": [{"gres": "nvidia_gpu:a100:4"}, {"gres": "nvidia_gpu:4,gpu:2"}]}`},
+			sacctScraper:   &StringByteScraper{msg: `{"errors": [], "jobs": [{"allocated_gres": "nvidia_gpu:a100:2"}, {"tres": {"allocated": [{"type": "gres", "name": "nvidia_gpu", "count": 1}]}}]}`},
+			pendingScraper: &StringByteScraper{msg: `{"errors": [], "jobs": [{"tres_req_str": "cpu=4,gres/nvidia_gpu=3"}, {"tres_req_str": "cpu=4,gres/gpu=1"}]}`},
+			gresName:       "nvidia_gpu",
+			errorCounter:   NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:          &gpuCache{limit: 10.0},
+		},
+		"fallback": &GpuCliFallbackFetcher{
+			sinfoScraper:   &StringByteScraper{msg: "nvidia_gpu:a100:4\nnvidia_gpu:4,gpu:2"},
+			sacctScraper:   &StringByteScraper{msg: "nvidia_gpu:a100:2\ngres/nvidia_gpu=1"},
+			pendingScraper: &StringByteScraper{msg: "gres/nvidia_gpu=3|1\ngres/gpu=1|1"},
+			gresName:       "nvidia_gpu",
+			errorCounter:   NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:          &gpuCache{limit: 10.0},
+		},
+	}
+	for name, fetcher := range fetchers {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			assert.Equal(8., metrics.Total)
+			assert.Equal(3., metrics.Alloc)
+			assert.Equal(3., metrics.RequestedPending)
+		})
+	}
+}
+
+func TestParseGresGpuTypes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(map[string]float64{"a100": 8}, parseGresGpuTypes("gpu:a100:8(IDX:0-7)", "gpu"))
+	// legacy entries add up
+	assert.Equal(map[string]float64{"": 2, "tesla": 1}, parseGresGpuTypes("gpu:2,gpu:tesla:1", "gpu"))
+	assert.Equal(map[string]float64{"v100": 2}, parseGresGpuTypes("gres/gpu:v100:2", "gpu"))
+	// the untyped TRES entry is the total, only the remainder is untyped
+	assert.Equal(map[string]float64{"a100": 4}, parseGresGpuTypes("cpu=8,gres/gpu=4,gres/gpu:a100=4", "gpu"))
+	assert.Equal(map[string]float64{"": 1, "a100": 2}, parseGresGpuTypes("gres/gpu=3,gres/gpu:a100=2", "gpu"))
+	assert.Equal(map[string]float64{"a100": 2, "v100": 1}, parseGresGpuTypes("gres/gpu:a100=2,gres/gpu:v100=1", "gpu"))
+	assert.Empty(parseGresGpuTypes("(null)", "gpu"))
+	assert.Equal(map[string]float64{"": 2, "v100": 2}, parseTresGpuTypes([]sacctTres{
+		{Type: "gres", Name: "gpu", Count: 4},
+		{Type: "gres", Name: "gpu:v100", Count: 2},
+	}, "gpu"))
+}
+
+func TestAddGpuTypes(t *testing.T) {
+	assert := assert.New(t)
+	types := map[string]float64{"a100": 1}
+	addGpuTypes(types, map[string]float64{"": 2, "a100": 1}, "")
+	assert.Equal(map[string]float64{"a100": 2, "untyped": 2}, types)
+	addGpuTypes(types, map[string]float64{"": 2}, "a100")
+	assert.Equal(map[string]float64{"a100": 4, "untyped": 2}, types)
+}
+
+func newGpuTypeFetchers(defaultType string) map[string]GpuFetcher {
+	return map[string]GpuFetcher{
+		"json": &GpuJsonFetcher{
+			sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_types.json"},
+			sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_types.json"},
+			defaultType:  defaultType,
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+		"fallback": &GpuCliFallbackFetcher{
+			sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_types_fallback.txt"},
+			sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_types_fallback.txt"},
+			defaultType:  defaultType,
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+	}
+}
+
+// the untyped gpu:2 allocation lands on an a100 node but can't be matched against the a100 total
+func TestGpuFetchers_TypesUntypedBucket(t *testing.T) {
+	for name, fetcher := range newGpuTypeFetchers("") {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			assert.Equal(10., metrics.Total)
+			assert.Equal(7., metrics.Alloc)
+			assert.Equal(map[string]float64{"a100": 8, "v100": 2, "untyped": 0}, metrics.TypeTotal)
+			assert.Equal(map[string]float64{"a100": 3, "v100": 2, "untyped": 2}, metrics.TypeAlloc)
+			assert.Equal(map[string]float64{"a100": 5, "v100": 0, "untyped": 0}, metrics.TypeIdle)
+			assert.Equal(map[string]float64{"gpu-a100-01": 3, "gpu-a100-02": 2, "gpu-v100-01": 2}, metrics.NodeAlloc)
+		})
+	}
+}
+
+func TestGpuFetchers_TypesDefaultType(t *testing.T) {
+	for name, fetcher := range newGpuTypeFetchers("a100") {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			assert.Equal(map[string]float64{"a100": 8, "v100": 2}, metrics.TypeTotal)
+			assert.Equal(map[string]float64{"a100": 5, "v100": 2}, metrics.TypeAlloc)
+			assert.Equal(map[string]float64{"a100": 3, "v100": 0}, metrics.TypeIdle)
+			assert.Equal(3., metrics.Idle)
+		})
+	}
+}
+
+func TestGpuMetricsSetTypes_Clamped(t *testing.T) {
+	assert := assert.New(t)
+	// sinfo and sacct aren't atomic, alloc can briefly exceed total
+	metrics := newGpuMetrics(4, 5)
+	metrics.setTypes(map[string]float64{"a100": 4}, map[string]float64{"a100": 5})
+	assert.Equal(map[string]float64{"a100": 0}, metrics.TypeIdle)
+}
+
+func TestGpuCollector_PerType(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	collector.fetcher = newGpuTypeFetchers("")["json"]
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	assert.NoError(err)
+	idle := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "slurm_gpus_idle_per_type" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			idle[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(map[string]float64{"a100": 5, "v100": 0, "untyped": 0}, idle)
+}
+
+func TestNewConfig_GpuDefaultType(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{GpuDefaultType: "a100"})
+	assert.NoError(err)
+	fetcher := NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.Equal("a100", fetcher.defaultType)
+}
+
+func TestNewConfig_GpuGresName(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal("gpu", config.cliOpts.gpuGresName)
+	config, err = NewConfig(&CliFlags{GpuGresName: "nvidia_gpu"})
+	assert.NoError(err)
+	assert.Equal("nvidia_gpu", config.cliOpts.gpuGresName)
+	fetcher := NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.Equal("nvidia_gpu", fetcher.gresName)
+}
+
+func TestGpuJsonFetcher_AllocatedTres(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_2311.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(10., metrics.Alloc)
+	assert.Equal(map[string]float64{"gpu01": 4, "gpu02": 4, "gpu03": 2}, metrics.NodeAlloc)
+}
+
+func TestArrayTaskCount(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(1., arrayTaskCount(""))
+	assert.Equal(10., arrayTaskCount("1-10"))
+	assert.Equal(10., arrayTaskCount("1-10%2"))
+	assert.Equal(5., arrayTaskCount("1-9:2"))
+	assert.Equal(4., arrayTaskCount("1,3,5-6"))
+	// unparsable task strings count as a single job
+	assert.Equal(1., arrayTaskCount("a-b"))
+}
+
+func TestGpuJsonFetcher_Pending(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper:   MockGpuSinfoScraper,
+		sacctScraper:   MockGpuSacctScraper,
+		pendingScraper: &MockScraper{fixture: "fixtures/squeue_gpu_pending.json"},
+		errorCounter:   NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:          &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// 4 + an array of 10 tasks requesting 1 each
+	assert.Equal(14., metrics.RequestedPending)
+}
+
+func TestGpuCliFallbackFetcher_Pending(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper:   MockGpuSinfoFallbackScraper,
+		sacctScraper:   MockGpuSacctFallbackScraper,
+		pendingScraper: &MockScraper{fixture: "fixtures/squeue_gpu_pending_fallback.txt"},
+		errorCounter:   NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:          &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// gpu:4 on 2 nodes, 3 array tasks with gpu:1, a cpu only job
+	assert.Equal(11., metrics.RequestedPending)
+}
+
+// mixed nodes contribute their unallocated GPUs to idle rather than all or nothing
+func TestGpuFetchers_MixedNode(t *testing.T) {
+	fetchers := map[string]GpuFetcher{
+		"json": &GpuJsonFetcher{
+			sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_mixed.json"},
+			sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_mixed.json"},
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+		"fallback": &GpuCliFallbackFetcher{
+			sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_mixed_fallback.txt"},
+			sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_mixed_fallback.txt"},
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+	}
+	for name, fetcher := range fetchers {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			assert.Equal(8., metrics.Total)
+			assert.Equal(3., metrics.Alloc)
+			assert.Equal(5., metrics.Idle)
+			assert.Equal(map[string]float64{"gpu-mix01": 3}, metrics.NodeAlloc)
+		})
+	}
+}
+
+// allocations are read from sinfo, sacct is never queried
+func TestGpuJsonFetcher_GresUsedAlloc(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper:  &MockScraper{fixture: "fixtures/sinfo_gpu_gres_used.json"},
+		gresUsedAlloc: true,
+		errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:         &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(24., metrics.Total)
+	assert.Equal(12., metrics.Alloc)
+	assert.Equal(12., metrics.Idle)
+	assert.Equal(map[string]float64{"a100": 11, "h100": 1, "untyped": 0}, metrics.TypeAlloc)
+	assert.Equal(map[string]float64{"gpu01": 3, "gpu02": 8, "gpu03": 1}, metrics.NodeAlloc)
+	assert.Empty(metrics.JobAlloc)
+}
+
+func TestNewGresGpuMetrics(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true})
+	assert.NoError(err)
+	data, err := os.ReadFile("fixtures/sinfo_gpu_gres_used.json")
+	assert.NoError(err)
+	resp := new(sinfoGpuResponse)
+	assert.NoError(json.Unmarshal(data, resp))
+	var nodes []GpuGresNode
+	for _, node := range resp.Nodes {
+		nodes = append(nodes, GpuGresNode{Name: node.nodeName(), Gres: node.Gres, GresUsed: node.GresUsed, State: node.State})
+	}
+	// same counts as the gres_used alloc source
+	metrics := NewGresGpuMetrics(config, nodes)
+	assert.Equal(24., metrics.Total)
+	assert.Equal(12., metrics.Alloc)
+	assert.Equal(12., metrics.Idle)
+	assert.Equal(map[string]float64{"a100": 11, "h100": 1, "untyped": 0}, metrics.TypeAlloc)
+	assert.Equal(map[string]float64{"gpu01": 3, "gpu02": 8, "gpu03": 1}, metrics.NodeAlloc)
+	assert.Empty(metrics.JobAlloc)
+}
+
+func TestNewConfig_GpuAllocSource(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true})
+	assert.NoError(err)
+	assert.Equal(gpuAllocSourceSacct, config.cliOpts.gpuAllocSource)
+	fetcher := NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.NotNil(fetcher.sacctScraper)
+	config, err = NewConfig(&CliFlags{SlurmGpusEnabled: true, GpuAllocSource: "gres_used"})
+	assert.NoError(err)
+	fetcher = NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.True(fetcher.gresUsedAlloc)
+	assert.Nil(fetcher.sacctScraper)
+	assert.NotContains(config.cliOpts.debugCommands(), "sacct_gpu")
+	_, err = NewConfig(&CliFlags{GpuAllocSource: "gres_used", SlurmCliFallback: true})
+	assert.Error(err)
+	_, err = NewConfig(&CliFlags{GpuAllocSource: "squeue"})
+	assert.Error(err)
+}
+
+func TestNewConfig_GpuAllocStates(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal([]string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=RUNNING", "--json"}, config.cliOpts.sacctGpu)
+	config, err = NewConfig(&CliFlags{GpuAllocStates: "running, completing,CONFIGURING"})
+	assert.NoError(err)
+	assert.Equal([]string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=RUNNING,COMPLETING,CONFIGURING", "--json"}, config.cliOpts.sacctGpu)
+	config, err = NewConfig(&CliFlags{GpuAllocStates: "RUNNING,COMPLETING", SlurmCliFallback: true})
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "-h", "-t", "RUNNING,COMPLETING", "-o", "%b|%N|%A|%u|%D"}, config.cliOpts.sacctGpu)
+	// an override wins over the states
+	config, err = NewConfig(&CliFlags{GpuAllocStates: "COMPLETING", SlurmSacctGpuOverride: "sacct --state=RUNNING --json"})
+	assert.NoError(err)
+	assert.Equal([]string{"sacct", "--state=RUNNING", "--json"}, config.cliOpts.sacctGpu)
+	for _, states := range []string{"RUNNING;rm", "RUNNING --json", "R1"} {
+		_, err := NewConfig(&CliFlags{GpuAllocStates: states})
+		assert.Error(err, states)
+	}
+}
+
+func TestParseGpuAllocStates(t *testing.T) {
+	assert := assert.New(t)
+	for input, expected := range map[string]string{
+		"":                    "RUNNING",
+		" , ":                 "RUNNING",
+		"running":             "RUNNING",
+		"RUNNING,completing":  "RUNNING,COMPLETING",
+		"RUNNING,,COMPLETED ": "RUNNING,COMPLETED",
+	} {
+		states, err := parseGpuAllocStates(input)
+		assert.NoError(err, input)
+		assert.Equal(expected, states, input)
+	}
+	_, err := parseGpuAllocStates("RUNNING --json")
+	assert.ErrorContains(err, "GPU alloc states")
+}
+
+// totals are read from the GRES column wherever the header puts it
+func TestGpuCliFallbackFetcher_Header(t *testing.T) {
+	for name, nodeFormat := range map[string]bool{"node": true, "gres": false} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			fetcher := &GpuCliFallbackFetcher{
+				sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_fallback_header.txt"},
+				nodeFormat:   nodeFormat,
+				sacctScraper: &StringByteScraper{msg: ""},
+				errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:        &gpuCache{limit: 10.0},
+			}
+			metrics, err := fetcher.FetchMetrics(context.Background())
+			assert.NoError(err)
+			// cs200 is listed under 2 partitions but only counted once
+			assert.Equal(12., metrics.Total)
+			assert.Equal(map[string]float64{"a100": 8, "untyped": 4}, metrics.TypeTotal)
+			assert.Equal(map[string]float64{"cs200": 8, "cs201": 4}, metrics.NodeTotal)
+		})
+	}
+}
+
+func TestGpuCliFallbackFetcher_ParseSkipped(t *testing.T) {
+	assert := assert.New(t)
+	sinfoBefore := CollectCounterValue(gpuParseSkippedCounter.WithLabelValues("sinfo"))
+	sacctBefore := CollectCounterValue(gpuParseSkippedCounter.WithLabelValues("sacct"))
+	fetcher := &GpuCliFallbackFetcher{
+		// the node format expects the gres in the 10th column
+		sinfoScraper: &StringByteScraper{msg: "idle|1000|gpu01|0.01|hw|1000|0/8/0/8|1|0|gpu:4\nidle|1000|gpu02\nsinfo: error"},
+		nodeFormat:   true,
+		sacctScraper: &StringByteScraper{msg: "gpu:2|gpu01|1|alice\n|gpu01|2|bob\ngpu:1|gpu01|3|bob|1|extra"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(4., metrics.Total)
+	assert.Equal(2., metrics.Alloc)
+	assert.Equal(sinfoBefore+2, CollectCounterValue(gpuParseSkippedCounter.WithLabelValues("sinfo")))
+	assert.Equal(sacctBefore+2, CollectCounterValue(gpuParseSkippedCounter.WithLabelValues("sacct")))
+}
+
+func TestGpuCliFallbackFetcher_QuotedAlloc(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuCliFallbackFetcher{
+		sinfoScraper: &StringByteScraper{msg: "gpu:a100:8|\ngpu:2|"},
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_quoted_fallback.txt"},
+		perJob:       true,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(5., metrics.Alloc)
+	assert.Equal(map[string]float64{"a100": 4, "untyped": 1}, metrics.TypeAlloc)
+	assert.Equal(map[string]float64{"gpu01": 1, "gpu02": 1, "gpu03": 1, "gpu04": 1, "gpu05": 1}, metrics.NodeAlloc)
+	assert.Contains(metrics.JobAlloc, JobGpuAlloc{JobId: "26515968", User: "carol", Gpus: 1})
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestNodeGpuUtilizationHistogram(t *testing.T) {
+	assert := assert.New(t)
+	// gpu03 reports more allocated than configured GPUs, cpu01 has none
+	count, sum, buckets := nodeGpuUtilizationHistogram(
+		map[string]float64{"gpu01": 4, "gpu02": 4, "gpu03": 2, "cpu01": 0},
+		map[string]float64{"gpu02": 3, "gpu03": 4, "cpu01": 1},
+	)
+	assert.Equal(uint64(3), count)
+	assert.Equal(1.75, sum)
+	assert.Equal(map[float64]uint64{0: 1, 0.25: 1, 0.5: 1, 0.75: 2, 1: 3}, buckets)
+}
+
+func TestGpuCollector_NodeUtilizationHistogram(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true, gpuNodeHistogram: true}})
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper:  &MockScraper{fixture: "fixtures/sinfo_gpu_spread.json"},
+		gresUsedAlloc: true,
+		errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:         &gpuCache{limit: 10.0},
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	assert.NoError(err)
+	idx := slices.IndexFunc(families, func(family *dto.MetricFamily) bool { return family.GetName() == "slurm_node_gpu_utilization" })
+	assert.GreaterOrEqual(idx, 0)
+	histogram := families[idx].GetMetric()[0].GetHistogram()
+	// 0, 0.25, 0.5, 1, 1 and 0.375 from the 8 GPU node
+	assert.Equal(uint64(6), histogram.GetSampleCount())
+	assert.Equal(3.125, histogram.GetSampleSum())
+	buckets := make(map[float64]uint64)
+	for _, bucket := range histogram.GetBucket() {
+		buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+	}
+	assert.Equal(map[float64]uint64{0: 1, 0.25: 2, 0.5: 4, 0.75: 4, 1: 6}, buckets)
+}
+
+func TestGpuCollector_NodeUtilizationHistogramDisabled(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	assert.Nil(collector.nodeUtilization)
+}
+
+// gpu02 is drained with 4 idle GPUs and gpu03 is draining with 1 of 4 GPUs still allocated
+func TestGpuFetchers_UtilizationBasis(t *testing.T) {
+	fetchers := func(basis string) map[string]GpuFetcher {
+		return map[string]GpuFetcher{
+			"json": &GpuJsonFetcher{
+				sinfoScraper:  &MockScraper{fixture: "fixtures/sinfo_gpu_drained.json"},
+				gresUsedAlloc: true,
+				utilBasis:     basis,
+				errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:         &gpuCache{limit: 10.0},
+			},
+			"fallback": &GpuCliFallbackFetcher{
+				sinfoScraper: &MockScraper{fixture: "fixtures/sinfo_gpu_drained_fallback.txt"},
+				nodeFormat:   true,
+				sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_drained_fallback.txt"},
+				utilBasis:    basis,
+				errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:        &gpuCache{limit: 10.0},
+			},
+		}
+	}
+	for _, tc := range []struct {
+		basis       string
+		utilization float64
+	}{
+		{gpuUtilizationBasisTotal, 0.25},
+		{gpuUtilizationBasisAvailable, 0.6},
+	} {
+		for mode, fetcher := range fetchers(tc.basis) {
+			t.Run(tc.basis+"/"+mode, func(t *testing.T) {
+				assert := assert.New(t)
+				metrics, err := fetcher.FetchMetrics(context.Background())
+				assert.NoError(err)
+				assert.Equal(12., metrics.Total)
+				assert.Equal(3., metrics.Alloc)
+				assert.Equal(7., metrics.Unavailable)
+				assert.InDelta(tc.utilization, metrics.Utilization, 1e-9)
+			})
+		}
+	}
+}
+
+func TestNewConfig_GpuUtilizationBasis(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true})
+	assert.NoError(err)
+	assert.Equal(gpuUtilizationBasisTotal, config.cliOpts.gpuUtilizationBasis)
+	config, err = NewConfig(&CliFlags{SlurmGpusEnabled: true, GpuUtilizationBasis: "available"})
+	assert.NoError(err)
+	assert.Equal(gpuUtilizationBasisAvailable, NewGpuCollector(config).fetcher.(*GpuJsonFetcher).utilBasis)
+	_, err = NewConfig(&CliFlags{GpuUtilizationBasis: "schedulable"})
+	assert.Error(err)
+}
+
+// gathers the GPU collector into name -> label values -> gauge value
+func gatherGpuGauges(t *testing.T, collector *GpuCollector) map[string]map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	gauges := make(map[string]map[string]float64)
+	for _, family := range families {
+		gauges[family.GetName()] = make(map[string]float64)
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetValue())
+			}
+			gauges[family.GetName()][strings.Join(labels, ",")] = metric.GetGauge().GetValue()
+		}
+	}
+	return gauges
+}
+
+func TestGpuCollector_SacctFailure(t *testing.T) {
+	for mode, fetcher := range map[string]GpuFetcher{
+		"json": &GpuJsonFetcher{
+			sinfoScraper: MockGpuSinfoScraper,
+			sacctScraper: new(MockFetchErrored),
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+		"fallback": &GpuCliFallbackFetcher{
+			sinfoScraper: MockGpuSinfoFallbackScraper,
+			sacctScraper: new(MockFetchErrored),
+			errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:        &gpuCache{limit: 10.0},
+		},
+	} {
+		t.Run(mode, func(t *testing.T) {
+			assert := assert.New(t)
+			collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+			collector.fetcher = fetcher
+			gauges := gatherGpuGauges(t, collector)
+			assert.Equal(map[string]float64{"": 0}, gauges["slurm_gpus_scrape_success"])
+			assert.Equal(map[string]float64{"total": 0, "alloc": 1}, gauges["slurm_gpus_stale"])
+			assert.Equal(map[string]float64{"": 14}, gauges["slurm_gpus_total"])
+			assert.NotEmpty(gauges["slurm_gpus_total_per_type"])
+			for _, name := range []string{"slurm_gpus_alloc", "slurm_gpus_idle", "slurm_gpus_utilization", "slurm_node_gpus_alloc", "slurm_gpus_alloc_per_type"} {
+				assert.NotContains(gauges, name)
+			}
+		})
+	}
+}
+
+func TestGpuCollector_SinfoFailure(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper: new(MockFetchErrored),
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	gauges := gatherGpuGauges(t, collector)
+	assert.Equal(map[string]float64{"total": 1, "alloc": 0}, gauges["slurm_gpus_stale"])
+	assert.Contains(gauges, "slurm_gpus_alloc")
+	assert.NotContains(gauges, "slurm_gpus_total")
+	assert.NotContains(gauges, "slurm_gpus_utilization")
+}
+
+func TestGpuCollector_Configured(t *testing.T) {
+	for fixture, configured := range map[string]float64{"fixtures/sinfo_gpu_out.json": 1, "fixtures/sinfo_out.json": 0} {
+		t.Run(fixture, func(t *testing.T) {
+			assert := assert.New(t)
+			collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+			collector.fetcher = &GpuJsonFetcher{
+				sinfoScraper:  &MockScraper{fixture: fixture},
+				gresUsedAlloc: true,
+				errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:         &gpuCache{limit: 10.0},
+			}
+			gauges := gatherGpuGauges(t, collector)
+			assert.Equal(map[string]float64{"": configured}, gauges["slurm_gpus_configured"])
+			assert.Equal(map[string]float64{"": 1}, gauges["slurm_gpus_scrape_success"])
+		})
+	}
+	// unknown when sinfo fails
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper: new(MockFetchErrored),
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	assert.NotContains(t, gatherGpuGauges(t, collector), "slurm_gpus_configured")
+}
+
+func TestGpuCollector_PendingFailure(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true, gpuPending: true}})
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper:   MockGpuSinfoScraper,
+		sacctScraper:   MockGpuSacctScraper,
+		pendingScraper: new(MockFetchErrored),
+		errorCounter:   NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:          &gpuCache{limit: 10.0},
+	}
+	gauges := gatherGpuGauges(t, collector)
+	// only the pending gauge is dropped
+	assert.NotContains(gauges, "slurm_gpus_requested_pending")
+	assert.Equal(map[string]float64{"total": 0, "alloc": 0, "pending": 1}, gauges["slurm_gpus_stale"])
+	assert.NotEmpty(gauges["slurm_gpus_alloc"])
+	assert.NotEmpty(gauges["slurm_gpus_total"])
+	assert.Equal(map[string]float64{"": 1}, gauges["slurm_gpus_scrape_success"])
+}
+
+func TestGpuCollector_CrosscheckFailure(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true, gpuAllocCrosscheck: true}})
+	collector.fetcher = &GpuJsonFetcher{
+		sinfoScraper:  MockGpuSinfoScraper,
+		sacctScraper:  MockGpuSacctScraper,
+		squeueScraper: new(MockFetchErrored),
+		errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:         &gpuCache{limit: 10.0},
+	}
+	gauges := gatherGpuGauges(t, collector)
+	// only the discrepancy is dropped
+	assert.NotContains(gauges, "slurm_gpus_alloc_discrepancy")
+	assert.Equal(map[string]float64{"total": 0, "alloc": 0, "alloc_discrepancy": 1}, gauges["slurm_gpus_stale"])
+	assert.NotEmpty(gauges["slurm_gpus_alloc"])
+	assert.NotEmpty(gauges["slurm_gpus_utilization"])
+	assert.Equal(map[string]float64{"": 1}, gauges["slurm_gpus_scrape_success"])
+}
+
+func TestGpuCollector_PendingDisabled(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true})
+	assert.NoError(err)
+	collector := NewGpuCollector(config)
+	assert.Nil(collector.pending)
+	assert.Nil(collector.fetcher.(*GpuJsonFetcher).pendingScraper)
+	assert.NotContains(config.cliOpts.debugCommands(), "squeue_pending_gpu")
+}
+
+func TestGpuCollector_GpuSeconds(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	collector.fetcher = fetcher
+	gpuSeconds := func() float64 {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		families, err := registry.Gather()
+		assert.NoError(err)
+		for _, family := range families {
+			if family.GetName() == "slurm_gpu_seconds_total" {
+				return family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return -1
+	}
+	// the first fetch only starts the clock
+	assert.Zero(gpuSeconds())
+	alloc := collector.lastAlloc.Alloc
+	assert.Positive(alloc)
+	// served from the cache, nothing new to count
+	collector.lastAllocAt = collector.lastAllocAt.Add(-10 * time.Second)
+	assert.Zero(gpuSeconds())
+	// a fresh fetch 10s after the first
+	fetcher.cache.t = fetcher.cache.t.Add(-time.Minute)
+	assert.InDelta(alloc*10, gpuSeconds(), alloc)
+	// a second fresh fetch 20s later accumulates on top
+	collector.lastAllocAt = collector.lastAllocAt.Add(-20 * time.Second)
+	fetcher.cache.t = fetcher.cache.t.Add(-time.Minute)
+	assert.InDelta(alloc*30, gpuSeconds(), alloc)
+}
+
+func TestGpuCollector_GpuSecondsPreviousAlloc(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	assert.Zero(collector.accumulateGpuSeconds(&GpuMetrics{Alloc: 4}))
+	// the 4 GPUs were held until the allocation changed to 8
+	collector.lastAllocAt = collector.lastAllocAt.Add(-10 * time.Second)
+	assert.InDelta(40, collector.accumulateGpuSeconds(&GpuMetrics{Alloc: 8}), 1)
+	collector.lastAllocAt = collector.lastAllocAt.Add(-10 * time.Second)
+	assert.InDelta(120, collector.accumulateGpuSeconds(&GpuMetrics{Alloc: 0}), 1)
+}
+
+func TestGpuCollector_GpuSecondsStaleScrape(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewGpuCollector(&Config{PollLimit: 10.0, cliOpts: &CliOpts{gpusEnabled: true}})
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper: MockGpuSinfoScraper,
+		sacctScraper: MockGpuSacctScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:        &gpuCache{limit: 10.0},
+	}
+	collector.fetcher = fetcher
+	gpuSeconds := func() float64 {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		families, err := registry.Gather()
+		assert.NoError(err)
+		for _, family := range families {
+			if family.GetName() == "slurm_gpu_seconds_total" {
+				return family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return -1
+	}
+	// first fresh fetch starts the clock
+	assert.Zero(gpuSeconds())
+	// the alloc cmd fails an hour later
+	collector.lastAllocAt = collector.lastAllocAt.Add(-time.Hour)
+	fetcher.cache.t = fetcher.cache.t.Add(-time.Minute)
+	fetcher.sacctScraper = new(MockFetchErrored)
+	assert.Zero(gpuSeconds())
+	assert.Nil(collector.lastAlloc)
+	// the next fresh fetch restarts the clock instead of counting the hour it knows nothing about
+	fetcher.cache.t = fetcher.cache.t.Add(-time.Minute)
+	fetcher.sacctScraper = MockGpuSacctScraper
+	assert.Zero(gpuSeconds())
+	alloc := collector.lastAlloc.Alloc
+	assert.Positive(alloc)
+	collector.lastAllocAt = collector.lastAllocAt.Add(-10 * time.Second)
+	fetcher.cache.t = fetcher.cache.t.Add(-time.Minute)
+	assert.InDelta(alloc*10, gpuSeconds(), alloc)
+}
+
+func TestGpuCollector_GresUsedSinfoFailure(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper:  new(MockFetchErrored),
+		gresUsedAlloc: true,
+		errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:         &gpuCache{limit: 10.0},
+	}
+	// allocations come from the same sinfo output, so nothing is left to report
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+}
+
+func TestGpuJsonFetcher_TruncatedJson(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sinfoScraper:  &StringByteScraper{msg: `{"nodes": [{"name": "gpu01", "gres": "gpu:a100:4"`},
+		gresUsedAlloc: true,
+		errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+		cache:         &gpuCache{limit: 10.0},
+	}
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.ErrorContains(err, `gpu:a100:4`)
+}
+
+func FuzzParseGresGpuCount(f *testing.F) {
+	// inputs of the unit tests plus malformed gres
+	for _, gres := range []string{
+		"gpu:2", "gpu:tesla:4", "gpu:1(IDX:0)", "gpu:2,gpu:tesla:1", "", "N/A", "(null)", "cpu:8", "GPU:3",
+		"gpu:a100:8(IDX:0-7)", "gpu:2,mem:10G", "gpu:2,mps_gpu:100", "gres/gpu:4", "gres:gpu:tesla:2",
+		"cpu=4,mem=1024M,gres/gpu=2", "cpu=4,gres/gpu:tesla=4", "cpu=4,gres/gpumem=16G",
+		"cpu=8,gres/gpu=4,gres/gpu:a100=4", "gres/gpu=3,gres/gpu:a100=2", "nvidia_gpu:a100:4(IDX:0-3)",
+		"gpu:", "gres/gpu=", "gres/gpu", "gres/gpu=abc", "gpu:(IDX:)", "gpu:2,", ",,", ":", "=", "(",
+		"gpu:NaN", "gres/gpu=-1", "gpu:Inf", "gpu:1e308,gpu:1e308", "gpu:0x10", "gres/gpu=1.5",
+	} {
+		f.Add(gres, "gpu")
+	}
+	f.Add("cpu=4,gres/nvidia_gpu:a100=4", "nvidia_gpu")
+	f.Fuzz(func(t *testing.T, gres string, name string) {
+		count := parseGresGpuCount(gres, name)
+		if math.IsNaN(count) || math.IsInf(count, 0) || count < 0 {
+			t.Errorf("parseGresGpuCount(%q, %q) = %v", gres, name, count)
+		}
+		for gpuType, typeCount := range parseGresGpuTypes(gres, name) {
+			if math.IsNaN(typeCount) || math.IsInf(typeCount, 0) || typeCount < 0 {
+				t.Errorf("parseGresGpuTypes(%q, %q)[%q] = %v", gres, name, gpuType, typeCount)
+			}
+		}
+	})
+}
+
+func TestGpuJsonFetcher_ErrorReasons(t *testing.T) {
+	testFetcherErrorReasons(t, func(scraper SlurmByteScraper) errorReasonFetcher[*GpuMetrics] {
+		return &GpuJsonFetcher{
+			sinfoScraper:  scraper,
+			gresUsedAlloc: true,
+			errorCounter:  NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+			cache:         &gpuCache{limit: 10.0},
+		}
+	}, map[string]SlurmByteScraper{
+		ScrapeErrorUnmarshal: &StringByteScraper{msg: `{"sinfo": [`},
+		ScrapeErrorApi:       &StringByteScraper{msg: `{"sinfo": [], "errors": ["Unable to contact slurm controller"]}`},
+	})
+}
+
+func TestGpuJsonFetcher_SacctErrorsAfterJobs(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sacctScraper: &StringByteScraper{msg: `{"jobs": [{"job_id": 1, "nodes": "gpu01", "allocated_gres": "gpu:2"}], "errors": ["Unable to contact slurm controller"]}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+	}
+	typeAlloc, _, _, err := fetcher.fetchAllocatedGpus(context.Background())
+	assert.EqualError(err, "Unable to contact slurm controller")
+	assert.Nil(typeAlloc)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter.WithLabelValues(ScrapeErrorApi)))
+}
+
+func TestGpuJsonFetcher_SacctMissingJobs(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &GpuJsonFetcher{
+		sacctScraper: &MockScraper{fixture: "fixtures/sacct_gpu_empty.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+	}
+	missing := schemaMissingFieldCounter.WithLabelValues("sacct", "jobs")
+	before := CollectCounterValue(missing)
+	// no running GPU jobs is a legitimate 0
+	typeAlloc, _, _, err := fetcher.fetchAllocatedGpus(context.Background())
+	assert.NoError(err)
+	assert.Zero(sumGpuTypes(typeAlloc))
+	assert.Equal(before, CollectCounterValue(missing))
+	// a renamed jobs array also reads as 0, but is counted
+	fetcher.sacctScraper = &MockScraper{fixture: "fixtures/sacct_gpu_missing_jobs.json"}
+	typeAlloc, _, _, err = fetcher.fetchAllocatedGpus(context.Background())
+	assert.NoError(err)
+	assert.Zero(sumGpuTypes(typeAlloc))
+	assert.Equal(before+1, CollectCounterValue(missing))
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+// sacct --json output listing the given count of GPU jobs, spread over 8 GPU nodes
+func largeSacctGpuJson(b *testing.B, jobs int) []byte {
+	type tres struct {
+		Type  string `json:"type"`
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	type job struct {
+		JobId int    `json:"job_id"`
+		User  string `json:"user"`
+		Nodes string `json:"nodes"`
+		Tres  struct {
+			Allocated []tres `json:"allocated"`
+		} `json:"tres"`
+	}
+	resp := struct {
+		Errors []string `json:"errors"`
+		Jobs   []job    `json:"jobs"`
+	}{Errors: []string{}}
+	for i := range jobs {
+		j := job{JobId: i, User: fmt.Sprintf("user%d", i%50), Nodes: fmt.Sprintf("gpu%02d", i%8)}
+		j.Tres.Allocated = []tres{{Type: "cpu", Count: 8}, {Type: "mem", Count: 64000}, {Type: "gres", Name: "gpu", Count: 1 + i%4}}
+		resp.Jobs = append(resp.Jobs, j)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// full unmarshal into a slice of jobs vs streaming the jobs array, on a 50k job response
+func BenchmarkSacctGpuDecode(b *testing.B) {
+	data := largeSacctGpuJson(b, 50000)
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var resp struct {
+				Errors []string      `json:"errors"`
+				Jobs   []sacctGpuJob `json:"jobs"`
+			}
+			if err := unmarshalCliJson(data, &resp); err != nil {
+				b.Fatal(err)
+			}
+			total := 0.
+			for _, job := range resp.Jobs {
+				total += sumGpuTypes(job.allocatedGpuTypes(defaultGpuGresName))
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			total := 0.
+			if _, _, err := streamCliJsonArray(data, "jobs", func(job *sacctGpuJob) {
+				total += sumGpuTypes(job.allocatedGpuTypes(defaultGpuGresName))
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// sinfo --json output listing the given count of 8 GPU nodes
+func largeSinfoGpuJson(b *testing.B, nodes int) []byte {
+	type node struct {
+		Name  string `json:"name"`
+		Gres  string `json:"gres"`
+		State string `json:"state"`
+	}
+	resp := struct {
+		Errors []string `json:"errors"`
+		Nodes  []node   `json:"nodes"`
+	}{Errors: []string{}}
+	for i := range nodes {
+		resp.Nodes = append(resp.Nodes, node{Name: fmt.Sprintf("gpu%02d", i), Gres: "gpu:a100:8(S:0-1)", State: "mixed"})
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// fallback sinfo and sacct output of the given count of 8 GPU nodes and GPU jobs
+func largeGpuFallback(nodes int, jobs int) (string, string) {
+	var sinfo, sacct strings.Builder
+	for range nodes {
+		sinfo.WriteString("gpu:a100:8(S:0-1)|\n")
+	}
+	for i := range jobs {
+		fmt.Fprintf(&sacct, "\"gpu:a100:%d\"|gpu%02d|%d|user%d\n", 1+i%4, i%nodes, i, i%50)
+	}
+	return sinfo.String(), sacct.String()
+}
+
+func readBenchFixture(b *testing.B, fixture string) string {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return string(data)
+}
+
+func BenchmarkGpuJsonFetch(b *testing.B) {
+	sizes := []struct {
+		name         string
+		sinfo, sacct string
+	}{
+		{"small", readBenchFixture(b, "fixtures/sinfo_gpu_out.json"), readBenchFixture(b, "fixtures/sacct_gpu_out.json")},
+		{"large", string(largeSinfoGpuJson(b, 2000)), string(largeSacctGpuJson(b, 50000))},
+	}
+	for _, size := range sizes {
+		b.Run(size.name, func(b *testing.B) {
+			fetcher := &GpuJsonFetcher{
+				sinfoScraper: &StringByteScraper{msg: size.sinfo},
+				sacctScraper: &StringByteScraper{msg: size.sacct},
+				errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:        &gpuCache{limit: 10.0},
+			}
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := fetcher.fetch(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGpuCliFallbackFetch(b *testing.B) {
+	largeSinfo, largeSacct := largeGpuFallback(2000, 50000)
+	sizes := []struct {
+		name         string
+		sinfo, sacct string
+	}{
+		{"small", readBenchFixture(b, "fixtures/sinfo_gpu_fallback.txt"), readBenchFixture(b, "fixtures/sacct_gpu_fallback.txt")},
+		{"large", largeSinfo, largeSacct},
+	}
+	for _, size := range sizes {
+		b.Run(size.name, func(b *testing.B) {
+			fetcher := &GpuCliFallbackFetcher{
+				sinfoScraper: &StringByteScraper{msg: size.sinfo},
+				sacctScraper: &StringByteScraper{msg: size.sacct},
+				errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "test_gpu_errors"}),
+				cache:        &gpuCache{limit: 10.0},
+			}
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := fetcher.fetch(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseGresGpuCount(b *testing.B) {
+	for name, gres := range map[string]string{
+		"legacy":       "gpu:a100:8(IDX:0-7)",
+		"legacy_multi": "gpu:2,gpu:tesla:1,mps_gpu:100",
+		"tres":         "cpu=4,mem=1024M,node=1,billing=16,gres/gpu=4,gres/gpu:a100=4",
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				parseGresGpuCount(gres, defaultGpuGresName)
+			}
+		})
+	}
 }
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// guards against a malformed hostlist allocating an absurd number of hosts. Counted across every range,
+// bracket group and expression, since their product grows far faster than any single range
+const maxHosts = 1 << 16
+
+// expands slurm's compressed hostlist notation i.e "gpu[01-03],cpu1" -> gpu01, gpu02, gpu03, cpu1
+func ExpandHostlist(hostlist string) ([]string, error) {
+	hosts := make([]string, 0)
+	for _, expr := range splitHostlist(hostlist) {
+		expanded, err := expandHostExpr(expr, maxHosts-len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+// splits on commas that aren't within brackets
+func splitHostlist(hostlist string) []string {
+	exprs := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range hostlist {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				exprs = append(exprs, hostlist[start:i])
+				start = i + 1
+			}
+		}
+	}
+	exprs = append(exprs, hostlist[start:])
+	return exprs
+}
+
+// expands a single host expression, i.e one without top level commas. Multiple bracket
+// groups expand to their cartesian product i.e "a[1-2]b[1-2]" -> a1b1, a1b2, a2b1, a2b2.
+// Expanding to more than limit hosts is an error, checked before the hosts are allocated
+func expandHostExpr(expr string, limit int) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, nil
+	}
+	lb := strings.Index(expr, "[")
+	if lb == -1 {
+		if strings.Contains(expr, "]") {
+			return nil, fmt.Errorf("unbalanced brackets in hostlist %q", expr)
+		}
+		if limit < 1 {
+			return nil, fmt.Errorf("hostlist exceeds %d hosts", maxHosts)
+		}
+		return []string{expr}, nil
+	}
+	rb := strings.Index(expr, "]")
+	if rb < lb {
+		return nil, fmt.Errorf("unbalanced brackets in hostlist %q", expr)
+	}
+	prefix, ranges, suffix := expr[:lb], expr[lb+1:rb], expr[rb+1:]
+	if strings.Contains(prefix, "]") || strings.Contains(ranges, "[") {
+		return nil, fmt.Errorf("nested brackets in hostlist %q", expr)
+	}
+	suffixes := []string{""}
+	if suffix != "" {
+		var err error
+		if suffixes, err = expandHostExpr(suffix, limit); err != nil {
+			return nil, err
+		}
+		if len(suffixes) == 0 {
+			suffixes = []string{""}
+		}
+	}
+	// every id of this group is repeated for each suffix
+	idLimit := limit / len(suffixes)
+	hosts := make([]string, 0)
+	for _, r := range strings.Split(ranges, ",") {
+		ids, err := expandHostRange(r, idLimit-len(hosts)/len(suffixes))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hostlist %q: %w", expr, err)
+		}
+		for _, id := range ids {
+			for _, s := range suffixes {
+				hosts = append(hosts, prefix+id+s)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// expands "01-03" -> 01, 02, 03 preserving the zero padding of the lower bound. More than limit ids is an error
+func expandHostRange(r string, limit int) ([]string, error) {
+	lo, hi, isRange := strings.Cut(strings.TrimSpace(r), "-")
+	start, err := strconv.ParseUint(lo, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if !isRange {
+		if limit < 1 {
+			return nil, fmt.Errorf("hostlist exceeds %d hosts", maxHosts)
+		}
+		return []string{lo}, nil
+	}
+	end, err := strconv.ParseUint(hi, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if end < start {
+		return nil, fmt.Errorf("descending range %s", r)
+	}
+	if limit < 1 || end-start >= uint64(limit) {
+		return nil, fmt.Errorf("range %s exceeds %d hosts", r, maxHosts)
+	}
+	ids := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		ids = append(ids, fmt.Sprintf("%0*d", len(lo), i))
+	}
+	return ids, nil
+}
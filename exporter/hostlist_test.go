@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandHostlist(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("gpu[01-03]")
+	assert.NoError(err)
+	assert.Equal([]string{"gpu01", "gpu02", "gpu03"}, hosts)
+}
+
+func TestExpandHostlist_CommaRanges(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("gpu[01,05-07]")
+	assert.NoError(err)
+	assert.Equal([]string{"gpu01", "gpu05", "gpu06", "gpu07"}, hosts)
+}
+
+func TestExpandHostlist_Mixed(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("cs61,gpu[1-2]")
+	assert.NoError(err)
+	assert.Equal([]string{"cs61", "gpu1", "gpu2"}, hosts)
+}
+
+func TestExpandHostlist_Single(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("cs61")
+	assert.NoError(err)
+	assert.Equal([]string{"cs61"}, hosts)
+	hosts, err = ExpandHostlist("")
+	assert.NoError(err)
+	assert.Empty(hosts)
+}
+
+func TestExpandHostlist_NoPadding(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("n[1-3,5]")
+	assert.NoError(err)
+	assert.Equal([]string{"n1", "n2", "n3", "n5"}, hosts)
+}
+
+func TestExpandHostlist_PaddingWidens(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("node[08-10]")
+	assert.NoError(err)
+	assert.Equal([]string{"node08", "node09", "node10"}, hosts)
+}
+
+func TestExpandHostlist_Suffix(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("a[01-02]b")
+	assert.NoError(err)
+	assert.Equal([]string{"a01b", "a02b"}, hosts)
+}
+
+func TestExpandHostlist_MultipleGroups(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("rack[1-2]-n[01-02]")
+	assert.NoError(err)
+	assert.Equal([]string{"rack1-n01", "rack1-n02", "rack2-n01", "rack2-n02"}, hosts)
+}
+
+func TestExpandHostlist_MultipleExprs(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("gpu[01-02],cpu[1,3],login")
+	assert.NoError(err)
+	assert.Equal([]string{"gpu01", "gpu02", "cpu1", "cpu3", "login"}, hosts)
+}
+
+func TestExpandHostlist_MaxHosts(t *testing.T) {
+	assert := assert.New(t)
+	hosts, err := ExpandHostlist("a[0-255]b[0-255]")
+	assert.NoError(err)
+	assert.Len(hosts, maxHosts)
+	_, err = ExpandHostlist("a[0-255]b[0-255],c")
+	assert.ErrorContains(err, "exceeds")
+}
+
+func TestExpandHostlist_Malformed(t *testing.T) {
+	assert := assert.New(t)
+	for _, hostlist := range []string{
+		"gpu[01-03",
+		"gpu01-03]",
+		"gpu[03-01]",
+		"gpu[]",
+		"gpu[a-b]",
+		"gpu[1-]",
+		"gpu[[1-2]]",
+		"gpu[1-2]]",
+		"gpu[0-99999999]",
+		// every range is within the cap but their product or sum isn't
+		"a[0-65535]b[0-65535]",
+		"a[0-300]b[0-300]",
+		"gpu[0-40000,0-40000]",
+		"gpu[0-40000],cpu[0-40000]",
+	} {
+		hosts, err := ExpandHostlist(hostlist)
+		assert.Error(err, hostlist)
+		assert.Nil(hosts, hostlist)
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reports the time between successive scrapes. When it drops below the poll limit
+// the slurm collectors are serving cached data on most scrapes.
+// Note that multiple prometheus servers scraping the same exporter will interleave
+type ScrapeIntervalCollector struct {
+	sync.Mutex
+	lastCollect    time.Time
+	pollLimit      float64
+	scrapeInterval *prometheus.Desc
+	pollLimitDesc  *prometheus.Desc
+}
+
+func NewScrapeIntervalCollector(config *Config) *ScrapeIntervalCollector {
+	return &ScrapeIntervalCollector{
+		pollLimit:      config.PollLimit,
+		scrapeInterval: config.NewDesc("slurm_exporter_scrape_interval_seconds", "observed time between successive scrapes of the exporter", nil, nil),
+		pollLimitDesc:  config.NewDesc("slurm_exporter_poll_limit_seconds", "minimum time between slurm cmd invocations, scrapes within the limit are served from cache", nil, nil),
+	}
+}
+
+func (sic *ScrapeIntervalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sic.scrapeInterval
+	ch <- sic.pollLimitDesc
+}
+
+func (sic *ScrapeIntervalCollector) Collect(ch chan<- prometheus.Metric) {
+	sic.Lock()
+	defer sic.Unlock()
+	now := time.Now()
+	ch <- prometheus.MustNewConstMetric(sic.pollLimitDesc, prometheus.GaugeValue, sic.pollLimit)
+	// nothing to compare against on the first scrape
+	if !sic.lastCollect.IsZero() {
+		ch <- prometheus.MustNewConstMetric(sic.scrapeInterval, prometheus.GaugeValue, now.Sub(sic.lastCollect).Seconds())
+	}
+	sic.lastCollect = now
+}
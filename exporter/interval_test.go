@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectInterval(sic *ScrapeIntervalCollector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 2)
+	sic.Collect(ch)
+	close(ch)
+	values := make(map[string]float64)
+	for metric := range ch {
+		dtoMetric := new(dto.Metric)
+		metric.Write(dtoMetric)
+		values[metric.Desc().String()] = dtoMetric.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestScrapeIntervalCollector(t *testing.T) {
+	assert := assert.New(t)
+	sic := NewScrapeIntervalCollector(&Config{PollLimit: 10})
+	first := collectInterval(sic)
+	// only the poll limit on the first scrape
+	assert.Len(first, 1)
+	assert.Equal(10., first[sic.pollLimitDesc.String()])
+	sic.lastCollect = time.Now().Add(-15 * time.Second)
+	second := collectInterval(sic)
+	assert.Len(second, 2)
+	assert.InDelta(15., second[sic.scrapeInterval.String()], 1)
+}
+
+func TestScrapeIntervalCollector_Describe(t *testing.T) {
+	assert := assert.New(t)
+	ch := make(chan *prometheus.Desc, 2)
+	NewScrapeIntervalCollector(&Config{}).Describe(ch)
+	assert.Len(ch, 2)
+}
+
+// the flag takes precedence over POLL_LIMIT
+func TestScrapeIntervalCollector_PollLimitFlag(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("POLL_LIMIT", "5")
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal(5., config.PollLimit)
+	assert.Equal("env", config.PollLimitSource)
+	config, err = NewConfig(&CliFlags{SlurmPollLimit: 20})
+	assert.NoError(err)
+	assert.Equal("flag", config.PollLimitSource)
+	sic := NewScrapeIntervalCollector(config)
+	assert.Equal(20., collectInterval(sic)[sic.pollLimitDesc.String()])
+}
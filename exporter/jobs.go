@@ -6,9 +6,15 @@ package exporter
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +32,8 @@ type NodeResource struct {
 type JobResource struct {
 	AllocCpus  float64                  `json:"allocated_cpus"`
 	AllocNodes map[string]*NodeResource `json:"allocated_nodes"`
+	// count of allocated nodes, 0 when squeue didn't report it i.e a fallback override without %D
+	AllocHosts float64 `json:"allocated_hosts"`
 }
 type JobMetric struct {
 	Account      string      `json:"account"`
@@ -37,70 +45,109 @@ type JobMetric struct {
 	Features     string      `json:"features"`
 	JobResources JobResource `json:"job_resources"`
 	StateReason  string      `json:"state_reason"`
+	// cluster the job runs on in a federation. Empty for jobs of the local cluster in fallback mode
+	Cluster string `json:"cluster"`
+	// hostlist of the nodes the job runs on i.e "cs[75-76]". Empty while pending
+	Nodes string `json:"nodes"`
+	// allocated TRES i.e "cpu=1,mem=62.50G,node=1,billing=1". Not reported by the fallback
+	TresAlloc string `json:"tres_alloc_str"`
+	// requested TRES, same format as TresAlloc. Not reported by the fallback
+	TresReq string `json:"tres_req_str"`
+	// nil when squeue didn't report it, i.e a fallback override without %Q
+	Priority *JobPriority `json:"priority"`
+	// unix time the job was submitted, 0 when squeue didn't report it i.e a fallback override without %V
+	SubmitTime IntFromOptionalStruct `json:"submit_time"`
+	// unix time the job started, 0 when squeue didn't report it i.e a fallback override without %S
+	StartTime IntFromOptionalStruct `json:"start_time"`
 }
 
-type squeueResponse struct {
-	Meta struct {
-		SlurmVersion struct {
-			Version struct {
-				Major int `json:"major"`
-				Micro int `json:"micro"`
-				Minor int `json:"minor"`
-			} `json:"version"`
-			Release string `json:"release"`
-		} `json:"Slurm"`
-	} `json:"meta"`
-	Errors []string    `json:"errors"`
-	Jobs   []JobMetric `json:"jobs"`
+// job priority, reported as a plain number by older slurm versions and as {"set": true, "infinite": false, "number": 1368}
+// by newer ones. Unset priorities are 0 and infinite ones +Inf
+type JobPriority float64
+
+func (jp *JobPriority) UnmarshalJSON(data []byte) error {
+	var nativeFloat float64
+	if err := json.Unmarshal(data, &nativeFloat); err == nil {
+		*jp = JobPriority(nativeFloat)
+		return nil
+	}
+	var numStruct struct {
+		Set      bool    `json:"set"`
+		Infinite bool    `json:"infinite"`
+		Number   float64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &numStruct); err != nil {
+		return err
+	}
+	switch {
+	case !numStruct.Set:
+		*jp = 0
+	case numStruct.Infinite:
+		*jp = JobPriority(math.Inf(1))
+	default:
+		*jp = JobPriority(numStruct.Number)
+	}
+	return nil
 }
 
 type JobJsonFetcher struct {
 	scraper    SlurmByteScraper
 	cache      *AtomicThrottledCache[JobMetric]
-	errCounter prometheus.Counter
+	errCounter *prometheus.CounterVec
 }
 
-func (jjf *JobJsonFetcher) fetch() ([]JobMetric, error) {
-	data, err := jjf.scraper.FetchRawBytes()
+func (jjf *JobJsonFetcher) fetch(ctx context.Context) ([]JobMetric, error) {
+	data, err := jjf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		jjf.errCounter.Inc()
+		jjf.errCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		return nil, err
 	}
-	var squeue squeueResponse
-	err = json.Unmarshal(data, &squeue)
+	// jobs are streamed like the sacct GPU jobs, the rest of the response is skipped
+	var jobs []JobMetric
+	apiErrors, found, err := streamCliJsonArray(data, "jobs", func(job *JobMetric) {
+		for _, resource := range job.JobResources.AllocNodes {
+			resource.Mem *= 1e9
+		}
+		jobs = append(jobs, *job)
+	})
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling node metrics %q", err))
+		jjf.errCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		return nil, err
 	}
-	for _, j := range squeue.Jobs {
-		for _, resource := range j.JobResources.AllocNodes {
-			resource.Mem *= 1e9
-		}
+	if len(apiErrors) > 0 {
+		recordApiErrors("squeue", apiErrors)
+		// squeue still lists the jobs it could load
+		jjf.errCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(apiErrors)))
+	}
+	if !found {
+		recordMissingField("squeue", "jobs")
 	}
-	return squeue.Jobs, nil
+	return jobs, nil
 }
 
-func (jjf *JobJsonFetcher) FetchMetrics() ([]JobMetric, error) {
-	return jjf.cache.FetchOrThrottle(jjf.fetch)
+func (jjf *JobJsonFetcher) FetchMetrics(ctx context.Context) ([]JobMetric, error) {
+	return jjf.cache.FetchOrThrottle(ctx, jjf.fetch)
 }
 
 func (jjf *JobJsonFetcher) ScrapeDuration() time.Duration {
 	return jjf.scraper.Duration()
 }
 
-func (jjf *JobJsonFetcher) ScrapeError() prometheus.Counter {
+func (jjf *JobJsonFetcher) ScrapeError() *prometheus.CounterVec {
 	return jjf.errCounter
 }
 
 type JobCliFallbackFetcher struct {
 	scraper    SlurmByteScraper
 	cache      *AtomicThrottledCache[JobMetric]
-	errCounter prometheus.Counter
+	errCounter *prometheus.CounterVec
 }
 
-func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
-	squeue, err := jcf.scraper.FetchRawBytes()
+func (jcf *JobCliFallbackFetcher) fetch(ctx context.Context) ([]JobMetric, error) {
+	squeue, err := jcf.scraper.FetchRawBytes(ctx)
 	if err != nil {
+		jcf.errCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		return nil, err
 	}
 	jobMetrics := make([]JobMetric, 0)
@@ -112,7 +159,13 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 		return nil, nil
 	}
 
+	// squeue -M prefixes each cluster's jobs with a "CLUSTER: <name>" line
+	cluster := ""
 	for i, line := range bytes.Split(squeue, []byte("\n")) {
+		if name, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("CLUSTER:")); ok {
+			cluster = string(bytes.TrimSpace(name))
+			continue
+		}
 		var metric struct {
 			Account     string    `json:"a"`
 			JobId       float64   `json:"id"`
@@ -122,17 +175,22 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 			UserName    string    `json:"u"`
 			Cpu         int64     `json:"cpu"`
 			Mem         string    `json:"mem"`
+			Nodes       float64   `json:"nodes"`
+			NodeList    string    `json:"nodelist"`
+			Submit      NAbleTime `json:"submit"`
+			Start       NAbleTime `json:"start"`
 			StateReason string    `json:"r"`
+			Priority    *float64  `json:"prio"`
 		}
 		if err := json.Unmarshal(line, &metric); err != nil {
 			slog.Error(fmt.Sprintf("squeue fallback parse error: failed on line %d `%s`", i, line))
-			jcf.errCounter.Inc()
+			jcf.errCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			continue
 		}
 		mem, err := MemToFloat(metric.Mem)
 		if err != nil {
 			slog.Error(fmt.Sprintf("squeue fallback parse error: failed on line %d `%s` with err `%q`", i, line, err))
-			jcf.errCounter.Inc()
+			jcf.errCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			continue
 		}
 		re := regexp.MustCompile(`^\((?P<reason>(.+))\)$`)
@@ -141,7 +199,7 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 				metric.StateReason = matches[re.SubexpIndex("reason")]
 			} else {
 				slog.Error(fmt.Sprintf("squeue failed to pull pending state reason. Got state reason: %s", metric.StateReason))
-				jcf.errCounter.Inc()
+				jcf.errCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			}
 		}
 
@@ -153,25 +211,38 @@ func (jcf *JobCliFallbackFetcher) fetch() ([]JobMetric, error) {
 			UserName:    metric.UserName,
 			EndTime:     float64(metric.EndTime.Unix()),
 			StateReason: metric.StateReason,
+			Cluster:     cluster,
+			Nodes:       metric.NodeList,
 			JobResources: JobResource{
 				AllocCpus:  float64(metric.Cpu),
 				AllocNodes: map[string]*NodeResource{"0": {Mem: mem}},
+				AllocHosts: metric.Nodes,
 			},
 		}
+		if metric.Priority != nil {
+			priority := JobPriority(*metric.Priority)
+			openapiJobMetric.Priority = &priority
+		}
+		if !metric.Submit.IsZero() {
+			openapiJobMetric.SubmitTime = IntFromOptionalStruct(metric.Submit.Unix())
+		}
+		if !metric.Start.IsZero() {
+			openapiJobMetric.StartTime = IntFromOptionalStruct(metric.Start.Unix())
+		}
 		jobMetrics = append(jobMetrics, openapiJobMetric)
 	}
 	return jobMetrics, nil
 }
 
-func (jcf *JobCliFallbackFetcher) FetchMetrics() ([]JobMetric, error) {
-	return jcf.cache.FetchOrThrottle(jcf.fetch)
+func (jcf *JobCliFallbackFetcher) FetchMetrics(ctx context.Context) ([]JobMetric, error) {
+	return jcf.cache.FetchOrThrottle(ctx, jcf.fetch)
 }
 
 func (jcf *JobCliFallbackFetcher) ScrapeDuration() time.Duration {
 	return jcf.scraper.Duration()
 }
 
-func (jcf *JobCliFallbackFetcher) ScrapeError() prometheus.Counter {
+func (jcf *JobCliFallbackFetcher) ScrapeError() *prometheus.CounterVec {
 	return jcf.errCounter
 }
 
@@ -191,12 +262,7 @@ func (nat *NAbleTime) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &tString); err != nil {
 		return err
 	}
-	nullSet := map[string]struct{}{"N/A": {}, "NONE": {}}
-	if _, ok := nullSet[tString]; ok {
-		nat.Time = time.Time{}
-		return nil
-	}
-	t, err := time.Parse("2006-01-02T15:04:05", tString)
+	t, err := parseSlurmTime(tString)
 	nat.Time = t
 	return err
 }
@@ -253,8 +319,213 @@ func parseAccountMetrics(jobs []JobMetric) map[string]*AccountMetric {
 	return accountMap
 }
 
+// bounds the accounts of slurm_billing_alloc. Accounts past the limit with the least billing are folded into "other"
+const maxBillingAccounts = 100
+
+type billingKey struct {
+	account   string
+	partition string
+}
+
+// billing TRES of running jobs per account and partition. Keeps the maxAccounts accounts with the most billing
+func parseBillingMetrics(jobs []JobMetric, maxAccounts int) map[billingKey]float64 {
+	billing := make(map[billingKey]float64)
+	accountBilling := make(map[string]float64)
+	for _, job := range jobs {
+		if job.JobState != "RUNNING" {
+			continue
+		}
+		if val, ok := parseTresValue(job.TresAlloc, "billing"); ok {
+			billing[billingKey{account: job.Account, partition: job.Partition}] += val
+			accountBilling[job.Account] += val
+		}
+	}
+	if len(accountBilling) <= maxAccounts {
+		return billing
+	}
+	accounts := slices.SortedFunc(maps.Keys(accountBilling), func(a, b string) int {
+		return cmp.Or(cmp.Compare(accountBilling[b], accountBilling[a]), strings.Compare(a, b))
+	})
+	kept := accounts[:maxAccounts]
+	folded := make(map[billingKey]float64)
+	for key, val := range billing {
+		if !slices.Contains(kept, key.account) {
+			key.account = otherLabelValue
+		}
+		folded[key] += val
+	}
+	return folded
+}
+
+// nodes a job holds, from its node TRES or, without TRES i.e in the fallback, its allocated host count
+func jobNodeCount(job *JobMetric) float64 {
+	if nodes, ok := parseTresValue(job.TresAlloc, "node"); ok {
+		return nodes
+	}
+	return job.JobResources.AllocHosts
+}
+
+// running jobs spanning more than one node, and the nodes held by running jobs. A node shared by several
+// jobs is counted once per job
+func parseMultinodeMetrics(jobs []JobMetric) (multinode float64, nodesInUse float64) {
+	for i := range jobs {
+		if jobs[i].JobState != "RUNNING" {
+			continue
+		}
+		nodes := jobNodeCount(&jobs[i])
+		if nodes > 1 {
+			multinode++
+		}
+		nodesInUse += nodes
+	}
+	return multinode, nodesInUse
+}
+
+// TRES compared between the request and the allocation of running jobs
+var tresDeltaNames = []string{"cpu", "mem", "gpu"}
+
+// requested minus allocated cpus, mem bytes and GPUs of running jobs per partition. Positive when jobs got less
+// than they asked for, negative when they got more i.e whole node allocations. Jobs missing either TRES string
+// i.e in the fallback, are skipped
+func parseTresDeltaMetrics(jobs []JobMetric, gresName string) map[string]map[string]float64 {
+	deltas := make(map[string]map[string]float64)
+	for _, job := range jobs {
+		if job.JobState != "RUNNING" || job.TresReq == "" || job.TresAlloc == "" {
+			continue
+		}
+		partition, ok := deltas[job.Partition]
+		if !ok {
+			partition = make(map[string]float64, len(tresDeltaNames))
+			deltas[job.Partition] = partition
+		}
+		reqCpu, _ := parseTresValue(job.TresReq, "cpu")
+		allocCpu, _ := parseTresValue(job.TresAlloc, "cpu")
+		partition["cpu"] += reqCpu - allocCpu
+		reqMem, _ := parseTresMem(job.TresReq)
+		allocMem, _ := parseTresMem(job.TresAlloc)
+		partition["mem"] += reqMem - allocMem
+		partition["gpu"] += parseGresGpuCount(job.TresReq, gresName) - parseGresGpuCount(job.TresAlloc, gresName)
+	}
+	return deltas
+}
+
+// default upper bounds of slurm_job_priority. Priorities range up to 2^32 so the buckets are exponential
+var defaultJobPriorityBuckets = prometheus.ExponentialBuckets(1, 10, 10)
+
+// observes the priority of every pending job. Returns the count, sum and cumulative bucket counts
+func jobPriorityHistogram(jobs []JobMetric, bounds []float64) (uint64, float64, map[float64]uint64) {
+	var count uint64
+	var sum float64
+	buckets := make(map[float64]uint64, len(bounds))
+	for _, bound := range bounds {
+		buckets[bound] = 0
+	}
+	for _, job := range jobs {
+		if job.JobState != "PENDING" || job.Priority == nil {
+			continue
+		}
+		priority := float64(*job.Priority)
+		count++
+		sum += priority
+		for _, bound := range bounds {
+			if priority <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	return count, sum, buckets
+}
+
+// default upper bounds of slurm_running_job_elapsed_seconds, from a minute to a week
+var defaultJobElapsedBuckets = []float64{60, 600, 3600, 6 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600}
+
+// observes the seconds every running job has been running for. Returns the count, sum and cumulative bucket counts
+func jobElapsedHistogram(jobs []JobMetric, bounds []float64, now time.Time) (uint64, float64, map[float64]uint64) {
+	var count uint64
+	var sum float64
+	buckets := make(map[float64]uint64, len(bounds))
+	for _, bound := range bounds {
+		buckets[bound] = 0
+	}
+	for _, job := range jobs {
+		if job.JobState != "RUNNING" || job.StartTime <= 0 {
+			continue
+		}
+		elapsed := max(now.Sub(time.Unix(int64(job.StartTime), 0)).Seconds(), 0)
+		count++
+		sum += elapsed
+		for _, bound := range bounds {
+			if elapsed <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	return count, sum, buckets
+}
+
+// upper cased job states of a comma separated list i.e "running, pending", skipping empty entries.
+// States are passed to slurm cmds, so anything but letters and underscores is rejected
+func parseJobStates(states string) ([]string, error) {
+	var parsed []string
+	for _, state := range strings.Split(states, ",") {
+		state = strings.ToUpper(strings.TrimSpace(state))
+		if state == "" {
+			continue
+		}
+		if strings.IndexFunc(state, func(r rune) bool { return (r < 'A' || r > 'Z') && r != '_' }) >= 0 {
+			return nil, fmt.Errorf("invalid job state %q", state)
+		}
+		parsed = append(parsed, state)
+	}
+	return parsed, nil
+}
+
+// normalizes comma separated job states i.e "running, pending" into a squeue --states filter. all keeps every
+// state, empty keeps squeue's default
+func parseSqueueStates(states string) (string, error) {
+	parsed, err := parseJobStates(states)
+	if err != nil {
+		return "", fmt.Errorf("squeue states: %w", err)
+	}
+	if slices.Contains(parsed, "ALL") {
+		return "all", nil
+	}
+	return strings.Join(parsed, ","), nil
+}
+
+// copy of jobs with the accounts and partitions outside the allowlists collapsed into "other"
+func bucketJobLabels(jobs []JobMetric, accounts LabelAllowlist, partitions LabelAllowlist) []JobMetric {
+	if len(accounts) == 0 && len(partitions) == 0 {
+		return jobs
+	}
+	bucketed := make([]JobMetric, 0, len(jobs))
+	for _, job := range jobs {
+		job.Account = accounts.Bucket(job.Account)
+		job.Partition = partitions.Bucket(job.Partition)
+		bucketed = append(bucketed, job)
+	}
+	return bucketed
+}
+
+// max seconds since submission over the pending jobs of each partition. Jobs without a submit time are skipped
+func oldestPendingJobs(jobs []JobMetric, now time.Time) map[string]float64 {
+	oldest := make(map[string]float64)
+	for _, job := range jobs {
+		if job.JobState != "PENDING" || job.SubmitTime <= 0 {
+			continue
+		}
+		age := max(now.Sub(time.Unix(int64(job.SubmitTime), 0)).Seconds(), 0)
+		if current, ok := oldest[job.Partition]; !ok || age > current {
+			oldest[job.Partition] = age
+		}
+	}
+	return oldest
+}
+
 type PartitionJobMetric struct {
 	partitionState map[string]float64
+	// per state job counts keyed by the job's cluster, "" when the job didn't report one
+	clusterState map[string]map[string]float64
 }
 
 func parsePartitionJobMetrics(jobs []JobMetric) map[string]*PartitionJobMetric {
@@ -264,10 +535,15 @@ func parsePartitionJobMetrics(jobs []JobMetric) map[string]*PartitionJobMetric {
 		if !ok {
 			metric = &PartitionJobMetric{
 				partitionState: make(map[string]float64),
+				clusterState:   make(map[string]map[string]float64),
 			}
 			partitionMetric[job.Partition] = metric
 		}
 		metric.partitionState[job.JobState]++
+		if _, ok := metric.clusterState[job.Cluster]; !ok {
+			metric.clusterState[job.Cluster] = make(map[string]float64)
+		}
+		metric.clusterState[job.Cluster][job.JobState]++
 	}
 	return partitionMetric
 }
@@ -322,10 +598,15 @@ func parseFeatureMetric(jobs []JobMetric) map[string]*FeatureJobMetric {
 
 type JobsCollector struct {
 	// collector state
-	fetcher      SlurmMetricFetcher[JobMetric]
-	fallback     bool
-	jobAllocCpus *prometheus.Desc
-	jobAllocMem  *prometheus.Desc
+	fetcher  SlurmMetricFetcher[JobMetric]
+	fallback bool
+	// job_cluster label of jobs that don't report a cluster
+	localCluster string
+	// per job allocations, only emitted with perJob. High cardinality
+	perJob        bool
+	jobAllocCpus  *prometheus.Desc
+	jobAllocMem   *prometheus.Desc
+	jobAllocNodes *prometheus.Desc
 	// user metrics
 	userJobStateTotal *prometheus.Desc
 	userJobMemAlloc   *prometheus.Desc
@@ -342,46 +623,83 @@ type JobsCollector struct {
 	featureJobTotal    *prometheus.Desc
 	// reason metrics
 	pendingReasonTotal *prometheus.Desc
+	// queue wait of the oldest pending job per partition
+	oldestPendingJob *prometheus.Desc
+	// weighted cost of running jobs
+	billingAlloc *prometheus.Desc
+	// parallel jobs vs single node jobs
+	jobsMultinode *prometheus.Desc
+	nodesInUse    *prometheus.Desc
+	// mis-sized requests of running jobs
+	tresDelta *prometheus.Desc
+	gresName  string
+	// priority spread of pending jobs
+	jobPriority     *prometheus.Desc
+	priorityBuckets []float64
+	// duration spread of running jobs
+	jobElapsed     *prometheus.Desc
+	elapsedBuckets []float64
+	// accounts and partitions outside these are labeled "other"
+	accountAllowlist   LabelAllowlist
+	partitionAllowlist LabelAllowlist
 	// exporter metrics
 	jobScrapeDuration *prometheus.Desc
-	jobScrapeError    prometheus.Counter
 }
 
 func (jc *JobsCollector) SetFetcher(fetcher SlurmMetricFetcher[JobMetric]) {
 	jc.fetcher = fetcher
 }
 
+func (jc *JobsCollector) Fetcher() SlurmMetricFetcher[JobMetric] {
+	return jc.fetcher
+}
+
 func NewJobsController(config *Config) *JobsCollector {
 	cliOpts := config.cliOpts
 	fetcher := config.TraceConf.sharedFetcher
 	return &JobsCollector{
-		fetcher:  fetcher,
-		fallback: cliOpts.fallback,
+		fetcher:      fetcher,
+		fallback:     cliOpts.fallback,
+		localCluster: config.ClusterName,
+		perJob:       cliOpts.jobAllocPerJob,
+		// label allowlists
+		accountAllowlist:   cliOpts.accountAllowlist,
+		partitionAllowlist: cliOpts.partitionAllowlist,
+		// priority histogram
+		jobPriority:     config.NewDesc("slurm_job_priority", "priority of pending jobs", nil, nil),
+		priorityBuckets: cliOpts.jobPriorityBuckets,
+		// elapsed time histogram
+		jobElapsed:     config.NewDesc("slurm_running_job_elapsed_seconds", "seconds running jobs have been running for", nil, nil),
+		elapsedBuckets: cliOpts.jobElapsedBuckets,
 		// individual job metrics
-		jobAllocCpus:            prometheus.NewDesc("slurm_job_alloc_cpus", "amount of cpus allocated per job", []string{"jobid"}, nil),
-		jobAllocMem:             prometheus.NewDesc("slurm_job_alloc_mem", "amount of mem allocated per job", []string{"jobid"}, nil),
-		userJobStateTotal:       prometheus.NewDesc("slurm_user_state_total", "total jobs per state per user", []string{"username", "state"}, nil),
-		userJobMemAlloc:         prometheus.NewDesc("slurm_user_mem_alloc", "total mem alloc per user", []string{"username", "state"}, nil),
-		userJobCpuAlloc:         prometheus.NewDesc("slurm_user_cpu_alloc", "total cpu alloc per user", []string{"username", "state"}, nil),
-		partitionJobStateTotal:  prometheus.NewDesc("slurm_partition_job_state_total", "total jobs per partition per state", []string{"partition", "state"}, nil),
-		accountJobStateMemAlloc: prometheus.NewDesc("slurm_account_job_state_mem_alloc", "alloc mem consumed per account per job state", []string{"account", "state"}, nil),
-		accountJobStateCpuAlloc: prometheus.NewDesc("slurm_account_job_state_cpu_alloc", "alloc cpu consumed per account per job state", []string{"account", "state"}, nil),
-		accountJobStateTotal:    prometheus.NewDesc("slurm_account_job_state_total", "total jobs per account per job state", []string{"account", "state"}, nil),
-		featureJobMemAlloc:      prometheus.NewDesc("slurm_feature_mem_alloc", "alloc mem consumed per feature", []string{"feature"}, nil),
-		featureJobCpuAlloc:      prometheus.NewDesc("slurm_feature_cpu_alloc", "alloc cpu consumed per feature", []string{"feature"}, nil),
-		featureJobTotal:         prometheus.NewDesc("slurm_feature_total", "alloc cpu consumed per feature", []string{"feature"}, nil),
-		pendingReasonTotal:      prometheus.NewDesc("slurm_pending_reason_total", "count of the reason jobs are pending", []string{"reason"}, nil),
-		jobScrapeDuration:       prometheus.NewDesc("slurm_job_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.squeue), nil, nil),
-		jobScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_job_scrape_error",
-			Help: "slurm job scrape error",
-		}),
+		jobAllocCpus:            config.NewDesc("slurm_job_alloc_cpus", "amount of cpus allocated per job", []string{"jobid"}, nil),
+		jobAllocMem:             config.NewDesc("slurm_job_alloc_mem", "amount of mem allocated per job", []string{"jobid"}, nil),
+		jobAllocNodes:           config.NewDesc("slurm_job_alloc_nodes", "amount of nodes allocated per job", []string{"jobid"}, nil),
+		userJobStateTotal:       config.NewDesc("slurm_user_state_total", "total jobs per state per user", []string{"username", "state"}, nil),
+		userJobMemAlloc:         config.NewDesc("slurm_user_mem_alloc", "total mem alloc per user", []string{"username", "state"}, nil),
+		userJobCpuAlloc:         config.NewDesc("slurm_user_cpu_alloc", "total cpu alloc per user", []string{"username", "state"}, nil),
+		partitionJobStateTotal:  config.NewDesc("slurm_partition_job_state_total", "total jobs per partition per state", []string{"partition", "state", "job_cluster"}, nil),
+		accountJobStateMemAlloc: config.NewDesc("slurm_account_job_state_mem_alloc", "alloc mem consumed per account per job state", []string{"account", "state"}, nil),
+		accountJobStateCpuAlloc: config.NewDesc("slurm_account_job_state_cpu_alloc", "alloc cpu consumed per account per job state", []string{"account", "state"}, nil),
+		accountJobStateTotal:    config.NewDesc("slurm_account_job_state_total", "total jobs per account per job state", []string{"account", "state"}, nil),
+		featureJobMemAlloc:      config.NewDesc("slurm_feature_mem_alloc", "alloc mem consumed per feature", []string{"feature"}, nil),
+		featureJobCpuAlloc:      config.NewDesc("slurm_feature_cpu_alloc", "alloc cpu consumed per feature", []string{"feature"}, nil),
+		featureJobTotal:         config.NewDesc("slurm_feature_total", "alloc cpu consumed per feature", []string{"feature"}, nil),
+		pendingReasonTotal:      config.NewDesc("slurm_pending_reason_total", "count of the reason jobs are pending", []string{"reason"}, nil),
+		oldestPendingJob:        config.NewDesc("slurm_oldest_pending_job_seconds", "seconds the oldest pending job of the partition has been waiting since submission", []string{"partition"}, nil),
+		billingAlloc:            config.NewDesc("slurm_billing_alloc", "billing TRES allocated to running jobs per account per partition", []string{"account", "partition"}, nil),
+		jobsMultinode:           config.NewDesc("slurm_jobs_multinode", "running jobs spanning more than one node", nil, nil),
+		tresDelta:               config.NewDesc("slurm_partition_tres_req_alloc_delta", "requested minus allocated TRES of running jobs per partition. cpu, mem in bytes or gpu", []string{"partition", "tres"}, nil),
+		gresName:                cliOpts.gpuGresName,
+		nodesInUse:              config.NewDesc("slurm_nodes_in_use", "nodes allocated to running jobs, summed per job", nil, nil),
+		jobScrapeDuration:       config.NewDesc("slurm_job_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.squeue), nil, nil),
 	}
 }
 
 func (jc *JobsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- jc.jobAllocCpus
 	ch <- jc.jobAllocMem
+	ch <- jc.jobAllocNodes
 	ch <- jc.userJobStateTotal
 	ch <- jc.userJobMemAlloc
 	ch <- jc.userJobCpuAlloc
@@ -393,20 +711,33 @@ func (jc *JobsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- jc.featureJobCpuAlloc
 	ch <- jc.featureJobTotal
 	ch <- jc.pendingReasonTotal
+	ch <- jc.oldestPendingJob
+	ch <- jc.billingAlloc
+	ch <- jc.jobsMultinode
+	ch <- jc.nodesInUse
+	ch <- jc.tresDelta
+	ch <- jc.jobPriority
+	ch <- jc.jobElapsed
 	ch <- jc.jobScrapeDuration
-	ch <- jc.jobScrapeError.Desc()
+	jc.fetcher.ScrapeError().Describe(ch)
 }
 
 func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- jc.fetcher.ScrapeError()
-	}()
-	jobMetrics, err := jc.fetcher.FetchMetrics()
+	jc.CollectContext(context.Background(), ch)
+}
+
+func (jc *JobsCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer jc.fetcher.ScrapeError().Collect(ch)
+	jobMetrics, err := jc.fetcher.FetchMetrics(ctx)
 	ch <- prometheus.MustNewConstMetric(jc.jobScrapeDuration, prometheus.GaugeValue, float64(jc.fetcher.ScrapeDuration().Milliseconds()))
 	if err != nil {
 		slog.Error(fmt.Sprintf("fetcher failure %q", err))
 		return
 	}
+	if jc.perJob {
+		jc.collectJobAllocs(jobMetrics, ch)
+	}
+	jobMetrics = bucketJobLabels(jobMetrics, jc.accountAllowlist, jc.partitionAllowlist)
 	userMetrics := parseUserJobMetrics(jobMetrics)
 	for user, metric := range userMetrics {
 		for state, allocCpu := range metric.allocCpu {
@@ -443,8 +774,13 @@ func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
 
 	partitionJobMetrics := parsePartitionJobMetrics(jobMetrics)
 	for partition, stateTotals := range partitionJobMetrics {
-		for state, totalJobs := range stateTotals.partitionState {
-			ch <- prometheus.MustNewConstMetric(jc.partitionJobStateTotal, prometheus.GaugeValue, totalJobs, partition, state)
+		for cluster, states := range stateTotals.clusterState {
+			if cluster == "" {
+				cluster = jc.localCluster
+			}
+			for state, totalJobs := range states {
+				ch <- prometheus.MustNewConstMetric(jc.partitionJobStateTotal, prometheus.GaugeValue, totalJobs, partition, state, cluster)
+			}
 		}
 	}
 
@@ -461,8 +797,48 @@ func (jc *JobsCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
+	for key, billing := range parseBillingMetrics(jobMetrics, maxBillingAccounts) {
+		ch <- prometheus.MustNewConstMetric(jc.billingAlloc, prometheus.GaugeValue, billing, key.account, key.partition)
+	}
+
+	multinode, nodesInUse := parseMultinodeMetrics(jobMetrics)
+	ch <- prometheus.MustNewConstMetric(jc.jobsMultinode, prometheus.GaugeValue, multinode)
+	ch <- prometheus.MustNewConstMetric(jc.nodesInUse, prometheus.GaugeValue, nodesInUse)
+
+	for partition, deltas := range parseTresDeltaMetrics(jobMetrics, jc.gresName) {
+		for _, tres := range tresDeltaNames {
+			ch <- prometheus.MustNewConstMetric(jc.tresDelta, prometheus.GaugeValue, deltas[tres], partition, tres)
+		}
+	}
+
+	count, sum, buckets := jobPriorityHistogram(jobMetrics, jc.priorityBuckets)
+	ch <- prometheus.MustNewConstHistogram(jc.jobPriority, count, sum, buckets)
+
+	count, sum, buckets = jobElapsedHistogram(jobMetrics, jc.elapsedBuckets, time.Now())
+	ch <- prometheus.MustNewConstHistogram(jc.jobElapsed, count, sum, buckets)
+
 	stateReasonMetric := parseStateReasonMetric(jobMetrics)
 	for pendingReason, pendingCount := range stateReasonMetric.pendingStateCount {
 		ch <- prometheus.MustNewConstMetric(jc.pendingReasonTotal, prometheus.GaugeValue, pendingCount, pendingReason)
 	}
+
+	for partition, age := range oldestPendingJobs(jobMetrics, time.Now()) {
+		ch <- prometheus.MustNewConstMetric(jc.oldestPendingJob, prometheus.GaugeValue, age, partition)
+	}
+}
+
+// emits the allocations of every job holding resources. Pending jobs only report requested resources
+func (jc *JobsCollector) collectJobAllocs(jobs []JobMetric, ch chan<- prometheus.Metric) {
+	for _, job := range jobs {
+		// squeue keeps the resources of finished jobs, i.e in the fallback's --states=all, only running jobs hold them
+		if job.JobState != "RUNNING" {
+			continue
+		}
+		jobId := strconv.FormatFloat(job.JobId, 'f', -1, 64)
+		ch <- prometheus.MustNewConstMetric(jc.jobAllocCpus, prometheus.GaugeValue, job.JobResources.AllocCpus, jobId)
+		ch <- prometheus.MustNewConstMetric(jc.jobAllocMem, prometheus.GaugeValue, totalAllocMem(&job.JobResources), jobId)
+		if job.JobResources.AllocHosts > 0 {
+			ch <- prometheus.MustNewConstMetric(jc.jobAllocNodes, prometheus.GaugeValue, job.JobResources.AllocHosts, jobId)
+		}
+	}
 }
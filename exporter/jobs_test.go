@@ -5,6 +5,8 @@
 package exporter
 
 import (
+	"context"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -16,12 +18,18 @@ import (
 
 var MockJobInfoScraper = &MockScraper{fixture: "fixtures/squeue_out.json"}
 
-func CollectCounterValue(counter prometheus.Counter) float64 {
-	metricChan := make(chan prometheus.Metric, 1)
+// sums every counter collected, i.e all the reasons of a scrape error counter
+func CollectCounterValue(counter prometheus.Collector) float64 {
+	metricChan := make(chan prometheus.Metric, 10)
 	counter.Collect(metricChan)
-	dtoMetric := new(dto.Metric)
-	(<-metricChan).Write(dtoMetric)
-	return dtoMetric.GetCounter().GetValue()
+	close(metricChan)
+	total := 0.
+	for metric := range metricChan {
+		dtoMetric := new(dto.Metric)
+		metric.Write(dtoMetric)
+		total += dtoMetric.GetCounter().GetValue()
+	}
+	return total
 }
 
 func TestNewJobsController(t *testing.T) {
@@ -32,7 +40,7 @@ func TestNewJobsController(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 		},
 		cliOpts: &CliOpts{
@@ -49,9 +57,9 @@ func TestParseJobMetrics(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.NoError(err)
 	// test parse of single job
 	var job *JobMetric
@@ -70,9 +78,9 @@ func TestParseCliFallback(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.fetch()
+	metrics, err := cliFallbackFetcher.fetch(context.Background())
 	assert.Nil(err)
 	assert.NotEmpty(metrics)
 	nodeAvailMetricsCount := 0
@@ -92,9 +100,9 @@ func TestUserJobMetric(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	//test
@@ -121,7 +129,7 @@ func TestJobCollect(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_out.json"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 			rate: 10,
 		},
@@ -149,7 +157,7 @@ func TestJobCollect_Fallback(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 			rate: 10,
 		},
@@ -178,9 +186,9 @@ func TestParsePartitionJobMetrics(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	partitionJobMetrics := parsePartitionJobMetrics(jms)
@@ -193,9 +201,9 @@ func TestParsePartMetrics(t *testing.T) {
 	fetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
-	jms, err := fetcher.fetch()
+	jms, err := fetcher.fetch(context.Background())
 	assert.Nil(err)
 
 	featureMetrics := parseFeatureMetric(jms)
@@ -211,7 +219,7 @@ func TestJobDescribe(t *testing.T) {
 	config.TraceConf.sharedFetcher = &JobJsonFetcher{
 		scraper:    MockJobInfoScraper,
 		cache:      NewAtomicThrottledCache[JobMetric](1),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
 	config.TraceConf.rate = 10
 	jc := NewJobsController(config)
@@ -228,6 +236,8 @@ func TestJobDescribe(t *testing.T) {
 
 func TestNAbleTimeJson(t *testing.T) {
 	assert := assert.New(t)
+	SetSlurmTimeZone(time.UTC)
+	defer SetSlurmTimeZone(nil)
 	data := `"2023-09-21T14:31:11"`
 	var nat NAbleTime
 	err := nat.UnmarshalJSON([]byte(data))
@@ -250,15 +260,15 @@ func TestParseCliFallbackEmpty(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.fetch()
+	metrics, err := cliFallbackFetcher.fetch(context.Background())
 	assert.NoError(err)
 	assert.Empty(metrics)
 	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter))
 	assert.Equal(1, scraper.Callcount)
 	scraper.msg = "\n"
-	metrics, err = cliFallbackFetcher.fetch()
+	metrics, err = cliFallbackFetcher.fetch(context.Background())
 	assert.NoError(err)
 	assert.Empty(metrics)
 	assert.Zero(CollectCounterValue(cliFallbackFetcher.errCounter))
@@ -271,13 +281,13 @@ func TestCliJobFetcherCacheHit(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -290,13 +300,13 @@ func TestCliJobFetcherCacheMiss(t *testing.T) {
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -309,13 +319,13 @@ func TestJsonJobFetcherCacheHit(t *testing.T) {
 	cliFallbackFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](100),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
@@ -328,28 +338,60 @@ func TestJsonJobFetcherCacheMiss(t *testing.T) {
 	cliFallbackFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	metrics, err := cliFallbackFetcher.FetchMetrics()
+	metrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	assert.Equal(1, scraper.CallCount)
-	metrics, err = cliFallbackFetcher.FetchMetrics()
+	metrics, err = cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(metrics)
 	assert.NoError(err)
 	// assert cache hit
 	assert.Equal(2, scraper.CallCount)
 }
 
+func TestJobJsonFetcher_ErrorReasons(t *testing.T) {
+	testFetcherErrorReasons(t, func(scraper SlurmByteScraper) errorReasonFetcher[[]JobMetric] {
+		return &JobJsonFetcher{scraper: scraper, cache: NewAtomicThrottledCache[JobMetric](1), errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{})}
+	}, map[string]SlurmByteScraper{
+		ScrapeErrorUnmarshal: &StringByteScraper{msg: `{"jobs": [`},
+		ScrapeErrorApi:       &StringByteScraper{msg: `{"jobs": [], "errors": ["Unable to contact slurm controller"]}`},
+	}, ScrapeErrorApi)
+}
+
+// squeue still lists the jobs it could load alongside errors, they're counted but the jobs are kept
+func TestJobJsonFetcher_ApiErrorKeepsJobs(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &StringByteScraper{msg: `{"jobs": [{"job_id": 1, "job_state": "RUNNING"}], "errors": ["Unable to load job 2"]}`},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(jobs, 1)
+	assert.Equal(1., CollectCounterValue(fetcher.errCounter.WithLabelValues(ScrapeErrorApi)))
+}
+
+func TestJobCliFallbackFetcher_ErrorReasons(t *testing.T) {
+	testFetcherErrorReasons(t, func(scraper SlurmByteScraper) errorReasonFetcher[[]JobMetric] {
+		return &JobCliFallbackFetcher{scraper: scraper, cache: NewAtomicThrottledCache[JobMetric](1), errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{})}
+	}, map[string]SlurmByteScraper{
+		// malformed rows are skipped, the rest of the output is kept
+		ScrapeErrorUnmarshal: &StringByteScraper{msg: `{"a": "account1", "id": `},
+	}, ScrapeErrorUnmarshal)
+}
+
 func TestParseStateReasonMetric_Fallback(t *testing.T) {
 	assert := assert.New(t)
 	scraper := &MockScraper{fixture: "fixtures/squeue_fallback.txt"}
 	cliFallbackFetcher := &JobCliFallbackFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	jobMetrics, err := cliFallbackFetcher.FetchMetrics()
+	jobMetrics, err := cliFallbackFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(jobMetrics)
 	assert.NoError(err)
 	m := parseStateReasonMetric(jobMetrics)
@@ -363,12 +405,535 @@ func TestParseStateReasonMetric_Json(t *testing.T) {
 	JsonFetcher := &JobJsonFetcher{
 		scraper:    scraper,
 		cache:      NewAtomicThrottledCache[JobMetric](0),
-		errCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "errors"}),
 	}
-	jobMetrics, err := JsonFetcher.FetchMetrics()
+	jobMetrics, err := JsonFetcher.FetchMetrics(context.Background())
 	assert.NotEmpty(jobMetrics)
 	assert.NoError(err)
 	m := parseStateReasonMetric(jobMetrics)
 	assert.NotEmpty(m.pendingStateCount)
 	assert.Equal(m.pendingStateCount["Dependency"], 1.)
 }
+
+func TestParsePartitionJobMetrics_Federation(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_federation.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	metrics := parsePartitionJobMetrics(jobs)
+	assert.Equal(3., metrics["hw-l"].partitionState["RUNNING"])
+	assert.Equal(map[string]map[string]float64{
+		"rivos": {"RUNNING": 1, "PENDING": 1},
+		"east":  {"RUNNING": 1},
+		"":      {"RUNNING": 1},
+	}, metrics["hw-l"].clusterState)
+}
+
+func TestParseCliFallback_Federation(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_federation_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	clusters := make(map[float64]string)
+	for _, job := range jobs {
+		clusters[job.JobId] = job.Cluster
+	}
+	assert.Equal(map[float64]string{1000: "rivos", 1001: "rivos", 1002: "east"}, clusters)
+	assert.Zero(CollectCounterValue(fetcher.errCounter))
+}
+
+func TestJobCollect_FederationClusterLabel(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		PollLimit:   10,
+		ClusterName: "west",
+		TraceConf: &TraceConfig{
+			sharedFetcher: &JobJsonFetcher{
+				scraper:    &MockScraper{fixture: "fixtures/squeue_federation.json"},
+				cache:      NewAtomicThrottledCache[JobMetric](1),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+			},
+			rate: 10,
+		},
+		cliOpts: &CliOpts{},
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	clusterTotals := make(map[string]float64)
+	for metric := range jobChan {
+		if !strings.Contains(metric.Desc().String(), "slurm_partition_job_state_total") {
+			continue
+		}
+		m := new(dto.Metric)
+		assert.NoError(metric.Write(m))
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "job_cluster" {
+				clusterTotals[label.GetValue()] += m.GetGauge().GetValue()
+			}
+		}
+	}
+	assert.Equal(map[string]float64{"rivos": 2, "east": 1, "west": 1}, clusterTotals)
+}
+
+func TestParseBillingMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_billing.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// pending jobs and jobs without a billing TRES aren't counted
+	assert.Equal(map[billingKey]float64{
+		{account: "account1", partition: "hw-l"}: 20,
+		{account: "account1", partition: "hw-h"}: 8,
+		{account: "account2", partition: "hw-l"}: 2.5,
+	}, parseBillingMetrics(jobs, maxBillingAccounts))
+}
+
+func TestParseTresDeltaMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_tres.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// the over allocated hw-l job offsets part of the under allocated one, the pending job isn't counted
+	assert.Equal(map[string]map[string]float64{
+		"hw-l": {"cpu": 3, "mem": 32e9, "gpu": 2},
+		"gpu":  {"cpu": 0, "mem": 0, "gpu": 0},
+	}, parseTresDeltaMetrics(jobs, "gpu"))
+	// the fallback reports no TRES
+	assert.Empty(parseTresDeltaMetrics([]JobMetric{{Partition: "hw-l", JobState: "RUNNING"}}, "gpu"))
+}
+
+func TestParseSqueueStates(t *testing.T) {
+	assert := assert.New(t)
+	for input, expected := range map[string]string{
+		"":                 "",
+		"running":          "RUNNING",
+		"running, pending": "RUNNING,PENDING",
+		"ALL":              "all",
+		"running,all":      "all",
+	} {
+		states, err := parseSqueueStates(input)
+		assert.NoError(err, input)
+		assert.Equal(expected, states, input)
+	}
+	for _, input := range []string{"RUNNING;rm", "RUNNING --json", "R1"} {
+		_, err := parseSqueueStates(input)
+		assert.Error(err, input)
+	}
+}
+
+func TestParseJobStates(t *testing.T) {
+	assert := assert.New(t)
+	states, err := parseJobStates(" running,,Node_Fail ")
+	assert.NoError(err)
+	assert.Equal([]string{"RUNNING", "NODE_FAIL"}, states)
+	states, err = parseJobStates(" , ")
+	assert.NoError(err)
+	assert.Empty(states)
+	_, err = parseJobStates("RUNNING;rm")
+	assert.EqualError(err, `invalid job state "RUNNING;RM"`)
+}
+
+func TestNewConfig_SqueueStates(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "--json"}, config.cliOpts.squeue)
+	config, err = NewConfig(&CliFlags{SqueueStates: "running,pending"})
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "--states=RUNNING,PENDING", "--json"}, config.cliOpts.squeue)
+	config, err = NewConfig(&CliFlags{SlurmCliFallback: true})
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "--states=all", "-h", "-r", "-o"}, config.cliOpts.squeue[:5])
+	config, err = NewConfig(&CliFlags{SqueueStates: "running,pending", SlurmCliFallback: true})
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "--states=RUNNING,PENDING", "-h", "-r", "-o"}, config.cliOpts.squeue[:5])
+	// an override wins over the states
+	config, err = NewConfig(&CliFlags{SqueueStates: "running", SlurmSqueueOverride: "squeue --json"})
+	assert.NoError(err)
+	assert.Equal([]string{"squeue", "--json"}, config.cliOpts.squeue)
+	_, err = NewConfig(&CliFlags{SqueueStates: "RUNNING;rm"})
+	assert.Error(err)
+}
+
+func TestParseMultinodeMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_multinode.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// the 2 node job without TRES falls back to its allocated hosts, the pending job isn't counted
+	multinode, nodesInUse := parseMultinodeMetrics(jobs)
+	assert.Equal(2., multinode)
+	assert.Equal(7., nodesInUse)
+}
+
+func TestParseBillingMetrics_MaxAccounts(t *testing.T) {
+	assert := assert.New(t)
+	jobs := []JobMetric{
+		{Account: "a", Partition: "p1", JobState: "RUNNING", TresAlloc: "billing=10"},
+		{Account: "b", Partition: "p1", JobState: "RUNNING", TresAlloc: "billing=1"},
+		{Account: "c", Partition: "p1", JobState: "RUNNING", TresAlloc: "billing=2"},
+		{Account: "c", Partition: "p2", JobState: "RUNNING", TresAlloc: "billing=3"},
+	}
+	assert.Equal(map[billingKey]float64{
+		{account: "a", partition: "p1"}:             10,
+		{account: otherLabelValue, partition: "p1"}: 3,
+		{account: otherLabelValue, partition: "p2"}: 3,
+	}, parseBillingMetrics(jobs, 1))
+}
+
+func TestJobPriority_UnmarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+	var jp JobPriority
+	assert.NoError(jp.UnmarshalJSON([]byte(`1013`)))
+	assert.Equal(JobPriority(1013), jp)
+	assert.NoError(jp.UnmarshalJSON([]byte(`{"set": true, "infinite": false, "number": 1368}`)))
+	assert.Equal(JobPriority(1368), jp)
+	assert.NoError(jp.UnmarshalJSON([]byte(`{"set": true, "infinite": true, "number": 0}`)))
+	assert.True(math.IsInf(float64(jp), 1))
+	assert.NoError(jp.UnmarshalJSON([]byte(`{"set": false, "infinite": false, "number": 0}`)))
+	assert.Zero(jp)
+	assert.Error(jp.UnmarshalJSON([]byte(`"high"`)))
+}
+
+// only pending jobs reporting a priority are observed
+func TestJobPriorityHistogram(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_priority.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	count, sum, buckets := jobPriorityHistogram(jobs, []float64{10, 1e4, 1e6})
+	assert.Equal(uint64(4), count)
+	assert.Equal(5.+1013+250000+4294967000, sum)
+	assert.Equal(map[float64]uint64{10: 1, 1e4: 2, 1e6: 3}, buckets)
+}
+
+func TestJobPriorityHistogram_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_priority_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	count, sum, buckets := jobPriorityHistogram(jobs, defaultJobPriorityBuckets)
+	assert.Equal(uint64(3), count)
+	assert.Equal(5.+1013+250000, sum)
+	assert.Equal(uint64(1), buckets[10])
+	assert.Equal(uint64(2), buckets[1e4])
+	assert.Equal(uint64(3), buckets[1e6])
+	// jobs from overrides without %Q aren't observed
+	jobs, err = (&JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](0),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}).FetchMetrics(context.Background())
+	assert.NoError(err)
+	count, _, _ = jobPriorityHistogram(jobs, defaultJobPriorityBuckets)
+	assert.Zero(count)
+}
+
+func TestJobCollect_PriorityBuckets(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{JobPriorityBuckets: "100, 1e6"})
+	assert.NoError(err)
+	config.TraceConf.sharedFetcher = &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_priority.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	var histogram *dto.Histogram
+	for metric := range jobChan {
+		if metric.Desc() != jc.jobPriority {
+			continue
+		}
+		m := new(dto.Metric)
+		assert.NoError(metric.Write(m))
+		histogram = m.GetHistogram()
+	}
+	assert.NotNil(histogram)
+	assert.Equal(uint64(4), histogram.GetSampleCount())
+	upperBounds := make([]float64, 0)
+	for _, bucket := range histogram.GetBucket() {
+		upperBounds = append(upperBounds, bucket.GetUpperBound())
+	}
+	assert.Equal([]float64{100, 1e6}, upperBounds)
+}
+
+func TestNewConfig_JobPriorityBucketsInvalid(t *testing.T) {
+	assert := assert.New(t)
+	for _, buckets := range []string{"10,x", "100,10", "1,1", ","} {
+		_, err := NewConfig(&CliFlags{JobPriorityBuckets: buckets})
+		assert.Error(err, buckets)
+	}
+}
+
+func TestJobCollect_LabelAllowlists(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MetricsAccountAllowlist: "account1", MetricsPartitionAllowlist: "hw-l"})
+	assert.NoError(err)
+	config.TraceConf.sharedFetcher = &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_billing.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	accounts := make(map[string]bool)
+	partitions := make(map[string]bool)
+	billing := make(map[string]float64)
+	for metric := range jobChan {
+		m := new(dto.Metric)
+		assert.NoError(metric.Write(m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if account, ok := labels["account"]; ok {
+			accounts[account] = true
+		}
+		if partition, ok := labels["partition"]; ok {
+			partitions[partition] = true
+		}
+		if metric.Desc() == jc.billingAlloc {
+			billing[labels["account"]+"/"+labels["partition"]] = m.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(map[string]bool{"account1": true, otherLabelValue: true}, accounts)
+	assert.Equal(map[string]bool{"hw-l": true, otherLabelValue: true}, partitions)
+	assert.Equal(map[string]float64{
+		"account1/hw-l":  20,
+		"account1/other": 8,
+		"other/hw-l":     2.5,
+	}, billing)
+}
+
+// per job allocations keyed by desc name then jobid
+func collectJobAllocs(t *testing.T, fetcher SlurmMetricFetcher[JobMetric]) map[string]map[string]float64 {
+	config, err := NewConfig(&CliFlags{JobAllocPerJob: true})
+	assert.NoError(t, err)
+	config.TraceConf.sharedFetcher = fetcher
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	allocs := map[string]map[string]float64{"cpus": {}, "mem": {}, "nodes": {}}
+	descs := map[*prometheus.Desc]string{jc.jobAllocCpus: "cpus", jc.jobAllocMem: "mem", jc.jobAllocNodes: "nodes"}
+	for metric := range jobChan {
+		name, ok := descs[metric.Desc()]
+		if !ok {
+			continue
+		}
+		m := new(dto.Metric)
+		assert.NoError(t, metric.Write(m))
+		allocs[name][m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	return allocs
+}
+
+func TestJobCollect_JobAllocs(t *testing.T) {
+	assert := assert.New(t)
+	allocs := collectJobAllocs(t, &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_job_resources.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	})
+	// neither the pending nor the completed job hold allocations
+	assert.Equal(map[string]float64{"4000": 8, "4001": 4}, allocs["cpus"])
+	assert.Equal(map[string]float64{"4000": 2, "4001": 1}, allocs["nodes"])
+	assert.Equal(map[string]float64{"4000": 6.4e13, "4001": 1.6e13}, allocs["mem"])
+}
+
+func TestJobCollect_JobAllocsFallback(t *testing.T) {
+	assert := assert.New(t)
+	allocs := collectJobAllocs(t, &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_job_resources_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	})
+	assert.Equal(map[string]float64{"4000": 8, "4001": 4}, allocs["cpus"])
+	assert.Equal(map[string]float64{"4000": 2, "4001": 1}, allocs["nodes"])
+	assert.Len(allocs["mem"], 2)
+}
+
+func TestJobCollect_JobAllocsDisabled(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	config.TraceConf.sharedFetcher = &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_job_resources.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	for metric := range jobChan {
+		assert.NotEqual(jc.jobAllocCpus, metric.Desc())
+	}
+}
+
+func TestOldestPendingJobs(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_pending_age.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	// the running job submitted first isn't pending
+	assert.Equal(map[string]float64{"hw-h": 10000, "hw-l": 4600, "gpu": 3000}, oldestPendingJobs(jobs, time.Unix(1700010000, 0)))
+}
+
+func TestOldestPendingJobs_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_pending_age_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	// squeue prints local times
+	now := time.Date(2023, 11, 15, 1, 0, 0, 0, time.Local)
+	assert.Equal(map[string]float64{"hw-h": 10000, "hw-l": 4600, "gpu": 3000}, oldestPendingJobs(jobs, now))
+}
+
+func TestOldestPendingJobs_NoSubmitTime(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_priority_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	assert.Empty(oldestPendingJobs(jobs, time.Now()))
+}
+
+func TestJobElapsedHistogram(t *testing.T) {
+	assert := assert.New(t)
+	expected := map[float64]uint64{60: 1, 600: 2, 3600: 3, 21600: 4, 86400: 4, 259200: 5, 604800: 5}
+	for _, fetcher := range []SlurmMetricFetcher[JobMetric]{
+		&JobJsonFetcher{
+			scraper:    &MockScraper{fixture: "fixtures/squeue_elapsed.json"},
+			cache:      NewAtomicThrottledCache[JobMetric](1),
+			errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		},
+		&JobCliFallbackFetcher{
+			scraper:    &MockScraper{fixture: "fixtures/squeue_elapsed_fallback.txt"},
+			cache:      NewAtomicThrottledCache[JobMetric](1),
+			errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		},
+	} {
+		jobs, err := fetcher.FetchMetrics(context.Background())
+		assert.NoError(err)
+		now := time.Unix(1700100000, 0)
+		if _, ok := fetcher.(*JobCliFallbackFetcher); ok {
+			// squeue prints local times
+			now = time.Date(2023, 11, 16, 2, 0, 0, 0, time.Local)
+		}
+		// the pending job isn't observed
+		count, sum, buckets := jobElapsedHistogram(jobs, defaultJobElapsedBuckets, now)
+		assert.Equal(uint64(6), count)
+		assert.Equal(809330., sum)
+		assert.Equal(expected, buckets)
+	}
+}
+
+func TestJobCollect_ElapsedBuckets(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{JobElapsedBuckets: "3600,86400"})
+	assert.NoError(err)
+	config.TraceConf.sharedFetcher = &JobJsonFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_elapsed.json"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jc := NewJobsController(config)
+	jobChan := make(chan prometheus.Metric)
+	go func() {
+		jc.Collect(jobChan)
+		close(jobChan)
+	}()
+	var histogram *dto.Histogram
+	for metric := range jobChan {
+		if metric.Desc() != jc.jobElapsed {
+			continue
+		}
+		m := new(dto.Metric)
+		assert.NoError(metric.Write(m))
+		histogram = m.GetHistogram()
+	}
+	assert.NotNil(histogram)
+	assert.Equal(uint64(6), histogram.GetSampleCount())
+	upperBounds := make([]float64, 0)
+	for _, bucket := range histogram.GetBucket() {
+		upperBounds = append(upperBounds, bucket.GetUpperBound())
+	}
+	assert.Equal([]float64{3600, 86400}, upperBounds)
+	_, err = NewConfig(&CliFlags{JobElapsedBuckets: "86400,3600"})
+	assert.Error(err)
+}
+
+func TestOldestPendingJobs_SlurmTimeZone(t *testing.T) {
+	assert := assert.New(t)
+	// slurmctld in New York, EST is UTC-5 in November
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(err)
+	SetSlurmTimeZone(loc)
+	defer SetSlurmTimeZone(nil)
+	fetcher := &JobCliFallbackFetcher{
+		scraper:    &MockScraper{fixture: "fixtures/squeue_pending_age_fallback.txt"},
+		cache:      NewAtomicThrottledCache[JobMetric](1),
+		errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+	}
+	jobs, err := fetcher.fetch(context.Background())
+	assert.NoError(err)
+	now := time.Date(2023, 11, 15, 6, 0, 0, 0, time.UTC)
+	assert.Equal(map[string]float64{"hw-h": 10000, "hw-l": 4600, "gpu": 3000}, oldestPendingJobs(jobs, now))
+}
@@ -4,7 +4,7 @@
 package exporter
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log/slog"
 	"time"
@@ -33,33 +33,34 @@ type scontrolLicResponse struct {
 type CliJsonLicMetricFetcher struct {
 	scraper      SlurmByteScraper
 	cache        *AtomicThrottledCache[LicenseMetric]
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 }
 
-func (cjl *CliJsonLicMetricFetcher) fetch() ([]LicenseMetric, error) {
-	licBytes, err := cjl.scraper.FetchRawBytes()
+func (cjl *CliJsonLicMetricFetcher) fetch(ctx context.Context) ([]LicenseMetric, error) {
+	licBytes, err := cjl.scraper.FetchRawBytes(ctx)
 	if err != nil {
 		slog.Error(fmt.Sprintf("fetch error %q", err))
-		cjl.errorCounter.Inc()
+		cjl.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		return nil, err
 	}
 	lic := new(scontrolLicResponse)
-	if err := json.Unmarshal(licBytes, lic); err != nil {
+	if err := unmarshalCliJson(licBytes, lic); err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling license metrics %q", err))
+		cjl.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		return nil, err
 	}
 	return lic.Licenses, nil
 }
 
-func (cjl *CliJsonLicMetricFetcher) FetchMetrics() ([]LicenseMetric, error) {
-	return cjl.cache.FetchOrThrottle(cjl.fetch)
+func (cjl *CliJsonLicMetricFetcher) FetchMetrics(ctx context.Context) ([]LicenseMetric, error) {
+	return cjl.cache.FetchOrThrottle(ctx, cjl.fetch)
 }
 
 func (cjl *CliJsonLicMetricFetcher) ScrapeDuration() time.Duration {
 	return cjl.cache.duration
 }
 
-func (cjl *CliJsonLicMetricFetcher) ScrapeError() prometheus.Counter {
+func (cjl *CliJsonLicMetricFetcher) ScrapeError() *prometheus.CounterVec {
 	return cjl.errorCounter
 }
 
@@ -71,31 +72,28 @@ type LicCollector struct {
 	licReserved     *prometheus.Desc
 	licLastConsumed *prometheus.Desc
 	licLastDeficit  *prometheus.Desc
-	licScrapeError  prometheus.Counter
+	licScrapeError  *prometheus.CounterVec
 }
 
 func NewLicCollector(config *Config) *LicCollector {
 	cliOpts := config.cliOpts
 	fetcher := &CliJsonLicMetricFetcher{
-		scraper: NewCliScraper(cliOpts.lic...),
-		cache:   NewAtomicThrottledCache[LicenseMetric](config.PollLimit),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		scraper: cliOpts.scraper("lic", cliOpts.lic),
+		cache:   newCollectorCache[LicenseMetric](config.PollLimit, "license"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 			Name: "slurm_lic_scrape_error",
 			Help: "slurm license scrape error",
 		}),
 	}
 	return &LicCollector{
 		fetcher:         fetcher,
-		licTotal:        prometheus.NewDesc("slurm_lic_total", "slurm license total", []string{"name"}, nil),
-		licUsed:         prometheus.NewDesc("slurm_lic_used", "slurm license used", []string{"name"}, nil),
-		licFree:         prometheus.NewDesc("slurm_lic_free", "slurm license free", []string{"name"}, nil),
-		licLastConsumed: prometheus.NewDesc("slurm_lic_last_consumed", "slurm license last_consumed", []string{"name"}, nil),
-		licLastDeficit:  prometheus.NewDesc("slurm_lic_last_deficit", "slurm license last_deficit", []string{"name"}, nil),
-		licReserved:     prometheus.NewDesc("slurm_lic_reserved", "slurm license reserved", []string{"name"}, nil),
-		licScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_lic_scrape_error",
-			Help: "slurm license scrape error",
-		}),
+		licTotal:        config.NewDesc("slurm_lic_total", "slurm license total", []string{"name"}, nil),
+		licUsed:         config.NewDesc("slurm_lic_used", "slurm license used", []string{"name"}, nil),
+		licFree:         config.NewDesc("slurm_lic_free", "slurm license free", []string{"name"}, nil),
+		licLastConsumed: config.NewDesc("slurm_lic_last_consumed", "slurm license last_consumed", []string{"name"}, nil),
+		licLastDeficit:  config.NewDesc("slurm_lic_last_deficit", "slurm license last_deficit", []string{"name"}, nil),
+		licReserved:     config.NewDesc("slurm_lic_reserved", "slurm license reserved", []string{"name"}, nil),
+		licScrapeError:  fetcher.ScrapeError(),
 	}
 }
 
@@ -106,16 +104,17 @@ func (lc *LicCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- lc.licReserved
 	ch <- lc.licLastConsumed
 	ch <- lc.licLastDeficit
-	ch <- lc.licScrapeError.Desc()
+	lc.licScrapeError.Describe(ch)
 }
 
 func (lc *LicCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- lc.licScrapeError
-	}()
-	licMetrics, err := lc.fetcher.FetchMetrics()
+	lc.CollectContext(context.Background(), ch)
+}
+
+func (lc *LicCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer lc.licScrapeError.Collect(ch)
+	licMetrics, err := lc.fetcher.FetchMetrics(ctx)
 	if err != nil {
-		lc.licScrapeError.Inc()
 		slog.Error(fmt.Sprintf("lic parse error %q", err))
 		return
 	}
@@ -36,7 +36,7 @@ func TestLicCollect(t *testing.T) {
 	lc.fetcher = &CliJsonLicMetricFetcher{
 		scraper:      MockLicFetcher,
 		cache:        NewAtomicThrottledCache[LicenseMetric](1),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
 	lcChan := make(chan prometheus.Metric)
 	go func() {
@@ -63,7 +63,7 @@ func TestLicCollect_ColectionE(t *testing.T) {
 	lc.fetcher = &CliJsonLicMetricFetcher{
 		scraper:      MockLicFetcher,
 		cache:        NewAtomicThrottledCache[LicenseMetric](1),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
 	lcChan := make(chan prometheus.Metric)
 	go func() {
@@ -76,7 +76,8 @@ func TestLicCollect_ColectionE(t *testing.T) {
 		licMetrics = append(licMetrics, metric)
 	}
 
-	assert.Equal(7, len(licMetrics))
+	// 6 license series and the scrape error per reason
+	assert.Equal(10, len(licMetrics))
 }
 
 func TestLicDescribe(t *testing.T) {
@@ -91,7 +92,7 @@ func TestLicDescribe(t *testing.T) {
 	lc.fetcher = &CliJsonLicMetricFetcher{
 		scraper:      MockLicFetcher,
 		cache:        NewAtomicThrottledCache[LicenseMetric](1),
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 	}
 	lcChan := make(chan *prometheus.Desc)
 	go func() {
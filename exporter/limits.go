@@ -5,6 +5,7 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -18,6 +19,9 @@ import (
 
 type AccountLimitMetric struct {
 	Account string
+	// empty for the account's own association, otherwise the user -> account association
+	// i.e user Bob can allocate x cpu within account Blah
+	User string
 	// limit to the amount of resources for a particular account in the RUNNING state
 	AllocatedMem  float64
 	AllocatedCPU  float64
@@ -28,14 +32,14 @@ type AccountLimitMetric struct {
 
 type AccountCsvFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[AccountLimitMetric]
 }
 
-func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
-	cliCsv, err := acf.scraper.FetchRawBytes()
+func (acf *AccountCsvFetcher) fetchFromCli(ctx context.Context) ([]AccountLimitMetric, error) {
+	cliCsv, err := acf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		acf.errorCounter.Inc()
+		acf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		slog.Error(fmt.Sprintf("failed to scrape account metrics with %q", err))
 		return nil, err
 	}
@@ -45,28 +49,24 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 	accountMetrics := make([]AccountLimitMetric, 0)
 	for records, err := reader.Read(); err != io.EOF; records, err = reader.Read() {
 		if err != nil {
-			acf.errorCounter.Inc()
+			acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			slog.Error(fmt.Sprintf("failed to scrape account metric row %v", records))
 			continue
 		}
 		if len(records) != 6 {
-			acf.errorCounter.Inc()
+			acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			slog.Error(fmt.Sprintf("failed to scrape account metric row %v", records))
 			continue
 		}
 		user, account, cpu, mem, runningJobs, totalJobs := records[0], records[1], records[2], records[3], records[4], records[5]
 
-		if user != "" {
-			// sacctmgr will display account limits by setting the user to ""
-			// otherwise the user -> account association is shown
-			// i.e user Bob can allocate x cpu within account Blah
-			continue
-		}
-		metric := AccountLimitMetric{Account: account}
+		// sacctmgr will display account limits by setting the user to ""
+		// user associations are kept for the inventory counts but their limits aren't exported
+		metric := AccountLimitMetric{Account: account, User: user}
 		if mem != "" {
 			if memMb, err := strconv.ParseFloat(mem, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric mem string %s", mem))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			} else {
 				metric.AllocatedMem = memMb * 1e6
 			}
@@ -74,7 +74,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if cpu != "" {
 			if cpuCount, err := strconv.ParseFloat(cpu, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric cpu string %s", cpu))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			} else {
 				metric.AllocatedCPU = cpuCount
 			}
@@ -82,7 +82,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if runningJobs != "" {
 			if runnableJobs, err := strconv.ParseFloat(runningJobs, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric AllocatableJobs (jobs in RUNNING state) with err: %q", err))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			} else {
 				metric.AllocatedJobs = runnableJobs
 			}
@@ -90,7 +90,7 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 		if totalJobs != "" {
 			if allJobs, err := strconv.ParseFloat(totalJobs, 64); err != nil {
 				slog.Error(fmt.Sprintf("failed to scrape account metric TotalJobs (jobs in RUNNING or PENDING state) with err: %q", err))
-				acf.errorCounter.Inc()
+				acf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			} else {
 				metric.TotalJobs = allJobs
 			}
@@ -100,11 +100,11 @@ func (acf *AccountCsvFetcher) fetchFromCli() ([]AccountLimitMetric, error) {
 	return accountMetrics, nil
 }
 
-func (acf *AccountCsvFetcher) FetchMetrics() ([]AccountLimitMetric, error) {
-	return acf.cache.FetchOrThrottle(acf.fetchFromCli)
+func (acf *AccountCsvFetcher) FetchMetrics(ctx context.Context) ([]AccountLimitMetric, error) {
+	return acf.cache.FetchOrThrottle(ctx, acf.fetchFromCli)
 }
 
-func (acf *AccountCsvFetcher) ScrapeError() prometheus.Counter {
+func (acf *AccountCsvFetcher) ScrapeError() *prometheus.CounterVec {
 	return acf.errorCounter
 }
 
@@ -119,7 +119,34 @@ type LimitCollector struct {
 	accountJobAllocCountLimit *prometheus.Desc
 	accountJobCountLimit      *prometheus.Desc
 	limitScrapeDuration       *prometheus.Desc
-	limitScrapeError          prometheus.Counter
+	limitScrapeError          *prometheus.CounterVec
+	// inventory of the associations sacctmgr lists
+	accounts     *prometheus.Desc
+	users        *prometheus.Desc
+	associations *prometheus.Desc
+}
+
+type associationInventory struct {
+	accounts     float64
+	users        float64
+	associations float64
+}
+
+// distinct accounts and users across all association rows. Every row, account or user, is an association
+func countAssociations(metrics []AccountLimitMetric) associationInventory {
+	accounts := make(map[string]bool)
+	users := make(map[string]bool)
+	for _, metric := range metrics {
+		accounts[metric.Account] = true
+		if metric.User != "" {
+			users[metric.User] = true
+		}
+	}
+	return associationInventory{
+		accounts:     float64(len(accounts)),
+		users:        float64(len(users)),
+		associations: float64(len(metrics)),
+	}
 }
 
 func NewLimitCollector(config *Config) *LimitCollector {
@@ -127,41 +154,46 @@ func NewLimitCollector(config *Config) *LimitCollector {
 	if !cliOpts.sacctEnabled {
 		log.Fatal("tried to invoke limit collector while cli disabled")
 	}
-	return &LimitCollector{
-		fetcher: &AccountCsvFetcher{
-			scraper: NewCliScraper(cliOpts.sacctmgr...),
-			cache:   NewAtomicThrottledCache[AccountLimitMetric](config.PollLimit),
-			errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
-				Name: "slurm_account_scrape_error",
-				Help: "Slurm sacct scrape error",
-			}),
-		},
-		accountCpuLimit:           prometheus.NewDesc("slurm_account_cpu_limit", "slurm account cpu limit", []string{"account"}, nil),
-		accountMemLimit:           prometheus.NewDesc("slurm_account_mem_limit", "slurm account mem limit (in bytes)", []string{"account"}, nil),
-		accountJobAllocCountLimit: prometheus.NewDesc("slurm_account_job_alloc_limit", "slurm account limit on the # of jobs allowed to be RUNNING state", []string{"account"}, nil),
-		accountJobCountLimit:      prometheus.NewDesc("slurm_account_job_limit", "slurm account limit on the # of jobs allowed to be RUNNING or PENDING state", []string{"account"}, nil),
-		limitScrapeDuration:       prometheus.NewDesc("slurm_limit_scrape_duration", "slurm sacctmgr scrape duration", nil, nil),
-		limitScrapeError: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "slurm_account_collect_error",
-			Help: "Slurm sacct collect error",
+	fetcher := &AccountCsvFetcher{
+		scraper: cliOpts.scraper("sacctmgr", cliOpts.sacctmgr),
+		cache:   newCollectorCache[AccountLimitMetric](config.PollLimit, "limit"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_account_scrape_error",
+			Help: "Slurm sacct scrape error",
 		}),
 	}
+	return &LimitCollector{
+		fetcher:                   fetcher,
+		accountCpuLimit:           config.NewDesc("slurm_account_cpu_limit", "slurm account cpu limit", []string{"account"}, nil),
+		accountMemLimit:           config.NewDesc("slurm_account_mem_limit", "slurm account mem limit (in bytes)", []string{"account"}, nil),
+		accountJobAllocCountLimit: config.NewDesc("slurm_account_job_alloc_limit", "slurm account limit on the # of jobs allowed to be RUNNING state", []string{"account"}, nil),
+		accountJobCountLimit:      config.NewDesc("slurm_account_job_limit", "slurm account limit on the # of jobs allowed to be RUNNING or PENDING state", []string{"account"}, nil),
+		limitScrapeDuration:       config.NewDesc("slurm_limit_scrape_duration", "slurm sacctmgr scrape duration", nil, nil),
+		limitScrapeError:          fetcher.ScrapeError(),
+		accounts:                  config.NewDesc("slurm_accounts_total", "distinct accounts with an association in sacctmgr", nil, nil),
+		users:                     config.NewDesc("slurm_users_total", "distinct users with an association in sacctmgr", nil, nil),
+		associations:              config.NewDesc("slurm_associations_total", "account and user associations in sacctmgr", nil, nil),
+	}
 }
 
 func (lc *LimitCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- lc.accountCpuLimit
 	ch <- lc.accountMemLimit
 	ch <- lc.limitScrapeDuration
-	ch <- lc.limitScrapeError.Desc()
+	lc.limitScrapeError.Describe(ch)
+	ch <- lc.accounts
+	ch <- lc.users
+	ch <- lc.associations
 }
 
 func (lc *LimitCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- lc.limitScrapeError
-	}()
-	limitMetrics, err := lc.fetcher.FetchMetrics()
+	lc.CollectContext(context.Background(), ch)
+}
+
+func (lc *LimitCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer lc.limitScrapeError.Collect(ch)
+	limitMetrics, err := lc.fetcher.FetchMetrics(ctx)
 	if err != nil {
-		lc.limitScrapeError.Inc()
 		slog.Error(fmt.Sprintf("lic parse error %q", err))
 		return
 	}
@@ -172,7 +204,14 @@ func (lc *LimitCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 	ch <- prometheus.MustNewConstMetric(lc.limitScrapeDuration, prometheus.GaugeValue, float64(lc.fetcher.ScrapeDuration().Milliseconds()))
+	inventory := countAssociations(limitMetrics)
+	ch <- prometheus.MustNewConstMetric(lc.accounts, prometheus.GaugeValue, inventory.accounts)
+	ch <- prometheus.MustNewConstMetric(lc.users, prometheus.GaugeValue, inventory.users)
+	ch <- prometheus.MustNewConstMetric(lc.associations, prometheus.GaugeValue, inventory.associations)
 	for _, account := range limitMetrics {
+		if account.User != "" {
+			continue
+		}
 		emitNonZeroVal(lc.accountMemLimit, account.AllocatedMem, account.Account)
 		emitNonZeroVal(lc.accountCpuLimit, account.AllocatedCPU, account.Account)
 		emitNonZeroVal(lc.accountJobAllocCountLimit, account.AllocatedJobs, account.Account)
@@ -4,6 +4,8 @@
 package exporter
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,15 +18,16 @@ func TestAccountLimitFetch(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := AccountCsvFetcher{
 		scraper:      MockSacctFetcher,
-		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
 	}
-	accountLimits, err := fetcher.fetchFromCli()
+	accountLimits, err := fetcher.fetchFromCli(context.Background())
 	assert.NoError(err)
-	assert.Len(accountLimits, 6)
+	// 6 accounts and the user association of shouldignore
+	assert.Len(accountLimits, 7)
 	var account5Limits AccountLimitMetric
 	for _, metric := range accountLimits {
-		if metric.Account == "account5" {
+		if metric.Account == "account5" && metric.User == "" {
 			account5Limits = metric
 		}
 	}
@@ -97,5 +100,54 @@ func TestLimitDescribe(t *testing.T) {
 		t.Log(desc.String())
 		limitMetrics = append(limitMetrics, desc)
 	}
-	assert.Len(limitMetrics, 4)
+	assert.Len(limitMetrics, 7)
+}
+
+func TestLimitCollector_FetchError(t *testing.T) {
+	assert := assert.New(t)
+	lc := NewLimitCollector(&Config{PollLimit: 10, cliOpts: &CliOpts{sacctEnabled: true}})
+	lc.fetcher = &AccountCsvFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/does_not_exist.txt"},
+		errorCounter: lc.limitScrapeError,
+		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
+	}
+	ch := make(chan prometheus.Metric, 20)
+	lc.Collect(ch)
+	close(ch)
+	errorCounters := 0
+	for metric := range ch {
+		if strings.Contains(metric.Desc().String(), "_error") {
+			errorCounters++
+		}
+	}
+	// a single reason labeled counter, the failed fetch is counted once
+	assert.Equal(len(scrapeErrorReasons), errorCounters)
+	assert.Equal(1., CollectCounterValue(lc.limitScrapeError))
+	assert.Equal(1., CollectCounterValue(lc.limitScrapeError.WithLabelValues(ScrapeErrorExec)))
+}
+
+func TestLimitCollector_Inventory(t *testing.T) {
+	assert := assert.New(t)
+	lc := NewLimitCollector(&Config{PollLimit: 10, cliOpts: &CliOpts{sacctEnabled: true}})
+	lc.fetcher = &AccountCsvFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/sacctmgr_inventory.txt"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[AccountLimitMetric](10),
+	}
+	metrics, err := lc.fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	// alice is associated with 2 accounts but counted once
+	assert.Equal(associationInventory{accounts: 4, users: 4, associations: 9}, countAssociations(metrics))
+
+	ch := make(chan prometheus.Metric, 20)
+	lc.Collect(ch)
+	close(ch)
+	cpuLimits := 0
+	for metric := range ch {
+		if metric.Desc() == lc.accountCpuLimit {
+			cpuLimits++
+		}
+	}
+	// alice's cpu limit within chemistry is a user association and isn't exported as an account limit
+	assert.Equal(1, cpuLimits)
 }
@@ -5,16 +5,23 @@
 package exporter
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"log/slog"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,9 +39,8 @@ func TestMain(m *testing.M) {
 func TestPromServer(t *testing.T) {
 	assert := assert.New(t)
 	cliOpts := &CliOpts{
-		sinfo:         []string{"cat", "fixtures/sinfo_out.json"},
-		squeue:        []string{"cat", "fixtures/squeue_out.json"},
-		excludeFilter: regexp.MustCompile(""),
+		sinfo:  []string{"cat", "fixtures/sinfo_out.json"},
+		squeue: []string{"cat", "fixtures/squeue_out.json"},
 	}
 	config := &Config{
 		PollLimit: 10,
@@ -44,7 +50,7 @@ func TestPromServer(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper: NewCliScraper(cliOpts.squeue...),
 				cache:   NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{
 					Name: "slurm_job_scrape_error",
 					Help: "job scrape error",
 				}),
@@ -57,8 +63,9 @@ func TestPromServer(t *testing.T) {
 	server.ServeHTTP(w, r)
 	assert.Equal(200, w.Code)
 	txt := w.Body.String()
-	assert.Contains(txt, "slurm_job_scrape_error 0")
-	assert.Contains(txt, "slurm_node_scrape_error 0")
+	assert.Contains(txt, `slurm_job_scrape_error{reason="exec-error"} 0`)
+	assert.Contains(txt, `slurm_node_scrape_error{reason="unmarshal"} 0`)
+	assert.Contains(txt, `slurm_exporter_build_info{goversion="`)
 }
 
 func TestNewConfig_Default(t *testing.T) {
@@ -76,8 +83,772 @@ func TestNewConfig_NonDefault(t *testing.T) {
 	cliFlags := CliFlags{SlurmCliFallback: true}
 	config, err := NewConfig(&cliFlags)
 	assert.Nil(err)
-	expected := []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "array_id": "%K", "r": "%R"}`}
+	expected := []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "nodes": %D, "nodelist": "%N", "submit": "%V", "start": "%S", "prio": %Q, "array_id": "%K", "r": "%R"}`}
 	assert.Equal(expected, config.cliOpts.squeue)
 }
 
+func TestNewConfig_SacctLookback(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SacctLookbackMinutes: 30})
+	assert.Nil(err)
+	assert.Equal([]string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=RUNNING", "--json", "--starttime=now-30minutes"}, config.cliOpts.sacctGpu)
+	// the fallback GPU alloc cmd is squeue, which doesn't take a start time
+	config, err = NewConfig(&CliFlags{SacctLookbackMinutes: 30, SlurmCliFallback: true})
+	assert.Nil(err)
+	assert.NotContains(config.cliOpts.sacctGpu, "--starttime=now-30minutes")
+	// no lookback by default
+	config, err = NewConfig(new(CliFlags))
+	assert.Nil(err)
+	assert.Equal([]string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=RUNNING", "--json"}, config.cliOpts.sacctGpu)
+}
+
+func TestNewConfig_Partitions(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmPartitionsEnabled: true})
+	assert.Nil(err)
+	assert.True(config.cliOpts.partitionsEnabled)
+	assert.Equal([]string{"sinfo", "-h", "-o", "%P|%a|%D|%T"}, config.cliOpts.sinfoPartition)
+	// the partition cmd is the same in fallback mode
+	config, err = NewConfig(&CliFlags{SlurmPartitionsEnabled: true, SlurmCliFallback: true})
+	assert.Nil(err)
+	assert.Equal([]string{"sinfo", "-h", "-o", "%P|%a|%D|%T"}, config.cliOpts.sinfoPartition)
+}
+
+func TestNewConfig_ExternalLabels(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{ExternalLabels: "region=us-east, cluster=rivos"})
+	assert.Nil(err)
+	assert.Equal(prometheus.Labels{"region": "us-east", "cluster": "rivos"}, config.ExternalLabels)
+}
+
+func TestNewConfig_FixtureDir(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	for fixture, src := range map[string]string{"sinfo": "fixtures/sinfo_out.json", "squeue": "fixtures/squeue_out.json"} {
+		data, err := os.ReadFile(src)
+		assert.NoError(err)
+		assert.NoError(os.WriteFile(filepath.Join(dir, fixture), data, 0o644))
+	}
+	assert.NoError(os.WriteFile(filepath.Join(dir, "scontrol_config"), []byte("ClusterName = fixture\n"), 0o644))
+	config, err := NewConfig(&CliFlags{SlurmFixtureDir: dir})
+	assert.Nil(err)
+	config.resolveClusterName()
+	assert.Equal("fixture", config.ClusterName)
+	nodeMetrics, err := NewNodeCollecter(config).Fetcher().FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.NotEmpty(nodeMetrics)
+	jobMetrics, err := config.TraceConf.sharedFetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(jobMetrics, 2)
+	// missing fixtures surface as scrape errors rather than falling back to the cli
+	_, err = NewLicCollector(config).fetcher.FetchMetrics(context.Background())
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestNewConfig_CacheJitter(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{CacheJitter: 0.1})
+	assert.NoError(err)
+	assert.Equal(0.1, config.cliOpts.cacheJitter)
+	for _, jitter := range []float64{-0.1, 1, 1.5} {
+		_, err = NewConfig(&CliFlags{CacheJitter: jitter})
+		assert.Error(err)
+	}
+}
+
+func TestNewConfig_Overrides(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{
+		SlurmSinfoOverride:     `sinfo -h -o "%n %G"`,
+		SlurmSinfoGpuOverride:  `sinfo -h -o "%n %G"`,
+		SlurmPartitionOverride: "sinfo  -h -o '%P|%a'",
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"sinfo", "-h", "-o", "%n %G"}, config.cliOpts.sinfo)
+	assert.Equal([]string{"sinfo", "-h", "-o", "%n %G"}, config.cliOpts.sinfoGpu)
+	assert.Equal([]string{"sinfo", "-h", "-o", "%P|%a"}, config.cliOpts.sinfoPartition)
+	_, err = NewConfig(&CliFlags{SlurmDiagOverride: `sdiag "--json`})
+	assert.Error(err)
+}
+
+func TestNewConfig_SlurmBinDir(t *testing.T) {
+	assert := assert.New(t)
+	for _, fallback := range []bool{false, true} {
+		config, err := NewConfig(&CliFlags{SlurmCliFallback: fallback, SlurmBinDir: "/opt/slurm/bin"})
+		assert.NoError(err)
+		cliOpts := config.cliOpts
+		for _, cmd := range [][]string{
+			cliOpts.squeue, cliOpts.sinfo, cliOpts.lic, cliOpts.sdiag, cliOpts.sacctmgr, cliOpts.sinfoGpu,
+			cliOpts.sacctGpu, cliOpts.squeueGpu, cliOpts.squeuePendingGpu, cliOpts.sinfoPartition, cliOpts.partitionConf,
+			cliOpts.completedJobs, cliOpts.ctldPing, cliOpts.nodeDetail, cliOpts.dbdPing,
+		} {
+			assert.Equal("/opt/slurm/bin", filepath.Dir(cmd[0]), cmd)
+		}
+		// the shared scrapers are built from the prefixed cmds
+		assert.Equal(cliOpts.sinfo, cliOpts.sharedSinfo.(*ThrottledScraper).scraper.(*ResponseSizeScraper).scraper.(*CliScraper).args)
+	}
+	config, err := NewConfig(&CliFlags{
+		SlurmBinDir:           "/opt/slurm/bin",
+		SlurmSinfoOverride:    "/usr/local/bin/sinfo --json",
+		SlurmSqueueOverride:   "squeue --json",
+		SlurmSinfoGpuOverride: "cat fixtures/sinfo_gpu_out.json",
+	})
+	assert.NoError(err)
+	// absolute overrides win, bare slurm binaries are still prefixed and other binaries are left to PATH
+	assert.Equal([]string{"/usr/local/bin/sinfo", "--json"}, config.cliOpts.sinfo)
+	assert.Equal([]string{"/opt/slurm/bin/squeue", "--json"}, config.cliOpts.squeue)
+	assert.Equal([]string{"cat", "fixtures/sinfo_gpu_out.json"}, config.cliOpts.sinfoGpu)
+	assert.Equal([]string{"/opt/slurm/bin/sdiag", "--json"}, config.cliOpts.sdiag)
+}
+
+// splitting on spaces used to shatter the json format into tokens like `{"a":` and `"%a",`
+func TestNewConfig_FallbackSqueueOverride(t *testing.T) {
+	assert := assert.New(t)
+	format := `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "array_id": "%K", "r": "%R"}`
+	override := `squeue --states=RUNNING -h -r -o '` + format + `'`
+	config, err := NewConfig(&CliFlags{SlurmCliFallback: true, SlurmSqueueOverride: override})
+	assert.NoError(err)
+	expected := []string{"squeue", "--states=RUNNING", "-h", "-r", "-o", format}
+	assert.Equal(expected, config.cliOpts.squeue)
+	fetcher, ok := config.TraceConf.sharedFetcher.(*JobCliFallbackFetcher)
+	assert.True(ok)
+	scraper, ok := fetcher.scraper.(*ResponseSizeScraper).scraper.(*CliScraper)
+	assert.True(ok)
+	assert.Equal(expected, scraper.args)
+}
+
+func TestNewConfig_ExternalLabelsMalformed(t *testing.T) {
+	assert := assert.New(t)
+	for _, labels := range []string{"region", "1region=us-east", "region=us-east,region=us-west", "region=us-east,"} {
+		_, err := NewConfig(&CliFlags{ExternalLabels: labels})
+		assert.Error(err, labels)
+	}
+}
+
+func gatherNodeMetricNames(t *testing.T, config *Config) []string {
+	registry := prometheus.NewRegistry()
+	nc := NewNodeCollecter(config)
+	nc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{Name: "slurm_node_scrape_error"}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	NewWrappedRegisterer(config, registry).MustRegister(nc)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	names := make([]string, 0)
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	return names
+}
+
+func TestNewWrappedRegisterer_Empty(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.Nil(err)
+	names := gatherNodeMetricNames(t, config)
+	assert.Contains(names, "slurm_cpus_total")
+	assert.Contains(names, "slurm_node_scrape_error")
+}
+
+func TestNewWrappedRegisterer_Prefix(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MetricsPrefix: "site_", ExternalLabels: "region=us-east"})
+	assert.Nil(err)
+	names := gatherNodeMetricNames(t, config)
+	assert.Contains(names, "site_slurm_cpus_total")
+	assert.NotContains(names, "slurm_cpus_total")
+}
+
+func TestConfig_MetricsHelp(t *testing.T) {
+	assert := assert.New(t)
+	helpFile := filepath.Join(t.TempDir(), "help.json")
+	assert.NoError(os.WriteFile(helpFile, []byte(`{"slurm_gpus_total": "GPUs as counted by the site", "gpu_scrape_errors": "site GPU scrape errors"}`), 0o644))
+	// keyed by the unprefixed name, the prefix is applied at registration
+	config, err := NewConfig(&CliFlags{MetricsPrefix: "site_", MetricsHelpFile: helpFile, SlurmGpusEnabled: true})
+	assert.NoError(err)
+	gc := NewGpuCollector(config)
+	assert.Contains(gc.total.String(), `help: "GPUs as counted by the site"`)
+	// metrics without an override keep their help
+	assert.Contains(gc.alloc.String(), `help: "Allocated GPUs"`)
+	descs := make(chan *prometheus.Desc, 1)
+	gc.fetcher.ScrapeError().Describe(descs)
+	assert.Contains((<-descs).String(), `help: "site GPU scrape errors"`)
+}
+
+func TestNewConfig_MetricsHelpFileMalformed(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	for i, content := range []string{`not json`, `{"slurm_cpus_total": ""}`, `["slurm_cpus_total"]`} {
+		helpFile := filepath.Join(dir, fmt.Sprintf("help%d.json", i))
+		assert.NoError(os.WriteFile(helpFile, []byte(content), 0o644))
+		_, err := NewConfig(&CliFlags{MetricsHelpFile: helpFile})
+		assert.Error(err, content)
+	}
+	_, err := NewConfig(&CliFlags{MetricsHelpFile: filepath.Join(dir, "missing.json")})
+	assert.Error(err)
+}
+
+func newTestGauge(name string) prometheus.Gauge {
+	return prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: name})
+}
+
+func TestCollectorGroups_Split(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{MetricsPath: "/metrics", SplitMetricsPaths: true}
+	combined := prometheus.NewRegistry()
+	combined.MustRegister(newTestGauge("slurm_exporter_core"))
+	groups := newCollectorGroups(config)
+	groups.MustRegister("node", newTestGauge("slurm_node_gauge"))
+	groups.MustRegister("gpu", newTestGauge("slurm_gpu_gauge"))
+	handlers := groups.Handlers(nil, nil)
+	assert.Len(handlers, 2)
+	w := httptest.NewRecorder()
+	handlers["/metrics/gpu"].ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/gpu", nil))
+	assert.Equal(200, w.Code)
+	assert.Contains(w.Body.String(), "slurm_gpu_gauge 0")
+	assert.NotContains(w.Body.String(), "slurm_node_gauge")
+	assert.NotContains(w.Body.String(), "slurm_exporter_core")
+	// the combined path still serves every group
+	families, err := groups.Gatherer(context.Background(), combined).Gather()
+	assert.NoError(err)
+	assert.Len(families, 3)
+}
+
+// blocks in Collect until released
+type blockingCollector struct {
+	desc    *prometheus.Desc
+	release chan struct{}
+}
+
+func (bc *blockingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.desc
+}
+
+func (bc *blockingCollector) Collect(ch chan<- prometheus.Metric) {
+	<-bc.release
+}
+
+func TestPrefetchCollectors(t *testing.T) {
+	assert := assert.New(t)
+	scraper := &MockScraper{fixture: "fixtures/sinfo_out.json"}
+	nc := NewNodeCollecter(&Config{PollLimit: 10, cliOpts: &CliOpts{}})
+	nc.SetFetcher(&NodeJsonFetcher{scraper: scraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](10)})
+	groups := newCollectorGroups(&Config{MetricsPath: "/metrics"})
+	groups.MustRegister("node", nc)
+	assert.True(prefetchCollectors(groups.collectors, time.Minute))
+	assert.Equal(1, scraper.CallCount)
+	// the first real scrape is served from the warm cache
+	_, err := nc.Fetcher().FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(1, scraper.CallCount)
+}
+
+func TestPrefetchCollectors_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	collector := &blockingCollector{desc: prometheus.NewDesc("slurm_blocking", "blocks", nil, nil), release: make(chan struct{})}
+	defer close(collector.release)
+	start := time.Now()
+	assert.False(prefetchCollectors([]prometheus.Collector{collector}, 10*time.Millisecond))
+	assert.Less(time.Since(start), time.Second)
+}
+
+func TestCollectorGroups_Combined(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{MetricsPath: "/metrics"}
+	groups := newCollectorGroups(config)
+	groups.MustRegister("node", newTestGauge("slurm_node_gauge"))
+	groups.MustRegister("gpu", newTestGauge("slurm_gpu_gauge"))
+	assert.Empty(groups.Handlers(nil, nil))
+	families, err := groups.Gatherer(context.Background(), prometheus.NewRegistry()).Gather()
+	assert.NoError(err)
+	assert.Len(families, 2)
+	// conflicting collectors fail at registration rather than on the first scrape
+	assert.Panics(func() { groups.MustRegister("job", newTestGauge("slurm_node_gauge")) })
+}
+
+// records the context it was collected with
+type contextRecordingCollector struct {
+	desc *prometheus.Desc
+	ctx  context.Context
+}
+
+func (crc *contextRecordingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- crc.desc
+}
+
+func (crc *contextRecordingCollector) Collect(ch chan<- prometheus.Metric) {
+	crc.CollectContext(context.Background(), ch)
+}
+
+func (crc *contextRecordingCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	crc.ctx = ctx
+	ch <- prometheus.MustNewConstMetric(crc.desc, prometheus.GaugeValue, 1)
+}
+
+func TestCollectorGroups_ScrapeContext(t *testing.T) {
+	assert := assert.New(t)
+	for _, split := range []bool{false, true} {
+		collector := &contextRecordingCollector{desc: prometheus.NewDesc("slurm_ctx", "ctx", nil, nil)}
+		groups := newCollectorGroups(&Config{MetricsPath: "/metrics", SplitMetricsPaths: split})
+		groups.MustRegister("node", collector)
+		handler := NewScrapeTimeoutHandler(newScrapeHTTPServer(func(ctx context.Context) prometheus.Gatherer {
+			return groups.Gatherer(ctx, prometheus.NewRegistry())
+		}, nil, nil))
+		if split {
+			handler = groups.Handlers(nil, nil)["/metrics/node"]
+		}
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Contains(w.Body.String(), "slurm_ctx 1")
+		deadline, ok := collector.ctx.Deadline()
+		assert.True(ok)
+		assert.WithinDuration(time.Now().Add(10*time.Second-scrapeTimeoutOffset), deadline, time.Second)
+	}
+}
+
+func TestCollectorGroups_MaxSeriesPerCollector(t *testing.T) {
+	assert := assert.New(t)
+	groups := newCollectorGroups(&Config{MetricsPath: "/metrics", MaxSeriesPerCollector: 3})
+	partitions := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_partition_gauge", Help: "partitions"}, []string{"partition"})
+	for _, p := range []string{"p5", "p3", "p1", "p4", "p2"} {
+		partitions.WithLabelValues(p).Set(1)
+	}
+	scrapeErrors := NewScrapeErrorCounter(prometheus.CounterOpts{Name: "slurm_partition_scrape_error", Help: "errors"})
+	groups.MustRegister("partition", partitions, scrapeErrors)
+	combined := groups.Gatherer(context.Background(), prometheus.NewRegistry())
+	families, err := combined.Gather()
+	assert.NoError(err)
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	// the first series in sorted order survive
+	kept := make([]string, 0)
+	for _, metric := range byName["slurm_partition_gauge"].GetMetric() {
+		kept = append(kept, metric.GetLabel()[0].GetValue())
+	}
+	assert.Equal([]string{"p1", "p2", "p3"}, kept)
+	// error counters pass through regardless of the cap
+	assert.Len(byName["slurm_partition_scrape_error"].GetMetric(), len(scrapeErrorReasons))
+	truncated := byName["slurm_collector_series_truncated"].GetMetric()
+	assert.Len(truncated, 1)
+	assert.Equal("partition", truncated[0].GetLabel()[0].GetValue())
+	assert.Equal(2., truncated[0].GetGauge().GetValue())
+	// back under the cap
+	partitions.DeleteLabelValues("p4")
+	partitions.DeleteLabelValues("p5")
+	families, err = combined.Gather()
+	assert.NoError(err)
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	assert.Len(byName["slurm_partition_gauge"].GetMetric(), 3)
+	assert.Equal(0., byName["slurm_collector_series_truncated"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestNewConfig_MaxSeriesPerCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{MaxSeriesPerCollector: 100})
+	assert.NoError(err)
+	assert.Equal(100, config.MaxSeriesPerCollector)
+	_, err = NewConfig(&CliFlags{MaxSeriesPerCollector: -1})
+	assert.Error(err)
+}
+
+func gatherDefaultCollectorNames(t *testing.T, goCollector bool, processCollector bool) []string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	unregisterDefaultCollectors(registry, goCollector, processCollector)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	names := make([]string, 0)
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	return names
+}
+
+func TestUnregisterDefaultCollectors(t *testing.T) {
+	assert := assert.New(t)
+	names := gatherDefaultCollectorNames(t, false, false)
+	assert.Contains(names, "go_goroutines")
+	names = gatherDefaultCollectorNames(t, true, false)
+	for _, name := range names {
+		assert.NotRegexp("^go_", name)
+	}
+	names = gatherDefaultCollectorNames(t, true, true)
+	assert.Empty(names)
+}
+
+func TestParseLabelMatchers(t *testing.T) {
+	assert := assert.New(t)
+	matchers, err := parseLabelMatchers("user=root, user=slurm,partition=debug")
+	assert.NoError(err)
+	assert.Equal([]LabelMatcher{{"user", "root"}, {"user", "slurm"}, {"partition", "debug"}}, matchers)
+	matchers, err = parseLabelMatchers("")
+	assert.NoError(err)
+	assert.Empty(matchers)
+	for _, malformed := range []string{"user", "1user=root", "user=root,"} {
+		_, err := parseLabelMatchers(malformed)
+		assert.Error(err, malformed)
+	}
+}
+
+func TestPromHTTPServer_ExcludeLabels(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	userJobs := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_user_jobs", Help: "jobs per user"}, []string{"user", "state"})
+	userJobs.WithLabelValues("root", "running").Set(1)
+	userJobs.WithLabelValues("root", "pending").Set(2)
+	userJobs.WithLabelValues("alice", "running").Set(3)
+	rootOnly := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_root_only", Help: "root only"}, []string{"user"})
+	rootOnly.WithLabelValues("root").Set(1)
+	unlabeled := newTestGauge("slurm_unlabeled")
+	registry.MustRegister(userJobs, rootOnly, unlabeled)
+	server := NewPromHTTPServer(registry, nil, []LabelMatcher{{"user", "root"}})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(200, w.Code)
+	txt := w.Body.String()
+	assert.Contains(txt, `slurm_user_jobs{state="running",user="alice"} 3`)
+	assert.NotContains(txt, `user="root"`)
+	// families without any remaining series are dropped
+	assert.NotContains(txt, "slurm_root_only")
+	assert.Contains(txt, "slurm_unlabeled 0")
+}
+
+func TestPromHTTPServer_ExcludeNamesAndLabels(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	userJobs := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_user_jobs", Help: "jobs per user"}, []string{"user"})
+	userJobs.WithLabelValues("root").Set(1)
+	userJobs.WithLabelValues("alice").Set(3)
+	registry.MustRegister(userJobs, newTestGauge("slurm_unlabeled"))
+	server := NewPromHTTPServer(registry, []*regexp.Regexp{regexp.MustCompile("^slurm_unlabeled$")}, []LabelMatcher{{"user", "root"}})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	txt := w.Body.String()
+	assert.Contains(txt, `slurm_user_jobs{user="alice"} 3`)
+	assert.NotContains(txt, `user="root"`)
+	assert.NotContains(txt, "slurm_unlabeled")
+}
+
+func TestParseExcludeFilters(t *testing.T) {
+	assert := assert.New(t)
+	for patterns, expected := range map[string][]string{
+		"":                       nil,
+		"^slurm_proc_":           {"^slurm_proc_"},
+		"^go_, ^slurm_proc_,":    {"^go_", "^slurm_proc_"},
+		"^slurm_(cpus|mem),^go_": {"^slurm_(cpus|mem)", "^go_"},
+		// commas inside a quantifier or class belong to the regex
+		"^a{1,3}$,[,x]": {"^a{1,3}$", "[,x]"},
+		`^a\,b$,^c`:     {`^a\,b$`, "^c"},
+	} {
+		filters, err := parseExcludeFilters(patterns)
+		assert.NoError(err, patterns)
+		var parsed []string
+		for _, filter := range filters {
+			parsed = append(parsed, filter.String())
+		}
+		assert.Equal(expected, parsed, patterns)
+	}
+	_, err := parseExcludeFilters("^go_,slurm_(")
+	assert.Error(err)
+}
+
+func TestPromHTTPServer_ExcludeMultipleRegexes(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestGauge("slurm_cpus_total"), newTestGauge("slurm_proc_pid"), newTestGauge("slurm_mem_real"), newTestGauge("slurm_gpus_total"))
+	config, err := NewConfig(&CliFlags{MetricsExcludeFilterRegex: "^slurm_proc_,_real$"})
+	assert.NoError(err)
+	server := NewPromHTTPServer(registry, config.cliOpts.excludeFilters, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	txt := w.Body.String()
+	// each family is matched by a different pattern
+	assert.NotContains(txt, "slurm_proc_pid")
+	assert.NotContains(txt, "slurm_mem_real")
+	assert.Contains(txt, "slurm_cpus_total 0")
+	assert.Contains(txt, "slurm_gpus_total 0")
+}
+
+func TestScrapeTimeoutHandler(t *testing.T) {
+	assert := assert.New(t)
+	var deadline time.Time
+	var ok bool
+	handler := NewScrapeTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(ok)
+	assert.WithinDuration(time.Now().Add(10*time.Second-scrapeTimeoutOffset), deadline, time.Second)
+}
+
+func TestScrapeTimeoutHandler_NoHeader(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewScrapeTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		assert.False(ok)
+	}))
+	for _, header := range []string{"", "abc", "-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestScrapeTimeoutHandler_BoundsCliScraper(t *testing.T) {
+	assert := assert.New(t)
+	var err error
+	handler := NewScrapeTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err = NewCliScraper("sleep", "100").FetchRawBytes(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.ErrorIs(err, ErrScrapeTimeout)
+	assert.Less(time.Since(start), 5*time.Second)
+}
+
+func TestScrapeTimeoutHandler_ConcurrentScrapes(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewScrapeTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := NewCliScraper("sleep", r.URL.Query().Get("sleep")).FetchRawBytes(r.Context()); err != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	short := httptest.NewRequest(http.MethodGet, "/metrics?sleep=10", nil)
+	short.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	shortW := httptest.NewRecorder()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(shortW, short)
+	}()
+	// a scrape without a timeout isn't cut short by the concurrent scrape's deadline
+	long := httptest.NewRecorder()
+	handler.ServeHTTP(long, httptest.NewRequest(http.MethodGet, "/metrics?sleep=0.5", nil))
+	wg.Wait()
+	assert.Equal(http.StatusGatewayTimeout, shortW.Code)
+	assert.Equal(http.StatusOK, long.Code)
+}
+
+func TestNewConfig_Pprof(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.Nil(err)
+	assert.False(config.PprofEnabled)
+	config, err = NewConfig(&CliFlags{PprofEnabled: true, PprofAddress: "localhost:6060"})
+	assert.Nil(err)
+	assert.True(config.PprofEnabled)
+	assert.Equal("localhost:6060", config.PprofAddress)
+}
+
+func TestPprofHandler(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(NewPprofHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/debug/pprof/")
+	assert.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(err)
+	assert.Contains(string(body), "goroutine")
+}
+
+func TestDebugRawHandler(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewDebugRawHandler(&CliOpts{
+		sinfo:  []string{"echo", `{"nodes": []}`},
+		squeue: []string{"echo", "26515966|RUNNING"},
+		lic:    []string{"echo", "not enabled"},
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/raw/sinfo", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal("{\"nodes\": []}\n", w.Body.String())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/raw/squeue", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal("26515966|RUNNING\n", w.Body.String())
+	// cmds of disabled collectors aren't exposed
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/raw/lic", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Contains(w.Body.String(), "[sinfo squeue]")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/raw/sinfo", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestDebugRawHandler_CmdError(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewDebugRawHandler(&CliOpts{sinfo: []string{"/bin/sh", "-c", "echo slurmctld down >&2"}})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/raw/sinfo", nil))
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.Contains(w.Body.String(), "slurmctld down")
+}
+
+func TestDebugRawHandler_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	handler := NewScrapeTimeoutHandler(NewDebugRawHandler(&CliOpts{sinfo: []string{"sleep", "10"}}))
+	r := httptest.NewRequest(http.MethodGet, "/debug/raw/sinfo", nil)
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.1")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.Less(time.Since(start), 5*time.Second)
+}
+
 // TODO: add integration test
+
+func TestDisableUnavailableCollectors(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{
+		SlurmDiagEnabled:          true,
+		SlurmDiagOverride:         generateRandString(16),
+		SlurmLicEnabled:           true,
+		SlurmLicenseOverride:      "ls",
+		SkipUnavailableCollectors: true,
+	})
+	assert.NoError(err)
+	assert.True(config.cliOpts.skipUnavailable)
+	config.cliOpts.disableUnavailableCollectors()
+	assert.False(config.cliOpts.diagsEnabled)
+	assert.True(config.cliOpts.licEnabled)
+	assert.NotContains(config.cliOpts.debugCommands(), "sdiag")
+}
+
+// canned output doesn't need the slurm cmds installed
+func TestDisableUnavailableCollectors_FixtureDir(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{
+		SlurmDiagEnabled:  true,
+		SlurmDiagOverride: generateRandString(16),
+		SlurmFixtureDir:   t.TempDir(),
+	})
+	assert.NoError(err)
+	config.cliOpts.disableUnavailableCollectors()
+	assert.True(config.cliOpts.diagsEnabled)
+}
+
+func TestNewConfig_SlurmTimeZone(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmTimeZone: "Europe/Paris"})
+	assert.NoError(err)
+	assert.Equal("Europe/Paris", config.cliOpts.slurmTimeZone.String())
+	config, err = NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Nil(config.cliOpts.slurmTimeZone)
+	_, err = NewConfig(&CliFlags{SlurmTimeZone: "Mars/Olympus_Mons"})
+	assert.Error(err)
+}
+
+func TestNewConfig_PollLimitEnvInvalid(t *testing.T) {
+	assert := assert.New(t)
+	for _, limit := range []string{"-1", "ten", "NaN", "Inf", ""} {
+		t.Setenv("POLL_LIMIT", limit)
+		_, err := NewConfig(new(CliFlags))
+		assert.Error(err, limit)
+	}
+	t.Setenv("POLL_LIMIT", "0")
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal(0., config.PollLimit)
+}
+
+func TestNewConfig_LogLevelEnvInvalid(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("LOGLEVEL", "verbose")
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal(slog.LevelInfo, config.LogLevel)
+	t.Setenv("LOGLEVEL", "error")
+	config, err = NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Equal(slog.LevelError, config.LogLevel)
+}
+
+func TestNewConfig_LogLevelFlagInvalid(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{LogLevel: "verbose"})
+	assert.NoError(err)
+	assert.Equal(slog.LevelInfo, config.LogLevel)
+	// an invalid flag keeps the level from env
+	t.Setenv("LOGLEVEL", "warn")
+	config, err = NewConfig(&CliFlags{LogLevel: "verbose"})
+	assert.NoError(err)
+	assert.Equal(slog.LevelWarn, config.LogLevel)
+	config, err = NewConfig(&CliFlags{LogLevel: "debug"})
+	assert.NoError(err)
+	assert.Equal(slog.LevelDebug, config.LogLevel)
+}
+
+func TestNewConfig_ShellOverride(t *testing.T) {
+	assert := assert.New(t)
+	override := `sh:sinfo -h -o '%P|%a' | grep -v debug`
+	config, err := NewConfig(&CliFlags{SlurmPartitionOverride: override, SlurmBinDir: "/opt/slurm/bin"})
+	assert.NoError(err)
+	// bin dir isn't applied inside the pipeline
+	assert.Equal([]string{"/bin/sh", "-c", `sinfo -h -o '%P|%a' | grep -v debug`}, config.cliOpts.sinfoPartition)
+	assert.Equal("sinfo", commandName(config.cliOpts.sinfoPartition))
+	// without a shell the pipe is just another arg
+	config, err = NewConfig(&CliFlags{SlurmPartitionOverride: "sinfo -h | grep gpu"})
+	assert.NoError(err)
+	assert.Equal([]string{"sinfo", "-h", "|", "grep", "gpu"}, config.cliOpts.sinfoPartition)
+	config, err = NewConfig(&CliFlags{SlurmPartitionOverride: "sinfo -h | grep -c cs", ShellCommands: true})
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/sh", "-c", "sinfo -h | grep -c cs"}, config.cliOpts.sinfoPartition)
+}
+
+func TestNewConfig_TraceOutput(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(err)
+	assert.Empty(config.TraceConf.outputDir)
+	assert.Equal(defaultTraceOutputMaxFiles, config.TraceConf.outputMaxFiles)
+	dir := filepath.Join(t.TempDir(), "traces")
+	// the dir is left alone unless tracing is enabled
+	config, err = NewConfig(&CliFlags{TraceOutputDir: dir})
+	assert.NoError(err)
+	assert.Empty(config.TraceConf.outputDir)
+	assert.NoDirExists(dir)
+	config, err = NewConfig(&CliFlags{TraceEnabled: true, TraceOutputDir: dir, TraceOutputMaxFiles: 5})
+	assert.NoError(err)
+	assert.Equal(dir, config.TraceConf.outputDir)
+	assert.Equal(5, config.TraceConf.outputMaxFiles)
+	assert.DirExists(dir)
+	_, err = NewConfig(&CliFlags{TraceOutputMaxFiles: -1})
+	assert.Error(err)
+}
+
+func TestNewConfig_CollectorTimeouts(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmGpusEnabled: true, SlurmGpuPending: true, CollectorTimeouts: "gpu=1m, job=20s"})
+	assert.NoError(err)
+	cliTimeout := func(scraper SlurmByteScraper) time.Duration {
+		return scraper.(*ResponseSizeScraper).scraper.(*CliScraper).timeout
+	}
+	gpuFetcher := NewGpuCollector(config).fetcher.(*GpuJsonFetcher)
+	assert.Equal(time.Minute, cliTimeout(gpuFetcher.sacctScraper))
+	assert.Equal(time.Minute, cliTimeout(gpuFetcher.pendingScraper))
+	assert.Equal(20*time.Second, cliTimeout(config.TraceConf.sharedFetcher.(*JobJsonFetcher).scraper))
+	// collectors without a timeout keep CLI_TIMEOUT
+	assert.Equal(10*time.Second, cliTimeout(config.cliOpts.scraper("sdiag", config.cliOpts.sdiag)))
+	for _, timeouts := range []string{"gpu", "scheduler=1m", "gpu=fast", "gpu=-1s", "gpu=0s"} {
+		_, err := NewConfig(&CliFlags{CollectorTimeouts: timeouts})
+		assert.Error(err, timeouts)
+	}
+}
@@ -6,14 +6,16 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os"
+	"sync"
 	"time"
 )
 
 type MockFetchErrored struct{}
 
-func (f *MockFetchErrored) FetchRawBytes() ([]byte, error) {
+func (f *MockFetchErrored) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	return nil, errors.New("mock fetch error")
 }
 
@@ -29,7 +31,7 @@ type MockScraper struct {
 	CallCount int
 }
 
-func (f *MockScraper) FetchRawBytes() ([]byte, error) {
+func (f *MockScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	defer func(t time.Time) {
 		f.duration = time.Since(t)
 	}(time.Now())
@@ -61,7 +63,7 @@ type StringByteScraper struct {
 	Callcount int
 }
 
-func (es *StringByteScraper) FetchRawBytes() ([]byte, error) {
+func (es *StringByteScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	es.Callcount++
 	return []byte(es.msg), nil
 }
@@ -69,3 +71,59 @@ func (es *StringByteScraper) FetchRawBytes() ([]byte, error) {
 func (es *StringByteScraper) Duration() time.Duration {
 	return time.Duration(1)
 }
+
+// implements SlurmByteScraper by sleeping for delay before returning msg, or err when set
+// used exclusively for testing
+type SlowScraper struct {
+	delay    time.Duration
+	msg      string
+	err      error
+	duration time.Duration
+}
+
+func (ss *SlowScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	defer func(t time.Time) {
+		ss.duration = time.Since(t)
+	}(time.Now())
+	time.Sleep(ss.delay)
+	if ss.err != nil {
+		return nil, ss.err
+	}
+	return []byte(ss.msg), nil
+}
+
+func (ss *SlowScraper) Duration() time.Duration {
+	return ss.duration
+}
+
+// implements SlurmByteScraper by blocking until unblock is closed while
+// tracking the high watermark of concurrent fetches
+// used exclusively for testing
+type BlockingScraper struct {
+	sync.Mutex
+	unblock     chan struct{}
+	inFlight    int
+	MaxInFlight int
+}
+
+func (bs *BlockingScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	bs.Lock()
+	bs.inFlight++
+	bs.MaxInFlight = max(bs.MaxInFlight, bs.inFlight)
+	bs.Unlock()
+	<-bs.unblock
+	bs.Lock()
+	bs.inFlight--
+	bs.Unlock()
+	return []byte{}, nil
+}
+
+func (bs *BlockingScraper) Duration() time.Duration {
+	return time.Duration(1)
+}
+
+func (bs *BlockingScraper) maxInFlight() int {
+	bs.Lock()
+	defer bs.Unlock()
+	return bs.MaxInFlight
+}
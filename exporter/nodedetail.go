@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unix time given as a plain int or, depending on the data_parser, {"set": true, "infinite": false, "number": 1700000000}.
+// Unset or infinite times parse as 0
+type OptionalUnixTime int64
+
+func (out *OptionalUnixTime) UnmarshalJSON(data []byte) error {
+	var nativeInt int64
+	if err := json.Unmarshal(data, &nativeInt); err == nil {
+		*out = OptionalUnixTime(nativeInt)
+		return nil
+	}
+	var numStruct struct {
+		Set      bool  `json:"set"`
+		Infinite bool  `json:"infinite"`
+		Number   int64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &numStruct); err != nil {
+		return err
+	}
+	if !numStruct.Set || numStruct.Infinite {
+		*out = 0
+		return nil
+	}
+	*out = OptionalUnixTime(numStruct.Number)
+	return nil
+}
+
+// node details only reported by scontrol, not sinfo
+type NodeDetailMetric struct {
+	Hostname        string           `json:"hostname"`
+	BootTime        OptionalUnixTime `json:"boot_time"`
+	SlurmdStartTime OptionalUnixTime `json:"slurmd_start_time"`
+}
+
+type scontrolNodeResponse struct {
+	Errors []string           `json:"errors"`
+	Nodes  []NodeDetailMetric `json:"nodes"`
+}
+
+type NodeDetailFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[NodeDetailMetric]
+}
+
+func (ndf *NodeDetailFetcher) fetch(ctx context.Context) ([]NodeDetailMetric, error) {
+	cliJson, err := ndf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		ndf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
+	}
+	resp := new(scontrolNodeResponse)
+	if err := unmarshalCliJson(cliJson, resp); err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling node detail metrics %q", err))
+		ndf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		recordApiErrors("scontrol", resp.Errors)
+		for _, e := range resp.Errors {
+			slog.Error(fmt.Sprintf("scontrol API error response: %q", e))
+		}
+		ndf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(resp.Errors)))
+		return nil, errors.New(resp.Errors[0])
+	}
+	return resp.Nodes, nil
+}
+
+func (ndf *NodeDetailFetcher) FetchMetrics(ctx context.Context) ([]NodeDetailMetric, error) {
+	return ndf.cache.FetchOrThrottle(ctx, ndf.fetch)
+}
+
+func (ndf *NodeDetailFetcher) ScrapeError() *prometheus.CounterVec {
+	return ndf.errorCounter
+}
+
+func (ndf *NodeDetailFetcher) ScrapeDuration() time.Duration {
+	return ndf.scraper.Duration()
+}
+
+// boot and slurmd start times per node, i.e to follow rolling reboots.
+// Nodes without a known time, like a down node that never registered, don't report it
+type NodeDetailCollector struct {
+	fetcher              SlurmMetricFetcher[NodeDetailMetric]
+	bootTime             *prometheus.Desc
+	slurmdStartTime      *prometheus.Desc
+	nodeSuffixes         NodeSuffixes
+	detailScrapeDuration *prometheus.Desc
+	detailScrapeError    *prometheus.CounterVec
+}
+
+func NewNodeDetailCollector(config *Config) *NodeDetailCollector {
+	cliOpts := config.cliOpts
+	fetcher := &NodeDetailFetcher{
+		scraper: cliOpts.scraper("scontrol_node", cliOpts.nodeDetail),
+		cache:   newCollectorCache[NodeDetailMetric](config.PollLimit, "node_detail"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_node_detail_scrape_error",
+			Help: "slurm node detail scrape error",
+		}),
+	}
+	return &NodeDetailCollector{
+		fetcher:              fetcher,
+		bootTime:             config.NewDesc("slurm_node_boot_timestamp_seconds", "unix time the node booted", []string{"node"}, nil),
+		slurmdStartTime:      config.NewDesc("slurm_node_slurmd_start_timestamp_seconds", "unix time slurmd started on the node", []string{"node"}, nil),
+		nodeSuffixes:         cliOpts.nodeSuffixes,
+		detailScrapeDuration: config.NewDesc("slurm_node_detail_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.nodeDetail), nil, nil),
+		detailScrapeError:    fetcher.ScrapeError(),
+	}
+}
+
+func (ndc *NodeDetailCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ndc.bootTime
+	ch <- ndc.slurmdStartTime
+	ch <- ndc.detailScrapeDuration
+	ndc.detailScrapeError.Describe(ch)
+}
+
+func (ndc *NodeDetailCollector) Collect(ch chan<- prometheus.Metric) {
+	ndc.CollectContext(context.Background(), ch)
+}
+
+func (ndc *NodeDetailCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer ndc.detailScrapeError.Collect(ch)
+	nodes, err := ndc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(ndc.detailScrapeDuration, prometheus.GaugeValue, float64(ndc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("node detail fetch error %q", err))
+		return
+	}
+	hostnames := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		hostnames = append(hostnames, node.Hostname)
+	}
+	nodeLabels := ndc.nodeSuffixes.Labels(hostnames)
+	for _, node := range nodes {
+		hostname := nodeLabels[node.Hostname]
+		if node.BootTime > 0 {
+			ch <- prometheus.MustNewConstMetric(ndc.bootTime, prometheus.GaugeValue, float64(node.BootTime), hostname)
+		}
+		if node.SlurmdStartTime > 0 {
+			ch <- prometheus.MustNewConstMetric(ndc.slurmdStartTime, prometheus.GaugeValue, float64(node.SlurmdStartTime), hostname)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionalUnixTimeJson(t *testing.T) {
+	assert := assert.New(t)
+	var ts OptionalUnixTime
+	assert.NoError(json.Unmarshal([]byte(`1739800000`), &ts))
+	assert.Equal(OptionalUnixTime(1739800000), ts)
+	assert.NoError(json.Unmarshal([]byte(`{"set": true, "infinite": false, "number": 1739800120}`), &ts))
+	assert.Equal(OptionalUnixTime(1739800120), ts)
+	assert.NoError(json.Unmarshal([]byte(`{"set": false, "infinite": false, "number": 0}`), &ts))
+	assert.Zero(ts)
+	assert.NoError(json.Unmarshal([]byte(`{"set": true, "infinite": true, "number": 0}`), &ts))
+	assert.Zero(ts)
+	assert.Error(json.Unmarshal([]byte(`"N/A"`), &ts))
+}
+
+func TestNodeDetailFetch_ApiError(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeDetailFetcher{
+		scraper:      &StringByteScraper{msg: `{"nodes": [], "errors": ["Unable to contact slurm controller"]}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[NodeDetailMetric](10),
+	}
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestNodeDetailCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{NodeDetailEnabled: true})
+	assert.NoError(err)
+	assert.Equal([]string{"scontrol", "show", "node", "--json"}, config.cliOpts.debugCommands()["scontrol_node"])
+	ndc := NewNodeDetailCollector(config)
+	ndc.fetcher = &NodeDetailFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_node.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[NodeDetailMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		ndc.Collect(ch)
+		close(ch)
+	}()
+	boot := make(map[string]float64)
+	slurmdStart := make(map[string]float64)
+	for metric := range ch {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		switch metric.Desc() {
+		case ndc.bootTime:
+			boot[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+		case ndc.slurmdStartTime:
+			slurmdStart[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+		}
+	}
+	// cs03 never registered, so it has neither time
+	assert.Equal(map[string]float64{"cs01": 1739800000, "cs02": 1739900000}, boot)
+	assert.Equal(map[string]float64{"cs01": 1739800120, "cs02": 1739910000}, slurmdStart)
+	assert.Zero(CollectCounterValue(ndc.detailScrapeError))
+}
+
+func TestNodeDetailCollector_StripSuffix(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{NodeDetailEnabled: true, NodeLabelStripSuffix: ".cluster.internal"})
+	assert.NoError(err)
+	ndc := NewNodeDetailCollector(config)
+	ndc.fetcher = &NodeDetailFetcher{
+		scraper:      &StringByteScraper{msg: `{"nodes": [{"hostname": "gpu01.cluster.internal", "boot_time": 1739800000}], "errors": []}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[NodeDetailMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		ndc.Collect(ch)
+		close(ch)
+	}()
+	var nodes []string
+	for metric := range ch {
+		if metric.Desc() != ndc.bootTime {
+			continue
+		}
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		nodes = append(nodes, dtoMetric.GetLabel()[0].GetValue())
+	}
+	assert.Equal([]string{"gpu01"}, nodes)
+}
@@ -6,10 +6,12 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -21,17 +23,126 @@ import (
 )
 
 type NodeMetric struct {
-	AllocMemory float64  `json:"alloc_memory"`
-	AllocCpus   float64  `json:"alloc_cpus"`
-	Cpus        float64  `json:"cpus"`
-	CpuLoad     float64  `json:"cpu_load"`
-	FreeMemory  float64  `json:"free_memory"`
-	Hostname    string   `json:"hostname"`
-	IdleCpus    float64  `json:"idle_cpus"`
-	Partitions  []string `json:"partitions"`
-	RealMemory  float64  `json:"real_memory"`
-	State       string   `json:"state"`
-	Weight      float64  `json:"weight"`
+	AllocMemory float64 `json:"alloc_memory"`
+	AllocCpus   float64 `json:"alloc_cpus"`
+	Cpus        float64 `json:"cpus"`
+	CpuLoad     float64 `json:"cpu_load"`
+	FreeMemory  float64 `json:"free_memory"`
+	Hostname    string  `json:"hostname"`
+	IdleCpus    float64 `json:"idle_cpus"`
+	// available and active features. The fallback only reports the available ones
+	Features       NodeFeatures `json:"features"`
+	ActiveFeatures NodeFeatures `json:"active_features"`
+	// cpus on down or drained nodes. Not reported by the json api, derived from the rest
+	OtherCpus  float64  `json:"-"`
+	Partitions []string `json:"partitions"`
+	RealMemory float64  `json:"real_memory"`
+	// why the node was drained or marked down. Empty for healthy nodes
+	Reason string  `json:"reason"`
+	State  string  `json:"state"`
+	Weight float64 `json:"weight"`
+	// flags reported next to the base state i.e NOT_RESPONDING. The fallback derives them from the compact state
+	StateFlags []string `json:"state_flags"`
+}
+
+const (
+	nodeFlagNotResponding = "NOT_RESPONDING"
+	nodeFlagInvalidReg    = "INVALID_REG"
+)
+
+// flags encoded in a compact sinfo state. A trailing * marks a node that isn't responding,
+// whatever its base state (idle*, alloc*), while inval replaces the base state entirely
+func parseCompactStateFlags(state string) []string {
+	var flags []string
+	state = strings.ToLower(state)
+	if strings.HasSuffix(state, "*") {
+		flags = append(flags, nodeFlagNotResponding)
+	}
+	if strings.HasPrefix(state, "inval") {
+		flags = append(flags, nodeFlagInvalidReg)
+	}
+	return flags
+}
+
+func (nm *NodeMetric) HasStateFlag(flag string) bool {
+	return slices.Contains(nm.StateFlags, flag)
+}
+
+// down, drained, draining or failing nodes. Fallback states are compact (drng, down*)
+// and joined by & when a node has several
+func (nm *NodeMetric) Unavailable() bool {
+	for _, state := range strings.Split(strings.ToLower(nm.State), "&") {
+		for _, prefix := range []string{"down", "drain", "drng", "fail"} {
+			if strings.HasPrefix(state, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// node features, reported as a comma separated string by older slurm versions and as a list by newer ones
+type NodeFeatures []string
+
+func (nf *NodeFeatures) UnmarshalJSON(data []byte) error {
+	var features []string
+	if err := json.Unmarshal(data, &features); err == nil {
+		*nf = features
+		return nil
+	}
+	var fString string
+	if err := json.Unmarshal(data, &fString); err != nil {
+		return err
+	}
+	*nf = parseNodeFeatures(fString)
+	return nil
+}
+
+// splits a comma separated feature list, dropping empty entries and the "(null)" placeholder
+func parseNodeFeatures(features string) NodeFeatures {
+	var parsed NodeFeatures
+	for _, feature := range strings.Split(features, ",") {
+		feature = strings.TrimSpace(feature)
+		if feature != "" && feature != "(null)" {
+			parsed = append(parsed, feature)
+		}
+	}
+	return parsed
+}
+
+// number of nodes advertising each feature. A node counts once per feature, whether available or active.
+// An empty allowlist counts every feature
+func countNodeFeatures(nodes []NodeMetric, allowlist []string) map[string]float64 {
+	counts := make(map[string]float64)
+	for _, node := range nodes {
+		seen := make(map[string]bool)
+		for _, feature := range slices.Concat(node.Features, node.ActiveFeatures) {
+			if seen[feature] || (len(allowlist) > 0 && !slices.Contains(allowlist, feature)) {
+				continue
+			}
+			seen[feature] = true
+			counts[feature]++
+		}
+	}
+	return counts
+}
+
+const maxNodeReasonLen = 64
+
+// bounds the reason label. Drops the [user@timestamp] suffix slurm appends,
+// collapses whitespace and truncates. Nodes without a reason are labeled "none"
+func normalizeNodeReason(reason string) string {
+	if idx := strings.LastIndex(reason, " ["); idx > 0 && strings.HasSuffix(reason, "]") {
+		reason = reason[:idx]
+	}
+	reason = strings.Join(strings.Fields(reason), " ")
+	if reason == "" {
+		return "none"
+	}
+	if runes := []rune(reason); len(runes) > maxNodeReasonLen {
+		reason = string(runes[:maxNodeReasonLen])
+	}
+	return reason
 }
 
 type sinfoResponse struct {
@@ -51,35 +162,42 @@ type sinfoResponse struct {
 
 type NodeJsonFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[NodeMetric]
 }
 
-func (cmf *NodeJsonFetcher) fetch() ([]NodeMetric, error) {
+func (cmf *NodeJsonFetcher) fetch(ctx context.Context) ([]NodeMetric, error) {
 	squeue := new(sinfoResponse)
-	cliJson, err := cmf.scraper.FetchRawBytes()
+	cliJson, err := cmf.scraper.FetchRawBytes(ctx)
 	if err != nil {
+		cmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		return nil, err
 	}
-	if err := json.Unmarshal(cliJson, squeue); err != nil {
+	if err := unmarshalCliJson(cliJson, squeue); err != nil {
 		slog.Error(fmt.Sprintf("Unmarshaling node metrics %q", err))
+		cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		return nil, err
 	}
 	if len(squeue.Errors) > 0 {
+		recordApiErrors("sinfo", squeue.Errors)
 		for _, e := range squeue.Errors {
 			slog.Error(fmt.Sprintf("Api error response %q", e))
 		}
-		cmf.errorCounter.Add(float64(len(squeue.Errors)))
+		cmf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(squeue.Errors)))
 		return nil, errors.New(squeue.Errors[0])
 	}
+	for i := range squeue.Nodes {
+		node := &squeue.Nodes[i]
+		node.OtherCpus = math.Max(0, node.Cpus-node.AllocCpus-node.IdleCpus)
+	}
 	return squeue.Nodes, nil
 }
 
-func (cmf *NodeJsonFetcher) FetchMetrics() ([]NodeMetric, error) {
-	return cmf.cache.FetchOrThrottle(cmf.fetch)
+func (cmf *NodeJsonFetcher) FetchMetrics(ctx context.Context) ([]NodeMetric, error) {
+	return cmf.cache.FetchOrThrottle(ctx, cmf.fetch)
 }
 
-func (cmf *NodeJsonFetcher) ScrapeError() prometheus.Counter {
+func (cmf *NodeJsonFetcher) ScrapeError() *prometheus.CounterVec {
 	return cmf.errorCounter
 }
 
@@ -111,36 +229,145 @@ func (naf *NAbleFloat) FromString(data string) error {
 	return naf.UnmarshalJSON([]byte(`"` + post + `"`))
 }
 
+// csv header of the fallback sinfo output:
+// StateCompact,Memory,NodeHost,CPUsLoad,Partition,FreeMem,CPUsState,Weight,AllocMem,Gres,Reason,Features
+// The column order is only assumed when sinfo runs with -h, otherwise it's read from the header line
+type sinfoCsvHeader int
+
+const (
+	sinfoState sinfoCsvHeader = iota
+	sinfoRealMemory
+	sinfoNodeHost
+	sinfoCPUsLoad
+	sinfoPartition
+	sinfoFreeMem
+	sinfoCPUsState
+	sinfoWeight
+	sinfoAllocMem
+	// optional, only parsed by the GPU collector when it shares the node sinfo output
+	sinfoGres
+	// optional, drain/down reason
+	sinfoReason
+	// optional, comma separated available features
+	sinfoFeatures
+	// delimits the end of the record
+	sinfoCsvSTOP
+)
+
+// titles sinfo prints for each -O field when run without -h
+var sinfoColumnTitles = map[string]sinfoCsvHeader{
+	"STATE":          sinfoState,
+	"MEMORY":         sinfoRealMemory,
+	"HOSTNAMES":      sinfoNodeHost,
+	"CPU_LOAD":       sinfoCPUsLoad,
+	"PARTITION":      sinfoPartition,
+	"FREE_MEM":       sinfoFreeMem,
+	"CPUS(A/I/O/T)":  sinfoCPUsState,
+	"WEIGHT":         sinfoWeight,
+	"ALLOCMEM":       sinfoAllocMem,
+	"GRES":           sinfoGres,
+	"REASON":         sinfoReason,
+	"AVAIL_FEATURES": sinfoFeatures,
+}
+
+// index of each field within a fallback sinfo record
+type sinfoColumns map[sinfoCsvHeader]int
+
+// headerless output is assumed to follow the sinfoCsvHeader order
+func defaultSinfoColumns() sinfoColumns {
+	cols := make(sinfoColumns)
+	for col := sinfoState; col < sinfoCsvSTOP; col++ {
+		cols[col] = int(col)
+	}
+	return cols
+}
+
+// maps the titles of a header record to their index. Unknown titles are ignored so operators
+// can reorder or add -O fields. Returns false when the record isn't a header
+func parseSinfoHeader(record []string) (sinfoColumns, bool) {
+	cols := make(sinfoColumns)
+	for idx, title := range record {
+		if col, ok := sinfoColumnTitles[strings.TrimSpace(title)]; ok {
+			cols[col] = idx
+		}
+	}
+	_, hasHost := cols[sinfoNodeHost]
+	_, hasGres := cols[sinfoGres]
+	return cols, hasHost || hasGres
+}
+
+// trimmed field of the record, false when the column is absent
+func (sc sinfoColumns) field(record []string, col sinfoCsvHeader) (string, bool) {
+	idx, ok := sc[col]
+	if !ok || idx >= len(record) {
+		return "", false
+	}
+	return strings.TrimSpace(record[idx]), true
+}
+
+// reorders the record into the sinfoCsvHeader order. Missing optional columns are left empty,
+// returns false when any of the columns up to sinfoAllocMem is missing
+func (sc sinfoColumns) normalize(record []string) ([]string, bool) {
+	normalized := make([]string, sinfoCsvSTOP)
+	for col := sinfoState; col < sinfoCsvSTOP; col++ {
+		val, ok := sc.field(record, col)
+		if !ok && col < sinfoGres {
+			return nil, false
+		}
+		normalized[col] = val
+	}
+	return normalized, true
+}
+
+// sinfo CPUsState field, formatted as alloc/idle/other/total
+type CpuState struct {
+	Alloc float64
+	Idle  float64
+	Other float64
+	Total float64
+}
+
+// nodes without a reported cpu state i.e "N/A" parse as all zeros
+func parseCpuState(cpuState string) (*CpuState, error) {
+	if cpuState == "N/A" {
+		return new(CpuState), nil
+	}
+	cpuStates := strings.Split(cpuState, "/")
+	if len(cpuStates) != 4 {
+		return nil, fmt.Errorf("unexpected cpu state format. Got %s", cpuState)
+	}
+	var vals [4]NAbleFloat
+	for i, state := range cpuStates {
+		if err := vals[i].FromString(state); err != nil {
+			return nil, fmt.Errorf("failed to parse cpu state %s with err: %q", cpuState, err)
+		}
+	}
+	return &CpuState{
+		Alloc: float64(vals[0]),
+		Idle:  float64(vals[1]),
+		Other: float64(vals[2]),
+		Total: float64(vals[3]),
+	}, nil
+}
+
+func (cs *CpuState) Valid() bool {
+	return cs.Alloc+cs.Idle+cs.Other == cs.Total
+}
+
 type NodeCliFallbackFetcher struct {
 	scraper      SlurmByteScraper
-	errorCounter prometheus.Counter
+	errorCounter *prometheus.CounterVec
 	cache        *AtomicThrottledCache[NodeMetric]
 }
 
-func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
-	sinfo, err := cmf.scraper.FetchRawBytes()
+func (cmf *NodeCliFallbackFetcher) fetch(ctx context.Context) ([]NodeMetric, error) {
+	sinfo, err := cmf.scraper.FetchRawBytes(ctx)
 	if err != nil {
-		cmf.errorCounter.Inc()
+		cmf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
 		return nil, err
 	}
 	sinfo = bytes.Trim(sinfo, " \n")
-	// parse csv from the following CSV format: "StateCompact,Memory,NodeHost,CPUsLoad,Partition,FreeMem,CPUsState,Weight,AllocMem"
 	nodeMetrics := make(map[string]*NodeMetric, 0)
-	type CsvHeader int
-	// csv header: StateCompact,Memory,NodeHost,CPUsLoad,Partition,FreeMem,CPUsState,Weight,AllocMem
-	const (
-		State CsvHeader = iota
-		RealMemory
-		NodeHost
-		CPUsLoad
-		Partition
-		FreeMem
-		CPUsState
-		Weight
-		AllocMem
-		// delimits the end of the record
-		CsvSTOP
-	)
 
 	type CliNodeMetric struct {
 		Hostname    string     `json:"n"`
@@ -156,88 +383,80 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 	csvReader := csv.NewReader(bytes.NewReader(sinfo))
 	csvReader.Comma = '|'
 	csvReader.TrimLeadingSpace = true
-	csvReader.FieldsPerRecord = 9
+	csvReader.FieldsPerRecord = -1
+	// free form reasons may contain quotes
+	csvReader.LazyQuotes = true
 
 	allRecords, err := csvReader.ReadAll()
 	if err != nil {
+		cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		return nil, fmt.Errorf("node cli buffer error %q", err)
 	}
 
-	for _, records := range allRecords {
-		if len(records) != int(CsvSTOP) {
-			slog.Error(fmt.Sprintf("node fallback cli record length expectation unmet. Expected %d fields, got %+v", int(CsvSTOP), records))
-			cmf.errorCounter.Inc()
-			continue
+	cols := defaultSinfoColumns()
+	if len(allRecords) > 0 {
+		if headerCols, ok := parseSinfoHeader(allRecords[0]); ok {
+			cols = headerCols
+			allRecords = allRecords[1:]
 		}
-		// santize the records to avoid trailing whitespace
-		for idx, record := range records {
-			records[idx] = strings.TrimSpace(record)
+	}
+
+	for _, record := range allRecords {
+		records, ok := cols.normalize(record)
+		if !ok {
+			slog.Error(fmt.Sprintf("node fallback cli record is missing fields. Expected at least %d fields, got %+v", int(sinfoGres), record))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+			continue
 		}
 		metric := new(CliNodeMetric)
-		metric.Hostname = records[NodeHost]
+		metric.Hostname = records[sinfoNodeHost]
 		// convert mem units from MB to Bytes
-		if realMem, err := strconv.ParseFloat(records[RealMemory], 64); err == nil {
+		if realMem, err := strconv.ParseFloat(records[sinfoRealMemory], 64); err == nil {
 			metric.RealMemory = realMem * 1e6
 		} else {
-			slog.Error(fmt.Sprintf("failed to parse real memory string %s with err: %q", records[RealMemory], err))
-			cmf.errorCounter.Inc()
+			slog.Error(fmt.Sprintf("failed to parse real memory string %s with err: %q", records[sinfoRealMemory], err))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
-		if err := metric.FreeMemory.FromString(records[FreeMem]); err == nil {
+		if err := metric.FreeMemory.FromString(records[sinfoFreeMem]); err == nil {
 			metric.FreeMemory *= 1e6
 		} else {
-			slog.Error(fmt.Sprintf("failed to parse free memory string %s with err: %q", records[FreeMem], err))
-			cmf.errorCounter.Inc()
+			slog.Error(fmt.Sprintf("failed to parse free memory string %s with err: %q", records[sinfoFreeMem], err))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
-		if err := metric.AllocMemory.FromString(records[AllocMem]); err == nil {
+		if err := metric.AllocMemory.FromString(records[sinfoAllocMem]); err == nil {
 			metric.AllocMemory *= 1e6
 		} else {
-			slog.Error(fmt.Sprintf("failed to parse alloc memory string %s with err: %q", records[AllocMem], err))
-			cmf.errorCounter.Inc()
+			slog.Error(fmt.Sprintf("failed to parse alloc memory string %s with err: %q", records[sinfoAllocMem], err))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
-		metric.CpuState = records[CPUsState]
-		metric.Partition = records[Partition]
-		if err := metric.CpuLoad.FromString(records[CPUsLoad]); err != nil {
-			cmf.errorCounter.Inc()
+		metric.CpuState = records[sinfoCPUsState]
+		metric.Partition = records[sinfoPartition]
+		if err := metric.CpuLoad.FromString(records[sinfoCPUsLoad]); err != nil {
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
-		metric.State = records[State]
-		if weight, err := strconv.ParseFloat(records[Weight], 64); err == nil {
+		metric.State = records[sinfoState]
+		if weight, err := strconv.ParseFloat(records[sinfoWeight], 64); err == nil {
 			metric.Weight = weight
 		} else {
-			slog.Error(fmt.Sprintf("failed to parse weight string %s with err: %q", records[Weight], err))
-			cmf.errorCounter.Inc()
+			slog.Error(fmt.Sprintf("failed to parse weight string %s with err: %q", records[sinfoWeight], err))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
 
-		cpuStates := strings.Split(metric.CpuState, "/")
-		if len(cpuStates) != 4 {
-			cmf.errorCounter.Inc()
-			return nil, fmt.Errorf("unexpected cpu state format. Got %s", metric.CpuState)
-		}
-		allocated, err := strconv.ParseFloat(cpuStates[0], 64)
-		if err != nil {
-			cmf.errorCounter.Inc()
-			return nil, err
-		}
-		idle, err := strconv.ParseFloat(cpuStates[1], 64)
-		if err != nil {
-			cmf.errorCounter.Inc()
-			return nil, err
-		}
-		other, err := strconv.ParseFloat(cpuStates[2], 64)
+		cpuState, err := parseCpuState(metric.CpuState)
 		if err != nil {
-			cmf.errorCounter.Inc()
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 			return nil, err
 		}
-		total, err := strconv.ParseFloat(cpuStates[3], 64)
-		if err != nil {
-			cmf.errorCounter.Inc()
-			return nil, err
+		if !cpuState.Valid() {
+			// likely a parse bug or a change in the sinfo format, keep the node but flag it
+			slog.Error(fmt.Sprintf("cpu state %s for node %s doesn't sum to total", metric.CpuState, metric.Hostname))
+			cmf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
 		}
-		_ = other
 		if nodeMetric, ok := nodeMetrics[metric.Hostname]; ok {
 			nodeMetric.Partitions = append(nodeMetric.Partitions, metric.Partition)
 			states := strings.Split(nodeMetric.State, "&")
@@ -245,20 +464,29 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 				// nodes can have multiple states. Our query puts them on separate lines
 				nodeMetric.State += "&" + metric.State
 			}
+			for _, flag := range parseCompactStateFlags(metric.State) {
+				if !nodeMetric.HasStateFlag(flag) {
+					nodeMetric.StateFlags = append(nodeMetric.StateFlags, flag)
+				}
+			}
 		} else {
 			nodeMetrics[metric.Hostname] = &NodeMetric{
 				Hostname:    metric.Hostname,
-				Cpus:        total,
+				Cpus:        cpuState.Total,
 				RealMemory:  metric.RealMemory,
 				FreeMemory:  float64(metric.FreeMemory),
 				Partitions:  []string{metric.Partition},
 				State:       metric.State,
 				AllocMemory: float64(metric.AllocMemory),
-				AllocCpus:   allocated,
-				IdleCpus:    idle,
+				AllocCpus:   cpuState.Alloc,
+				IdleCpus:    cpuState.Idle,
+				OtherCpus:   cpuState.Other,
 				Weight:      metric.Weight,
 				CpuLoad:     float64(metric.CpuLoad),
 			}
+			nodeMetrics[metric.Hostname].Reason = records[sinfoReason]
+			nodeMetrics[metric.Hostname].Features = parseNodeFeatures(records[sinfoFeatures])
+			nodeMetrics[metric.Hostname].StateFlags = parseCompactStateFlags(metric.State)
 		}
 	}
 	var nodeValues []NodeMetric
@@ -268,8 +496,8 @@ func (cmf *NodeCliFallbackFetcher) fetch() ([]NodeMetric, error) {
 	return nodeValues, nil
 }
 
-func (cmf *NodeCliFallbackFetcher) FetchMetrics() ([]NodeMetric, error) {
-	return cmf.cache.FetchOrThrottle(cmf.fetch)
+func (cmf *NodeCliFallbackFetcher) FetchMetrics(ctx context.Context) ([]NodeMetric, error) {
+	return cmf.cache.FetchOrThrottle(ctx, cmf.fetch)
 }
 
 type PartitionMetric struct {
@@ -284,6 +512,26 @@ type PartitionMetric struct {
 	Weight           float64
 }
 
+// copy of nodes with the partitions outside the allowlist collapsed into "other". A node is counted once
+// in "other", however many of its partitions collapse into it
+func bucketNodePartitions(nodes []NodeMetric, allowlist LabelAllowlist) []NodeMetric {
+	if len(allowlist) == 0 {
+		return nodes
+	}
+	bucketed := make([]NodeMetric, 0, len(nodes))
+	for _, node := range nodes {
+		partitions := make([]string, 0, len(node.Partitions))
+		for _, p := range node.Partitions {
+			if p = allowlist.Bucket(p); !slices.Contains(partitions, p) {
+				partitions = append(partitions, p)
+			}
+		}
+		node.Partitions = partitions
+		bucketed = append(bucketed, node)
+	}
+	return bucketed
+}
+
 func fetchNodePartitionMetrics(nodes []NodeMetric) map[string]*PartitionMetric {
 	partitions := make(map[string]*PartitionMetric)
 	for _, node := range nodes {
@@ -311,7 +559,7 @@ func fetchNodePartitionMetrics(nodes []NodeMetric) map[string]*PartitionMetric {
 	return partitions
 }
 
-func (cmf *NodeCliFallbackFetcher) ScrapeError() prometheus.Counter {
+func (cmf *NodeCliFallbackFetcher) ScrapeError() *prometheus.CounterVec {
 	return cmf.errorCounter
 }
 
@@ -326,7 +574,9 @@ type PerStateMetric struct {
 
 type CpuSummaryMetric struct {
 	Total    float64
+	Alloc    float64
 	Idle     float64
+	Other    float64
 	Load     float64
 	PerState map[string]*PerStateMetric
 }
@@ -337,7 +587,9 @@ func fetchNodeTotalCpuMetrics(nodes []NodeMetric) *CpuSummaryMetric {
 	}
 	for _, node := range nodes {
 		cpuSummaryMetrics.Total += node.Cpus
+		cpuSummaryMetrics.Alloc += node.AllocCpus
 		cpuSummaryMetrics.Idle += node.IdleCpus
+		cpuSummaryMetrics.Other += node.OtherCpus
 		cpuSummaryMetrics.Load += node.CpuLoad
 		if metric, ok := cpuSummaryMetrics.PerState[node.State]; ok {
 			metric.Cpus += node.Cpus
@@ -368,6 +620,12 @@ func fetchNodeTotalMemMetrics(nodes []NodeMetric) *MemSummaryMetric {
 type NodesCollector struct {
 	// collector state
 	fetcher SlurmMetricFetcher[NodeMetric]
+	// only export these features, all when empty
+	featureAllowlist []string
+	// partitions outside it are labeled "other"
+	partitionAllowlist LabelAllowlist
+	// stripped from node labels
+	nodeSuffixes NodeSuffixes
 	// partition summary metrics
 	partitionCpus        *prometheus.Desc
 	partitionRealMemory  *prometheus.Desc
@@ -381,55 +639,77 @@ type NodesCollector struct {
 	// cpu summary stats
 	cpusPerState      *prometheus.Desc
 	totalCpus         *prometheus.Desc
+	totalAllocCpus    *prometheus.Desc
 	totalIdleCpus     *prometheus.Desc
+	totalOtherCpus    *prometheus.Desc
+	cpuUtilization    *prometheus.Desc
 	totalCpuLoad      *prometheus.Desc
 	nodeCountPerState *prometheus.Desc
+	nodeDown          *prometheus.Desc
+	nodesByFeature    *prometheus.Desc
+	// nodes flagged independently of their base state
+	nodesNotResponding *prometheus.Desc
+	nodesInvalidReg    *prometheus.Desc
 	// memory summary stats
 	totalRealMemory  *prometheus.Desc
 	totalFreeMemory  *prometheus.Desc
 	totalAllocMemory *prometheus.Desc
 	// exporter metrics
 	nodeScrapeDuration *prometheus.Desc
-	nodeScrapeErrors   prometheus.Counter
+	nodeScrapeErrors   *prometheus.CounterVec
 }
 
 func NewNodeCollecter(config *Config) *NodesCollector {
 	cliOpts := config.cliOpts
-	byteScraper := NewCliScraper(cliOpts.sinfo...)
-	errorCounter := prometheus.NewCounter(prometheus.CounterOpts{
+	byteScraper := cliOpts.scraper("sinfo", cliOpts.sinfo)
+	if cliOpts.sharedSinfo != nil {
+		byteScraper = cliOpts.sharedSinfo
+	}
+	errorCounter := config.NewScrapeErrorCounter(prometheus.CounterOpts{
 		Name: "slurm_node_scrape_error",
 		Help: "slurm node info scrape errors",
 	})
 	var fetcher SlurmMetricFetcher[NodeMetric]
 	if cliOpts.fallback {
-		fetcher = &NodeCliFallbackFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: NewAtomicThrottledCache[NodeMetric](config.PollLimit)}
+		fetcher = &NodeCliFallbackFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: newCollectorCache[NodeMetric](config.PollLimit, "node")}
 	} else {
-		fetcher = &NodeJsonFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: NewAtomicThrottledCache[NodeMetric](config.PollLimit)}
+		fetcher = &NodeJsonFetcher{scraper: byteScraper, errorCounter: errorCounter, cache: newCollectorCache[NodeMetric](config.PollLimit, "node")}
 	}
 	return &NodesCollector{
-		fetcher: fetcher,
+		fetcher:          fetcher,
+		featureAllowlist: cliOpts.nodeFeatureAllowlist,
+		// label allowlists
+		partitionAllowlist: cliOpts.partitionAllowlist,
+		nodeSuffixes:       cliOpts.nodeSuffixes,
 		// partition stats
-		partitionCpus:        prometheus.NewDesc("slurm_partition_total_cpus", "Total cpus per partition", []string{"partition"}, nil),
-		partitionRealMemory:  prometheus.NewDesc("slurm_partition_real_mem", "Real mem per partition", []string{"partition"}, nil),
-		partitionFreeMemory:  prometheus.NewDesc("slurm_partition_free_mem", "Free mem per partition", []string{"partition"}, nil),
-		partitionAllocMemory: prometheus.NewDesc("slurm_partition_alloc_mem", "Alloc mem per partition per state", []string{"partition", "state"}, nil),
-		partitionAllocCpus:   prometheus.NewDesc("slurm_partition_alloc_cpus", "Alloc cpus per partition per state", []string{"partition", "state"}, nil),
-		partitionNodeCount:   prometheus.NewDesc("slurm_partition_node_count", "Node count per partition per state", []string{"partition", "state"}, nil),
-		partitionIdleCpus:    prometheus.NewDesc("slurm_partition_idle_cpus", "Idle cpus per partition", []string{"partition"}, nil),
-		partitionWeight:      prometheus.NewDesc("slurm_partition_weight", "Total node weight per partition??", []string{"partition"}, nil),
-		partitionCpuLoad:     prometheus.NewDesc("slurm_partition_cpu_load", "Total cpu load per partition", []string{"partition"}, nil),
+		partitionCpus:        config.NewDesc("slurm_partition_total_cpus", "Total cpus per partition", []string{"partition"}, nil),
+		partitionRealMemory:  config.NewDesc("slurm_partition_real_mem", "Real mem per partition", []string{"partition"}, nil),
+		partitionFreeMemory:  config.NewDesc("slurm_partition_free_mem", "Free mem per partition", []string{"partition"}, nil),
+		partitionAllocMemory: config.NewDesc("slurm_partition_alloc_mem", "Alloc mem per partition per state", []string{"partition", "state"}, nil),
+		partitionAllocCpus:   config.NewDesc("slurm_partition_alloc_cpus", "Alloc cpus per partition per state", []string{"partition", "state"}, nil),
+		partitionNodeCount:   config.NewDesc("slurm_partition_node_count", "Node count per partition per state", []string{"partition", "state"}, nil),
+		partitionIdleCpus:    config.NewDesc("slurm_partition_idle_cpus", "Idle cpus per partition", []string{"partition"}, nil),
+		partitionWeight:      config.NewDesc("slurm_partition_weight", "Total node weight per partition??", []string{"partition"}, nil),
+		partitionCpuLoad:     config.NewDesc("slurm_partition_cpu_load", "Total cpu load per partition", []string{"partition"}, nil),
 		// node cpu summary stats
-		totalCpus:         prometheus.NewDesc("slurm_cpus_total", "Total cpus", nil, nil),
-		totalIdleCpus:     prometheus.NewDesc("slurm_cpus_idle", "Total idle cpus", nil, nil),
-		totalCpuLoad:      prometheus.NewDesc("slurm_cpu_load", "Total cpu load", nil, nil),
-		cpusPerState:      prometheus.NewDesc("slurm_cpus_per_state", "Cpus per state i.e alloc, mixed, draining, etc.", []string{"state"}, nil),
-		nodeCountPerState: prometheus.NewDesc("slurm_node_count_per_state", "nodes per state", []string{"state"}, nil),
+		totalCpus:          config.NewDesc("slurm_cpus_total", "Total cpus", nil, nil),
+		totalAllocCpus:     config.NewDesc("slurm_cpus_alloc", "Total alloc cpus", nil, nil),
+		totalIdleCpus:      config.NewDesc("slurm_cpus_idle", "Total idle cpus", nil, nil),
+		totalOtherCpus:     config.NewDesc("slurm_cpus_other", "Total cpus on down or drained nodes", nil, nil),
+		cpuUtilization:     config.NewDesc("slurm_cpus_utilization", "Total alloc cpus / total cpus", nil, nil),
+		totalCpuLoad:       config.NewDesc("slurm_cpu_load", "Total cpu load", nil, nil),
+		cpusPerState:       config.NewDesc("slurm_cpus_per_state", "Cpus per state i.e alloc, mixed, draining, etc.", []string{"state"}, nil),
+		nodeCountPerState:  config.NewDesc("slurm_node_count_per_state", "nodes per state", []string{"state"}, nil),
+		nodeDown:           config.NewDesc("slurm_node_down", "1 per down, drained or draining node, labeled with the normalized reason", []string{"node", "reason"}, nil),
+		nodesByFeature:     config.NewDesc("slurm_nodes_by_feature", "Nodes advertising each available or active feature", []string{"feature"}, nil),
+		nodesNotResponding: config.NewDesc("slurm_nodes_not_responding", "Nodes not responding to the controller, whatever their base state", nil, nil),
+		nodesInvalidReg:    config.NewDesc("slurm_nodes_invalid_reg", "Nodes that registered with an invalid configuration", nil, nil),
 		// node memory summary stats
-		totalRealMemory:  prometheus.NewDesc("slurm_mem_real", "Total real mem", nil, nil),
-		totalFreeMemory:  prometheus.NewDesc("slurm_mem_free", "Total free mem", nil, nil),
-		totalAllocMemory: prometheus.NewDesc("slurm_mem_alloc", "Total alloc mem", nil, nil),
+		totalRealMemory:  config.NewDesc("slurm_mem_real", "Total real mem", nil, nil),
+		totalFreeMemory:  config.NewDesc("slurm_mem_free", "Total free mem", nil, nil),
+		totalAllocMemory: config.NewDesc("slurm_mem_alloc", "Total alloc mem", nil, nil),
 		// exporter stats
-		nodeScrapeDuration: prometheus.NewDesc("slurm_node_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sinfo), nil, nil),
+		nodeScrapeDuration: config.NewDesc("slurm_node_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sinfo), nil, nil),
 		nodeScrapeErrors:   fetcher.ScrapeError(),
 	}
 }
@@ -445,20 +725,29 @@ func (nc *NodesCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nc.partitionRealMemory
 	ch <- nc.partitionWeight
 	ch <- nc.totalCpus
+	ch <- nc.totalAllocCpus
 	ch <- nc.totalIdleCpus
+	ch <- nc.totalOtherCpus
+	ch <- nc.cpuUtilization
 	ch <- nc.cpusPerState
+	ch <- nc.nodeDown
+	ch <- nc.nodesByFeature
+	ch <- nc.nodesNotResponding
+	ch <- nc.nodesInvalidReg
 	ch <- nc.totalRealMemory
 	ch <- nc.totalFreeMemory
 	ch <- nc.totalAllocMemory
 	ch <- nc.nodeScrapeDuration
-	ch <- nc.nodeScrapeErrors.Desc()
+	nc.nodeScrapeErrors.Describe(ch)
 }
 
 func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		ch <- nc.fetcher.ScrapeError()
-	}()
-	nodeMetrics, err := nc.fetcher.FetchMetrics()
+	nc.CollectContext(context.Background(), ch)
+}
+
+func (nc *NodesCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer nc.fetcher.ScrapeError().Collect(ch)
+	nodeMetrics, err := nc.fetcher.FetchMetrics(ctx)
 	ch <- prometheus.MustNewConstMetric(nc.nodeScrapeDuration, prometheus.GaugeValue, float64(nc.fetcher.ScrapeDuration().Milliseconds()))
 	if err != nil {
 		slog.Error("Failed to parse node metrics: " + err.Error())
@@ -472,7 +761,7 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 	// partition set
-	partitionMetrics := fetchNodePartitionMetrics(nodeMetrics)
+	partitionMetrics := fetchNodePartitionMetrics(bucketNodePartitions(nodeMetrics, nc.partitionAllowlist))
 	for partition, metric := range partitionMetrics {
 		emitStateVal(partition, metric.StateAllocCpus, nc.partitionAllocCpus)
 		emitStateVal(partition, metric.StateAllocMemory, nc.partitionAllocMemory)
@@ -499,12 +788,39 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 	// node cpu summary set
 	nodeCpuMetrics := fetchNodeTotalCpuMetrics(nodeMetrics)
 	ch <- prometheus.MustNewConstMetric(nc.totalCpus, prometheus.GaugeValue, nodeCpuMetrics.Total)
+	ch <- prometheus.MustNewConstMetric(nc.totalAllocCpus, prometheus.GaugeValue, nodeCpuMetrics.Alloc)
 	ch <- prometheus.MustNewConstMetric(nc.totalIdleCpus, prometheus.GaugeValue, nodeCpuMetrics.Idle)
+	ch <- prometheus.MustNewConstMetric(nc.totalOtherCpus, prometheus.GaugeValue, nodeCpuMetrics.Other)
+	if nodeCpuMetrics.Total > 0 {
+		ch <- prometheus.MustNewConstMetric(nc.cpuUtilization, prometheus.GaugeValue, nodeCpuMetrics.Alloc/nodeCpuMetrics.Total)
+	}
 	ch <- prometheus.MustNewConstMetric(nc.totalCpuLoad, prometheus.GaugeValue, nodeCpuMetrics.Load)
 	for state, psm := range nodeCpuMetrics.PerState {
 		ch <- prometheus.MustNewConstMetric(nc.cpusPerState, prometheus.GaugeValue, psm.Cpus, state)
 		ch <- prometheus.MustNewConstMetric(nc.nodeCountPerState, prometheus.GaugeValue, psm.Count, state)
 	}
+	notResponding, invalidReg := 0., 0.
+	hostnames := make([]string, 0, len(nodeMetrics))
+	for _, node := range nodeMetrics {
+		hostnames = append(hostnames, node.Hostname)
+	}
+	nodeLabels := nc.nodeSuffixes.Labels(hostnames)
+	for _, node := range nodeMetrics {
+		if node.Unavailable() {
+			ch <- prometheus.MustNewConstMetric(nc.nodeDown, prometheus.GaugeValue, 1, nodeLabels[node.Hostname], normalizeNodeReason(node.Reason))
+		}
+		if node.HasStateFlag(nodeFlagNotResponding) {
+			notResponding++
+		}
+		if node.HasStateFlag(nodeFlagInvalidReg) {
+			invalidReg++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(nc.nodesNotResponding, prometheus.GaugeValue, notResponding)
+	ch <- prometheus.MustNewConstMetric(nc.nodesInvalidReg, prometheus.GaugeValue, invalidReg)
+	for feature, count := range countNodeFeatures(nodeMetrics, nc.featureAllowlist) {
+		ch <- prometheus.MustNewConstMetric(nc.nodesByFeature, prometheus.GaugeValue, count, feature)
+	}
 	// node mem summary set
 	memMetrics := fetchNodeTotalMemMetrics(nodeMetrics)
 	ch <- prometheus.MustNewConstMetric(nc.totalRealMemory, prometheus.GaugeValue, memMetrics.RealMemory)
@@ -515,3 +831,7 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 func (nc *NodesCollector) SetFetcher(fetcher SlurmMetricFetcher[NodeMetric]) {
 	nc.fetcher = fetcher
 }
+
+func (nc *NodesCollector) Fetcher() SlurmMetricFetcher[NodeMetric] {
+	return nc.fetcher
+}
@@ -5,11 +5,15 @@
 package exporter
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,8 +37,8 @@ func TestNewNodeCollector(t *testing.T) {
 }
 
 func TestParseNodeMetrics(t *testing.T) {
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to parse metrics with %s", err)
 	}
@@ -54,8 +58,8 @@ func sumStateMetric(metric map[string]float64) float64 {
 
 func TestPartitionMetric(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodePartitionMetrics(nodeMetrics)
 	assert.Equal(1, len(metrics))
@@ -72,8 +76,8 @@ func TestPartitionMetric(t *testing.T) {
 
 func TestNodeSummaryCpuMetric(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodeTotalCpuMetrics(nodeMetrics)
 	assert.Equal(4, len(metrics.PerState))
@@ -85,8 +89,8 @@ func TestNodeSummaryCpuMetric(t *testing.T) {
 
 func TestNodeSummaryMemoryMetrics(t *testing.T) {
 	assert := assert.New(t)
-	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	nodeMetrics, err := fetcher.FetchMetrics()
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	metrics := fetchNodeTotalMemMetrics(nodeMetrics)
 	assert.Equal(114688., metrics.AllocMemory)
@@ -100,7 +104,7 @@ func TestNodeCollector(t *testing.T) {
 	assert.Nil(err)
 	nc := NewNodeCollecter(config)
 	// cache miss, use our mock fetcher
-	nc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
 	metricChan := make(chan prometheus.Metric)
 	go func() {
 		nc.Collect(metricChan)
@@ -120,7 +124,7 @@ func TestNodeDescribe(t *testing.T) {
 	config, err := NewConfig(new(CliFlags))
 	assert.Nil(err)
 	jc := NewNodeCollecter(config)
-	jc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	jc.fetcher = &NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
 	go func() {
 		jc.Describe(ch)
 		close(ch)
@@ -136,8 +140,8 @@ func TestParseFallbackNodeMetricsCsv(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
 	byteFetcher := &MockScraper{fixture: "fixtures/sinfo_fallback.txt"}
-	fetcher := NodeCliFallbackFetcher{scraper: byteFetcher, errorCounter: prometheus.NewCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
-	metrics, err := fetcher.FetchMetrics()
+	fetcher := NodeCliFallbackFetcher{scraper: byteFetcher, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
 	assert.Nil(err)
 	assert.NotEmpty(metrics)
 	cs222Idx := slices.IndexFunc(metrics, func(m NodeMetric) bool { return m.Hostname == "cs222" })
@@ -164,3 +168,349 @@ func TestNAbleFloat_Float(t *testing.T) {
 	assert.NoError(n.UnmarshalJSON(data))
 	assert.Equal(expected, float64(n))
 }
+
+func TestParseFallbackNodeMetricsCsv_Gres(t *testing.T) {
+	assert := assert.New(t)
+	byteFetcher := &MockScraper{fixture: "fixtures/sinfo_gpu_node_fallback.txt"}
+	fetcher := NodeCliFallbackFetcher{scraper: byteFetcher, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.Nil(err)
+	assert.Len(metrics, 3)
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestParseCpuState(t *testing.T) {
+	assert := assert.New(t)
+	cpuState, err := parseCpuState("4/12/0/16")
+	assert.NoError(err)
+	assert.Equal(&CpuState{Alloc: 4, Idle: 12, Other: 0, Total: 16}, cpuState)
+	assert.True(cpuState.Valid())
+}
+
+func TestParseCpuState_NA(t *testing.T) {
+	assert := assert.New(t)
+	cpuState, err := parseCpuState("N/A")
+	assert.NoError(err)
+	assert.Equal(new(CpuState), cpuState)
+	cpuState, err = parseCpuState("N/A/N/A/16")
+	assert.Error(err)
+	assert.Nil(cpuState)
+}
+
+func TestParseCpuState_Malformed(t *testing.T) {
+	assert := assert.New(t)
+	_, err := parseCpuState("4/12/16")
+	assert.Error(err)
+	_, err = parseCpuState("4/x/0/16")
+	assert.Error(err)
+}
+
+func TestNodeSummaryCpuMetric_Alloc(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_fallback.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	metrics := fetchNodeTotalCpuMetrics(nodeMetrics)
+	assert.Equal(70., metrics.Other)
+	assert.Equal(metrics.Total, metrics.Alloc+metrics.Idle+metrics.Other)
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestNodeSummaryCpuMetric_JsonOther(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeJsonFetcher{scraper: MockNodeInfoScraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	metrics := fetchNodeTotalCpuMetrics(nodeMetrics)
+	assert.Equal(metrics.Total, metrics.Alloc+metrics.Idle+metrics.Other)
+}
+
+func TestParseFallbackNodeMetricsCsv_CpuStateMismatch(t *testing.T) {
+	assert := assert.New(t)
+	sinfo := "mix|1030000|cs22|13.35|hw-l*|492574|40/24/4/64|168|841728"
+	fetcher := NodeCliFallbackFetcher{scraper: &StringByteScraper{msg: sinfo}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(metrics, 1)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestNodeJsonFetcher_ErrorReasons(t *testing.T) {
+	testFetcherErrorReasons(t, func(scraper SlurmByteScraper) errorReasonFetcher[[]NodeMetric] {
+		return &NodeJsonFetcher{scraper: scraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	}, map[string]SlurmByteScraper{
+		ScrapeErrorUnmarshal: &StringByteScraper{msg: `{"nodes": [`},
+		ScrapeErrorApi:       &StringByteScraper{msg: `{"nodes": [], "errors": ["Unable to contact slurm controller"]}`},
+	})
+}
+
+func TestNodeCliFallbackFetcher_ErrorReasons(t *testing.T) {
+	testFetcherErrorReasons(t, func(scraper SlurmByteScraper) errorReasonFetcher[[]NodeMetric] {
+		return &NodeCliFallbackFetcher{scraper: scraper, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	}, map[string]SlurmByteScraper{
+		ScrapeErrorUnmarshal: &StringByteScraper{msg: "mix|notmem|cs22|13.35|hw-l*|492574|40/24/0/64|168|841728"},
+	})
+}
+
+func TestNodeUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	for _, state := range []string{"down", "down*", "drain", "drng", "DRAINED", "draining", "fail", "mix&drain"} {
+		assert.True((&NodeMetric{State: state}).Unavailable(), state)
+	}
+	for _, state := range []string{"mix", "idle", "allocated", "alloc&comp", ""} {
+		assert.False((&NodeMetric{State: state}).Unavailable(), state)
+	}
+}
+
+func TestNormalizeNodeReason(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("none", normalizeNodeReason(""))
+	assert.Equal("none", normalizeNodeReason("  "))
+	assert.Equal("Kill task failed", normalizeNodeReason("Kill task failed [root@2024-01-01T00:00:00]"))
+	assert.Equal("sched maintenance", normalizeNodeReason(" sched \t maintenance "))
+	assert.Len(normalizeNodeReason(strings.Repeat("a", 100)), maxNodeReasonLen)
+}
+
+func collectNodeDown(t *testing.T, fetcher SlurmMetricFetcher[NodeMetric]) map[string]string {
+	config, err := NewConfig(new(CliFlags))
+	assert.Nil(t, err)
+	nc := NewNodeCollecter(config)
+	nc.fetcher = fetcher
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(metricChan)
+		close(metricChan)
+	}()
+	reasons := make(map[string]string)
+	for m := range metricChan {
+		if m.Desc() != nc.nodeDown {
+			continue
+		}
+		metric := new(dto.Metric)
+		assert.NoError(t, m.Write(metric))
+		assert.Equal(t, 1., metric.GetGauge().GetValue())
+		labels := make(map[string]string)
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		reasons[labels["node"]] = labels["reason"]
+	}
+	return reasons
+}
+
+func TestNodeCollector_DownReasonJson(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_drain_out.json"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	reasons := collectNodeDown(t, fetcher)
+	assert.Equal(map[string]string{
+		"cs3.example.company.com": "Kill task failed",
+		"cs4.example.company.com": "sched maintenance",
+		"cs5.example.company.com": "none",
+	}, reasons)
+}
+
+func TestNodeCollector_DownReasonFallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_fallback_reason.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	reasons := collectNodeDown(t, fetcher)
+	assert.Equal(map[string]string{
+		"cs100": "Kill task failed",
+		"cs101": "sched maintenance",
+		"cs102": "Not responding",
+		"cs103": "none",
+	}, reasons)
+}
+
+// mixed nodes report their idle cpus from CPUsState, not all or nothing
+func TestNodeSummaryCpuMetric_Mixed(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_fallback.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	idx := slices.IndexFunc(nodeMetrics, func(m NodeMetric) bool { return m.Hostname == "cs22" })
+	assert.GreaterOrEqual(idx, 0)
+	assert.Equal("mix", nodeMetrics[idx].State)
+	assert.Equal(40., nodeMetrics[idx].AllocCpus)
+	assert.Equal(24., nodeMetrics[idx].IdleCpus)
+	metrics := fetchNodeTotalCpuMetrics(nodeMetrics[idx : idx+1])
+	assert.Equal(24., metrics.Idle)
+	assert.Equal(64., metrics.PerState["mix"].Cpus)
+}
+
+func TestNodeFeatures_UnmarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+	var features NodeFeatures
+	assert.NoError(json.Unmarshal([]byte(`"nvlink, ib,,(null)"`), &features))
+	assert.Equal(NodeFeatures{"nvlink", "ib"}, features)
+	assert.NoError(json.Unmarshal([]byte(`["nvlink","ib"]`), &features))
+	assert.Equal(NodeFeatures{"nvlink", "ib"}, features)
+	assert.NoError(json.Unmarshal([]byte(`""`), &features))
+	assert.Empty(features)
+	assert.Error(json.Unmarshal([]byte(`1`), &features))
+}
+
+func TestCountNodeFeatures(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_features.json"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Equal(map[string]float64{"nvlink": 2, "ib": 3, "a100": 1, "h100": 1, "avx512": 1}, countNodeFeatures(nodeMetrics, nil))
+	assert.Equal(map[string]float64{"nvlink": 2, "ib": 3}, countNodeFeatures(nodeMetrics, []string{"nvlink", "ib", "missing"}))
+}
+
+// nodes listed once per partition are only counted once
+func TestCountNodeFeatures_Fallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_features_fallback.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	nodeMetrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(nodeMetrics, 4)
+	assert.Equal(map[string]float64{"nvlink": 2, "ib": 3, "a100": 1, "h100": 1, "avx512": 1}, countNodeFeatures(nodeMetrics, nil))
+}
+
+func TestNodeCollector_NodesByFeature(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{NodeFeatureAllowlist: "nvlink, ib"})
+	assert.Nil(err)
+	nc := NewNodeCollecter(config)
+	nc.fetcher = &NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_features.json"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(metricChan)
+		close(metricChan)
+	}()
+	counts := make(map[string]float64)
+	for m := range metricChan {
+		if m.Desc() != nc.nodesByFeature {
+			continue
+		}
+		metric := new(dto.Metric)
+		assert.NoError(m.Write(metric))
+		counts[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(map[string]float64{"nvlink": 2, "ib": 3}, counts)
+}
+
+// columns are mapped by their header title, unknown columns i.e CPUS are ignored
+func TestParseFallbackNodeMetricsCsv_Header(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_fallback_header.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+	assert.Len(metrics, 2)
+	idx := slices.IndexFunc(metrics, func(m NodeMetric) bool { return m.Hostname == "cs200" })
+	assert.GreaterOrEqual(idx, 0)
+	assert.Equal("mix", metrics[idx].State)
+	assert.Equal(1.03e12, metrics[idx].RealMemory)
+	assert.Equal(13.35, metrics[idx].CpuLoad)
+	assert.Equal(24., metrics[idx].IdleCpus)
+	assert.Equal(168., metrics[idx].Weight)
+	assert.ElementsMatch([]string{"hw-l*", "hw-m"}, metrics[idx].Partitions)
+	assert.Equal([]string{"nvlink", "ib"}, []string(metrics[idx].Features))
+	idx = slices.IndexFunc(metrics, func(m NodeMetric) bool { return m.Hostname == "cs201" })
+	assert.GreaterOrEqual(idx, 0)
+	assert.Equal("Kill task failed", metrics[idx].Reason)
+}
+
+func TestParseSinfoHeader(t *testing.T) {
+	assert := assert.New(t)
+	cols, ok := parseSinfoHeader([]string{"CPUS ", " GRES", "HOSTNAMES"})
+	assert.True(ok)
+	assert.Equal(sinfoColumns{sinfoGres: 1, sinfoNodeHost: 2}, cols)
+	_, ok = parseSinfoHeader([]string{"mix", "1030000", "cs200"})
+	assert.False(ok)
+	records, ok := cols.normalize([]string{"64", "gpu:1", "cs200"})
+	assert.False(ok)
+	assert.Nil(records)
+	gres, ok := cols.field([]string{"64", " gpu:1 "}, sinfoGres)
+	assert.True(ok)
+	assert.Equal("gpu:1", gres)
+	_, ok = cols.field([]string{"64", "gpu:1"}, sinfoNodeHost)
+	assert.False(ok)
+}
+
+func TestParseCompactStateFlags(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{nodeFlagNotResponding}, parseCompactStateFlags("idle*"))
+	assert.Equal([]string{nodeFlagNotResponding}, parseCompactStateFlags("ALLOC*"))
+	assert.Equal([]string{nodeFlagInvalidReg}, parseCompactStateFlags("inval"))
+	assert.Equal([]string{nodeFlagNotResponding, nodeFlagInvalidReg}, parseCompactStateFlags("inval*"))
+	assert.Empty(parseCompactStateFlags("idle"))
+	assert.Empty(parseCompactStateFlags("mix"))
+}
+
+// flags don't replace the base state, a node can be both idle and not responding
+func TestParseFallbackNodeMetricsCsv_StateFlags(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_state_flags_fallback.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	nodes := make(map[string]NodeMetric)
+	for _, m := range metrics {
+		nodes[m.Hostname] = m
+	}
+	assert.Equal("idle*", nodes["cs10"].State)
+	assert.Equal(128., nodes["cs10"].IdleCpus)
+	assert.Equal([]string{nodeFlagNotResponding}, nodes["cs10"].StateFlags)
+	assert.Equal([]string{nodeFlagNotResponding}, nodes["cs11"].StateFlags)
+	assert.Equal([]string{nodeFlagInvalidReg}, nodes["cs12"].StateFlags)
+	assert.Empty(nodes["cs13"].StateFlags)
+}
+
+func collectStateFlagGauges(t *testing.T, fetcher SlurmMetricFetcher[NodeMetric]) (float64, float64) {
+	config, err := NewConfig(new(CliFlags))
+	assert.NoError(t, err)
+	nc := NewNodeCollecter(config)
+	nc.fetcher = fetcher
+	metricChan := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(metricChan)
+		close(metricChan)
+	}()
+	var notResponding, invalidReg float64
+	for m := range metricChan {
+		metric := new(dto.Metric)
+		switch m.Desc() {
+		case nc.nodesNotResponding:
+			assert.NoError(t, m.Write(metric))
+			notResponding = metric.GetGauge().GetValue()
+		case nc.nodesInvalidReg:
+			assert.NoError(t, m.Write(metric))
+			invalidReg = metric.GetGauge().GetValue()
+		}
+	}
+	return notResponding, invalidReg
+}
+
+func TestNodeCollector_StateFlagsJson(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeJsonFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_state_flags.json"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	notResponding, invalidReg := collectStateFlagGauges(t, fetcher)
+	assert.Equal(2., notResponding)
+	assert.Equal(2., invalidReg)
+}
+
+func TestNodeCollector_StateFlagsFallback(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := &NodeCliFallbackFetcher{scraper: &MockScraper{fixture: "fixtures/sinfo_state_flags_fallback.txt"}, errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}), cache: NewAtomicThrottledCache[NodeMetric](1)}
+	notResponding, invalidReg := collectStateFlagGauges(t, fetcher)
+	assert.Equal(2., notResponding)
+	assert.Equal(1., invalidReg)
+}
+
+func TestBucketNodePartitions(t *testing.T) {
+	assert := assert.New(t)
+	nodes := []NodeMetric{
+		{Hostname: "cs1", Partitions: []string{"hw-l*", "hw-m", "hw-h"}},
+		{Hostname: "cs2", Partitions: []string{"hw-h"}},
+	}
+	bucketed := bucketNodePartitions(nodes, LabelAllowlist{"hw-l"})
+	assert.Equal([]string{"hw-l*", otherLabelValue}, bucketed[0].Partitions)
+	assert.Equal([]string{otherLabelValue}, bucketed[1].Partitions)
+	// the fetched nodes are shared with the cache and left untouched
+	assert.Equal([]string{"hw-l*", "hw-m", "hw-h"}, nodes[0].Partitions)
+	partitions := fetchNodePartitionMetrics(bucketed)
+	assert.Equal(2., partitions[otherLabelValue].StateNodeCount[""])
+	assert.Equal(1., partitions["hw-l*"].StateNodeCount[""])
+}
@@ -0,0 +1,399 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// a single sinfo line, i.e the amount of nodes within a partition in a given state
+type PartitionStateMetric struct {
+	Partition string
+	// sinfo suffixes the default partition with "*"
+	Default bool
+	// partition availability i.e up, down, drain, inact
+	Avail     string
+	NodeState string
+	Nodes     float64
+}
+
+type PartitionCliFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[PartitionStateMetric]
+}
+
+// parses output of the form "%P|%a|%D|%T" i.e "gpu*|up|4|mixed"
+func (pcf *PartitionCliFetcher) fetch(ctx context.Context) ([]PartitionStateMetric, error) {
+	sinfo, err := pcf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		pcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimSpace(sinfo)))
+	reader.Comma = '|'
+	reader.FieldsPerRecord = -1
+	metrics := make([]PartitionStateMetric, 0)
+	for records, err := reader.Read(); err != io.EOF; records, err = reader.Read() {
+		if err != nil {
+			pcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+			slog.Error(fmt.Sprintf("failed to read partition row with err: %q", err))
+			continue
+		}
+		if len(records) != 4 {
+			pcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+			slog.Error(fmt.Sprintf("partition fallback cli record length expectation unmet. Expected 4 fields, got %+v", records))
+			continue
+		}
+		for idx, record := range records {
+			records[idx] = strings.TrimSpace(record)
+		}
+		nodes, err := strconv.ParseFloat(records[2], 64)
+		if err != nil {
+			pcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+			slog.Error(fmt.Sprintf("failed to parse partition node count %s with err: %q", records[2], err))
+			continue
+		}
+		partition, isDefault := strings.CutSuffix(records[0], "*")
+		metrics = append(metrics, PartitionStateMetric{
+			Partition: partition,
+			Default:   isDefault,
+			Avail:     records[1],
+			NodeState: records[3],
+			Nodes:     nodes,
+		})
+	}
+	return metrics, nil
+}
+
+func (pcf *PartitionCliFetcher) FetchMetrics(ctx context.Context) ([]PartitionStateMetric, error) {
+	return pcf.cache.FetchOrThrottle(ctx, pcf.fetch)
+}
+
+func (pcf *PartitionCliFetcher) ScrapeError() *prometheus.CounterVec {
+	return pcf.errorCounter
+}
+
+func (pcf *PartitionCliFetcher) ScrapeDuration() time.Duration {
+	return pcf.scraper.Duration()
+}
+
+type PartitionSummary struct {
+	Default    bool
+	Avail      string
+	StateNodes map[string]float64
+}
+
+func parsePartitionSummary(metrics []PartitionStateMetric) map[string]*PartitionSummary {
+	partitions := make(map[string]*PartitionSummary)
+	for _, metric := range metrics {
+		summary, ok := partitions[metric.Partition]
+		if !ok {
+			summary = &PartitionSummary{
+				Avail:      metric.Avail,
+				StateNodes: make(map[string]float64),
+			}
+			partitions[metric.Partition] = summary
+		}
+		summary.Default = summary.Default || metric.Default
+		summary.StateNodes[metric.NodeState] += metric.Nodes
+	}
+	return partitions
+}
+
+type PartitionCollector struct {
+	fetcher                 SlurmMetricFetcher[PartitionStateMetric]
+	partitionNodes          *prometheus.Desc
+	partitionState          *prometheus.Desc
+	partitionIsDefault      *prometheus.Desc
+	partitionScrapeDuration *prometheus.Desc
+	partitionScrapeError    *prometheus.CounterVec
+	partitionAllowlist      LabelAllowlist
+}
+
+func NewPartitionCollector(config *Config) *PartitionCollector {
+	cliOpts := config.cliOpts
+	fetcher := &PartitionCliFetcher{
+		scraper: cliOpts.scraper("sinfo_partition", cliOpts.sinfoPartition),
+		cache:   newCollectorCache[PartitionStateMetric](config.PollLimit, "partition"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_partition_scrape_error",
+			Help: "slurm partition scrape error",
+		}),
+	}
+	return &PartitionCollector{
+		fetcher:                 fetcher,
+		partitionNodes:          config.NewDesc("slurm_partition_nodes_total", "nodes per partition per node state", []string{"partition", "state"}, nil),
+		partitionState:          config.NewDesc("slurm_partition_state", "partition availability i.e up, down, drain, inact. Value is always 1", []string{"partition", "state"}, nil),
+		partitionIsDefault:      config.NewDesc("slurm_partition_is_default", "1 if the partition is the cluster default", []string{"partition"}, nil),
+		partitionScrapeDuration: config.NewDesc("slurm_partition_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.sinfoPartition), nil, nil),
+		partitionScrapeError:    fetcher.ScrapeError(),
+		partitionAllowlist:      cliOpts.partitionAllowlist,
+	}
+}
+
+func (pc *PartitionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.partitionNodes
+	ch <- pc.partitionState
+	ch <- pc.partitionIsDefault
+	ch <- pc.partitionScrapeDuration
+	pc.partitionScrapeError.Describe(ch)
+}
+
+func (pc *PartitionCollector) Collect(ch chan<- prometheus.Metric) {
+	pc.CollectContext(context.Background(), ch)
+}
+
+func (pc *PartitionCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer pc.partitionScrapeError.Collect(ch)
+	metrics, err := pc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(pc.partitionScrapeDuration, prometheus.GaugeValue, float64(pc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("partition fetch error %q", err))
+		return
+	}
+	// node counts outside the allowlist are summed into other. Availability and the default flag can't be
+	// summed, they're only exported for allowlisted partitions
+	otherNodes := make(map[string]float64)
+	for partition, summary := range parsePartitionSummary(metrics) {
+		if !pc.partitionAllowlist.Allows(partition) {
+			for state, nodes := range summary.StateNodes {
+				otherNodes[state] += nodes
+			}
+			continue
+		}
+		for state, nodes := range summary.StateNodes {
+			ch <- prometheus.MustNewConstMetric(pc.partitionNodes, prometheus.GaugeValue, nodes, partition, state)
+		}
+		ch <- prometheus.MustNewConstMetric(pc.partitionState, prometheus.GaugeValue, 1, partition, summary.Avail)
+		isDefault := 0.
+		if summary.Default {
+			isDefault = 1
+		}
+		ch <- prometheus.MustNewConstMetric(pc.partitionIsDefault, prometheus.GaugeValue, isDefault, partition)
+	}
+	for state, nodes := range otherNodes {
+		ch <- prometheus.MustNewConstMetric(pc.partitionNodes, prometheus.GaugeValue, nodes, otherLabelValue, state)
+	}
+}
+
+// partition limit from scontrol. Given as a plain int or, depending on the data_parser,
+// {"set": true, "infinite": false, "number": 4}. UNLIMITED or unset limits parse as +Inf
+type PartitionLimit float64
+
+func (pl *PartitionLimit) UnmarshalJSON(data []byte) error {
+	var nativeFloat float64
+	if err := json.Unmarshal(data, &nativeFloat); err == nil {
+		*pl = PartitionLimit(nativeFloat)
+		return nil
+	}
+	var numStruct struct {
+		Set      bool    `json:"set"`
+		Infinite bool    `json:"infinite"`
+		Number   float64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &numStruct); err != nil {
+		return err
+	}
+	if !numStruct.Set || numStruct.Infinite {
+		*pl = PartitionLimit(math.Inf(1))
+		return nil
+	}
+	*pl = PartitionLimit(numStruct.Number)
+	return nil
+}
+
+type PartitionConfigMetric struct {
+	Name  string `json:"name"`
+	Nodes struct {
+		Total PartitionLimit `json:"total"`
+	} `json:"nodes"`
+	Cpus struct {
+		Total PartitionLimit `json:"total"`
+	} `json:"cpus"`
+	Maximums struct {
+		CpusPerNode PartitionLimit `json:"cpus_per_node"`
+		Nodes       PartitionLimit `json:"nodes"`
+		// minutes
+		Time PartitionLimit `json:"time"`
+	} `json:"maximums"`
+	Tres struct {
+		// configured TRES i.e "cpu=256,mem=1000G,node=4,billing=256"
+		Configured string `json:"configured"`
+	} `json:"tres"`
+	// i.e ["DEFAULT", "HIDDEN"]
+	Flags  []string `json:"flags"`
+	Groups struct {
+		// comma separated AllowGroups, empty when every group is allowed
+		Allowed string `json:"allowed"`
+	} `json:"groups"`
+}
+
+func (pcm *PartitionConfigMetric) hasFlag(flag string) bool {
+	return slices.ContainsFunc(pcm.Flags, func(f string) bool { return strings.EqualFold(f, flag) })
+}
+
+// groups listed in the allow_groups label before the rest are elided, a long AllowGroups would bloat the series
+const maxAllowGroupsLabel = 5
+
+// sorted AllowGroups label of slurm_partition_info, "ALL" when unrestricted
+func allowGroupsLabel(allowed string) string {
+	var groups []string
+	for _, group := range strings.Split(allowed, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 || slices.Contains(groups, "ALL") {
+		return "ALL"
+	}
+	slices.Sort(groups)
+	if len(groups) > maxAllowGroupsLabel {
+		groups = append(groups[:maxAllowGroupsLabel], fmt.Sprintf("+%d more", len(groups)-maxAllowGroupsLabel))
+	}
+	return strings.Join(groups, ",")
+}
+
+type scontrolPartitionResponse struct {
+	Errors     []string                `json:"errors"`
+	Partitions []PartitionConfigMetric `json:"partitions"`
+}
+
+type PartitionConfigFetcher struct {
+	scraper      SlurmByteScraper
+	errorCounter *prometheus.CounterVec
+	cache        *AtomicThrottledCache[PartitionConfigMetric]
+}
+
+func (pcf *PartitionConfigFetcher) fetch(ctx context.Context) ([]PartitionConfigMetric, error) {
+	cliJson, err := pcf.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		pcf.errorCounter.WithLabelValues(fetchErrorReason(err)).Inc()
+		return nil, err
+	}
+	resp := new(scontrolPartitionResponse)
+	if err := unmarshalCliJson(cliJson, resp); err != nil {
+		slog.Error(fmt.Sprintf("Unmarshaling partition config metrics %q", err))
+		pcf.errorCounter.WithLabelValues(ScrapeErrorUnmarshal).Inc()
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		recordApiErrors("scontrol", resp.Errors)
+		for _, e := range resp.Errors {
+			slog.Error(fmt.Sprintf("scontrol API error response: %q", e))
+		}
+		pcf.errorCounter.WithLabelValues(ScrapeErrorApi).Add(float64(len(resp.Errors)))
+		return nil, errors.New(resp.Errors[0])
+	}
+	return resp.Partitions, nil
+}
+
+func (pcf *PartitionConfigFetcher) FetchMetrics(ctx context.Context) ([]PartitionConfigMetric, error) {
+	return pcf.cache.FetchOrThrottle(ctx, pcf.fetch)
+}
+
+func (pcf *PartitionConfigFetcher) ScrapeError() *prometheus.CounterVec {
+	return pcf.errorCounter
+}
+
+func (pcf *PartitionConfigFetcher) ScrapeDuration() time.Duration {
+	return pcf.scraper.Duration()
+}
+
+// static partition limits for capacity planning. Unlimited values are exported as +Inf
+type PartitionConfigCollector struct {
+	fetcher              SlurmMetricFetcher[PartitionConfigMetric]
+	maxCpusPerNode       *prometheus.Desc
+	maxNodes             *prometheus.Desc
+	maxTime              *prometheus.Desc
+	totalCpus            *prometheus.Desc
+	totalNodes           *prometheus.Desc
+	billingTotal         *prometheus.Desc
+	info                 *prometheus.Desc
+	configScrapeDuration *prometheus.Desc
+	configScrapeError    *prometheus.CounterVec
+	// limits can't be summed, partitions outside the allowlist are dropped
+	partitionAllowlist LabelAllowlist
+}
+
+func NewPartitionConfigCollector(config *Config) *PartitionConfigCollector {
+	cliOpts := config.cliOpts
+	fetcher := &PartitionConfigFetcher{
+		scraper: cliOpts.scraper("scontrol_partition", cliOpts.partitionConf),
+		cache:   newCollectorCache[PartitionConfigMetric](config.PollLimit, "partition_config"),
+		errorCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_partition_config_scrape_error",
+			Help: "slurm partition config scrape error",
+		}),
+	}
+	return &PartitionConfigCollector{
+		fetcher:              fetcher,
+		maxCpusPerNode:       config.NewDesc("slurm_partition_max_cpus", "MaxCPUsPerNode per partition, +Inf if unlimited", []string{"partition"}, nil),
+		maxNodes:             config.NewDesc("slurm_partition_max_nodes", "MaxNodes per job per partition, +Inf if unlimited", []string{"partition"}, nil),
+		maxTime:              config.NewDesc("slurm_partition_max_time_seconds", "MaxTime per partition, +Inf if unlimited", []string{"partition"}, nil),
+		totalCpus:            config.NewDesc("slurm_partition_config_cpus", "TotalCPUs configured per partition", []string{"partition"}, nil),
+		totalNodes:           config.NewDesc("slurm_partition_config_nodes", "TotalNodes configured per partition", []string{"partition"}, nil),
+		billingTotal:         config.NewDesc("slurm_billing_total", "billing TRES configured per partition", []string{"partition"}, nil),
+		info:                 config.NewDesc("slurm_partition_info", "partition attributes, always 1", []string{"partition", "default", "hidden", "allow_groups"}, nil),
+		configScrapeDuration: config.NewDesc("slurm_partition_config_scrape_duration", fmt.Sprintf("how long the cmd %v took (ms)", cliOpts.partitionConf), nil, nil),
+		configScrapeError:    fetcher.ScrapeError(),
+		partitionAllowlist:   cliOpts.partitionAllowlist,
+	}
+}
+
+func (pcc *PartitionConfigCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pcc.maxCpusPerNode
+	ch <- pcc.maxNodes
+	ch <- pcc.maxTime
+	ch <- pcc.totalCpus
+	ch <- pcc.totalNodes
+	ch <- pcc.billingTotal
+	ch <- pcc.info
+	ch <- pcc.configScrapeDuration
+	pcc.configScrapeError.Describe(ch)
+}
+
+func (pcc *PartitionConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	pcc.CollectContext(context.Background(), ch)
+}
+
+func (pcc *PartitionConfigCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer pcc.configScrapeError.Collect(ch)
+	metrics, err := pcc.fetcher.FetchMetrics(ctx)
+	ch <- prometheus.MustNewConstMetric(pcc.configScrapeDuration, prometheus.GaugeValue, float64(pcc.fetcher.ScrapeDuration().Milliseconds()))
+	if err != nil {
+		slog.Error(fmt.Sprintf("partition config fetch error %q", err))
+		return
+	}
+	for _, partition := range metrics {
+		if !pcc.partitionAllowlist.Allows(partition.Name) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(pcc.maxCpusPerNode, prometheus.GaugeValue, float64(partition.Maximums.CpusPerNode), partition.Name)
+		ch <- prometheus.MustNewConstMetric(pcc.maxNodes, prometheus.GaugeValue, float64(partition.Maximums.Nodes), partition.Name)
+		ch <- prometheus.MustNewConstMetric(pcc.maxTime, prometheus.GaugeValue, float64(partition.Maximums.Time)*60, partition.Name)
+		ch <- prometheus.MustNewConstMetric(pcc.totalCpus, prometheus.GaugeValue, float64(partition.Cpus.Total), partition.Name)
+		ch <- prometheus.MustNewConstMetric(pcc.totalNodes, prometheus.GaugeValue, float64(partition.Nodes.Total), partition.Name)
+		if billing, ok := parseTresValue(partition.Tres.Configured, "billing"); ok {
+			ch <- prometheus.MustNewConstMetric(pcc.billingTotal, prometheus.GaugeValue, billing, partition.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(pcc.info, prometheus.GaugeValue, 1, partition.Name, strconv.FormatBool(partition.hasFlag("DEFAULT")), strconv.FormatBool(partition.hasFlag("HIDDEN")), allowGroupsLabel(partition.Groups.Allowed))
+	}
+}
@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+var MockPartitionScraper = &MockScraper{fixture: "fixtures/sinfo_partition.txt"}
+
+func TestPartitionFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := PartitionCliFetcher{
+		scraper:      MockPartitionScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionStateMetric](10),
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(metrics, 7)
+	assert.Equal(PartitionStateMetric{Partition: "gpu", Default: true, Avail: "up", NodeState: "mixed", Nodes: 2}, metrics[0])
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestPartitionFetch_Malformed(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := PartitionCliFetcher{
+		scraper:      &StringByteScraper{msg: "gpu*|up|2|mixed\ngpu*|up|x|idle\ngpu|up"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionStateMetric](10),
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(metrics, 1)
+	assert.Equal(2., CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestParsePartitionSummary(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := PartitionCliFetcher{
+		scraper:      MockPartitionScraper,
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionStateMetric](10),
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	summary := parsePartitionSummary(metrics)
+	assert.Len(summary, 4)
+	assert.True(summary["gpu"].Default)
+	assert.False(summary["hw-l"].Default)
+	assert.Equal(map[string]float64{"mixed": 2, "allocated": 1, "drained": 1}, summary["gpu"].StateNodes)
+	assert.Equal("drain", summary["maint"].Avail)
+	assert.Equal("down", summary["old"].Avail)
+}
+
+func TestPartitionCollector(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		PollLimit: 10,
+		cliOpts:   &CliOpts{},
+	}
+	pc := NewPartitionCollector(config)
+	pc.fetcher = &PartitionCliFetcher{
+		scraper:      MockPartitionScraper,
+		errorCounter: pc.partitionScrapeError,
+		cache:        NewAtomicThrottledCache[PartitionStateMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pc.Collect(ch)
+		close(ch)
+	}()
+	metrics := make([]prometheus.Metric, 0)
+	for metric, ok := <-ch; ok; metric, ok = <-ch {
+		metrics = append(metrics, metric)
+	}
+	// 7 node state series, 4 partition states, 4 default flags, duration & error per reason
+	assert.Len(metrics, 20)
+}
+
+func TestPartitionCollector_Allowlist(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmPartitionsEnabled: true, MetricsPartitionAllowlist: "gpu"})
+	assert.NoError(err)
+	pc := NewPartitionCollector(config)
+	pc.fetcher = &PartitionCliFetcher{
+		scraper:      MockPartitionScraper,
+		errorCounter: pc.partitionScrapeError,
+		cache:        NewAtomicThrottledCache[PartitionStateMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pc.Collect(ch)
+		close(ch)
+	}()
+	nodes := make(map[string]float64)
+	partitions := make(map[string]bool)
+	for metric := range ch {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		labels := make(map[string]string)
+		for _, label := range dtoMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		switch metric.Desc() {
+		case pc.partitionNodes:
+			nodes[labels["partition"]+"/"+labels["state"]] = dtoMetric.GetGauge().GetValue()
+		case pc.partitionState, pc.partitionIsDefault:
+			partitions[labels["partition"]] = true
+		}
+	}
+	// hw-l, maint and old are summed into other
+	assert.Equal(map[string]float64{
+		"gpu/mixed": 2, "gpu/allocated": 1, "gpu/drained": 1,
+		"other/idle": 13, "other/down*": 2, "other/down": 4,
+	}, nodes)
+	// availability can't be summed, it's only kept for allowlisted partitions
+	assert.Equal(map[string]bool{"gpu": true}, partitions)
+}
+
+func TestPartitionDescribe(t *testing.T) {
+	assert := assert.New(t)
+	config := &Config{
+		PollLimit: 10,
+		cliOpts:   &CliOpts{},
+	}
+	pc := NewPartitionCollector(config)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		pc.Describe(ch)
+		close(ch)
+	}()
+	descs := make([]*prometheus.Desc, 0)
+	for desc, ok := <-ch; ok; desc, ok = <-ch {
+		descs = append(descs, desc)
+	}
+	assert.Len(descs, 5)
+}
+
+func TestPartitionLimit(t *testing.T) {
+	assert := assert.New(t)
+	var limit PartitionLimit
+	assert.NoError(json.Unmarshal([]byte(`12`), &limit))
+	assert.Equal(PartitionLimit(12), limit)
+	assert.NoError(json.Unmarshal([]byte(`{"set": true, "infinite": false, "number": 4}`), &limit))
+	assert.Equal(PartitionLimit(4), limit)
+	assert.NoError(json.Unmarshal([]byte(`{"set": true, "infinite": true, "number": 0}`), &limit))
+	assert.True(math.IsInf(float64(limit), 1))
+	assert.NoError(json.Unmarshal([]byte(`{"set": false, "infinite": false, "number": 0}`), &limit))
+	assert.True(math.IsInf(float64(limit), 1))
+	assert.Error(json.Unmarshal([]byte(`"UNLIMITED"`), &limit))
+}
+
+func TestPartitionConfigFetch(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := PartitionConfigFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_partition.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	metrics, err := fetcher.FetchMetrics(context.Background())
+	assert.NoError(err)
+	assert.Len(metrics, 2)
+	gpu := metrics[0]
+	assert.Equal("gpu", gpu.Name)
+	assert.Equal(PartitionLimit(4), gpu.Nodes.Total)
+	assert.Equal(PartitionLimit(256), gpu.Cpus.Total)
+	assert.Equal(PartitionLimit(48), gpu.Maximums.CpusPerNode)
+	assert.Equal(PartitionLimit(2), gpu.Maximums.Nodes)
+	assert.Equal(PartitionLimit(1440), gpu.Maximums.Time)
+	hw := metrics[1]
+	assert.True(math.IsInf(float64(hw.Maximums.CpusPerNode), 1))
+	assert.True(math.IsInf(float64(hw.Maximums.Nodes), 1))
+	assert.True(math.IsInf(float64(hw.Maximums.Time), 1))
+	assert.Zero(CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestPartitionConfigFetch_ApiError(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := PartitionConfigFetcher{
+		scraper:      &StringByteScraper{msg: `{"partitions": [], "errors": ["Unable to contact slurm controller"]}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+	assert.Equal(1., CollectCounterValue(fetcher.errorCounter))
+}
+
+func TestPartitionConfigCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{PartitionConfigEnabled: true})
+	assert.NoError(err)
+	assert.True(config.cliOpts.partConfEnabled)
+	assert.Equal([]string{"scontrol", "show", "partition", "--json"}, config.cliOpts.partitionConf)
+	pcc := NewPartitionConfigCollector(config)
+	pcc.fetcher = &PartitionConfigFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_partition.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pcc.Collect(ch)
+		close(ch)
+	}()
+	maxTimes := make(map[string]float64)
+	count := 0
+	for metric := range ch {
+		count++
+		if metric.Desc() != pcc.maxTime {
+			continue
+		}
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		maxTimes[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+	}
+	// 5 limits and info per partition, scrape duration & error per reason
+	assert.Equal(17, count)
+	assert.Equal(86400., maxTimes["gpu"])
+	assert.True(math.IsInf(maxTimes["hw"], 1))
+}
+
+func TestPartitionConfigCollector_Billing(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{PartitionConfigEnabled: true})
+	assert.NoError(err)
+	pcc := NewPartitionConfigCollector(config)
+	pcc.fetcher = &PartitionConfigFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_partition_billing.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pcc.Collect(ch)
+		close(ch)
+	}()
+	billing := make(map[string]float64)
+	for metric := range ch {
+		if metric.Desc() != pcc.billingTotal {
+			continue
+		}
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		billing[dtoMetric.GetLabel()[0].GetValue()] = dtoMetric.GetGauge().GetValue()
+	}
+	// hw doesn't configure billing weights
+	assert.Equal(map[string]float64{"gpu": 512}, billing)
+}
+
+func TestAllowGroupsLabel(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("ALL", allowGroupsLabel(""))
+	assert.Equal("ALL", allowGroupsLabel("ALL"))
+	assert.Equal("admin,ml", allowGroupsLabel("ml, admin"))
+	assert.Equal("g1,g2,g3,g4,g5,+2 more", allowGroupsLabel("g7,g1,g3,g6,g2,g5,g4"))
+}
+
+func TestPartitionConfigCollector_Info(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{PartitionConfigEnabled: true})
+	assert.NoError(err)
+	pcc := NewPartitionConfigCollector(config)
+	pcc.fetcher = &PartitionConfigFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_partition_info.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pcc.Collect(ch)
+		close(ch)
+	}()
+	info := make(map[string]map[string]string)
+	for metric := range ch {
+		if metric.Desc() != pcc.info {
+			continue
+		}
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		assert.Equal(1., dtoMetric.GetGauge().GetValue())
+		labels := make(map[string]string)
+		for _, label := range dtoMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		info[labels["partition"]] = labels
+	}
+	assert.Equal(map[string]map[string]string{
+		"gpu":   {"partition": "gpu", "default": "false", "hidden": "true", "allow_groups": "admin,ml,vision"},
+		"hw":    {"partition": "hw", "default": "true", "hidden": "false", "allow_groups": "ALL"},
+		"staff": {"partition": "staff", "default": "false", "hidden": "false", "allow_groups": "g1,g2,g3,g4,g5,+2 more"},
+	}, info)
+}
+
+func TestPartitionConfigCollector_Allowlist(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{PartitionConfigEnabled: true, MetricsPartitionAllowlist: "gpu,hw"})
+	assert.NoError(err)
+	pcc := NewPartitionConfigCollector(config)
+	pcc.fetcher = &PartitionConfigFetcher{
+		scraper:      &MockScraper{fixture: "fixtures/scontrol_partition_info.json"},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[PartitionConfigMetric](10),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		pcc.Collect(ch)
+		close(ch)
+	}()
+	partitions := make(map[string]bool)
+	for metric := range ch {
+		dtoMetric := new(dto.Metric)
+		assert.NoError(metric.Write(dtoMetric))
+		for _, label := range dtoMetric.GetLabel() {
+			if label.GetName() == "partition" {
+				partitions[label.GetValue()] = true
+			}
+		}
+	}
+	// staff is dropped rather than folded into other, limits can't be summed
+	assert.Equal(map[string]bool{"gpu": true, "hw": true}, partitions)
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// openapi & data_parser plugin versions the json fetchers know how to parse
+var knownSchemaVersions = map[string]bool{
+	"v0.0.37": true,
+	"v0.0.38": true,
+	"v0.0.39": true,
+	"v0.0.40": true,
+	"v0.0.41": true,
+	"v0.0.42": true,
+}
+
+var schemaUnrecognizedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "slurm_unrecognized_schema_total",
+	Help: "slurm json responses generated by a plugin version the exporter doesn't recognize",
+}, []string{"command", "version"})
+
+type slurmMeta struct {
+	Meta struct {
+		SlurmVersion SlurmVersion      `json:"Slurm"`
+		Plugins      map[string]string `json:"plugins"`
+		Plugin       map[string]string `json:"plugin"`
+	} `json:"meta"`
+}
+
+// version of the plugin that generated the response i.e v0.0.37 or v0.0.41.
+// Returns "" if it can't be determined
+func (sm *slurmMeta) SchemaVersion() string {
+	// data_parser is listed under plugins in 23.02 and plugin from 23.11 onwards.
+	// Prior releases use the openapi plugin type instead
+	for _, plugins := range []map[string]string{sm.Meta.Plugin, sm.Meta.Plugins} {
+		if dataParser := plugins["data_parser"]; dataParser != "" {
+			return path.Base(dataParser)
+		}
+		if pluginType := plugins["type"]; strings.HasPrefix(pluginType, "openapi/v") {
+			return path.Base(pluginType)
+		}
+	}
+	return ""
+}
+
+// detectSchemaVersion decodes only the meta block of a slurm json response.
+// Unrecognized versions are logged & counted so that slurm upgrades don't break parsing silently
+func detectSchemaVersion(command string, data []byte) string {
+	meta := new(slurmMeta)
+	if err := json.Unmarshal(data, meta); err != nil {
+		// leave reporting malformed json to the caller's full unmarshal
+		return ""
+	}
+	version := meta.SchemaVersion()
+	if !knownSchemaVersions[version] {
+		slog.Warn(fmt.Sprintf("%s json generated by unrecognized plugin version %q (slurm %s), parsing may be incomplete", command, version, meta.Meta.SlurmVersion.Release))
+		schemaUnrecognizedCounter.WithLabelValues(command, version).Inc()
+	}
+	return version
+}
+
+var schemaMissingFieldCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "slurm_schema_missing_field_total",
+	Help: "slurm json responses missing a field the exporter reads, most likely renamed by a slurm upgrade",
+}, []string{"command", "field"})
+
+// counts a response without field. Unlike an empty field, which is a legitimate "nothing to report",
+// an absent one would otherwise be read as zero without notice
+func recordMissingField(command string, field string) {
+	slog.Warn(fmt.Sprintf("%s json has no %q field, its schema may have changed", command, field))
+	schemaMissingFieldCounter.WithLabelValues(command, field).Inc()
+}
+
+// distinct error labels per command before the rest are bucketed into "other"
+const maxApiErrorLabels = 20
+
+var apiErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "slurm_api_errors_total",
+	Help: "errors returned in the errors array of slurm json responses, normalized to bound cardinality",
+}, []string{"command", "error"})
+
+var (
+	apiErrorLabelsLock sync.Mutex
+	apiErrorLabels     = make(map[string]map[string]bool)
+	// ids, counts, hosts etc. i.e "Invalid user id 1234"
+	apiErrorNumberRegex = regexp.MustCompile(`[0-9]+`)
+)
+
+const maxApiErrorLen = 64
+
+// strips the variable parts of a slurm error so recurring errors share a label. Truncated by rune,
+// label values must be valid utf-8
+func normalizeApiError(e string) string {
+	e = apiErrorNumberRegex.ReplaceAllString(strings.ToLower(strings.ToValidUTF8(e, "?")), "N")
+	e = strings.Join(strings.Fields(e), " ")
+	if runes := []rune(e); len(runes) > maxApiErrorLen {
+		e = string(runes[:maxApiErrorLen])
+	}
+	return e
+}
+
+// counts every error a slurm json response returned
+func recordApiErrors(command string, errs []string) {
+	apiErrorLabelsLock.Lock()
+	defer apiErrorLabelsLock.Unlock()
+	seen, ok := apiErrorLabels[command]
+	if !ok {
+		seen = make(map[string]bool)
+		apiErrorLabels[command] = seen
+	}
+	for _, e := range errs {
+		label := normalizeApiError(e)
+		if !seen[label] {
+			if len(seen) >= maxApiErrorLabels {
+				label = "other"
+			} else {
+				seen[label] = true
+			}
+		}
+		apiErrorCounter.WithLabelValues(command, label).Inc()
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectSchemaVersion(t *testing.T) {
+	assert := assert.New(t)
+	fixtures := map[string]string{
+		// openapi plugin
+		"fixtures/squeue_out.json": "v0.0.37",
+		// data_parser under plugins
+		"fixtures/sdiag.json": "v0.0.39",
+		// data_parser under plugin with stringified version numbers
+		"fixtures/sdiag_2405.json":    "v0.0.41",
+		"fixtures/sinfo_gpu_out.json": "v0.0.39",
+	}
+	for fixture, expected := range fixtures {
+		data, err := os.ReadFile(fixture)
+		assert.NoError(err)
+		assert.Equal(expected, detectSchemaVersion("test_known", data), fixture)
+	}
+	assert.Zero(CollectCounterValue(schemaUnrecognizedCounter.WithLabelValues("test_known", "")))
+}
+
+func TestDetectSchemaVersion_Unrecognized(t *testing.T) {
+	assert := assert.New(t)
+	data := []byte(`{"meta": {"plugin": {"data_parser": "data_parser/v0.0.99"}, "slurm": {"release": "99.11.0"}}}`)
+	assert.Equal("v0.0.99", detectSchemaVersion("test_unrecognized", data))
+	assert.Equal(1., CollectCounterValue(schemaUnrecognizedCounter.WithLabelValues("test_unrecognized", "v0.0.99")))
+	// missing meta entirely
+	assert.Equal("", detectSchemaVersion("test_unrecognized", []byte(`{"nodes": []}`)))
+	assert.Equal(1., CollectCounterValue(schemaUnrecognizedCounter.WithLabelValues("test_unrecognized", "")))
+}
+
+func TestNormalizeApiError(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("invalid user id N", normalizeApiError("Invalid user id 1234"))
+	assert.Equal("invalid user id N", normalizeApiError("Invalid  user id 99 "))
+	assert.Len(normalizeApiError(strings.Repeat("a", 100)), 64)
+	// multi-byte characters across byte 64 are kept whole
+	unicodeErr := normalizeApiError(strings.Repeat("a", 63) + strings.Repeat("é", 10))
+	assert.True(utf8.ValidString(unicodeErr))
+	assert.Equal(strings.Repeat("a", 63)+"é", unicodeErr)
+	assert.True(utf8.ValidString(normalizeApiError("bad \xff byte")))
+	assert.NotPanics(func() { recordApiErrors("test_unicode", []string{strings.Repeat("ü", 80)}) })
+}
+
+func TestRecordApiErrors(t *testing.T) {
+	assert := assert.New(t)
+	recordApiErrors("test_record", []string{"Invalid user id 1234", "Invalid user id 5678"})
+	assert.Equal(2., CollectCounterValue(apiErrorCounter.WithLabelValues("test_record", "invalid user id N")))
+}
+
+func TestRecordApiErrors_Bounded(t *testing.T) {
+	assert := assert.New(t)
+	errs := make([]string, 0)
+	for i := 0; i < maxApiErrorLabels+5; i++ {
+		errs = append(errs, "error "+strings.Repeat("x", i))
+	}
+	recordApiErrors("test_bounded", errs)
+	assert.Len(apiErrorLabels["test_bounded"], maxApiErrorLabels)
+	assert.Equal(5., CollectCounterValue(apiErrorCounter.WithLabelValues("test_bounded", "other")))
+}
+
+func TestNodeJsonFetcher_ApiErrors(t *testing.T) {
+	assert := assert.New(t)
+	fetcher := NodeJsonFetcher{
+		scraper:      &StringByteScraper{msg: `{"errors": ["Invalid user id 42"], "nodes": []}`},
+		errorCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
+		cache:        NewAtomicThrottledCache[NodeMetric](1),
+	}
+	before := CollectCounterValue(apiErrorCounter.WithLabelValues("sinfo", "invalid user id N"))
+	_, err := fetcher.FetchMetrics(context.Background())
+	assert.Error(err)
+	assert.Equal(before+1, CollectCounterValue(apiErrorCounter.WithLabelValues("sinfo", "invalid user id N")))
+}
@@ -5,33 +5,263 @@
 package exporter
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"log/slog"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 )
 
 type CliOpts struct {
-	sinfo         []string
-	squeue        []string
-	sacctmgr      []string
-	lic           []string
-	sdiag         []string
-	sinfoGpu      []string
-	sacctGpu      []string
-	licEnabled    bool
-	diagsEnabled  bool
-	gpusEnabled   bool
-	fallback      bool
-	sacctEnabled  bool
-	excludeFilter *regexp.Regexp
+	sinfo             []string
+	squeue            []string
+	sacctmgr          []string
+	lic               []string
+	sdiag             []string
+	sinfoGpu          []string
+	sacctGpu          []string
+	squeueGpu         []string
+	squeuePendingGpu  []string
+	sinfoPartition    []string
+	partitionConf     []string
+	licEnabled        bool
+	diagsEnabled      bool
+	gpusEnabled       bool
+	partitionsEnabled bool
+	partConfEnabled   bool
+	fallback          bool
+	sacctEnabled      bool
+	excludeFilters    []*regexp.Regexp
+	excludeLabels     []LabelMatcher
+	// cross check the sacct GPU allocation against squeue's allocated TRES
+	gpuAllocCrosscheck bool
+	// sum the GPUs requested by pending jobs, an extra squeue call per GPU scrape
+	gpuPending bool
+	// emit per job GPU allocations. High cardinality
+	gpuPerJob bool
+	// emit per job cpu, mem and node allocations. High cardinality
+	jobAllocPerJob bool
+	// emit a histogram of per node GPU utilization
+	gpuNodeHistogram bool
+	// ewma smoothing factor for GPU utilization, 0 disables smoothing
+	gpuUtilizationAlpha float64
+	// total or available, the denominator of GPU utilization
+	gpuUtilizationBasis string
+	// gres resource name counted as GPUs i.e nvidia_gpu
+	gpuGresName string
+	// type label of untyped GPUs, "untyped" when empty
+	gpuDefaultType string
+	// sacct or gres_used, where json mode reads allocated GPUs from
+	gpuAllocSource string
+	// max slurm commands in flight across all collectors. 0 is unbounded
+	maxConcurrentScrapes int
+	// node features exported by slurm_nodes_by_feature, all when empty
+	nodeFeatureAllowlist []string
+	// random offset of each cache's throttle window as a fraction of the poll limit
+	cacheJitter float64
+	// sinfo output shared between the node and GPU collectors
+	sharedSinfo SlurmByteScraper
+	// parse GPU totals from sharedSinfo instead of running sinfoGpu
+	gpuSharesSinfo bool
+	// read cli output from <fixtureDir>/<fixture> instead of running the cmds
+	fixtureDir string
+	// disable optional collectors whose cmds aren't installed instead of failing every scrape
+	skipUnavailable bool
+	// upper bounds of the pending job priority histogram
+	jobPriorityBuckets []float64
+	// upper bounds of the running job elapsed time histogram
+	jobElapsedBuckets []float64
+	// timezone cli timestamps are printed in, nil is the exporter's local time
+	slurmTimeZone *time.Location
+	// accounts and partitions outside these are aggregated into "other", empty keeps all
+	accountAllowlist   LabelAllowlist
+	partitionAllowlist LabelAllowlist
+	// suffixes stripped from every node label
+	nodeSuffixes NodeSuffixes
+	// prints dcgm-exporter metrics, joined with the slurm node and job of each GPU
+	dcgm        []string
+	dcgmEnabled bool
+	// sacct listing the jobs that ended in a terminal state within the last minutes
+	completedJobs    []string
+	completedEnabled bool
+	// per node details only scontrol reports i.e boot time
+	nodeDetail        []string
+	nodeDetailEnabled bool
+	// pings the primary and backup slurmctld
+	ctldPing        []string
+	ctldPingEnabled bool
+	// trivial sacctmgr query timing the slurmdbd round trip
+	dbdPing        []string
+	dbdPingEnabled bool
+	// prints ClusterName, only run at startup when the cluster isn't named
+	scontrolConfig []string
+	// cli timeout per fixture, overriding CLI_TIMEOUT for the cmds of slow collectors
+	cmdTimeouts map[string]time.Duration
+}
+
+// cmds served by the debug endpoint, keyed by fixture name. Only cmds of enabled collectors are listed
+func (c *CliOpts) debugCommands() map[string][]string {
+	cmds := map[string][]string{
+		"sinfo":  c.sinfo,
+		"squeue": c.squeue,
+	}
+	if c.licEnabled {
+		cmds["lic"] = c.lic
+	}
+	if c.diagsEnabled {
+		cmds["sdiag"] = c.sdiag
+	}
+	if c.sacctEnabled {
+		cmds["sacctmgr"] = c.sacctmgr
+	}
+	if c.gpusEnabled {
+		cmds["sinfo_gpu"] = c.sinfoGpu
+		if c.fallback || c.gpuAllocSource != gpuAllocSourceGresUsed {
+			cmds["sacct_gpu"] = c.sacctGpu
+		}
+		if c.gpuPending {
+			cmds["squeue_pending_gpu"] = c.squeuePendingGpu
+		}
+		if c.gpuAllocCrosscheck {
+			cmds["squeue_gpu"] = c.squeueGpu
+		}
+	}
+	if c.partitionsEnabled {
+		cmds["sinfo_partition"] = c.sinfoPartition
+	}
+	if c.partConfEnabled {
+		cmds["scontrol_partition"] = c.partitionConf
+	}
+	if c.completedEnabled {
+		cmds["sacct_completed"] = c.completedJobs
+	}
+	if c.dcgmEnabled {
+		cmds["dcgm"] = c.dcgm
+	}
+	if c.ctldPingEnabled {
+		cmds["scontrol_ping"] = c.ctldPing
+	}
+	if c.dbdPingEnabled {
+		cmds["sacctmgr_ping"] = c.dbdPing
+	}
+	if c.nodeDetailEnabled {
+		cmds["scontrol_node"] = c.nodeDetail
+	}
+	return cmds
+}
+
+// disables optional collectors with a cmd missing from PATH, i.e sdiag on a minimal client install.
+// The node and job collectors are always kept
+func (c *CliOpts) disableUnavailableCollectors() {
+	if c.fixtureDir != "" {
+		return
+	}
+	cmds := c.debugCommands()
+	collectors := []struct {
+		name     string
+		enabled  *bool
+		fixtures []string
+	}{
+		{"license", &c.licEnabled, []string{"lic"}},
+		{"diag", &c.diagsEnabled, []string{"sdiag"}},
+		{"limit", &c.sacctEnabled, []string{"sacctmgr"}},
+		{"gpu", &c.gpusEnabled, []string{"sinfo_gpu", "sacct_gpu", "squeue_pending_gpu", "squeue_gpu"}},
+		{"partition", &c.partitionsEnabled, []string{"sinfo_partition"}},
+		{"partition config", &c.partConfEnabled, []string{"scontrol_partition"}},
+		{"dcgm", &c.dcgmEnabled, []string{"dcgm"}},
+		{"completed jobs", &c.completedEnabled, []string{"sacct_completed"}},
+		{"controller ping", &c.ctldPingEnabled, []string{"scontrol_ping"}},
+		{"slurmdbd", &c.dbdPingEnabled, []string{"sacctmgr_ping"}},
+		{"node detail", &c.nodeDetailEnabled, []string{"scontrol_node"}},
+	}
+	for _, collector := range collectors {
+		if !*collector.enabled {
+			continue
+		}
+		for _, fixture := range collector.fixtures {
+			args, ok := cmds[fixture]
+			if !ok {
+				continue
+			}
+			if err := lookupSlurmBinary(args); err != nil {
+				slog.Warn(fmt.Sprintf("disabling the %s collector: %q", collector.name, err))
+				*collector.enabled = false
+				break
+			}
+		}
+	}
+}
+
+// returns a scraper for args, or a file read of the named fixture when a fixture dir is configured
+func (c *CliOpts) scraper(fixture string, args []string) SlurmByteScraper {
+	if c.fixtureDir != "" {
+		return NewResponseSizeScraper(fixture, NewFileScraper(filepath.Join(c.fixtureDir, fixture)))
+	}
+	scraper := NewCliScraper(args...)
+	scraper.command = fixture
+	if timeout, ok := c.cmdTimeouts[fixture]; ok {
+		scraper.timeout = timeout
+	}
+	return NewResponseSizeScraper(fixture, scraper)
+}
+
+// fixtures of the cmds run by each collector group, see -slurm.collector-timeout
+var collectorGroupFixtures = map[string][]string{
+	"node":      {"sinfo", "scontrol_node"},
+	"job":       {"squeue", "sacct_completed"},
+	"license":   {"lic"},
+	"diag":      {"sdiag", "scontrol_ping", "sacctmgr_ping"},
+	"limit":     {"sacctmgr"},
+	"gpu":       {"sinfo_gpu", "sacct_gpu", "squeue_pending_gpu", "squeue_gpu", "dcgm"},
+	"partition": {"sinfo_partition", "scontrol_partition"},
+}
+
+// parses comma separated cli timeouts per collector group i.e "gpu=60s,job=20s" into timeouts per fixture
+func parseCollectorTimeouts(timeouts string) (map[string]time.Duration, error) {
+	cmdTimeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(timeouts, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		group, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("collector timeout %q must be <collector>=<duration>", entry)
+		}
+		fixtures, ok := collectorGroupFixtures[strings.TrimSpace(group)]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q, expected one of %v", group, slices.Sorted(maps.Keys(collectorGroupFixtures)))
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout of collector %q: %w", group, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout of collector %q must be positive, got %v", group, timeout)
+		}
+		for _, fixture := range fixtures {
+			cmdTimeouts[fixture] = timeout
+		}
+	}
+	return cmdTimeouts, nil
 }
 
 type TraceConfig struct {
@@ -39,6 +269,9 @@ type TraceConfig struct {
 	path          string
 	rate          uint64
 	sharedFetcher SlurmMetricFetcher[JobMetric]
+	// uploads are also persisted here when set, keeping at most outputMaxFiles
+	outputDir      string
+	outputMaxFiles int
 }
 
 type Config struct {
@@ -47,13 +280,44 @@ type Config struct {
 	LogLevel      slog.Level
 	ListenAddress string
 	MetricsPath   string
-	cliOpts       *CliOpts
+	PprofEnabled  bool
+	PprofAddress  string
+	// constant labels added to every exported series
+	ExternalLabels prometheus.Labels
+	// prepended verbatim to every slurm metric name i.e "site_"
+	MetricsPrefix string
+	// help text replacing the built-in one, keyed by the unprefixed metric name
+	MetricsHelp map[string]string
+	// also serve each collector group under MetricsPath/<group>, i.e /metrics/gpu
+	SplitMetricsPaths bool
+	// drop the go runtime & process collectors client_golang registers by default
+	DisableGoCollector      bool
+	DisableProcessCollector bool
+	// serve the raw output of the configured slurm cmds under /debug/raw/<cmd>
+	DebugEndpointsEnabled bool
+	// collect every collector once at startup to warm the caches
+	PrefetchOnStart bool
+	// where PollLimit was resolved from: default, env or flag
+	PollLimitSource string
+	// series a single collector may emit per scrape, 0 is unlimited
+	MaxSeriesPerCollector int
+	// detected at startup unless overridden, see resolveClusterName
+	ClusterName string
+	// export ClusterName as the cluster label of every slurm metric
+	ClusterLabelEnabled bool
+	cliOpts             *CliOpts
+}
+
+// lets fetchers outside this package, i.e the native libslurm ones, follow -slurm.collect-gpus
+func (c *Config) GpusEnabled() bool {
+	return c.cliOpts.gpusEnabled
 }
 
 type CliFlags struct {
 	SlurmLicEnabled           bool
 	SlurmDiagEnabled          bool
 	SlurmGpusEnabled          bool
+	SlurmPartitionsEnabled    bool
 	SlurmCliFallback          bool
 	TraceEnabled              bool
 	SacctEnabled              bool
@@ -61,16 +325,227 @@ type CliFlags struct {
 	LogLevel                  string
 	ListenAddress             string
 	MetricsPath               string
+	PprofEnabled              bool
+	PprofAddress              string
 	SlurmSqueueOverride       string
 	SlurmSinfoOverride        string
 	SlurmDiagOverride         string
 	SlurmAcctOverride         string
 	SlurmSinfoGpuOverride     string
 	SlurmSacctGpuOverride     string
+	SlurmPartitionOverride    string
+	PartitionConfigEnabled    bool
+	PartitionConfigOverride   string
+	SlurmSqueueGpuOverride    string
+	SlurmPendingGpuOverride   string
+	SlurmGpuAllocCrosscheck   bool
+	SlurmGpuPending           bool
+	SlurmGpuPerJob            bool
+	GpuUtilizationAlpha       float64
+	SlurmMaxConcurrentScrapes int
+	SacctLookbackMinutes      int
 	TraceRate                 uint64
 	TracePath                 string
+	TraceOutputDir            string
+	TraceOutputMaxFiles       int
 	SlurmLicenseOverride      string
 	MetricsExcludeFilterRegex string
+	MetricsExcludeLabels      string
+	ExternalLabels            string
+	MetricsPrefix             string
+	MetricsHelpFile           string
+	ClusterName               string
+	ClusterLabelEnabled       bool
+	SplitMetricsPaths         bool
+	DisableGoCollector        bool
+	DisableProcessCollector   bool
+	SlurmFixtureDir           string
+	DebugEndpointsEnabled     bool
+	GpuGresName               string
+	GpuDefaultType            string
+	PrefetchOnStart           bool
+	CacheJitter               float64
+	NodeFeatureAllowlist      string
+	GpuAllocSource            string
+	GpuAllocStates            string
+	SqueueStates              string
+	GpuNodeUtilHistogram      bool
+	GpuUtilizationBasis       string
+	SkipUnavailableCollectors bool
+	JobPriorityBuckets        string
+	MetricsAccountAllowlist   string
+	MetricsPartitionAllowlist string
+	NodeLabelStripSuffix      string
+	DcgmEnabled               bool
+	DcgmOverride              string
+	SlurmBinDir               string
+	CompletedJobsEnabled      bool
+	CompletedJobsMinutes      int
+	CompletedJobsOverride     string
+	MaxSeriesPerCollector     int
+	JobAllocPerJob            bool
+	JobElapsedBuckets         string
+	SlurmTimeZone             string
+	CollectorTimeouts         string
+	ControllerPingEnabled     bool
+	ControllerPingOverride    string
+	SlurmdbdEnabled           bool
+	SlurmdbdOverride          string
+	NodeDetailEnabled         bool
+	NodeDetailOverride        string
+	ShellCommands             bool
+}
+
+// binaries resolved under -slurm.bin-dir. Other binaries i.e an override's cat or curl are left to PATH
+var slurmBinaries = []string{"sacct", "sacctmgr", "scontrol", "sdiag", "sinfo", "squeue"}
+
+// prefixes a cmd naming a bare slurm binary with binDir. Paths i.e an absolute override are kept as is
+func withSlurmBinDir(args []string, binDir string) []string {
+	if binDir == "" || len(args) == 0 || !slices.Contains(slurmBinaries, args[0]) {
+		return args
+	}
+	return append([]string{filepath.Join(binDir, args[0])}, args[1:]...)
+}
+
+// parses comma separated, strictly increasing histogram upper bounds i.e "1,100,1e4"
+func parseBuckets(buckets string) ([]float64, error) {
+	var bounds []float64
+	for _, bound := range strings.Split(buckets, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", bound, err)
+		}
+		if len(bounds) > 0 && val <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("buckets must be strictly increasing, got %s", buckets)
+		}
+		bounds = append(bounds, val)
+	}
+	return bounds, nil
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parses "k1=v1,k2=v2" into prometheus labels
+func parseExternalLabels(labels string) (prometheus.Labels, error) {
+	extLabels := make(prometheus.Labels)
+	if labels == "" {
+		return extLabels, nil
+	}
+	for _, pair := range strings.Split(labels, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !labelNameRegex.MatchString(k) {
+			return nil, fmt.Errorf("invalid external label %q, expected k=v", pair)
+		}
+		if _, ok := extLabels[k]; ok {
+			return nil, fmt.Errorf("duplicate external label %q", k)
+		}
+		extLabels[k] = strings.TrimSpace(v)
+	}
+	return extLabels, nil
+}
+
+// reads a json object of metric name to help text i.e {"slurm_cpus_total": "..."}
+func loadMetricsHelp(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var help map[string]string
+	if err := json.Unmarshal(data, &help); err != nil {
+		return nil, fmt.Errorf("invalid metrics help file %s: %w", path, err)
+	}
+	for name, text := range help {
+		if text == "" {
+			return nil, fmt.Errorf("empty help for metric %q in %s", name, path)
+		}
+	}
+	return help, nil
+}
+
+// splits comma separated regexes i.e "^go_,^slurm_proc_". Commas within braces, brackets or parens
+// i.e a{1,3} are part of the regex
+func splitRegexList(patterns string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(patterns); i++ {
+		switch patterns[i] {
+		case '\\':
+			// skip the escaped char
+			i++
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, patterns[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, patterns[start:])
+}
+
+func parseExcludeFilters(patterns string) ([]*regexp.Regexp, error) {
+	var filters []*regexp.Regexp
+	for _, pattern := range splitRegexList(patterns) {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		filter, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func matchesAnyRegex(name string, filters []*regexp.Regexp) bool {
+	for _, filter := range filters {
+		if filter.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// drops individual series whose label Name equals Value
+type LabelMatcher struct {
+	Name  string
+	Value string
+}
+
+// parses "k1=v1,k1=v2" into matchers. Unlike external labels, a label may repeat
+func parseLabelMatchers(matchers string) ([]LabelMatcher, error) {
+	var labelMatchers []LabelMatcher
+	if matchers == "" {
+		return labelMatchers, nil
+	}
+	for _, pair := range strings.Split(matchers, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !labelNameRegex.MatchString(k) {
+			return nil, fmt.Errorf("invalid label matcher %q, expected k=v", pair)
+		}
+		labelMatchers = append(labelMatchers, LabelMatcher{Name: k, Value: strings.TrimSpace(v)})
+	}
+	return labelMatchers, nil
+}
+
+func matchesAnyLabel(metric *dto.Metric, matchers []LabelMatcher) bool {
+	for _, label := range metric.GetLabel() {
+		for _, matcher := range matchers {
+			if label.GetName() == matcher.Name && label.GetValue() == matcher.Value {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 var logLevelMap = map[string]slog.Level{
@@ -80,55 +555,120 @@ var logLevelMap = map[string]slog.Level{
 	"error": slog.LevelError,
 }
 
+// an unknown level would be the map's zero value, info, regardless of the current level. Keeps current instead
+func parseLogLevel(source string, lvl string, current slog.Level) slog.Level {
+	level, ok := logLevelMap[lvl]
+	if !ok {
+		slog.Warn(fmt.Sprintf("ignoring unknown %s %q, expected debug, info, warn or error", source, lvl))
+		return current
+	}
+	return level
+}
+
 func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	// defaults
-	compiledExcludeRegex, err := regexp.Compile(cliFlags.MetricsExcludeFilterRegex)
+	excludeFilters, err := parseExcludeFilters(cliFlags.MetricsExcludeFilterRegex)
+	if err != nil {
+		return nil, err
+	}
+	excludeLabels, err := parseLabelMatchers(cliFlags.MetricsExcludeLabels)
+	if err != nil {
+		return nil, err
+	}
+	gpuAllocStates, err := parseGpuAllocStates(cliFlags.GpuAllocStates)
+	if err != nil {
+		return nil, err
+	}
+	squeueStates, err := parseSqueueStates(cliFlags.SqueueStates)
 	if err != nil {
 		return nil, err
 	}
 	cliOpts := CliOpts{
-		squeue:        []string{"squeue", "--json"},
-		sinfo:         []string{"sinfo", "--json"},
-		lic:           []string{"scontrol", "show", "lic", "--json"},
-		sdiag:         []string{"sdiag", "--json"},
-		sacctmgr:      []string{"sacctmgr", "show", "assoc", "format=User,Account,GrpCPU,GrpMem,GrpJobs,GrpSubmit", "--noheader", "--parsable2"},
-		sinfoGpu:      []string{"sinfo", "--json"},
-		sacctGpu:      []string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=RUNNING", "--json"},
-		licEnabled:    cliFlags.SlurmLicEnabled,
-		diagsEnabled:  cliFlags.SlurmDiagEnabled,
-		gpusEnabled:   cliFlags.SlurmGpusEnabled,
-		fallback:      cliFlags.SlurmCliFallback,
-		sacctEnabled:  cliFlags.SacctEnabled,
-		excludeFilter: compiledExcludeRegex,
+		squeue:               []string{"squeue", "--json"},
+		sinfo:                []string{"sinfo", "--json"},
+		lic:                  []string{"scontrol", "show", "lic", "--json"},
+		sdiag:                []string{"sdiag", "--json"},
+		sacctmgr:             []string{"sacctmgr", "show", "assoc", "format=User,Account,GrpCPU,GrpMem,GrpJobs,GrpSubmit", "--noheader", "--parsable2"},
+		sinfoGpu:             []string{"sinfo", "--json"},
+		sacctGpu:             []string{"sacct", "-a", "-X", "--format=ReqTRES", "--state=" + gpuAllocStates, "--json"},
+		squeueGpu:            []string{"squeue", "--states=RUNNING", "--json"},
+		squeuePendingGpu:     []string{"squeue", "--states=PENDING", "--json"},
+		sinfoPartition:       []string{"sinfo", "-h", "-o", "%P|%a|%D|%T"},
+		partitionConf:        []string{"scontrol", "show", "partition", "--json"},
+		licEnabled:           cliFlags.SlurmLicEnabled,
+		diagsEnabled:         cliFlags.SlurmDiagEnabled,
+		gpusEnabled:          cliFlags.SlurmGpusEnabled,
+		partitionsEnabled:    cliFlags.SlurmPartitionsEnabled,
+		partConfEnabled:      cliFlags.PartitionConfigEnabled,
+		fallback:             cliFlags.SlurmCliFallback,
+		sacctEnabled:         cliFlags.SacctEnabled,
+		excludeFilters:       excludeFilters,
+		excludeLabels:        excludeLabels,
+		gpuAllocCrosscheck:   cliFlags.SlurmGpuAllocCrosscheck,
+		gpuPending:           cliFlags.SlurmGpuPending,
+		gpuPerJob:            cliFlags.SlurmGpuPerJob,
+		jobAllocPerJob:       cliFlags.JobAllocPerJob,
+		gpuNodeHistogram:     cliFlags.GpuNodeUtilHistogram,
+		gpuUtilizationAlpha:  cliFlags.GpuUtilizationAlpha,
+		gpuUtilizationBasis:  gpuUtilizationBasisTotal,
+		gpuGresName:          defaultGpuGresName,
+		gpuDefaultType:       cliFlags.GpuDefaultType,
+		gpuAllocSource:       gpuAllocSourceSacct,
+		maxConcurrentScrapes: cliFlags.SlurmMaxConcurrentScrapes,
+		cacheJitter:          cliFlags.CacheJitter,
+		nodeFeatureAllowlist: parseNodeFeatures(cliFlags.NodeFeatureAllowlist),
+		fixtureDir:           cliFlags.SlurmFixtureDir,
+		skipUnavailable:      cliFlags.SkipUnavailableCollectors,
+		jobPriorityBuckets:   defaultJobPriorityBuckets,
+		jobElapsedBuckets:    defaultJobElapsedBuckets,
+		accountAllowlist:     parseLabelAllowlist(cliFlags.MetricsAccountAllowlist),
+		partitionAllowlist:   parseLabelAllowlist(cliFlags.MetricsPartitionAllowlist),
+		nodeSuffixes:         NodeSuffixes(parseLabelAllowlist(cliFlags.NodeLabelStripSuffix)),
+		dcgm:                 []string{"curl", "-s", "http://localhost:9400/metrics"},
+		dcgmEnabled:          cliFlags.DcgmEnabled,
+		completedEnabled:     cliFlags.CompletedJobsEnabled,
+		ctldPing:             []string{"scontrol", "ping", "--json"},
+		ctldPingEnabled:      cliFlags.ControllerPingEnabled,
+		dbdPing:              []string{"sacctmgr", "-n", "-P", "show", "cluster", "format=cluster"},
+		dbdPingEnabled:       cliFlags.SlurmdbdEnabled,
+		nodeDetail:           []string{"scontrol", "show", "node", "--json"},
+		scontrolConfig:       []string{"scontrol", "show", "config"},
+		nodeDetailEnabled:    cliFlags.NodeDetailEnabled,
 	}
 	traceConf := TraceConfig{
-		enabled: cliFlags.TraceEnabled,
-		path:    "/trace",
-		rate:    10,
+		enabled:        cliFlags.TraceEnabled,
+		path:           "/trace",
+		rate:           10,
+		outputMaxFiles: defaultTraceOutputMaxFiles,
 	}
 	config := &Config{
-		PollLimit:     10,
-		LogLevel:      slog.LevelInfo,
-		ListenAddress: ":9092",
-		MetricsPath:   "/metrics",
-		TraceConf:     &traceConf,
-		cliOpts:       &cliOpts,
+		PollLimit:       10,
+		PollLimitSource: "default",
+		LogLevel:        slog.LevelInfo,
+		ListenAddress:   ":9092",
+		MetricsPath:     "/metrics",
+		TraceConf:       &traceConf,
+		cliOpts:         &cliOpts,
 	}
 	if lm, ok := os.LookupEnv("POLL_LIMIT"); ok {
 		if limit, err := strconv.ParseFloat(lm, 64); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid POLL_LIMIT %q: %w", lm, err)
+		} else if limit < 0 || math.IsNaN(limit) || math.IsInf(limit, 0) {
+			return nil, fmt.Errorf("POLL_LIMIT must be a non negative number of seconds, got %q", lm)
 		} else {
 			config.PollLimit = limit
+			config.PollLimitSource = "env"
 		}
 	}
 	if cliFlags.SlurmPollLimit > 0 {
 		config.PollLimit = cliFlags.SlurmPollLimit
+		config.PollLimitSource = "flag"
 	}
 	if lvl, ok := os.LookupEnv("LOGLEVEL"); ok {
-		config.LogLevel = logLevelMap[lvl]
+		config.LogLevel = parseLogLevel("LOGLEVEL", lvl, config.LogLevel)
 	}
 	if cliFlags.LogLevel != "" {
-		config.LogLevel = logLevelMap[cliFlags.LogLevel]
+		config.LogLevel = parseLogLevel("-web.log-level", cliFlags.LogLevel, config.LogLevel)
 	}
 	if cliFlags.ListenAddress != "" {
 		config.ListenAddress = cliFlags.ListenAddress
@@ -136,17 +676,112 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if cliFlags.MetricsPath != "" {
 		config.MetricsPath = cliFlags.MetricsPath
 	}
-	if cliFlags.SlurmSqueueOverride != "" {
-		cliOpts.squeue = strings.Split(cliFlags.SlurmSqueueOverride, " ")
+	if cliFlags.GpuUtilizationAlpha < 0 || cliFlags.GpuUtilizationAlpha > 1 {
+		return nil, fmt.Errorf("GPU utilization smoothing alpha must be within [0, 1], got %f", cliFlags.GpuUtilizationAlpha)
+	}
+	if cliFlags.CacheJitter < 0 || cliFlags.CacheJitter >= 1 {
+		return nil, fmt.Errorf("cache jitter must be within [0, 1), got %f", cliFlags.CacheJitter)
+	}
+	switch cliFlags.GpuAllocSource {
+	case "", gpuAllocSourceSacct:
+	case gpuAllocSourceGresUsed:
+		if cliOpts.fallback {
+			return nil, fmt.Errorf("GPU alloc source %s requires json mode, disable the cli fallback", gpuAllocSourceGresUsed)
+		}
+		cliOpts.gpuAllocSource = gpuAllocSourceGresUsed
+	default:
+		return nil, fmt.Errorf("GPU alloc source must be %s or %s, got %q", gpuAllocSourceSacct, gpuAllocSourceGresUsed, cliFlags.GpuAllocSource)
+	}
+	switch cliFlags.GpuUtilizationBasis {
+	case "", gpuUtilizationBasisTotal:
+	case gpuUtilizationBasisAvailable:
+		cliOpts.gpuUtilizationBasis = gpuUtilizationBasisAvailable
+	default:
+		return nil, fmt.Errorf("GPU utilization basis must be %s or %s, got %q", gpuUtilizationBasisTotal, gpuUtilizationBasisAvailable, cliFlags.GpuUtilizationBasis)
+	}
+	if cliFlags.JobPriorityBuckets != "" {
+		buckets, err := parseBuckets(cliFlags.JobPriorityBuckets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job priority buckets: %w", err)
+		}
+		cliOpts.jobPriorityBuckets = buckets
+	}
+	if cliFlags.JobElapsedBuckets != "" {
+		buckets, err := parseBuckets(cliFlags.JobElapsedBuckets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job elapsed buckets: %w", err)
+		}
+		cliOpts.jobElapsedBuckets = buckets
 	}
-	if cliFlags.SlurmSinfoOverride != "" {
-		cliOpts.sinfo = strings.Split(cliFlags.SlurmSinfoOverride, " ")
+	if cliOpts.cmdTimeouts, err = parseCollectorTimeouts(cliFlags.CollectorTimeouts); err != nil {
+		return nil, err
 	}
-	if cliFlags.SlurmDiagOverride != "" {
-		cliOpts.sdiag = strings.Split(cliFlags.SlurmDiagOverride, " ")
+	if cliFlags.SlurmTimeZone != "" {
+		loc, err := time.LoadLocation(cliFlags.SlurmTimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slurm timezone %q: %w", cliFlags.SlurmTimeZone, err)
+		}
+		cliOpts.slurmTimeZone = loc
+	}
+	config.PprofEnabled = cliFlags.PprofEnabled
+	config.PprofAddress = cliFlags.PprofAddress
+	config.MetricsPrefix = cliFlags.MetricsPrefix
+	config.SplitMetricsPaths = cliFlags.SplitMetricsPaths
+	config.DisableGoCollector = cliFlags.DisableGoCollector
+	config.DisableProcessCollector = cliFlags.DisableProcessCollector
+	config.DebugEndpointsEnabled = cliFlags.DebugEndpointsEnabled
+	config.PrefetchOnStart = cliFlags.PrefetchOnStart
+	if config.ExternalLabels, err = parseExternalLabels(cliFlags.ExternalLabels); err != nil {
+		return nil, err
+	}
+	if config.MetricsHelp, err = loadMetricsHelp(cliFlags.MetricsHelpFile); err != nil {
+		return nil, err
 	}
-	if cliFlags.SlurmAcctOverride != "" {
-		cliOpts.sacctmgr = strings.Split(cliFlags.SlurmAcctOverride, " ")
+	config.ClusterName = cliFlags.ClusterName
+	config.ClusterLabelEnabled = cliFlags.ClusterLabelEnabled
+	if cliFlags.CompletedJobsMinutes < 0 {
+		return nil, fmt.Errorf("completed jobs window must be positive, got %d minutes", cliFlags.CompletedJobsMinutes)
+	}
+	completedMinutes := cliFlags.CompletedJobsMinutes
+	if completedMinutes == 0 {
+		completedMinutes = defaultCompletedJobsMinutes
+	}
+	cliOpts.completedJobs = completedJobsCmd(completedMinutes)
+	if cliFlags.MaxSeriesPerCollector < 0 {
+		return nil, fmt.Errorf("max series per collector must be positive, got %d", cliFlags.MaxSeriesPerCollector)
+	}
+	config.MaxSeriesPerCollector = cliFlags.MaxSeriesPerCollector
+	// overrides are tokenized like a shell would, so quoted format strings stay a single arg.
+	// Shell overrides, see parseOverride, are the exception
+	for cmd, override := range map[*[]string]string{
+		&cliOpts.squeue:           cliFlags.SlurmSqueueOverride,
+		&cliOpts.sinfo:            cliFlags.SlurmSinfoOverride,
+		&cliOpts.sdiag:            cliFlags.SlurmDiagOverride,
+		&cliOpts.sacctmgr:         cliFlags.SlurmAcctOverride,
+		&cliOpts.lic:              cliFlags.SlurmLicenseOverride,
+		&cliOpts.sinfoGpu:         cliFlags.SlurmSinfoGpuOverride,
+		&cliOpts.sacctGpu:         cliFlags.SlurmSacctGpuOverride,
+		&cliOpts.sinfoPartition:   cliFlags.SlurmPartitionOverride,
+		&cliOpts.partitionConf:    cliFlags.PartitionConfigOverride,
+		&cliOpts.squeueGpu:        cliFlags.SlurmSqueueGpuOverride,
+		&cliOpts.squeuePendingGpu: cliFlags.SlurmPendingGpuOverride,
+		&cliOpts.dcgm:             cliFlags.DcgmOverride,
+		&cliOpts.completedJobs:    cliFlags.CompletedJobsOverride,
+		&cliOpts.ctldPing:         cliFlags.ControllerPingOverride,
+		&cliOpts.dbdPing:          cliFlags.SlurmdbdOverride,
+		&cliOpts.nodeDetail:       cliFlags.NodeDetailOverride,
+	} {
+		if override == "" {
+			continue
+		}
+		args, err := parseOverride(override, cliFlags.ShellCommands)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cli override %q: %w", override, err)
+		}
+		if cliFlags.ShellCommands || strings.HasPrefix(override, shellCommandPrefix) {
+			slog.Warn(fmt.Sprintf("running cli override %q through /bin/sh. The shell expands it, so it must only come from a trusted source", override))
+		}
+		*cmd = args
 	}
 	if cliFlags.TraceRate != 0 {
 		traceConf.rate = cliFlags.TraceRate
@@ -154,72 +789,497 @@ func NewConfig(cliFlags *CliFlags) (*Config, error) {
 	if cliFlags.TracePath != "" {
 		traceConf.path = cliFlags.TracePath
 	}
-	if cliFlags.SlurmLicenseOverride != "" {
-		cliOpts.lic = strings.Split(cliFlags.SlurmLicenseOverride, " ")
+	if cliFlags.TraceOutputMaxFiles < 0 {
+		return nil, fmt.Errorf("invalid trace output max files %d, must be >= 0", cliFlags.TraceOutputMaxFiles)
+	} else if cliFlags.TraceOutputMaxFiles > 0 {
+		traceConf.outputMaxFiles = cliFlags.TraceOutputMaxFiles
+	}
+	// traces are only uploaded with tracing enabled
+	if cliFlags.TraceEnabled && cliFlags.TraceOutputDir != "" {
+		if err := os.MkdirAll(cliFlags.TraceOutputDir, 0o755); err != nil {
+			return nil, fmt.Errorf("invalid trace output dir: %w", err)
+		}
+		traceConf.outputDir = cliFlags.TraceOutputDir
 	}
-	if cliFlags.SlurmSinfoGpuOverride != "" {
-		cliOpts.sinfoGpu = strings.Split(cliFlags.SlurmSinfoGpuOverride, " ")
+	if cliFlags.GpuGresName != "" {
+		cliOpts.gpuGresName = cliFlags.GpuGresName
 	}
-	if cliFlags.SlurmSacctGpuOverride != "" {
-		cliOpts.sacctGpu = strings.Split(cliFlags.SlurmSacctGpuOverride, " ")
+	if squeueStates != "" && cliFlags.SlurmSqueueOverride == "" {
+		cliOpts.squeue = []string{"squeue", "--states=" + squeueStates, "--json"}
 	}
 	if cliOpts.fallback {
 		// we define a custom json format that we convert back into the openapi format
 		if cliFlags.SlurmSqueueOverride == "" {
-			cliOpts.squeue = []string{"squeue", "--states=all", "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "array_id": "%K", "r": "%R"}`}
+			if squeueStates == "" {
+				squeueStates = "all"
+			}
+			cliOpts.squeue = []string{"squeue", "--states=" + squeueStates, "-h", "-r", "-o", `{"a": "%a", "id": %A, "end_time": "%e", "u": "%u", "state": "%T", "p": "%P", "cpu": %C, "mem": "%m", "nodes": %D, "nodelist": "%N", "submit": "%V", "start": "%S", "prio": %Q, "array_id": "%K", "r": "%R"}`}
 		}
 		if cliFlags.SlurmSinfoOverride == "" {
-			// set field lengths wide enough to avoid truncation
-			cliOpts.sinfo = []string{"sinfo", "-h", "-O", "StateCompact:12|,Memory:15|,NodeHost:30|,CPUsLoad:12|,Partition:15|,FreeMem:15|,CPUsState:15|,Weight:10|,AllocMem:15"}
+			// set field lengths wide enough to avoid truncation. The header maps columns by title, see sinfoColumnTitles
+			cliOpts.sinfo = []string{"sinfo", "-O", "StateCompact:12|,Memory:15|,NodeHost:30|,CPUsLoad:12|,Partition:15|,FreeMem:15|,CPUsState:15|,Weight:10|,AllocMem:15|,Gres:30|,Reason:50|,Features:100"}
 		}
 		if cliFlags.SlurmSinfoGpuOverride == "" {
-			cliOpts.sinfoGpu = []string{"sinfo", "-h", "-O", "Gres:30|"}
+			cliOpts.sinfoGpu = []string{"sinfo", "-O", "Gres:30|"}
+		}
+		if cliFlags.ControllerPingOverride == "" {
+			cliOpts.ctldPing = []string{"scontrol", "ping"}
 		}
 		if cliFlags.SlurmSacctGpuOverride == "" {
-			cliOpts.sacctGpu = []string{"squeue", "-h", "-t", "RUNNING", "-o", "%b"}
+			// %b is per node, so it's scaled by the node count %D like the pending GPUs
+			cliOpts.sacctGpu = []string{"squeue", "-h", "-t", gpuAllocStates, "-o", "%b|%N|%A|%u|%D"}
+		}
+		if cliFlags.SlurmSqueueGpuOverride == "" {
+			cliOpts.squeueGpu = []string{"squeue", "-h", "--states=RUNNING", "-O", "tres-alloc:200"}
+		}
+		if cliFlags.SlurmPendingGpuOverride == "" {
+			// -r lists pending array tasks individually, %b is per node so it's scaled by the node count %D
+			cliOpts.squeuePendingGpu = []string{"squeue", "-h", "-r", "--states=PENDING", "-o", "%b|%D"}
 		}
+	}
+	if cliFlags.SlurmBinDir != "" {
+		for _, cmd := range []*[]string{
+			&cliOpts.squeue, &cliOpts.sinfo, &cliOpts.lic, &cliOpts.sdiag, &cliOpts.sacctmgr, &cliOpts.sinfoGpu,
+			&cliOpts.sacctGpu, &cliOpts.squeueGpu, &cliOpts.squeuePendingGpu, &cliOpts.sinfoPartition, &cliOpts.partitionConf,
+			&cliOpts.completedJobs, &cliOpts.ctldPing, &cliOpts.nodeDetail, &cliOpts.dbdPing, &cliOpts.scontrolConfig,
+		} {
+			*cmd = withSlurmBinDir(*cmd, cliFlags.SlurmBinDir)
+		}
+	}
+	if cliOpts.fallback {
 		// must instantiate the job fetcher here since it is shared between 2 collectors
 		traceConf.sharedFetcher = &JobCliFallbackFetcher{
-			scraper: NewCliScraper(cliOpts.squeue...),
-			cache:   NewAtomicThrottledCache[JobMetric](config.PollLimit),
-			errCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			scraper: cliOpts.scraper("squeue", cliOpts.squeue),
+			cache:   newCollectorCache[JobMetric](config.PollLimit, "job"),
+			errCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 				Name: "job_scrape_errors",
-				Help: "job scrape errors",
+				Help: "slurm job scrape error",
 			}),
 		}
 	} else {
 		traceConf.sharedFetcher = &JobJsonFetcher{
-			scraper: NewCliScraper(cliOpts.squeue...),
-			cache:   NewAtomicThrottledCache[JobMetric](config.PollLimit),
-			errCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			scraper: cliOpts.scraper("squeue", cliOpts.squeue),
+			cache:   newCollectorCache[JobMetric](config.PollLimit, "job"),
+			errCounter: config.NewScrapeErrorCounter(prometheus.CounterOpts{
 				Name: "job_scrape_errors",
-				Help: "job scrape errors",
+				Help: "slurm job scrape error",
 			}),
 		}
 	}
+	if cliFlags.SacctLookbackMinutes > 0 {
+		// sacct with --state returns jobs that were in that state at any point during the window.
+		// The state filter must remain, otherwise jobs that finished within the window get counted
+		if len(cliOpts.sacctGpu) > 0 && filepath.Base(cliOpts.sacctGpu[0]) == "sacct" {
+			cliOpts.sacctGpu = append(cliOpts.sacctGpu, fmt.Sprintf("--starttime=now-%dminutes", cliFlags.SacctLookbackMinutes))
+		} else {
+			slog.Warn(fmt.Sprintf("sacct lookback ignored, GPU alloc cmd %v is not sacct", cliOpts.sacctGpu))
+		}
+	}
+	// parse GPU totals from the node sinfo output when the cmds are identical. In fallback mode the
+	// default node format carries the Gres column, so the GPU sinfo call can be dropped entirely
+	cliOpts.gpuSharesSinfo = slices.Equal(cliOpts.sinfo, cliOpts.sinfoGpu) ||
+		(cliOpts.fallback && cliFlags.SlurmSinfoOverride == "" && cliFlags.SlurmSinfoGpuOverride == "")
+	// must instantiate the sinfo scraper here since it is shared between the node & GPU collectors
+	cliOpts.sharedSinfo = NewThrottledScraper(cliOpts.scraper("sinfo", cliOpts.sinfo), config.PollLimit)
 	return config, nil
 }
 
-func NewPromHTTPServer(metricsExcludeFilter *regexp.Regexp) http.Handler {
-	// Create a handler that filters metrics based on the exclude regex pattern
-	if metricsExcludeFilter == nil || metricsExcludeFilter.String() == "" {
+func NewPromHTTPServer(gatherer prometheus.Gatherer, metricsExcludeFilters []*regexp.Regexp, excludeLabels []LabelMatcher) http.Handler {
+	if len(metricsExcludeFilters) == 0 && len(excludeLabels) == 0 && gatherer == prometheus.DefaultGatherer {
 		return promhttp.Handler()
 	}
-	slog.Info("filtering metrics based on regex: " + metricsExcludeFilter.String())
-	filteredGatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
-		allMetrics, err := prometheus.DefaultGatherer.Gather()
+	logExcludeFilters(metricsExcludeFilters, excludeLabels)
+	return promhttp.HandlerFor(filterGatherer(gatherer, metricsExcludeFilters, excludeLabels), promhttp.HandlerOpts{})
+}
+
+// serves the gatherer returned for each request's context, so the slurm cmds run while gathering are bound by it
+func newScrapeHTTPServer(gatherer func(context.Context) prometheus.Gatherer, metricsExcludeFilters []*regexp.Regexp, excludeLabels []LabelMatcher) http.Handler {
+	logExcludeFilters(metricsExcludeFilters, excludeLabels)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filtered := filterGatherer(gatherer(r.Context()), metricsExcludeFilters, excludeLabels)
+		promhttp.HandlerFor(filtered, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+func logExcludeFilters(metricsExcludeFilters []*regexp.Regexp, excludeLabels []LabelMatcher) {
+	if len(metricsExcludeFilters) > 0 {
+		slog.Info(fmt.Sprintf("filtering metrics based on regexes: %v", metricsExcludeFilters))
+	}
+	if len(excludeLabels) > 0 {
+		slog.Info(fmt.Sprintf("filtering series based on labels: %v", excludeLabels))
+	}
+}
+
+// drops the metrics matching any of the exclude regex patterns and the series matching any of the exclude labels
+func filterGatherer(gatherer prometheus.Gatherer, metricsExcludeFilters []*regexp.Regexp, excludeLabels []LabelMatcher) prometheus.Gatherer {
+	filterNames := len(metricsExcludeFilters) > 0
+	if !filterNames && len(excludeLabels) == 0 {
+		return gatherer
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		allMetrics, err := gatherer.Gather()
 		if err != nil {
 			return nil, err
 		}
 		var filteredMetrics []*dto.MetricFamily
 		for _, mf := range allMetrics {
-			if !metricsExcludeFilter.MatchString(mf.GetName()) {
-				filteredMetrics = append(filteredMetrics, mf)
+			if matchesAnyRegex(mf.GetName(), metricsExcludeFilters) {
+				continue
 			}
+			if len(excludeLabels) > 0 {
+				kept := mf.Metric[:0]
+				for _, metric := range mf.Metric {
+					if !matchesAnyLabel(metric, excludeLabels) {
+						kept = append(kept, metric)
+					}
+				}
+				// families left without series are dropped entirely
+				if len(kept) == 0 {
+					continue
+				}
+				mf.Metric = kept
+			}
+			filteredMetrics = append(filteredMetrics, mf)
 		}
 		return filteredMetrics, nil
 	})
-	return promhttp.HandlerFor(filteredGatherer, promhttp.HandlerOpts{})
+}
+
+// headroom left for the exporter to serialize the response before prometheus gives up
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// bounds the request's context, and with it the slurm cli scrapes, by the X-Prometheus-Scrape-Timeout-Seconds
+// header. Without the header the scrapers fall back to their configured timeout i.e CLI_TIMEOUT
+func NewScrapeTimeoutHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeoutSeconds, err := strconv.ParseFloat(header, 64)
+		if err != nil || timeoutSeconds <= 0 {
+			slog.Warn(fmt.Sprintf("ignoring invalid scrape timeout header %q", header))
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout := time.Duration(timeoutSeconds * float64(time.Second))
+		if timeout > 2*scrapeTimeoutOffset {
+			timeout -= scrapeTimeoutOffset
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// pprof handlers registered on their own mux. Importing net/http/pprof for side effects
+// would expose them on the default mux regardless of flags
+func NewPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// runs the configured cmd on every request and returns its output unparsed, i.e /debug/raw/sinfo.
+// Uncached so the output reflects what slurm returns right now, but still bounded by the scrape limiter & timeout
+func NewDebugRawHandler(cliOpts *CliOpts) http.Handler {
+	cmds := cliOpts.debugCommands()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/debug/raw/")
+		args, ok := cmds[name]
+		if !ok {
+			names := slices.Sorted(maps.Keys(cmds))
+			http.Error(w, fmt.Sprintf("unknown cmd %q, expected one of %v", name, names), http.StatusNotFound)
+			return
+		}
+		data, err := cliOpts.scraper(name, args).FetchRawBytes(r.Context())
+		if err != nil {
+			slog.Error(fmt.Sprintf("debug cmd %v failed: %q", args, err))
+			http.Error(w, fmt.Sprintf("cmd %v failed: %s", args, err), http.StatusBadGateway)
+			return
+		}
+		if json.Valid(data) {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Write(data)
+	})
+}
+
+// applies the configured prefix and external labels centrally instead of threading them through every Desc
+func NewWrappedRegisterer(config *Config, reg prometheus.Registerer) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(config.ExternalLabels, prometheus.WrapRegistererWithPrefix(config.MetricsPrefix, reg))
+}
+
+// help text of the named metric, the -metrics.help-file override if any. Keyed by the unprefixed name
+func (c *Config) help(name string, help string) string {
+	if override, ok := c.MetricsHelp[name]; ok {
+		return override
+	}
+	return help
+}
+
+// prometheus.NewDesc with the configured help text, collectors build their Descs through it
+func (c *Config) NewDesc(name string, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(name, c.help(name, help), variableLabels, constLabels)
+}
+
+// NewScrapeErrorCounter with the configured help text
+func (c *Config) NewScrapeErrorCounter(opts prometheus.CounterOpts) *prometheus.CounterVec {
+	opts.Help = c.help(opts.Name, opts.Help)
+	return NewScrapeErrorCounter(opts)
+}
+
+// removes the go runtime and/or process collectors registered by default on the DefaultRegisterer.
+// Cleaner than excluding go_.* & process_.* with -metrics.exclude since they're never collected
+func unregisterDefaultCollectors(reg prometheus.Registerer, goCollector bool, processCollector bool) {
+	if goCollector && reg.Unregister(collectors.NewGoCollector()) {
+		slog.Info("go runtime collector disabled")
+	}
+	if processCollector && reg.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})) {
+		slog.Info("process collector disabled")
+	}
+}
+
+// a collector whose slurm cmds are bound by the context of the scrape, see NewScrapeTimeoutHandler.
+// Its Collect runs them with context.Background, only bound by their own timeout
+type ContextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// binds a collector to the context of a single scrape
+type scrapeCollector struct {
+	ctx       context.Context
+	collector prometheus.Collector
+}
+
+func (sc *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	sc.collector.Describe(ch)
+}
+
+func (sc *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	collectContext(sc.ctx, sc.collector, ch)
+}
+
+func collectContext(ctx context.Context, collector prometheus.Collector, ch chan<- prometheus.Metric) {
+	if cc, ok := collector.(ContextCollector); ok {
+		cc.CollectContext(ctx, ch)
+		return
+	}
+	collector.Collect(ch)
+}
+
+// collectors per group, served together under MetricsPath or, with SplitMetricsPaths,
+// also per group so expensive groups can be scraped on their own interval.
+// Collect has no context, so the collectors are registered anew for every scrape, binding them to its context
+type collectorGroups struct {
+	config *Config
+	groups map[string][]prometheus.Collector
+	// every registered collector regardless of group, for prefetching
+	collectors []prometheus.Collector
+}
+
+func newCollectorGroups(config *Config) *collectorGroups {
+	return &collectorGroups{
+		config: config,
+		groups: make(map[string][]prometheus.Collector),
+	}
+}
+
+func (cg *collectorGroups) MustRegister(group string, collectors ...prometheus.Collector) {
+	cg.collectors = append(cg.collectors, collectors...)
+	cg.groups[group] = append(cg.groups[group], collectors...)
+	// panics on conflicting collectors at startup rather than on every scrape
+	cg.registry(context.Background(), slices.Collect(maps.Keys(cg.groups))...)
+}
+
+// a registry of the collectors of groups, bound to ctx
+func (cg *collectorGroups) registry(ctx context.Context, groups ...string) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	wrapped := NewWrappedRegisterer(cg.config, reg)
+	for _, group := range groups {
+		if limit := cg.config.MaxSeriesPerCollector; limit > 0 {
+			wrapped.MustRegister(&seriesCapCollector{
+				ctx:        ctx,
+				group:      group,
+				limit:      limit,
+				collectors: cg.groups[group],
+				truncated:  cg.config.NewDesc("slurm_collector_series_truncated", "number of series the collector group dropped over the max series per collector", []string{"collector"}, nil),
+			})
+			continue
+		}
+		for _, collector := range cg.groups[group] {
+			wrapped.MustRegister(&scrapeCollector{ctx: ctx, collector: collector})
+		}
+	}
+	return reg
+}
+
+// scrape error counters are never truncated, they're what tells a truncated scrape from a failed one
+var scrapeErrorMetricRe = regexp.MustCompile(`_scrape_errors?$`)
+
+// forwards at most limit series of a collector group, so a label explosion i.e
+// thousands of partitions or jobs can't take down Prometheus. Series are kept in
+// sorted order so the same ones survive from one scrape to the next
+type seriesCapCollector struct {
+	ctx        context.Context
+	group      string
+	limit      int
+	collectors []prometheus.Collector
+	truncated  *prometheus.Desc
+}
+
+func (scc *seriesCapCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range scc.collectors {
+		collector.Describe(ch)
+	}
+	ch <- scc.truncated
+}
+
+func (scc *seriesCapCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	var wg sync.WaitGroup
+	for _, collector := range scc.collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectContext(scc.ctx, collector, metrics)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(metrics)
+	}()
+	series := make([]cappedSeries, 0)
+	for metric := range metrics {
+		if scrapeErrorMetricRe.MatchString(descName(metric.Desc())) {
+			ch <- metric
+			continue
+		}
+		series = append(series, cappedSeries{key: seriesKey(metric), metric: metric})
+	}
+	slices.SortFunc(series, func(a, b cappedSeries) int {
+		return strings.Compare(a.key, b.key)
+	})
+	truncated := max(len(series)-scc.limit, 0)
+	if truncated > 0 {
+		slog.Warn(fmt.Sprintf("collector group %s emitted %d series, truncated to %d", scc.group, len(series), scc.limit))
+		series = series[:scc.limit]
+	}
+	for _, s := range series {
+		ch <- s.metric
+	}
+	ch <- prometheus.MustNewConstMetric(scc.truncated, prometheus.GaugeValue, float64(truncated), scc.group)
+}
+
+// the fully qualified name of desc, client_golang only exposes it through String
+func descName(desc *prometheus.Desc) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(desc.String(), `Desc{fqName: "`), `"`)
+	return name
+}
+
+type cappedSeries struct {
+	key    string
+	metric prometheus.Metric
+}
+
+// orders series by metric name, then label values
+func seriesKey(metric prometheus.Metric) string {
+	var b strings.Builder
+	b.WriteString(descName(metric.Desc()))
+	pb := new(dto.Metric)
+	if err := metric.Write(pb); err != nil {
+		return b.String()
+	}
+	// labels are sorted by name on Write
+	for _, label := range pb.GetLabel() {
+		b.WriteString("\xff" + label.GetName() + "=" + label.GetValue())
+	}
+	return b.String()
+}
+
+// combined gatherer served under MetricsPath, includes every group bound to ctx
+func (cg *collectorGroups) Gatherer(ctx context.Context, combined prometheus.Gatherer) prometheus.Gatherer {
+	if len(cg.groups) == 0 {
+		return combined
+	}
+	return prometheus.Gatherers{combined, cg.registry(ctx, slices.Collect(maps.Keys(cg.groups))...)}
+}
+
+// per group handlers keyed by path, only served with SplitMetricsPaths
+func (cg *collectorGroups) Handlers(metricsExcludeFilters []*regexp.Regexp, excludeLabels []LabelMatcher) map[string]http.Handler {
+	handlers := make(map[string]http.Handler)
+	if !cg.config.SplitMetricsPaths {
+		return handlers
+	}
+	for group := range cg.groups {
+		gatherer := func(ctx context.Context) prometheus.Gatherer {
+			return cg.registry(ctx, group)
+		}
+		handlers[path.Join(cg.config.MetricsPath, group)] = NewScrapeTimeoutHandler(newScrapeHTTPServer(gatherer, metricsExcludeFilters, excludeLabels))
+	}
+	return handlers
+}
+
+// upper bound on the startup prefetch, the cmds themselves are still bound by CLI_TIMEOUT
+const prefetchTimeout = time.Minute
+
+// collects every collector once and discards the output so the first real scrape hits warm caches.
+// Collect rather than FetchMetrics covers collectors without a fetcher. Fetch errors are logged by the
+// collectors themselves. Returns false if the collectors didn't finish within the timeout
+func prefetchCollectors(collectors []prometheus.Collector, timeout time.Duration) bool {
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, collector := range collectors {
+			wg.Add(1)
+			go func(collector prometheus.Collector) {
+				defer wg.Done()
+				ch := make(chan prometheus.Metric)
+				go func() {
+					collector.Collect(ch)
+					close(ch)
+				}()
+				for range ch {
+				}
+			}(collector)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info(fmt.Sprintf("prefetched %d collectors in %s", len(collectors), time.Since(start)))
+		return true
+	case <-time.After(timeout):
+		slog.Warn(fmt.Sprintf("prefetch didn't finish within %s, continuing in the background", timeout))
+		return false
+	}
+}
+
+// the node and job collectors are always enabled, so a missing sinfo or squeue almost always
+// means the exporter runs on a host without the slurm client installed
+func warnMissingSlurmBinaries(cliOpts *CliOpts) {
+	if cliOpts.fixtureDir != "" {
+		return
+	}
+	for _, args := range [][]string{cliOpts.sinfo, cliOpts.squeue} {
+		if err := lookupSlurmBinary(args); errors.Is(err, ErrSlurmBinaryNotFound) {
+			slog.Error(fmt.Sprintf("%s not found on PATH; is this host a SLURM client?", commandName(args)))
+		}
+	}
 }
 
 func InitPromServer(config *Config) http.Handler {
@@ -227,30 +1287,101 @@ func InitPromServer(config *Config) http.Handler {
 		Level: config.LogLevel,
 	})
 	slog.SetDefault(slog.New(textHandler))
-	prometheus.MustRegister(NewNodeCollecter(config), NewJobsController(config))
+	cliOpts := config.cliOpts
+	slog.Info(fmt.Sprintf("poll limit %gs from %s", config.PollLimit, config.PollLimitSource))
+	if cliOpts.maxConcurrentScrapes > 0 {
+		slog.Info(fmt.Sprintf("limiting concurrent slurm scrapes to %d", cliOpts.maxConcurrentScrapes))
+	}
+	SetMaxConcurrentScrapes(cliOpts.maxConcurrentScrapes)
+	warnMissingSlurmBinaries(cliOpts)
+	if cliOpts.skipUnavailable {
+		cliOpts.disableUnavailableCollectors()
+	}
+	SetCacheJitter(cliOpts.cacheJitter)
+	SetSlurmTimeZone(cliOpts.slurmTimeZone)
+	config.resolveClusterName()
+	if len(config.ExternalLabels) > 0 {
+		slog.Info(fmt.Sprintf("adding external labels %v to slurm metrics", config.ExternalLabels))
+	}
+	if config.MetricsPrefix != "" {
+		slog.Info("prefixing slurm metrics with " + config.MetricsPrefix)
+	}
+	unregisterDefaultCollectors(prometheus.DefaultRegisterer, config.DisableGoCollector, config.DisableProcessCollector)
+	registry := NewWrappedRegisterer(config, prometheus.DefaultRegisterer)
+	registry.MustRegister(NewBuildInfoCollector(), NewScrapeIntervalCollector(config), scrapeExitCodeGauge, scrapeResponseBytesGauge, cacheServedGauge, apiErrorCounter, schemaMissingFieldCounter)
+	groups := newCollectorGroups(config)
+	groups.MustRegister("node", NewNodeCollecter(config))
+	groups.MustRegister("job", NewJobsController(config))
 	if traceconf := config.TraceConf; traceconf.enabled {
 		slog.Info("trace path enabled at path: " + config.ListenAddress + traceconf.path)
 		traceController := NewTraceCollector(config)
 		http.HandleFunc(traceconf.path, traceController.uploadTrace)
-		prometheus.MustRegister(traceController)
+		groups.MustRegister("trace", traceController)
+	}
+	if config.PprofEnabled && config.PprofAddress == "" {
+		slog.Info("pprof enabled at path: " + config.ListenAddress + "/debug/pprof/")
+		http.Handle("/debug/pprof/", NewPprofHandler())
+	}
+	if config.DebugEndpointsEnabled {
+		slog.Info("raw slurm output enabled at path: " + config.ListenAddress + "/debug/raw/")
+		http.Handle("/debug/raw/", NewScrapeTimeoutHandler(NewDebugRawHandler(cliOpts)))
+	}
+	if cliOpts.nodeDetailEnabled {
+		slog.Info("node detail collection enabled")
+		groups.MustRegister("node", NewNodeDetailCollector(config))
 	}
-	cliOpts := config.cliOpts
 	if cliOpts.licEnabled {
 		slog.Info("licence collection enabled")
-		prometheus.MustRegister(NewLicCollector(config))
+		groups.MustRegister("license", NewLicCollector(config))
 	}
 	if cliOpts.diagsEnabled {
 		slog.Info("daemon diagnostic collection enabled")
-		prometheus.MustRegister(NewDiagsCollector(config))
+		groups.MustRegister("diag", NewDiagsCollector(config))
+	}
+	if cliOpts.ctldPingEnabled {
+		slog.Info("controller ping enabled")
+		groups.MustRegister("diag", NewControllerCollector(config))
+	}
+	if cliOpts.dbdPingEnabled {
+		slog.Info("slurmdbd health collection enabled")
+		groups.MustRegister("diag", NewSlurmdbdCollector(config))
 	}
 	if cliOpts.sacctEnabled {
 		slog.Info("account limit collection enabled")
-		prometheus.MustRegister(NewLimitCollector(config))
+		groups.MustRegister("limit", NewLimitCollector(config))
 	}
 	if cliOpts.gpusEnabled {
 		slog.Info("GPU metrics collection enabled")
-		prometheus.MustRegister(NewGpuCollector(config))
+		registry.MustRegister(schemaUnrecognizedCounter, gpuParseSkippedCounter)
+		groups.MustRegister("gpu", NewGpuCollector(config))
 	}
 
-	return NewPromHTTPServer(cliOpts.excludeFilter)
+	if cliOpts.partitionsEnabled {
+		slog.Info("partition state collection enabled")
+		groups.MustRegister("partition", NewPartitionCollector(config))
+	}
+	if cliOpts.partConfEnabled {
+		slog.Info("partition config collection enabled")
+		groups.MustRegister("partition", NewPartitionConfigCollector(config))
+	}
+	if cliOpts.dcgmEnabled {
+		slog.Info("dcgm GPU hardware metrics collection enabled")
+		groups.MustRegister("gpu", NewDcgmCollector(config))
+	}
+	if cliOpts.completedEnabled {
+		slog.Info("completed job collection enabled")
+		groups.MustRegister("job", NewCompletedJobsCollector(config))
+	}
+
+	if config.PrefetchOnStart {
+		go prefetchCollectors(groups.collectors, prefetchTimeout)
+	}
+	for groupPath, handler := range groups.Handlers(cliOpts.excludeFilters, cliOpts.excludeLabels) {
+		slog.Info("serving collector group metrics at " + config.ListenAddress + groupPath)
+		http.Handle(groupPath, handler)
+	}
+	gatherer := func(ctx context.Context) prometheus.Gatherer {
+		return groups.Gatherer(ctx, prometheus.DefaultGatherer)
+	}
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, NewScrapeTimeoutHandler(newScrapeHTTPServer(gatherer, cliOpts.excludeFilters, cliOpts.excludeLabels)))
 }
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// round trip of a trivial sacctmgr query. sacct and sacctmgr go through slurmdbd, so when it's slow or
+// down the limit, completed job and GPU metrics go stale
+type SlurmdbdCollector struct {
+	scraper       SlurmByteScraper
+	up            *prometheus.Desc
+	queryDuration *prometheus.Desc
+	scrapeError   *prometheus.CounterVec
+}
+
+func NewSlurmdbdCollector(config *Config) *SlurmdbdCollector {
+	cliOpts := config.cliOpts
+	return &SlurmdbdCollector{
+		scraper:       cliOpts.scraper("sacctmgr_ping", cliOpts.dbdPing),
+		up:            config.NewDesc("slurm_slurmdbd_up", "1 when slurmdbd answers a trivial sacctmgr query, 0 when it errors or times out", nil, nil),
+		queryDuration: config.NewDesc("slurm_slurmdbd_query_duration_seconds", fmt.Sprintf("how long the cmd %v took, including failed or timed out queries", cliOpts.dbdPing), nil, nil),
+		scrapeError: config.NewScrapeErrorCounter(prometheus.CounterOpts{
+			Name: "slurm_slurmdbd_scrape_error",
+			Help: "slurmdbd query errors",
+		}),
+	}
+}
+
+func (sc *SlurmdbdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sc.up
+	ch <- sc.queryDuration
+	sc.scrapeError.Describe(ch)
+}
+
+func (sc *SlurmdbdCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.CollectContext(context.Background(), ch)
+}
+
+func (sc *SlurmdbdCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer sc.scrapeError.Collect(ch)
+	_, err := sc.scraper.FetchRawBytes(ctx)
+	ch <- prometheus.MustNewConstMetric(sc.queryDuration, prometheus.GaugeValue, sc.scraper.Duration().Seconds())
+	up := 1.
+	if err != nil {
+		up = 0
+		sc.scrapeError.WithLabelValues(fetchErrorReason(err)).Inc()
+		slog.Error(fmt.Sprintf("slurmdbd query error %q", err))
+	}
+	ch <- prometheus.MustNewConstMetric(sc.up, prometheus.GaugeValue, up)
+}
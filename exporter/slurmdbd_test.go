@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// slurm_slurmdbd_up and slurm_slurmdbd_query_duration_seconds
+func collectSlurmdbd(t *testing.T, sc *SlurmdbdCollector) (float64, float64) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		sc.Collect(ch)
+		close(ch)
+	}()
+	var up, duration float64
+	for m := range ch {
+		metric := new(dto.Metric)
+		assert.NoError(t, m.Write(metric))
+		switch m.Desc() {
+		case sc.up:
+			up = metric.GetGauge().GetValue()
+		case sc.queryDuration:
+			duration = metric.GetGauge().GetValue()
+		}
+	}
+	return up, duration
+}
+
+func TestSlurmdbdCollector(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmdbdEnabled: true})
+	assert.NoError(err)
+	assert.Equal([]string{"sacctmgr", "-n", "-P", "show", "cluster", "format=cluster"}, config.cliOpts.debugCommands()["sacctmgr_ping"])
+	sc := NewSlurmdbdCollector(config)
+	sc.scraper = &SlowScraper{delay: 50 * time.Millisecond, msg: "rivos\n"}
+	up, duration := collectSlurmdbd(t, sc)
+	assert.Equal(1., up)
+	assert.GreaterOrEqual(duration, 0.05)
+	assert.Zero(CollectCounterValue(sc.scrapeError))
+}
+
+func TestSlurmdbdCollector_Down(t *testing.T) {
+	assert := assert.New(t)
+	config, err := NewConfig(&CliFlags{SlurmdbdEnabled: true})
+	assert.NoError(err)
+	sc := NewSlurmdbdCollector(config)
+	// a query killed at the cli timeout still reports how long it hung
+	sc.scraper = &SlowScraper{delay: 50 * time.Millisecond, err: fmt.Errorf("%w: %w", ErrScrapeTimeout, errors.New("signal: killed"))}
+	up, duration := collectSlurmdbd(t, sc)
+	assert.Zero(up)
+	assert.GreaterOrEqual(duration, 0.05)
+	assert.Equal(1., CollectCounterValue(sc.scrapeError))
+	assert.Equal(1., CollectCounterValue(sc.scrapeError.WithLabelValues(ScrapeErrorTimeout)))
+}
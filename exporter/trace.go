@@ -5,12 +5,16 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -118,10 +122,85 @@ func (m *AtomicProcFetcher) Fetch() map[int64]*TraceInfo {
 	return cpy
 }
 
+const (
+	// files kept in the trace output dir unless overridden
+	defaultTraceOutputMaxFiles int    = 1_000
+	traceFilePrefix            string = "trace-"
+)
+
+// persists uploaded traces as timestamped files for offline analysis.
+// The oldest files are removed once the dir holds more than maxFiles traces
+type traceOutput struct {
+	sync.Mutex
+	dir      string
+	maxFiles int
+	// sorted trace files in dir, listed once on the first write so traces of previous runs are rotated too
+	files []string
+}
+
+// file names sort by upload time
+func traceFileName(trace *TraceInfo) string {
+	return fmt.Sprintf("%s%s-%d.json", traceFilePrefix, trace.uploadAt.UTC().Format("20060102T150405.000000000Z"), trace.JobId)
+}
+
+func (to *traceOutput) write(trace *TraceInfo) error {
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return err
+	}
+	to.Lock()
+	defer to.Unlock()
+	if to.files == nil {
+		files, err := listTraceFiles(to.dir)
+		if err != nil {
+			return err
+		}
+		to.files = files
+	}
+	name := traceFileName(trace)
+	if err := os.WriteFile(filepath.Join(to.dir, name), data, 0o644); err != nil {
+		return err
+	}
+	if i, found := slices.BinarySearch(to.files, name); !found {
+		to.files = slices.Insert(to.files, i, name)
+	}
+	return to.rotate()
+}
+
+// sorted trace file names in dir, other files are ignored
+func listTraceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	traces := []string{}
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasPrefix(name, traceFilePrefix) && strings.HasSuffix(name, ".json") {
+			traces = append(traces, name)
+		}
+	}
+	// ReadDir already sorts by name
+	return traces, nil
+}
+
+// removes the oldest trace files past maxFiles. Callers must hold the lock
+func (to *traceOutput) rotate() error {
+	for len(to.files) > to.maxFiles {
+		if err := os.Remove(filepath.Join(to.dir, to.files[0])); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		to.files = to.files[1:]
+	}
+	return nil
+}
+
 type TraceCollector struct {
 	ProcessFetcher *AtomicProcFetcher
 	squeueFetcher  SlurmMetricFetcher[JobMetric]
 	fallback       bool
+	nodeSuffixes   NodeSuffixes
+	// nil unless traces are persisted, see TraceConfig
+	output *traceOutput
 	// actual proc monitoring
 	jobAllocMem  *prometheus.Desc
 	jobAllocCpus *prometheus.Desc
@@ -135,19 +214,25 @@ type TraceCollector struct {
 
 func NewTraceCollector(config *Config) *TraceCollector {
 	traceConfig := config.TraceConf
+	var output *traceOutput
+	if traceConfig.outputDir != "" {
+		output = &traceOutput{dir: traceConfig.outputDir, maxFiles: traceConfig.outputMaxFiles}
+	}
 	return &TraceCollector{
+		output:         output,
 		ProcessFetcher: NewAtomicProFetcher(traceConfig.rate),
 		squeueFetcher:  traceConfig.sharedFetcher,
 		fallback:       config.cliOpts.fallback,
+		nodeSuffixes:   config.cliOpts.nodeSuffixes,
 		// add for job id correlation
-		jobAllocMem:  prometheus.NewDesc("slurm_job_mem_alloc", "running job mem allocated", []string{"jobid"}, nil),
-		jobAllocCpus: prometheus.NewDesc("slurm_job_cpu_alloc", "running job cpus allocated", []string{"jobid"}, nil),
-		pid:          prometheus.NewDesc("slurm_proc_pid", "pid of running slurm job", []string{"jobid", "hostname"}, nil),
-		cpuUsage:     prometheus.NewDesc("slurm_proc_cpu_usage", "actual cpu usage collected from proc monitor", []string{"jobid", "username"}, nil),
-		memUsage:     prometheus.NewDesc("slurm_proc_mem_usage", "proc mem usage", []string{"jobid", "username"}, nil),
-		threadCount:  prometheus.NewDesc("slurm_proc_threadcount", "threads currently being used", []string{"jobid", "username"}, nil),
-		writeBytes:   prometheus.NewDesc("slurm_proc_write_bytes", "proc write bytes", []string{"jobid", "username"}, nil),
-		readBytes:    prometheus.NewDesc("slurm_proc_read_bytes", "proc read bytes", []string{"jobid", "username"}, nil),
+		jobAllocMem:  config.NewDesc("slurm_job_mem_alloc", "running job mem allocated", []string{"jobid"}, nil),
+		jobAllocCpus: config.NewDesc("slurm_job_cpu_alloc", "running job cpus allocated", []string{"jobid"}, nil),
+		pid:          config.NewDesc("slurm_proc_pid", "pid of running slurm job", []string{"jobid", "hostname"}, nil),
+		cpuUsage:     config.NewDesc("slurm_proc_cpu_usage", "actual cpu usage collected from proc monitor", []string{"jobid", "username"}, nil),
+		memUsage:     config.NewDesc("slurm_proc_mem_usage", "proc mem usage", []string{"jobid", "username"}, nil),
+		threadCount:  config.NewDesc("slurm_proc_threadcount", "threads currently being used", []string{"jobid", "username"}, nil),
+		writeBytes:   config.NewDesc("slurm_proc_write_bytes", "proc write bytes", []string{"jobid", "username"}, nil),
+		readBytes:    config.NewDesc("slurm_proc_read_bytes", "proc read bytes", []string{"jobid", "username"}, nil),
 	}
 }
 
@@ -163,11 +248,20 @@ func (c *TraceCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *TraceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+func (c *TraceCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	procs := c.ProcessFetcher.Fetch()
-	jobMetrics, err := c.squeueFetcher.FetchMetrics()
+	jobMetrics, err := c.squeueFetcher.FetchMetrics(ctx)
 	if err != nil {
 		return
 	}
+	hostnames := make([]string, 0, len(procs))
+	for _, p := range procs {
+		hostnames = append(hostnames, p.Hostname)
+	}
+	nodeLabels := c.nodeSuffixes.Labels(hostnames)
 	for _, j := range jobMetrics {
 		p, ok := procs[int64(j.JobId)]
 		if !ok {
@@ -176,7 +270,7 @@ func (c *TraceCollector) Collect(ch chan<- prometheus.Metric) {
 		jobid := fmt.Sprint(p.JobId)
 		ch <- prometheus.MustNewConstMetric(c.jobAllocMem, prometheus.GaugeValue, totalAllocMem(&j.JobResources), jobid)
 		ch <- prometheus.MustNewConstMetric(c.jobAllocCpus, prometheus.GaugeValue, j.JobResources.AllocCpus, jobid)
-		ch <- prometheus.MustNewConstMetric(c.pid, prometheus.GaugeValue, float64(p.Pid), jobid, p.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.pid, prometheus.GaugeValue, float64(p.Pid), jobid, nodeLabels[p.Hostname])
 		ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.GaugeValue, p.Cpus, jobid, p.Username)
 		ch <- prometheus.MustNewConstMetric(c.memUsage, prometheus.GaugeValue, p.Mem, jobid, p.Username)
 		ch <- prometheus.MustNewConstMetric(c.threadCount, prometheus.GaugeValue, p.Threads, jobid, p.Username)
@@ -197,6 +291,11 @@ func (c *TraceCollector) uploadTrace(w http.ResponseWriter, r *http.Request) {
 			slog.Error(fmt.Sprintf("failed to add to map with: %q", err))
 			return
 		}
+		if c.output != nil {
+			if err := c.output.write(&info); err != nil {
+				slog.Error(fmt.Sprintf("failed to write trace to %s: %q", c.output.dir, err))
+			}
+		}
 	}
 	if r.Method == http.MethodGet {
 
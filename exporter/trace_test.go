@@ -6,6 +6,7 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -87,6 +88,53 @@ func TestUploadTracePost(t *testing.T) {
 	assert.Equal(1, len(c.ProcessFetcher.Info))
 }
 
+func TestUploadTracePost_OutputDir(t *testing.T) {
+	assert := assert.New(t)
+	fixture, err := os.ReadFile("fixtures/trace_info_body.json")
+	assert.NoError(err)
+	dir := t.TempDir()
+	config, err := NewConfig(&CliFlags{TraceEnabled: true, TraceOutputDir: dir})
+	assert.NoError(err)
+	c := NewTraceCollector(config)
+	c.uploadTrace(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "dummy.url:8092/trace", bytes.NewBuffer(fixture)))
+	files, err := filepath.Glob(filepath.Join(dir, "trace-*-10.json"))
+	assert.NoError(err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	assert.NoError(err)
+	var written TraceInfo
+	assert.NoError(json.Unmarshal(data, &written))
+	assert.Equal(int64(35642), written.Pid)
+	assert.Equal("somehost", written.Hostname)
+	// invalid traces aren't persisted
+	c.uploadTrace(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "dummy.url:8092/trace", bytes.NewBufferString(`{"pid": 1}`)))
+	files, err = filepath.Glob(filepath.Join(dir, "trace-*"))
+	assert.NoError(err)
+	assert.Len(files, 1)
+}
+
+func TestTraceOutput_Rotate(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	// unrelated files are left alone
+	assert.NoError(os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644))
+	// traces of a previous run count towards maxFiles
+	assert.NoError(os.WriteFile(filepath.Join(dir, "trace-20231115T005959.000000000Z-9.json"), nil, 0o644))
+	output := &traceOutput{dir: dir, maxFiles: 2}
+	start := time.Date(2023, 11, 15, 1, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		assert.NoError(output.write(&TraceInfo{JobId: int64(i + 1), uploadAt: start.Add(time.Duration(i) * time.Second)}))
+	}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal([]string{"notes.txt", "trace-20231115T010002.000000000Z-3.json", "trace-20231115T010003.000000000Z-4.json"}, names)
+	assert.Equal(names[1:], output.files)
+}
+
 func TestUploadTraceGet(t *testing.T) {
 	assert := assert.New(t)
 	r := httptest.NewRequest(http.MethodGet, "dummy.url:8092/trace", nil)
@@ -109,7 +157,7 @@ func TestTraceControllerCollect(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    MockJobInfoScraper,
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 		},
 		cliOpts: new(CliOpts),
@@ -139,7 +187,7 @@ func TestTraceControllerCollect_Fallback(t *testing.T) {
 			sharedFetcher: &JobCliFallbackFetcher{
 				scraper:    &MockScraper{fixture: "fixtures/squeue_fallback.txt"},
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 		},
 		cliOpts: &CliOpts{fallback: true},
@@ -169,7 +217,7 @@ func TestTraceControllerDescribe(t *testing.T) {
 			sharedFetcher: &JobJsonFetcher{
 				scraper:    MockJobInfoScraper,
 				cache:      NewAtomicThrottledCache[JobMetric](1),
-				errCounter: prometheus.NewCounter(prometheus.CounterOpts{}),
+				errCounter: NewScrapeErrorCounter(prometheus.CounterOpts{}),
 			},
 		},
 		cliOpts: new(CliOpts),
@@ -199,7 +247,7 @@ func TestPython3Wrapper(t *testing.T) {
 	assert := assert.New(t)
 	fetcher := NewCliScraper("python3", "../wrappers/proctrac.py", "--cmd", "sleep", "100", "--jobid=10", "--validate")
 	t.Logf("cmd: %+v", fetcher.args)
-	wrapperOut, err := fetcher.FetchRawBytes()
+	wrapperOut, err := fetcher.FetchRawBytes(context.Background())
 	assert.Nil(err)
 	var info TraceInfo
 	json.Unmarshal(wrapperOut, &info)
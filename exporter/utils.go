@@ -6,16 +6,24 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"log/slog"
 
@@ -23,7 +31,7 @@ import (
 )
 
 type SlurmPrimitiveMetric interface {
-	NodeMetric | JobMetric | DiagMetric | LicenseMetric | AccountLimitMetric
+	NodeMetric | JobMetric | DiagMetric | LicenseMetric | AccountLimitMetric | PartitionStateMetric | PartitionConfigMetric | DcgmGpuMetric | CompletedJobMetric | NodeDetailMetric
 }
 
 type CoercedInt int
@@ -56,51 +64,171 @@ type SlurmVersion struct {
 }
 
 // interface for getting data from slurm
-// used for dep injection/ease of testing & for add slurmrestd support later
+// used for dep injection/ease of testing & for add slurmrestd support later.
+// ctx is the context of the scrape, see NewScrapeTimeoutHandler
 type SlurmByteScraper interface {
-	FetchRawBytes() ([]byte, error)
+	FetchRawBytes(ctx context.Context) ([]byte, error)
 	Duration() time.Duration
 }
 
 type SlurmMetricFetcher[M SlurmPrimitiveMetric] interface {
-	FetchMetrics() ([]M, error)
+	FetchMetrics(ctx context.Context) ([]M, error)
 	ScrapeDuration() time.Duration
-	ScrapeError() prometheus.Counter
+	ScrapeError() *prometheus.CounterVec
+}
+
+// reason label values of the scrape error counters
+const (
+	// the output couldn't be parsed
+	ScrapeErrorUnmarshal = "unmarshal"
+	// slurm answered with errors, or in the cext, a libslurm call failed
+	ScrapeErrorApi = "api-error"
+	// the cmd failed or couldn't be run
+	ScrapeErrorExec = "exec-error"
+	// the cmd was killed for outliving its timeout, see ErrScrapeTimeout
+	ScrapeErrorTimeout = "timeout"
+)
+
+var scrapeErrorReasons = []string{ScrapeErrorUnmarshal, ScrapeErrorApi, ScrapeErrorExec, ScrapeErrorTimeout}
+
+// scrape errors labeled by reason. Every reason starts at 0 so the first error of each shows up in rate()
+func NewScrapeErrorCounter(opts prometheus.CounterOpts) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(opts, []string{"reason"})
+	for _, reason := range scrapeErrorReasons {
+		counter.WithLabelValues(reason)
+	}
+	return counter
+}
+
+// reason of a failed FetchRawBytes
+func fetchErrorReason(err error) string {
+	if errors.Is(err, ErrScrapeTimeout) {
+		return ScrapeErrorTimeout
+	}
+	return ScrapeErrorExec
+}
+
+// backing store for throttled fetches. Get reports whether the cached value
+// is still within the throttle window
+type Cache[T any] interface {
+	Get() (T, bool)
+	Set(T)
+	Age() time.Duration
+}
+
+// serves the cache while fresh, otherwise hydrates it with fetchFunc.
+// Callers must serialize access to the cache. duration is updated on every successful miss.
+// served, when not nil, is set to 1 on a hit and 0 on a miss
+func fetchOrThrottle[T any](ctx context.Context, cache Cache[T], duration *time.Duration, served prometheus.Gauge, fetchFunc func(context.Context) (T, error)) (T, error) {
+	if cached, ok := cache.Get(); ok {
+		if served != nil {
+			served.Set(1)
+		}
+		return cached, nil
+	}
+	if served != nil {
+		served.Set(0)
+	}
+	t := time.Now()
+	data, err := fetchFunc(ctx)
+	if err != nil {
+		var empty T
+		return empty, err
+	}
+	*duration = time.Since(t)
+	cache.Set(data)
+	return data, nil
 }
 
 type AtomicThrottledCache[C SlurmPrimitiveMetric] struct {
 	sync.Mutex
 	t     time.Time
 	limit float64
-	cache []C
+	// see newJitterFactor
+	jitter float64
+	cache  []C
 	// duration of last cache miss
 	duration time.Duration
+	// slurm_collector_cache_served of the owning collector, nil when not reported
+	served prometheus.Gauge
+}
+
+func (atc *AtomicThrottledCache[C]) Get() ([]C, bool) {
+	return atc.cache, len(atc.cache) > 0 && atc.Age().Seconds() < jitteredLimit(atc.limit, atc.jitter)
+}
+
+func (atc *AtomicThrottledCache[C]) Set(data []C) {
+	atc.cache = data
+	atc.t = time.Now()
+}
+
+func (atc *AtomicThrottledCache[C]) Age() time.Duration {
+	return time.Since(atc.t)
 }
 
 // atomic fetch of either the cache or the collector
 // reset & hydrate as necessary
-func (atc *AtomicThrottledCache[C]) FetchOrThrottle(fetchFunc func() ([]C, error)) ([]C, error) {
+func (atc *AtomicThrottledCache[C]) FetchOrThrottle(ctx context.Context, fetchFunc func(context.Context) ([]C, error)) ([]C, error) {
 	atc.Lock()
 	defer atc.Unlock()
-	if len(atc.cache) > 0 && time.Since(atc.t).Seconds() < atc.limit {
-		return atc.cache, nil
-	}
-	t := time.Now()
-	slurmData, err := fetchFunc()
-	if err != nil {
-		return nil, err
-	}
-	atc.duration = time.Since(t)
-	atc.cache = slurmData
-	atc.t = time.Now()
-	return slurmData, nil
+	return fetchOrThrottle[[]C](ctx, atc, &atc.duration, atc.served, fetchFunc)
 }
 
 func NewAtomicThrottledCache[C SlurmPrimitiveMetric](limit float64) *AtomicThrottledCache[C] {
 	return &AtomicThrottledCache[C]{
-		t:     time.Now(),
-		limit: limit,
+		t:      time.Now(),
+		limit:  limit,
+		jitter: newJitterFactor(),
+	}
+}
+
+var cacheServedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "slurm_collector_cache_served",
+	Help: "1 when the last collect of a collector was served from its cache, 0 when it fetched fresh data",
+}, []string{"collector"})
+
+// cache of a collector reporting its hits in slurm_collector_cache_served
+func newCollectorCache[C SlurmPrimitiveMetric](limit float64, collector string) *AtomicThrottledCache[C] {
+	atc := NewAtomicThrottledCache[C](limit)
+	atc.served = cacheServedGauge.WithLabelValues(collector)
+	return atc
+}
+
+// caches expire within limit ± limit*fraction so collectors sharing a poll limit don't refresh in lockstep
+var cacheJitter atomic.Uint64
+
+// fraction of the poll limit, 0 disables jitter
+func SetCacheJitter(fraction float64) {
+	cacheJitter.Store(math.Float64bits(fraction))
+}
+
+// drawn once per cache within [-1, 1]. Scaled by the jitter fraction at lookup so it can be set after the caches exist
+func newJitterFactor() float64 {
+	return rand.Float64()*2 - 1
+}
+
+func jitteredLimit(limit float64, factor float64) float64 {
+	return limit * (1 + factor*math.Float64frombits(cacheJitter.Load()))
+}
+
+// timezone of the slurm controller, the cli prints timestamps in it without an offset. nil is the exporter's local time
+var slurmTimeZone atomic.Pointer[time.Location]
+
+func SetSlurmTimeZone(loc *time.Location) {
+	slurmTimeZone.Store(loc)
+}
+
+// parses a slurm cli timestamp i.e 2023-09-21T14:31:11 in the slurm timezone. N/A, NONE and Unknown are the zero time
+func parseSlurmTime(timestamp string) (time.Time, error) {
+	switch timestamp {
+	case "N/A", "NONE", "Unknown":
+		return time.Time{}, nil
+	}
+	loc := slurmTimeZone.Load()
+	if loc == nil {
+		loc = time.Local
 	}
+	return time.ParseInLocation("2006-01-02T15:04:05", timestamp, loc)
 }
 
 func track(cmd []string) (string, time.Time) {
@@ -111,9 +239,293 @@ func duration(msg string, start time.Time) {
 	slog.Debug(fmt.Sprintf("cmd %s took %s secs", msg, time.Since(start)))
 }
 
+// bounds the number of slurm commands in flight. A nil sem is unbounded
+type ScrapeLimiter struct {
+	sem chan struct{}
+}
+
+func NewScrapeLimiter(limit int) *ScrapeLimiter {
+	if limit <= 0 {
+		return &ScrapeLimiter{}
+	}
+	return &ScrapeLimiter{sem: make(chan struct{}, limit)}
+}
+
+// blocks until a slot is available or ctx is done. Returns the func that frees the slot
+func (sl *ScrapeLimiter) Acquire(ctx context.Context) (func(), error) {
+	if sl.sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sl.sem <- struct{}{}:
+		return func() { <-sl.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// shared by every CliScraper so that concurrent collectors don't flood slurmctld
+var scrapeLimiter atomic.Pointer[ScrapeLimiter]
+
+func init() {
+	scrapeLimiter.Store(NewScrapeLimiter(0))
+}
+
+// limit <= 0 removes the bound
+func SetMaxConcurrentScrapes(limit int) {
+	scrapeLimiter.Store(NewScrapeLimiter(limit))
+}
+
+// last exit code per scraped command, labeled like slurm_scrape_response_bytes. 127 is command not found,
+// 126 not executable, -1 killed
+var scrapeExitCodeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "slurm_scrape_exit_code",
+	Help: "exit code of the last invocation of a slurm cli command. 0 on success",
+}, []string{"command"})
+
+// maps a cmd error onto a shell style exit code
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return 127
+	case errors.Is(err, fs.ErrPermission):
+		return 126
+	default:
+		return -1
+	}
+}
+
+// label value of everything outside a LabelAllowlist
+const otherLabelValue = "other"
+
+// label values kept by the account and partition allowlists. Every collector aggregating by account
+// or partition buckets its values through one, the rest collapse into "other". Empty keeps every value
+type LabelAllowlist []string
+
+// parses a comma separated allowlist, dropping empty entries
+func parseLabelAllowlist(values string) LabelAllowlist {
+	var allowlist LabelAllowlist
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			allowlist = append(allowlist, value)
+		}
+	}
+	return allowlist
+}
+
+// the fallback marks the default partition with a trailing *, it matches the plain name
+func (la LabelAllowlist) Allows(value string) bool {
+	return len(la) == 0 || slices.Contains(la, value) || slices.Contains(la, strings.TrimSuffix(value, "*"))
+}
+
+func (la LabelAllowlist) Bucket(value string) string {
+	if la.Allows(value) {
+		return value
+	}
+	return otherLabelValue
+}
+
+// domain suffixes stripped from node labels i.e .cluster.internal, so gpu01.cluster.internal is labeled gpu01
+type NodeSuffixes []string
+
+// strips the first matching suffix. A name that is only the suffix is kept as is
+func (ns NodeSuffixes) Strip(node string) string {
+	for _, suffix := range ns {
+		if trimmed, ok := strings.CutSuffix(node, suffix); ok && trimmed != "" {
+			return trimmed
+		}
+	}
+	return node
+}
+
+// node labels keyed by hostname. Hosts stripping to the same name, i.e gpu01.a.internal and gpu01.b.internal,
+// keep their full hostname so they aren't merged into one series
+func (ns NodeSuffixes) Labels(nodes []string) map[string]string {
+	labels := make(map[string]string, len(nodes))
+	hosts := make(map[string]string)
+	collisions := make(map[string]bool)
+	for _, node := range nodes {
+		stripped := ns.Strip(node)
+		labels[node] = stripped
+		if host, ok := hosts[stripped]; ok && host != node {
+			collisions[stripped] = true
+		}
+		hosts[stripped] = node
+	}
+	for node, label := range labels {
+		if collisions[label] {
+			labels[node] = node
+		}
+	}
+	return labels
+}
+
+// raw value of the named TRES in a TRES string i.e "cpu=4,mem=1024M,billing=8"
+func tresEntry(tres string, name string) (string, bool) {
+	for _, entry := range strings.Split(tres, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(entry), "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// value of the named TRES in a TRES string. Values with unit suffixes i.e mem aren't parsed, see parseTresMem
+func parseTresValue(tres string, name string) (float64, bool) {
+	v, ok := tresEntry(tres, name)
+	if !ok {
+		return 0, false
+	}
+	val, err := strconv.ParseFloat(v, 64)
+	return val, err == nil
+}
+
+// bytes of the mem TRES i.e mem=62.50G
+func parseTresMem(tres string) (float64, bool) {
+	v, ok := tresEntry(tres, "mem")
+	if !ok {
+		return 0, false
+	}
+	val, err := MemToFloat(v)
+	return val, err == nil
+}
+
+// bytes of the offending output quoted in json unmarshal errors
+const unmarshalSnippetLen = 200
+
+// json.Unmarshal of a slurm cmd's output. Errors are wrapped with the offset they occurred at and a snippet
+// of the output around it, or its start when the offset is unknown, so changes in slurm's output can be
+// diagnosed from the logs alone
+func unmarshalCliJson(data []byte, v any) error {
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+	return cliJsonError(err, data, offset)
+}
+
+// wraps a json error with the offset it occurred at and a snippet of data around it. A negative offset is unknown
+func cliJsonError(err error, data []byte, offset int64) error {
+	start := 0
+	if offset >= 0 {
+		start = max(0, min(len(data), int(offset))-unmarshalSnippetLen/2)
+	}
+	end := min(len(data), start+unmarshalSnippetLen)
+	if offset < 0 {
+		return fmt.Errorf("%w near %q", err, data[start:end])
+	}
+	return fmt.Errorf("%w at offset %d near %q", err, offset, data[start:end])
+}
+
+// decodes the elements of the top level array field of a slurm json response one at a time and passes each to
+// visit, so large responses i.e sacct on a busy cluster are never held as a slice of structs. Other fields are
+// skipped except for errors, which are returned along with whether field was present at all, since an absent field
+// most likely means it was renamed by a slurm upgrade rather than that there's nothing to report.
+// Errors are wrapped like unmarshalCliJson
+func streamCliJsonArray[T any](data []byte, field string, visit func(*T)) ([]string, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	wrap := func(err error) error {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return cliJsonError(err, data, syntaxErr.Offset)
+		}
+		return cliJsonError(err, data, dec.InputOffset())
+	}
+	expectDelim := func(want json.Delim) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return wrap(err)
+		}
+		if tok != want {
+			return wrap(fmt.Errorf("expected %v, got %v", want, tok))
+		}
+		return nil
+	}
+	if err := expectDelim('{'); err != nil {
+		return nil, false, err
+	}
+	var apiErrors []string
+	found := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false, wrap(err)
+		}
+		switch tok {
+		case field:
+			found = true
+			if err := expectDelim('['); err != nil {
+				return nil, false, err
+			}
+			for dec.More() {
+				item := new(T)
+				if err := dec.Decode(item); err != nil {
+					return nil, false, wrap(err)
+				}
+				visit(item)
+			}
+			if err := expectDelim(']'); err != nil {
+				return nil, false, err
+			}
+		case "errors":
+			if err := dec.Decode(&apiErrors); err != nil {
+				return nil, false, wrap(err)
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return nil, false, wrap(err)
+			}
+		}
+	}
+	if err := expectDelim('}'); err != nil {
+		return nil, false, err
+	}
+	return apiErrors, found, nil
+}
+
+// returned by CliScraper when its cmd can't be found, most likely because the host isn't a slurm client
+var ErrSlurmBinaryNotFound = errors.New("slurm binary not found")
+
+// returned by CliScraper when its cmd is killed for outliving its timeout
+var ErrScrapeTimeout = errors.New("slurm cmd timed out")
+
+// wraps a missing binary error, whether looked up on PATH or by absolute path, with ErrSlurmBinaryNotFound
+func binaryNotFound(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrSlurmBinaryNotFound, err)
+	}
+	return err
+}
+
+// checks that a cmd's binary exists without running it
+func lookupSlurmBinary(args []string) error {
+	if len(args) == 0 {
+		return errors.New("need at least 1 args")
+	}
+	_, err := exec.LookPath(commandName(args))
+	return binaryNotFound(err)
+}
+
 // implements SlurmByteScraper by fetch data from cli
 type CliScraper struct {
-	args     []string
+	args []string
+	// slurm_scrape_exit_code label, the cmd's fixture name i.e squeue_gpu so cmds sharing a binary don't
+	// overwrite each other. Defaults to the binary's name
+	command  string
 	timeout  time.Duration
 	duration time.Duration
 }
@@ -122,26 +534,48 @@ func (cf *CliScraper) Duration() time.Duration {
 	return cf.duration
 }
 
-func (cf *CliScraper) FetchRawBytes() ([]byte, error) {
-	defer func(t time.Time) { cf.duration = time.Since(t) }(time.Now())
+// the cmd is killed once it outlives either its timeout or the scrape's ctx
+func (cf *CliScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
 	if len(cf.args) == 0 {
 		return nil, errors.New("need at least 1 args")
 	}
+	// a scrape queued behind the limiter gives up with the scrape's deadline
+	release, err := scrapeLimiter.Load().Acquire(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: %v: waiting for a scrape slot: %w", ErrScrapeTimeout, cf.args, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: waiting for a scrape slot: %w", cf.args, err)
+	}
+	defer release()
+	defer func(t time.Time) { cf.duration = time.Since(t) }(time.Now())
 	defer duration(track(cf.args))
-	cmd := exec.Command(cf.args[0], cf.args[1:]...)
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cf.args[0], cf.args[1:]...)
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	command := cf.command
+	if command == "" {
+		command = filepath.Base(commandName(cf.args))
 	}
-	timer := time.AfterFunc(cf.timeout, func() {
-		if err := cmd.Process.Kill(); err != nil {
-			slog.Error(fmt.Sprintf("failed to cancel cmd: %v", cf.args))
+	exitCodeGauge := scrapeExitCodeGauge.WithLabelValues(command)
+	if err := cmd.Start(); err != nil {
+		exitCodeGauge.Set(float64(exitCode(err)))
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %v: %w", ErrScrapeTimeout, cf.args, err)
 		}
-	})
-	defer timer.Stop()
-	if err := cmd.Wait(); err != nil {
+		return nil, binaryNotFound(err)
+	}
+	err = cmd.Wait()
+	exitCodeGauge.Set(float64(exitCode(err)))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: %v: %w", ErrScrapeTimeout, cf.args, err)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%v: %w", cf.args, ctx.Err())
+	}
+	if err != nil {
 		return nil, err
 	}
 	if errb.Len() > 0 {
@@ -164,6 +598,194 @@ func NewCliScraper(args ...string) *CliScraper {
 	}
 }
 
+// splits a cmd into args the way a posix shell would, without any expansion. Whitespace separates args,
+// single quotes are literal and double quotes or a backslash escape the next char. The cmd is never run by a shell
+func splitCommand(cmd string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	// distinguishes an empty quoted arg i.e '' from no arg at all
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range cmd {
+		switch {
+		case escaped:
+			// inside double quotes a backslash only escapes chars the shell treats specially
+			if quote == '"' && !strings.ContainsRune(`"\$`+"`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+// overrides with this prefix are run through the shell, i.e "sh:sinfo -h -o %N | grep gpu"
+const shellCommandPrefix = "sh:"
+
+// args of a cli override. Overrides prefixed with sh:, or every override when shell is set, run through
+// /bin/sh -c so they can be pipelines. Others are split by splitCommand and never see a shell
+func parseOverride(override string, shell bool) ([]string, error) {
+	cmd, prefixed := strings.CutPrefix(override, shellCommandPrefix)
+	if !prefixed && !shell {
+		return splitCommand(override)
+	}
+	if strings.TrimSpace(cmd) == "" {
+		return nil, errors.New("empty command")
+	}
+	return []string{"/bin/sh", "-c", cmd}, nil
+}
+
+// binary a cmd runs. For shell overrides that's the first command of the pipeline, i.e sinfo for
+// "sh:sinfo -h -o %N | grep gpu", so exit codes and availability checks name the slurm cmd rather than sh
+func commandName(args []string) string {
+	if len(args) != 3 || args[0] != "/bin/sh" || args[1] != "-c" {
+		return args[0]
+	}
+	fields, err := splitCommand(args[2])
+	if err != nil {
+		return args[0]
+	}
+	for _, field := range fields {
+		// leading variable assignments i.e "TZ=UTC sinfo"
+		if strings.Contains(field, "=") {
+			continue
+		}
+		if name, _, _ := strings.Cut(field, "|"); strings.TrimRight(name, ";&") != "" {
+			return strings.TrimRight(name, ";&")
+		}
+		break
+	}
+	return args[0]
+}
+
+// implements SlurmByteScraper by reading canned cli output from a file.
+// Used to replay a user's sinfo/squeue dump without a slurm cluster
+type FileScraper struct {
+	path     string
+	duration time.Duration
+}
+
+func (fs *FileScraper) Duration() time.Duration {
+	return fs.duration
+}
+
+func (fs *FileScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	defer func(t time.Time) { fs.duration = time.Since(t) }(time.Now())
+	return os.ReadFile(fs.path)
+}
+
+func NewFileScraper(path string) *FileScraper {
+	return &FileScraper{path: path}
+}
+
+// byte size of the last successful output per cmd, keyed by the cmd's fixture name i.e sinfo_gpu
+var scrapeResponseBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "slurm_scrape_response_bytes",
+	Help: "size in bytes of the last successful output of a slurm cli command",
+}, []string{"command"})
+
+// implements SlurmByteScraper by recording the output size of another scraper in slurm_scrape_response_bytes.
+// Output size drives parse time and memory, so it's tracked to capacity plan the exporter
+type ResponseSizeScraper struct {
+	command string
+	scraper SlurmByteScraper
+}
+
+func (rs *ResponseSizeScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	data, err := rs.scraper.FetchRawBytes(ctx)
+	if err == nil {
+		scrapeResponseBytesGauge.WithLabelValues(rs.command).Set(float64(len(data)))
+	}
+	return data, err
+}
+
+func (rs *ResponseSizeScraper) Duration() time.Duration {
+	return rs.scraper.Duration()
+}
+
+func NewResponseSizeScraper(command string, scraper SlurmByteScraper) *ResponseSizeScraper {
+	return &ResponseSizeScraper{command: command, scraper: scraper}
+}
+
+// implements SlurmByteScraper by caching the raw output of another scraper.
+// Used to share a single cli invocation between collectors within one throttle window
+type ThrottledScraper struct {
+	sync.Mutex
+	scraper SlurmByteScraper
+	t       time.Time
+	limit   float64
+	jitter  float64
+	cache   []byte
+}
+
+func (ts *ThrottledScraper) FetchRawBytes(ctx context.Context) ([]byte, error) {
+	ts.Lock()
+	defer ts.Unlock()
+	if ts.cache != nil && time.Since(ts.t).Seconds() < jitteredLimit(ts.limit, ts.jitter) {
+		return ts.cache, nil
+	}
+	data, err := ts.scraper.FetchRawBytes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ts.cache = data
+	ts.t = time.Now()
+	return data, nil
+}
+
+func (ts *ThrottledScraper) Duration() time.Duration {
+	return ts.scraper.Duration()
+}
+
+func NewThrottledScraper(scraper SlurmByteScraper, limit float64) *ThrottledScraper {
+	return &ThrottledScraper{
+		scraper: scraper,
+		limit:   limit,
+		jitter:  newJitterFactor(),
+	}
+}
+
 // convert slurm mem string to float64 bytes
 func MemToFloat(mem string) (float64, error) {
 	if num, err := strconv.ParseFloat(mem, 64); err == nil {
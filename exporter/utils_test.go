@@ -5,14 +5,23 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"log/slog"
+	"maps"
+	"slices"
 )
 
 const chars string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -36,7 +45,7 @@ func generateRandString(n int) string {
 func TestCliFetcher(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("ls")
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NoError(err)
 	assert.NotNil(data)
 }
@@ -44,16 +53,38 @@ func TestCliFetcher(t *testing.T) {
 func TestCliFetcher_Timeout(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("sleep", "100")
-	cliFetcher.timeout = 0
-	data, err := cliFetcher.FetchRawBytes()
-	assert.EqualError(err, "signal: killed")
+	cliFetcher.timeout = 10 * time.Millisecond
+	data, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.ErrorIs(err, ErrScrapeTimeout)
+	assert.ErrorContains(err, "signal: killed")
+	assert.Equal(ScrapeErrorTimeout, fetchErrorReason(err))
+	assert.Nil(data)
+}
+
+func TestCliFetcher_ExpiredContext(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	data, err := NewCliScraper("sleep", "100").FetchRawBytes(ctx)
+	assert.ErrorIs(err, ErrScrapeTimeout)
+	assert.Equal(ScrapeErrorTimeout, fetchErrorReason(err))
+	assert.Nil(data)
+}
+
+func TestCliFetcher_Cancelled(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	data, err := NewCliScraper("sleep", "100").FetchRawBytes(ctx)
+	assert.ErrorIs(err, context.Canceled)
+	assert.NotErrorIs(err, ErrScrapeTimeout)
 	assert.Nil(data)
 }
 
 func TestCliFetcher_EmptyArgs(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper()
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.EqualError(err, "need at least 1 args")
 	assert.Nil(data)
 }
@@ -61,28 +92,191 @@ func TestCliFetcher_EmptyArgs(t *testing.T) {
 func TestCliFetcher_ExitCodeCmd(t *testing.T) {
 	assert := assert.New(t)
 	cliFetcher := NewCliScraper("ls", generateRandString(64))
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NotNil(err)
+	assert.Equal(ScrapeErrorExec, fetchErrorReason(err))
 	assert.Nil(data)
 }
 
+func TestNewScrapeErrorCounter(t *testing.T) {
+	assert := assert.New(t)
+	counter := NewScrapeErrorCounter(prometheus.CounterOpts{Name: "slurm_test_scrape_error"})
+	// every reason is exported before its first error
+	ch := make(chan prometheus.Metric, 10)
+	counter.Collect(ch)
+	assert.Len(ch, 4)
+	counter.WithLabelValues(ScrapeErrorApi).Inc()
+	assert.Equal(1., CollectCounterValue(counter))
+	assert.Equal(1., CollectCounterValue(counter.WithLabelValues(ScrapeErrorApi)))
+	assert.Zero(CollectCounterValue(counter.WithLabelValues(ScrapeErrorUnmarshal)))
+}
+
+func collectExitCode(command string) float64 {
+	dtoMetric := new(dto.Metric)
+	scrapeExitCodeGauge.WithLabelValues(command).Write(dtoMetric)
+	return dtoMetric.GetGauge().GetValue()
+}
+
+func TestCliFetcher_ExitCodeGauge(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewCliScraper("ls", generateRandString(64)).FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.Equal(2., collectExitCode("ls"))
+	_, err = NewCliScraper("ls").FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Zero(collectExitCode("ls"))
+}
+
+func TestCliFetcher_ExitCodeNotFound(t *testing.T) {
+	assert := assert.New(t)
+	cmd := generateRandString(16)
+	_, err := NewCliScraper(cmd).FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.Equal(127., collectExitCode(cmd))
+}
+
+func TestCliOpts_ScraperExitCodeLabel(t *testing.T) {
+	assert := assert.New(t)
+	opts := &CliOpts{}
+	// squeue runs under several fixture names, each keeps its own exit code
+	_, err := opts.scraper("squeue_gpu", []string{"ls", generateRandString(64)}).FetchRawBytes(context.Background())
+	assert.Error(err)
+	_, err = opts.scraper("squeue_pending_gpu", []string{"ls"}).FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Equal(2., collectExitCode("squeue_gpu"))
+	assert.Zero(collectExitCode("squeue_pending_gpu"))
+}
+
+func TestCliFetcher_ExitCodeKilled(t *testing.T) {
+	assert := assert.New(t)
+	cliFetcher := NewCliScraper("sleep", "100")
+	cliFetcher.timeout = 0
+	_, err := cliFetcher.FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.Equal(-1., collectExitCode("sleep"))
+}
+
 func TestCliFetcher_StdErr(t *testing.T) {
 	assert := assert.New(t)
 	// the rare case where stderr is written but exit code is still 0
 	cmd := `echo -e "error" 1>&2`
 	cliFetcher := NewCliScraper("/bin/bash", "-c", cmd)
-	data, err := cliFetcher.FetchRawBytes()
+	data, err := cliFetcher.FetchRawBytes(context.Background())
 	assert.NotNil(err)
 	assert.Nil(data)
 }
 
+func TestFileScraper(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "sinfo")
+	assert.NoError(os.WriteFile(path, []byte(`{"nodes": []}`), 0o644))
+	scraper := NewFileScraper(path)
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Equal(`{"nodes": []}`, string(data))
+}
+
+func TestFileScraper_Missing(t *testing.T) {
+	assert := assert.New(t)
+	scraper := NewFileScraper(filepath.Join(t.TempDir(), "sinfo"))
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.ErrorIs(err, os.ErrNotExist)
+	assert.Nil(data)
+}
+
+func TestScrapeLimiter_Bounded(t *testing.T) {
+	assert := assert.New(t)
+	limiter := NewScrapeLimiter(2)
+	scraper := &BlockingScraper{unblock: make(chan struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.Acquire(context.Background())
+			assert.NoError(err)
+			defer release()
+			scraper.FetchRawBytes(context.Background())
+		}()
+	}
+	// let every goroutine contend for a slot before unblocking
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(2, scraper.maxInFlight())
+	close(scraper.unblock)
+	wg.Wait()
+	assert.Equal(2, scraper.maxInFlight())
+}
+
+func TestScrapeLimiter_Unbounded(t *testing.T) {
+	assert := assert.New(t)
+	limiter := NewScrapeLimiter(0)
+	scraper := &BlockingScraper{unblock: make(chan struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.Acquire(context.Background())
+			assert.NoError(err)
+			defer release()
+			scraper.FetchRawBytes(context.Background())
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(5, scraper.maxInFlight())
+	close(scraper.unblock)
+	wg.Wait()
+}
+
+func TestCliFetcher_SharedLimiter(t *testing.T) {
+	assert := assert.New(t)
+	SetMaxConcurrentScrapes(1)
+	defer SetMaxConcurrentScrapes(0)
+	// hold the only slot so the cli scraper must wait on it
+	release, err := scrapeLimiter.Load().Acquire(context.Background())
+	assert.NoError(err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := NewCliScraper("ls").FetchRawBytes(context.Background())
+		assert.NoError(err)
+	}()
+	select {
+	case <-done:
+		t.Fatal("cli scraper ran without acquiring the shared limiter")
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	<-done
+}
+
+func TestCliFetcher_LimiterExpiredContext(t *testing.T) {
+	assert := assert.New(t)
+	SetMaxConcurrentScrapes(1)
+	defer SetMaxConcurrentScrapes(0)
+	release, err := scrapeLimiter.Load().Acquire(context.Background())
+	assert.NoError(err)
+	defer release()
+	// the scrape deadline expires while the only slot is held
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = NewCliScraper("ls").FetchRawBytes(ctx)
+	assert.ErrorIs(err, ErrScrapeTimeout)
+	assert.Equal(ScrapeErrorTimeout, fetchErrorReason(err))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = NewCliScraper("ls").FetchRawBytes(ctx)
+	assert.ErrorIs(err, context.Canceled)
+	assert.NotErrorIs(err, ErrScrapeTimeout)
+}
+
 func TestAtomicThrottledCache_CompMiss(t *testing.T) {
 	assert := assert.New(t)
 	cache := NewAtomicThrottledCache[NodeMetric](10)
 	// empty cache scenario
 	called := false
 	host := "host1"
-	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+	info, err := cache.FetchOrThrottle(context.Background(), func(context.Context) ([]NodeMetric, error) {
 		called = true
 		return []NodeMetric{{Hostname: host}}, nil
 	})
@@ -100,7 +294,7 @@ func TestAtomicThrottledCache_Hit(t *testing.T) {
 	cache.cache = []NodeMetric{{Hostname: "host1"}}
 	// empty cache scenario
 	called := false
-	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+	info, err := cache.FetchOrThrottle(context.Background(), func(context.Context) ([]NodeMetric, error) {
 		called = true
 		return []NodeMetric{{Hostname: "host2"}}, nil
 	})
@@ -117,7 +311,7 @@ func TestAtomicThrottledCache_Stale(t *testing.T) {
 	cache := NewAtomicThrottledCache[NodeMetric](0)
 	cache.cache = []NodeMetric{{Hostname: "host1"}}
 	called := false
-	info, err := cache.FetchOrThrottle(func() ([]NodeMetric, error) {
+	info, err := cache.FetchOrThrottle(context.Background(), func(context.Context) ([]NodeMetric, error) {
 		called = true
 		return []NodeMetric{{Hostname: "host2"}}, nil
 	})
@@ -129,6 +323,172 @@ func TestAtomicThrottledCache_Stale(t *testing.T) {
 	assert.Equal(cache.cache[0].Hostname, "host2")
 }
 
+func TestAtomicThrottledCache_Served(t *testing.T) {
+	assert := assert.New(t)
+	cache := newCollectorCache[NodeMetric](math.MaxFloat64, "node_test")
+	fetch := func(context.Context) ([]NodeMetric, error) {
+		return []NodeMetric{{Hostname: "host1"}}, nil
+	}
+	gauge := new(dto.Metric)
+	// first fetch misses the empty cache
+	_, err := cache.FetchOrThrottle(context.Background(), fetch)
+	assert.NoError(err)
+	assert.NoError(cacheServedGauge.WithLabelValues("node_test").Write(gauge))
+	assert.Equal(0., gauge.GetGauge().GetValue())
+	// an immediate second fetch is served from the cache
+	_, err = cache.FetchOrThrottle(context.Background(), fetch)
+	assert.NoError(err)
+	assert.NoError(cacheServedGauge.WithLabelValues("node_test").Write(gauge))
+	assert.Equal(1., gauge.GetGauge().GetValue())
+	// and back to 0 once stale
+	cache.limit = 0
+	_, err = cache.FetchOrThrottle(context.Background(), fetch)
+	assert.NoError(err)
+	assert.NoError(cacheServedGauge.WithLabelValues("node_test").Write(gauge))
+	assert.Equal(0., gauge.GetGauge().GetValue())
+}
+
+type errorReasonFetcher[T any] interface {
+	FetchMetrics(ctx context.Context) (T, error)
+	ScrapeError() *prometheus.CounterVec
+}
+
+// fails a fetcher built by newFetcher with each reason, exec and timeout failures are always tested.
+// A fetch returns an error unless its reason is in partialReasons, i.e squeue listing jobs alongside errors
+func testFetcherErrorReasons[T any](t *testing.T, newFetcher func(SlurmByteScraper) errorReasonFetcher[T], scrapers map[string]SlurmByteScraper, partialReasons ...string) {
+	cases := map[string]SlurmByteScraper{
+		ScrapeErrorExec:    new(MockFetchErrored),
+		ScrapeErrorTimeout: &SlowScraper{err: fmt.Errorf("%w: signal: killed", ErrScrapeTimeout)},
+	}
+	maps.Copy(cases, scrapers)
+	for reason, scraper := range cases {
+		t.Run(reason, func(t *testing.T) {
+			assert := assert.New(t)
+			fetcher := newFetcher(scraper)
+			_, err := fetcher.FetchMetrics(context.Background())
+			if slices.Contains(partialReasons, reason) {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+			// only the failure's own reason is incremented
+			assert.Equal(1., CollectCounterValue(fetcher.ScrapeError().WithLabelValues(reason)))
+			assert.Equal(1., CollectCounterValue(fetcher.ScrapeError()))
+		})
+	}
+}
+
+// exercises the throttle window shared by every Cache implementation
+func testCacheThrottle[T any](t *testing.T, fresh Cache[T], stale Cache[T], value T) {
+	assert := assert.New(t)
+	_, ok := fresh.Get()
+	assert.False(ok)
+	fresh.Set(value)
+	cached, ok := fresh.Get()
+	assert.True(ok)
+	assert.Equal(value, cached)
+	assert.Less(fresh.Age(), time.Minute)
+	// a hit skips the fetch
+	var duration time.Duration
+	_, err := fetchOrThrottle(context.Background(), fresh, &duration, nil, func(context.Context) (T, error) {
+		t.Fatal("fetch called on a fresh cache")
+		return value, nil
+	})
+	assert.NoError(err)
+	assert.Zero(duration)
+	// a stale cache is rehydrated
+	stale.Set(value)
+	_, ok = stale.Get()
+	assert.False(ok)
+	called := false
+	_, err = fetchOrThrottle(context.Background(), stale, &duration, nil, func(context.Context) (T, error) {
+		called = true
+		return value, nil
+	})
+	assert.NoError(err)
+	assert.True(called)
+	// errors leave the previous value in place
+	_, err = fetchOrThrottle(context.Background(), stale, &duration, nil, func(context.Context) (T, error) {
+		return value, fmt.Errorf("fetch failed")
+	})
+	assert.Error(err)
+	cached, _ = stale.Get()
+	assert.Equal(value, cached)
+}
+
+func TestAtomicThrottledCache_Jitter(t *testing.T) {
+	assert := assert.New(t)
+	SetCacheJitter(0.1)
+	defer SetCacheJitter(0)
+	early := NewAtomicThrottledCache[NodeMetric](1)
+	late := NewAtomicThrottledCache[NodeMetric](1)
+	// random factors are drawn per cache
+	assert.NotEqual(early.jitter, late.jitter)
+	early.jitter, late.jitter = -1, 1
+	metrics := []NodeMetric{{Hostname: "node1"}}
+	early.Set(metrics)
+	late.Set(metrics)
+	// both were set at the same time but expire at 0.9s and 1.1s
+	early.t = early.t.Add(-time.Second)
+	late.t = late.t.Add(-time.Second)
+	_, earlyHit := early.Get()
+	_, lateHit := late.Get()
+	assert.False(earlyHit)
+	assert.True(lateHit)
+}
+
+func TestJitteredLimit(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(10., jitteredLimit(10, 1))
+	SetCacheJitter(0.1)
+	defer SetCacheJitter(0)
+	assert.InDelta(11., jitteredLimit(10, 1), 1e-9)
+	assert.InDelta(9., jitteredLimit(10, -1), 1e-9)
+	for i := 0; i < 100; i++ {
+		limit := jitteredLimit(10, newJitterFactor())
+		assert.GreaterOrEqual(limit, 9.)
+		assert.LessOrEqual(limit, 11.)
+	}
+}
+
+func TestCache_AtomicThrottledCache(t *testing.T) {
+	testCacheThrottle[[]NodeMetric](t, NewAtomicThrottledCache[NodeMetric](math.MaxFloat64), NewAtomicThrottledCache[NodeMetric](0), []NodeMetric{{Hostname: "host1"}})
+}
+
+func TestCache_GpuCache(t *testing.T) {
+	testCacheThrottle[*GpuMetrics](t, &gpuCache{limit: math.MaxFloat64}, &gpuCache{limit: 0}, &GpuMetrics{Total: 8})
+}
+
+func TestThrottledScraper_Hit(t *testing.T) {
+	assert := assert.New(t)
+	scraper := &StringByteScraper{msg: "sinfo"}
+	throttled := NewThrottledScraper(scraper, math.MaxFloat64)
+	for i := 0; i < 3; i++ {
+		data, err := throttled.FetchRawBytes(context.Background())
+		assert.NoError(err)
+		assert.Equal([]byte("sinfo"), data)
+	}
+	assert.Equal(1, scraper.Callcount)
+}
+
+func TestThrottledScraper_Stale(t *testing.T) {
+	assert := assert.New(t)
+	scraper := &StringByteScraper{msg: "sinfo"}
+	throttled := NewThrottledScraper(scraper, 0)
+	throttled.FetchRawBytes(context.Background())
+	throttled.FetchRawBytes(context.Background())
+	assert.Equal(2, scraper.Callcount)
+}
+
+func TestThrottledScraper_Error(t *testing.T) {
+	assert := assert.New(t)
+	throttled := NewThrottledScraper(new(MockFetchErrored), math.MaxFloat64)
+	data, err := throttled.FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.Nil(data)
+	assert.Nil(throttled.cache)
+}
+
 func TestConvertMemToFloat(t *testing.T) {
 	assert := assert.New(t)
 	e := 1.2e+7
@@ -149,3 +509,257 @@ func TestConvertMemToFloat_Sad(t *testing.T) {
 	assert.Error(err)
 	assert.Equal(-1., n)
 }
+
+func TestSplitCommand(t *testing.T) {
+	assert := assert.New(t)
+	for cmd, expected := range map[string][]string{
+		"sinfo --json":                       {"sinfo", "--json"},
+		"  sinfo   -h\t-o %P  ":              {"sinfo", "-h", "-o", "%P"},
+		`squeue -o '{"a": "%a", "id": %A}'`:  {"squeue", "-o", `{"a": "%a", "id": %A}`},
+		`squeue -o "%b %N" -h`:               {"squeue", "-o", "%b %N", "-h"},
+		`squeue -o "{\"a\": \"%a\"}"`:        {"squeue", "-o", `{"a": "%a"}`},
+		`sinfo -o "a\b" with\ space`:         {"sinfo", "-o", `a\b`, "with space"},
+		`sacct --format='' -X`:               {"sacct", "--format=", "-X"},
+		`sacct --format=User,'Grp CPU'"Mem"`: {"sacct", "--format=User,Grp CPUMem"},
+	} {
+		args, err := splitCommand(cmd)
+		assert.NoError(err, cmd)
+		assert.Equal(expected, args, cmd)
+	}
+}
+
+func TestSplitCommand_Invalid(t *testing.T) {
+	assert := assert.New(t)
+	for _, cmd := range []string{"", "   ", `squeue -o '%a`, `squeue -o "%a`, `squeue \`} {
+		_, err := splitCommand(cmd)
+		assert.Error(err, cmd)
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	assert := assert.New(t)
+	args, err := parseOverride(`sinfo -o "%N %T"`, false)
+	assert.NoError(err)
+	assert.Equal([]string{"sinfo", "-o", "%N %T"}, args)
+	args, err = parseOverride("sh:sinfo -h -o %N | grep gpu", false)
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/sh", "-c", "sinfo -h -o %N | grep gpu"}, args)
+	// every override runs through the shell when enabled, the prefix is optional
+	args, err = parseOverride("sinfo -h -o %N | grep gpu", true)
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/sh", "-c", "sinfo -h -o %N | grep gpu"}, args)
+	args, err = parseOverride("sh:sinfo | grep gpu", true)
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/sh", "-c", "sinfo | grep gpu"}, args)
+	for _, override := range []string{"sh:", "sh:  "} {
+		_, err := parseOverride(override, false)
+		assert.Error(err, override)
+	}
+}
+
+func TestCliFetcher_BinaryNotFound(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewCliScraper(generateRandString(16)).FetchRawBytes(context.Background())
+	assert.ErrorIs(err, ErrSlurmBinaryNotFound)
+	_, err = NewCliScraper(filepath.Join(t.TempDir(), "sinfo")).FetchRawBytes(context.Background())
+	assert.ErrorIs(err, ErrSlurmBinaryNotFound)
+	// failures of an existing binary aren't reported as missing
+	_, err = NewCliScraper("ls", generateRandString(64)).FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.NotErrorIs(err, ErrSlurmBinaryNotFound)
+}
+
+func TestLookupSlurmBinary(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(lookupSlurmBinary([]string{"ls", "-l"}))
+	assert.ErrorIs(lookupSlurmBinary([]string{generateRandString(16)}), ErrSlurmBinaryNotFound)
+	assert.ErrorIs(lookupSlurmBinary([]string{filepath.Join(t.TempDir(), "sinfo")}), ErrSlurmBinaryNotFound)
+	assert.Error(lookupSlurmBinary(nil))
+}
+
+func TestUnmarshalCliJson(t *testing.T) {
+	assert := assert.New(t)
+	var resp sinfoResponse
+	assert.NoError(unmarshalCliJson([]byte(`{"nodes": []}`), &resp))
+	// truncated output
+	err := unmarshalCliJson([]byte(`{"nodes": [{"hostname": "cs25"`), &resp)
+	assert.ErrorContains(err, "unexpected end of JSON input")
+	assert.ErrorContains(err, `near "{\"nodes\": [{\"hostname\": \"cs25\""`)
+	// the snippet is centered on the offset of the error rather than the start of the output
+	padding := strings.Repeat(" ", 1000)
+	err = unmarshalCliJson([]byte(`{"nodes": [`+padding+`{"hostname": 25}]}`), &resp)
+	assert.ErrorContains(err, "at offset 1026")
+	assert.ErrorContains(err, `{\"hostname\": 25}]}`)
+	assert.NotContains(err.Error(), `{\"nodes\"`)
+	// plain text instead of json i.e an error message
+	err = unmarshalCliJson([]byte("sinfo: error: "+padding), &resp)
+	assert.ErrorContains(err, `near "sinfo: error:`)
+	assert.Less(len(err.Error()), 300)
+}
+
+func TestParseTresValue(t *testing.T) {
+	assert := assert.New(t)
+	billing, ok := parseTresValue("cpu=1,mem=62.50G,node=1,billing=2.5", "billing")
+	assert.True(ok)
+	assert.Equal(2.5, billing)
+	cpus, ok := parseTresValue("cpu=4, gres/gpu=2", "cpu")
+	assert.True(ok)
+	assert.Equal(4., cpus)
+	_, ok = parseTresValue("cpu=1,mem=62.50G", "billing")
+	assert.False(ok)
+	_, ok = parseTresValue("cpu=1,mem=62.50G", "mem")
+	assert.False(ok)
+	_, ok = parseTresValue("", "billing")
+	assert.False(ok)
+}
+
+func TestLabelAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	allowlist := parseLabelAllowlist(" hw-l, ,gpu,")
+	assert.Equal(LabelAllowlist{"hw-l", "gpu"}, allowlist)
+	assert.Equal("hw-l", allowlist.Bucket("hw-l"))
+	// default partition in fallback mode
+	assert.Equal("hw-l*", allowlist.Bucket("hw-l*"))
+	assert.Equal(otherLabelValue, allowlist.Bucket("hw-h"))
+	assert.Equal("hw-h", parseLabelAllowlist("").Bucket("hw-h"))
+	assert.True(allowlist.Allows("gpu*"))
+	assert.False(allowlist.Allows("hw-h"))
+	assert.True(parseLabelAllowlist("").Allows("hw-h"))
+}
+
+func TestNodeSuffixes_Strip(t *testing.T) {
+	assert := assert.New(t)
+	suffixes := NodeSuffixes{".cluster.internal", ".lab"}
+	assert.Equal("gpu01", suffixes.Strip("gpu01.cluster.internal"))
+	assert.Equal("cs01", suffixes.Strip("cs01.lab"))
+	assert.Equal("gpu01.other.internal", suffixes.Strip("gpu01.other.internal"))
+	assert.Equal("gpu01", suffixes.Strip("gpu01"))
+	// never strip down to an empty label
+	assert.Equal(".lab", suffixes.Strip(".lab"))
+	assert.Equal("gpu01.lab", NodeSuffixes(nil).Strip("gpu01.lab"))
+}
+
+func TestNodeSuffixes_Labels(t *testing.T) {
+	assert := assert.New(t)
+	suffixes := NodeSuffixes{".cluster.internal", ".lab"}
+	// gpu01 is in both domains, its hosts keep their hostname rather than merge into one series
+	labels := suffixes.Labels([]string{"gpu01.cluster.internal", "gpu01.lab", "cs01.lab", "cs01.lab", "gpu02"})
+	assert.Equal(map[string]string{
+		"gpu01.cluster.internal": "gpu01.cluster.internal",
+		"gpu01.lab":              "gpu01.lab",
+		"cs01.lab":               "cs01",
+		"gpu02":                  "gpu02",
+	}, labels)
+	// a stripped host colliding with an unsuffixed one
+	assert.Equal(map[string]string{"cs01.lab": "cs01.lab", "cs01": "cs01"}, suffixes.Labels([]string{"cs01.lab", "cs01"}))
+}
+
+func TestParseSlurmTime(t *testing.T) {
+	assert := assert.New(t)
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	assert.NoError(err)
+	SetSlurmTimeZone(loc)
+	defer SetSlurmTimeZone(nil)
+	// PST is UTC-8, PDT UTC-7
+	parsed, err := parseSlurmTime("2023-11-15T01:00:00")
+	assert.NoError(err)
+	assert.True(parsed.Equal(time.Date(2023, 11, 15, 9, 0, 0, 0, time.UTC)))
+	parsed, err = parseSlurmTime("2023-07-01T01:00:00")
+	assert.NoError(err)
+	assert.True(parsed.Equal(time.Date(2023, 7, 1, 8, 0, 0, 0, time.UTC)))
+	SetSlurmTimeZone(time.UTC)
+	parsed, err = parseSlurmTime("2023-11-15T01:00:00")
+	assert.NoError(err)
+	assert.True(parsed.Equal(time.Date(2023, 11, 15, 1, 0, 0, 0, time.UTC)))
+	for _, unset := range []string{"N/A", "NONE", "Unknown"} {
+		parsed, err = parseSlurmTime(unset)
+		assert.NoError(err)
+		assert.True(parsed.IsZero())
+	}
+	_, err = parseSlurmTime("yesterday")
+	assert.Error(err)
+}
+
+func TestResponseSizeScraper(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "squeue.json")
+	assert.NoError(os.WriteFile(path, []byte(`{"jobs": []}`), 0o644))
+	scraper := NewResponseSizeScraper("squeue_test", NewFileScraper(path))
+	data, err := scraper.FetchRawBytes(context.Background())
+	assert.NoError(err)
+	assert.Len(data, 12)
+	gauge := new(dto.Metric)
+	assert.NoError(scrapeResponseBytesGauge.WithLabelValues("squeue_test").Write(gauge))
+	assert.Equal(12., gauge.GetGauge().GetValue())
+	// a failed fetch keeps the last size
+	assert.NoError(os.Remove(path))
+	_, err = scraper.FetchRawBytes(context.Background())
+	assert.Error(err)
+	assert.NoError(scrapeResponseBytesGauge.WithLabelValues("squeue_test").Write(gauge))
+	assert.Equal(12., gauge.GetGauge().GetValue())
+}
+
+func TestStreamCliJsonArray(t *testing.T) {
+	assert := assert.New(t)
+	var ids []int
+	visit := func(job *CompletedJobMetric) {
+		ids = append(ids, int(job.JobId))
+	}
+	apiErrors, found, err := streamCliJsonArray([]byte(`{"meta": {"plugin": {"name": "x"}}, "jobs": [{"job_id": 1}, {"job_id": 2}], "errors": []}`), "jobs", visit)
+	assert.NoError(err)
+	assert.Empty(apiErrors)
+	assert.True(found)
+	assert.Equal([]int{1, 2}, ids)
+	// an empty array is present, a renamed one isn't
+	_, found, err = streamCliJsonArray([]byte(`{"jobs": [], "errors": []}`), "jobs", visit)
+	assert.NoError(err)
+	assert.True(found)
+	_, found, err = streamCliJsonArray([]byte(`{"job_list": [{"job_id": 5}], "errors": []}`), "jobs", visit)
+	assert.NoError(err)
+	assert.False(found)
+	assert.Equal([]int{1, 2}, ids)
+	// errors are returned wherever they are in the response
+	ids = nil
+	apiErrors, _, err = streamCliJsonArray([]byte(`{"jobs": [{"job_id": 3}], "errors": ["Invalid user"], "warnings": [{"a": 1}]}`), "jobs", visit)
+	assert.NoError(err)
+	assert.Equal([]string{"Invalid user"}, apiErrors)
+	assert.Equal([]int{3}, ids)
+	// truncated output
+	_, _, err = streamCliJsonArray([]byte(`{"jobs": [{"job_id": 4}, {"job_id"`), "jobs", visit)
+	assert.ErrorContains(err, `near "{\"jobs\"`)
+	// wrong types are wrapped with their offset
+	padding := strings.Repeat(" ", 1000)
+	_, _, err = streamCliJsonArray([]byte(`{"jobs": [`+padding+`{"job_id": true}]}`), "jobs", visit)
+	assert.ErrorContains(err, "at offset")
+	assert.ErrorContains(err, `{\"job_id\": true}`)
+	// plain text instead of json
+	_, _, err = streamCliJsonArray([]byte("sacct: error: "+padding), "jobs", visit)
+	assert.ErrorContains(err, `near "sacct: error:`)
+	_, _, err = streamCliJsonArray([]byte(`{"jobs": {}}`), "jobs", visit)
+	assert.Error(err)
+}
+
+func BenchmarkParseTRES(b *testing.B) {
+	tres := "cpu=64,mem=512000M,node=2,billing=128,gres/gpu=8,gres/gpu:a100=8"
+	b.ReportAllocs()
+	for range b.N {
+		parseTresValue(tres, "billing")
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	assert := assert.New(t)
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sinfo", "-h"}, "sinfo"},
+		{[]string{"/opt/slurm/bin/sinfo", "--json"}, "/opt/slurm/bin/sinfo"},
+		{[]string{"/bin/sh", "-c", "sinfo -h -o %N | grep x"}, "sinfo"},
+		{[]string{"/bin/sh", "-c", "TZ=UTC squeue|cut -f1"}, "squeue"},
+		{[]string{"/bin/sh", "-c", "sdiag; true"}, "sdiag"},
+		{[]string{"/bin/sh", "-c", "'unterminated"}, "/bin/sh"},
+	} {
+		assert.Equal(tc.expected, commandName(tc.args), tc.args)
+	}
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2023 Rivos Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// populated at build time, i.e
+// go build -ldflags "-X github.com/rivosinc/prometheus-slurm-exporter/exporter.Version=v1.0.0 -X github.com/rivosinc/prometheus-slurm-exporter/exporter.Revision=$(git rev-parse HEAD)"
+var (
+	Version  = "dev"
+	Revision = "unknown"
+)
+
+func NewBuildInfoCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "slurm_exporter_build_info",
+		Help: "slurm exporter build info. Value is always 1",
+		ConstLabels: prometheus.Labels{
+			"version":   Version,
+			"revision":  Revision,
+			"goversion": runtime.Version(),
+		},
+	}, func() float64 { return 1 })
+}
@@ -15,24 +15,84 @@ var (
 		`Address to listen on for telemetry "(default: :9092)"`)
 	metricsPath = flag.String("web.telemetry-path", "",
 		"Path under which to expose metrics (default: /metrics)")
-	logLevel              = flag.String("web.log-level", "", "Log level: info, debug, error, warning")
-	traceEnabled          = flag.Bool("trace.enabled", false, "Set up Post endpoint for collecting traces")
-	tracePath             = flag.String("trace.path", "", "POST path to upload job proc info")
-	traceRate             = flag.Uint64("trace.rate", 0, "number of seconds proc info should stay in memory before being marked as stale (default 10)")
-	slurmPollLimit        = flag.Float64("slurm.poll-limit", 0, "throttle for slurmctld (default: 10s)")
-	slurmSinfoOverride    = flag.String("slurm.sinfo-cli", "", "sinfo cli override")
-	slurmSqueueOverride   = flag.String("slurm.squeue-cli", "", "squeue cli override")
-	slurmLicenseOverride  = flag.String("slurm.lic-cli", "", "squeue cli override")
-	slurmDiagOverride     = flag.String("slurm.diag-cli", "", "sdiag cli override")
-	slurmSaactOverride    = flag.String("slurm.sacctmgr-cli", "", "saactmgr cli override")
-	slurmSinfoGpuOverride = flag.String("slurm.sinfo-gpu-cli", "", "sinfo cli override for GPU metrics")
-	slurmSacctGpuOverride = flag.String("slurm.sacct-gpu-cli", "", "sacct cli override for GPU metrics")
-	slurmLicEnabled       = flag.Bool("slurm.collect-licenses", false, "Collect license info from slurm")
-	slurmDiagEnabled      = flag.Bool("slurm.collect-diags", false, "Collect daemon diagnostics stats from slurm")
-	slurmSacctEnabled     = flag.Bool("slurm.collect-limits", false, "Collect account and user limits from slurm")
-	slurmGpusEnabled      = flag.Bool("slurm.collect-gpus", false, "Collect GPU metrics from slurm")
-	slurmCliFallback      = flag.Bool("slurm.cli-fallback", true, "drop the --json arg and revert back to standard squeue for performance reasons")
-	metricsFilterRegex    = flag.String("metrics.exclude", "", "Regex pattern for metrics to exclude")
+	pprofEnabled           = flag.Bool("web.enable-pprof", false, "Serve pprof profiles under /debug/pprof")
+	pprofAddress           = flag.String("web.pprof-address", "", "Address to serve pprof on (default: the metrics listen address)")
+	logLevel               = flag.String("web.log-level", "", "Log level: debug, info, warn, error")
+	traceEnabled           = flag.Bool("trace.enabled", false, "Set up Post endpoint for collecting traces")
+	tracePath              = flag.String("trace.path", "", "POST path to upload job proc info")
+	traceRate              = flag.Uint64("trace.rate", 0, "number of seconds proc info should stay in memory before being marked as stale (default 10)")
+	traceOutputDir         = flag.String("trace.output-dir", "", "Also write each uploaded trace to a timestamped file in this dir")
+	traceOutputMaxFiles    = flag.Int("trace.output-max-files", 0, "Oldest trace files are removed past this count (default 1000)")
+	slurmPollLimit         = flag.Float64("slurm.poll-limit", 0, "throttle for slurmctld (default: 10s)")
+	slurmSinfoOverride     = flag.String("slurm.sinfo-cli", "", "sinfo cli override")
+	slurmSqueueOverride    = flag.String("slurm.squeue-cli", "", "squeue cli override")
+	slurmLicenseOverride   = flag.String("slurm.lic-cli", "", "squeue cli override")
+	slurmDiagOverride      = flag.String("slurm.diag-cli", "", "sdiag cli override")
+	slurmSaactOverride     = flag.String("slurm.sacctmgr-cli", "", "saactmgr cli override")
+	slurmSinfoGpuOverride  = flag.String("slurm.sinfo-gpu-cli", "", "sinfo cli override for GPU metrics")
+	slurmSacctGpuOverride  = flag.String("slurm.sacct-gpu-cli", "", "sacct cli override for GPU metrics")
+	slurmSqueueGpuOverride = flag.String("slurm.squeue-gpu-cli", "", "squeue cli override for the GPU allocation cross check")
+	slurmPendingOverride   = flag.String("slurm.squeue-pending-gpu-cli", "", "squeue cli override for pending GPU demand")
+	slurmPartitionOverride = flag.String("slurm.partition-cli", "", "sinfo cli override for partition state metrics. Output must be formatted as %P|%a|%D|%T")
+	partitionConfOverride  = flag.String("slurm.partition-config-cli", "", "scontrol cli override for partition limit metrics. Must emit the scontrol show partition --json format")
+	nodeDetailOverride     = flag.String("slurm.node-detail-cli", "", "scontrol cli override for node boot times. Must emit the scontrol show node --json format")
+	controllerPingOverride = flag.String("slurm.controller-ping-cli", "", "scontrol ping cli override. Must emit the scontrol ping --json format, or the plain format with -slurm.cli-fallback")
+	slurmdbdOverride       = flag.String("slurm.slurmdbd-cli", "", "Trivial sacctmgr query timed to probe slurmdbd (default: sacctmgr -n -P show cluster format=cluster)")
+	dcgmOverride           = flag.String("slurm.dcgm-cli", "", "Cmd printing dcgm-exporter metrics, i.e a script curling every GPU node (default: curl -s http://localhost:9400/metrics)")
+	slurmLicEnabled        = flag.Bool("slurm.collect-licenses", false, "Collect license info from slurm")
+	slurmDiagEnabled       = flag.Bool("slurm.collect-diags", false, "Collect daemon diagnostics stats from slurm")
+	slurmSacctEnabled      = flag.Bool("slurm.collect-limits", false, "Collect account and user limits from slurm")
+	slurmGpusEnabled       = flag.Bool("slurm.collect-gpus", false, "Collect GPU metrics from slurm")
+	slurmPartitionsEnabled = flag.Bool("slurm.collect-partitions", false, "Collect partition availability and node state metrics from slurm")
+	partitionConfEnabled   = flag.Bool("slurm.collect-partition-config", false, "Collect partition limits i.e MaxNodes, MaxTime from scontrol")
+	shellCommands          = flag.Bool("slurm.shell-commands", false, "Run every cli override through /bin/sh -c so overrides can be pipelines. Overrides prefixed with sh: always are. Only use with trusted overrides")
+	nodeDetailEnabled      = flag.Bool("slurm.collect-node-detail", false, "Collect node boot and slurmd start times from scontrol show node")
+	controllerPingEnabled  = flag.Bool("slurm.collect-controller-ping", false, "Ping the primary and backup slurmctld with scontrol ping, exported as slurm_controller_up")
+	slurmdbdEnabled        = flag.Bool("slurm.collect-slurmdbd", false, "Time a trivial sacctmgr query, exported as slurm_slurmdbd_up and slurm_slurmdbd_query_duration_seconds")
+	dcgmEnabled            = flag.Bool("slurm.collect-gpu-dcgm", false, "Collect GPU power and temperature from dcgm-exporter, labeled by slurm node and job")
+	slurmGpuCrosscheck     = flag.Bool("slurm.gpu-alloc-crosscheck", false, "Cross check sacct GPU allocation against squeue allocated TRES")
+	slurmGpuPending        = flag.Bool("slurm.gpu-pending", false, "Emit slurm_gpus_requested_pending, running an extra squeue for pending jobs per GPU scrape")
+	jobAllocPerJob         = flag.Bool("slurm.job-alloc-per-job", false, "Emit allocated cpus, mem and nodes per running job. High cardinality, one series per job")
+	slurmGpuPerJob         = flag.Bool("slurm.gpu-per-job", false, "Emit allocated GPUs per running job. High cardinality, one series per GPU job")
+	gpuUtilizationAlpha    = flag.Float64("slurm.gpu-utilization-smoothing-alpha", 0, "ewma smoothing factor within [0, 1] for slurm_gpus_utilization. Lower is smoother (default: 0, no smoothing)")
+	gpuGresName            = flag.String("slurm.gpu-gres-name", "gpu", "GRES resource name counted as GPUs, for sites that rename it i.e nvidia_gpu")
+	gpuDefaultType         = flag.String("slurm.gpu-default-type", "", "Type label for GPUs without a gres type in the per type GPU metrics i.e a100 (default: untyped)")
+	gpuAllocStates         = flag.String("slurm.gpu-alloc-states", "RUNNING", "Comma separated job states whose GPUs count as allocated i.e RUNNING,COMPLETING,CONFIGURING. Terminal states over-count. Ignored with -slurm.sacct-gpu-cli")
+	squeueStates           = flag.String("slurm.squeue-states", "", "Comma separated job states squeue lists i.e running,pending, shrinking its output on busy clusters. Job metrics only count these states (default: squeue's default in json mode, all in fallback mode)")
+	gpuAllocSource         = flag.String("slurm.gpu-alloc-source", "sacct", "Where json mode reads allocated GPUs from, sacct or gres_used. gres_used parses sinfo's per node gres_used and skips the sacct call, but drops per job GPU allocations")
+	gpuUtilizationBasis    = flag.String("slurm.gpu-utilization-basis", "total", "Denominator of slurm_gpus_utilization, total or available. available excludes idle GPUs on down, drained or failing nodes")
+	gpuNodeHistogram       = flag.Bool("slurm.gpu-node-utilization-histogram", false, "Emit slurm_node_gpu_utilization, a histogram of allocated / total GPUs per node")
+	slurmMaxScrapes        = flag.Int("slurm.max-concurrent-scrapes", 0, "max slurm commands in flight across all collectors (default: unbounded)")
+	sacctLookbackMinutes   = flag.Int("slurm.sacct-lookback-minutes", 0, "bound the sacct GPU query with --starttime=now-N minutes. Jobs are still filtered on --state so long running jobs are kept (default: no lookback)")
+	prefetchOnStart        = flag.Bool("slurm.prefetch-on-start", false, "Run every enabled collector once at startup so the first scrape hits warm caches")
+	nodeFeatureAllowlist   = flag.String("slurm.node-feature-allowlist", "", "Comma separated node features exported by slurm_nodes_by_feature i.e nvlink,ib (default: all features)")
+	cacheJitter            = flag.Float64("slurm.cache-jitter", 0.1, "Randomly offset each cache's throttle window by up to this fraction of the poll limit, so collectors don't refresh in lockstep. Within [0, 1)")
+	slurmCliFallback       = flag.Bool("slurm.cli-fallback", true, "drop the --json arg and revert back to standard squeue for performance reasons")
+	metricsFilterRegex     = flag.String("metrics.exclude", "", "Comma separated regex patterns, metrics matching any of them are excluded")
+	metricsExcludeLabels   = flag.String("metrics.exclude-label", "", "Drop series with any of these labels, formatted as k1=v1,k1=v2 i.e user=root")
+	clusterName            = flag.String("slurm.cluster", "", "Cluster name, labeling local jobs in a federation and exported with -slurm.cluster-label (default: ClusterName from $SLURM_CONF or scontrol show config)")
+	clusterLabel           = flag.Bool("slurm.cluster-label", false, "Add a cluster label with the cluster name to every slurm metric")
+	accountAllowlist       = flag.String("metrics.account-allowlist", "", "Comma separated accounts labeled in job and billing metrics, the rest are aggregated into account=\"other\" (default: all accounts)")
+	partitionAllowlist     = flag.String("metrics.partition-allowlist", "", "Comma separated partitions labeled in job, billing and node partition metrics, the rest are aggregated into partition=\"other\" (default: all partitions)")
+	nodeLabelStripSuffix   = flag.String("metrics.node-label-strip-suffix", "", "Comma separated domain suffixes stripped from node labels i.e .cluster.internal labels gpu01.cluster.internal as gpu01")
+	metricsPrefix          = flag.String("metrics.prefix", "", "Prefix prepended to every slurm metric name i.e site_")
+	metricsHelpFile        = flag.String("metrics.help-file", "", "Json file of metric name to help text i.e {\"slurm_cpus_total\": \"...\"}, replacing the built-in help of those metrics")
+	externalLabels         = flag.String("metrics.external-labels", "", "Constant labels added to every slurm metric, formatted as k1=v1,k2=v2")
+	noGoCollector          = flag.Bool("web.disable-go-collector", false, "Don't export go_* runtime metrics")
+	noProcessCollector     = flag.Bool("web.disable-process-collector", false, "Don't export process_* metrics")
+	debugEndpoints         = flag.Bool("web.enable-debug-endpoints", false, "Serve the raw output of the configured slurm cmds under /debug/raw/<cmd> i.e /debug/raw/sinfo")
+	slurmFixtureDir        = flag.String("slurm.fixture-dir", "", "Read canned cli output from files in this dir instead of running slurm cmds i.e <dir>/sinfo. For CI and reproducing parsing issues")
+	skipUnavailable        = flag.Bool("slurm.skip-unavailable-collectors", false, "Disable enabled collectors whose slurm cmd isn't on PATH, with a warning, instead of failing every scrape")
+	completedJobsEnabled   = flag.Bool("slurm.collect-completed-jobs", false, "Count jobs ending as COMPLETED, FAILED, TIMEOUT or CANCELLED in slurm_jobs_completed_total, from sacct")
+	completedJobsMinutes   = flag.Int("slurm.completed-jobs-window-minutes", 15, "Lookback of the completed jobs sacct query. Must exceed the scrape interval or jobs ending between scrapes are missed")
+	completedJobsOverride  = flag.String("slurm.completed-jobs-cli", "", "sacct cli override for completed jobs. Must emit the sacct --json format")
+	maxSeriesPerCollector  = flag.Int("slurm.max-series-per-collector", 0, "Max series a collector group emits per scrape, the excess is dropped and counted in slurm_collector_series_truncated. 0 is unlimited")
+	collectorTimeouts      = flag.String("slurm.collector-timeout", "", "Comma separated cli timeouts per collector, overriding CLI_TIMEOUT i.e gpu=60s,job=20s. Collectors are node, job, license, diag, limit, gpu and partition")
+	slurmTimeZone          = flag.String("slurm.timezone", "", "IANA timezone of slurmctld i.e America/Los_Angeles. The cli prints timestamps in it without an offset, set it when the exporter runs in another timezone (default: local)")
+	slurmBinDir            = flag.String("slurm.bin-dir", "", "Dir of the slurm binaries i.e /opt/slurm/bin, for installs outside of PATH. Applies to the default cmds and to overrides naming a bare slurm binary, not to sh: overrides (default: PATH)")
+	jobElapsedBuckets      = flag.String("slurm.job-elapsed-buckets", "", "Comma separated upper bounds in seconds of the slurm_running_job_elapsed_seconds histogram i.e 3600,86400 (default: 1m, 10m, 1h, 6h, 1d, 3d and 7d)")
+	jobPriorityBuckets     = flag.String("slurm.job-priority-buckets", "", "Comma separated upper bounds of the slurm_job_priority histogram i.e 1000,10000,100000 (default: powers of 10 from 1 to 1e9)")
+	splitMetricsPaths      = flag.Bool("web.split-metrics-paths", false, "Also serve each collector group under <telemetry-path>/<group> i.e /metrics/gpu, so expensive collectors can be scraped less often")
 )
 
 func main() {
@@ -40,9 +100,13 @@ func main() {
 	cliFlags := exporter.CliFlags{
 		ListenAddress:             *listenAddress,
 		MetricsPath:               *metricsPath,
+		PprofEnabled:              *pprofEnabled,
+		PprofAddress:              *pprofAddress,
 		LogLevel:                  *logLevel,
 		TraceEnabled:              *traceEnabled,
 		TracePath:                 *tracePath,
+		TraceOutputDir:            *traceOutputDir,
+		TraceOutputMaxFiles:       *traceOutputMaxFiles,
 		SlurmPollLimit:            *slurmPollLimit,
 		SlurmSinfoOverride:        *slurmSinfoOverride,
 		SlurmSqueueOverride:       *slurmSqueueOverride,
@@ -51,13 +115,69 @@ func main() {
 		SlurmLicEnabled:           *slurmLicEnabled,
 		SlurmDiagEnabled:          *slurmDiagEnabled,
 		SlurmGpusEnabled:          *slurmGpusEnabled,
+		SlurmPartitionsEnabled:    *slurmPartitionsEnabled,
+		SlurmPartitionOverride:    *slurmPartitionOverride,
+		PartitionConfigEnabled:    *partitionConfEnabled,
+		PartitionConfigOverride:   *partitionConfOverride,
 		SacctEnabled:              *slurmSacctEnabled,
 		SlurmCliFallback:          *slurmCliFallback,
 		TraceRate:                 *traceRate,
 		SlurmAcctOverride:         *slurmSaactOverride,
 		SlurmSinfoGpuOverride:     *slurmSinfoGpuOverride,
 		SlurmSacctGpuOverride:     *slurmSacctGpuOverride,
+		SlurmSqueueGpuOverride:    *slurmSqueueGpuOverride,
+		SlurmPendingGpuOverride:   *slurmPendingOverride,
+		SlurmGpuAllocCrosscheck:   *slurmGpuCrosscheck,
+		SlurmGpuPending:           *slurmGpuPending,
+		SlurmGpuPerJob:            *slurmGpuPerJob,
+		GpuUtilizationAlpha:       *gpuUtilizationAlpha,
+		SlurmMaxConcurrentScrapes: *slurmMaxScrapes,
+		SacctLookbackMinutes:      *sacctLookbackMinutes,
 		MetricsExcludeFilterRegex: *metricsFilterRegex,
+		MetricsExcludeLabels:      *metricsExcludeLabels,
+		ExternalLabels:            *externalLabels,
+		MetricsPrefix:             *metricsPrefix,
+		MetricsHelpFile:           *metricsHelpFile,
+		ClusterName:               *clusterName,
+		ClusterLabelEnabled:       *clusterLabel,
+		SplitMetricsPaths:         *splitMetricsPaths,
+		DisableGoCollector:        *noGoCollector,
+		DisableProcessCollector:   *noProcessCollector,
+		SlurmFixtureDir:           *slurmFixtureDir,
+		DebugEndpointsEnabled:     *debugEndpoints,
+		GpuGresName:               *gpuGresName,
+		GpuDefaultType:            *gpuDefaultType,
+		PrefetchOnStart:           *prefetchOnStart,
+		CacheJitter:               *cacheJitter,
+		NodeFeatureAllowlist:      *nodeFeatureAllowlist,
+		GpuAllocSource:            *gpuAllocSource,
+		GpuAllocStates:            *gpuAllocStates,
+		SqueueStates:              *squeueStates,
+		GpuNodeUtilHistogram:      *gpuNodeHistogram,
+		GpuUtilizationBasis:       *gpuUtilizationBasis,
+		SkipUnavailableCollectors: *skipUnavailable,
+		JobPriorityBuckets:        *jobPriorityBuckets,
+		MetricsAccountAllowlist:   *accountAllowlist,
+		MetricsPartitionAllowlist: *partitionAllowlist,
+		NodeLabelStripSuffix:      *nodeLabelStripSuffix,
+		DcgmEnabled:               *dcgmEnabled,
+		DcgmOverride:              *dcgmOverride,
+		SlurmBinDir:               *slurmBinDir,
+		CompletedJobsEnabled:      *completedJobsEnabled,
+		CompletedJobsMinutes:      *completedJobsMinutes,
+		CompletedJobsOverride:     *completedJobsOverride,
+		MaxSeriesPerCollector:     *maxSeriesPerCollector,
+		JobAllocPerJob:            *jobAllocPerJob,
+		JobElapsedBuckets:         *jobElapsedBuckets,
+		SlurmTimeZone:             *slurmTimeZone,
+		CollectorTimeouts:         *collectorTimeouts,
+		ControllerPingEnabled:     *controllerPingEnabled,
+		ControllerPingOverride:    *controllerPingOverride,
+		SlurmdbdEnabled:           *slurmdbdEnabled,
+		SlurmdbdOverride:          *slurmdbdOverride,
+		NodeDetailEnabled:         *nodeDetailEnabled,
+		NodeDetailOverride:        *nodeDetailOverride,
+		ShellCommands:             *shellCommands,
 	}
 	config, err := exporter.NewConfig(&cliFlags)
 	if err != nil {
@@ -65,6 +185,12 @@ func main() {
 	}
 	handler := exporter.InitPromServer(config)
 	http.Handle(config.MetricsPath, handler)
+	if config.PprofEnabled && config.PprofAddress != "" {
+		slog.Info("serving pprof at " + config.PprofAddress + "/debug/pprof/")
+		go func() {
+			log.Fatalf("pprof server exited with %q", http.ListenAndServe(config.PprofAddress, exporter.NewPprofHandler()))
+		}()
+	}
 	slog.Info("serving metrics at " + config.ListenAddress + config.MetricsPath)
 	log.Fatalf("server exited with %q", http.ListenAndServe(config.ListenAddress, nil))
 